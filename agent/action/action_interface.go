@@ -1,9 +1,17 @@
 package action
 
+import (
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
+)
+
 type Action interface {
 	IsAsynchronous() bool
 	IsPersistent() bool
 
+	// ConcurrencyClass determines which task pool runs asynchronous actions
+	// e.g. actions changing job state should be boshtask.ConcurrencyExclusive
+	ConcurrencyClass() boshtask.ConcurrencyClass
+
 	// Action should implement Run
 	// Arguments should be the list of arguments the payload will include
 	// and necessary for running the action
@@ -19,3 +27,31 @@ type Action interface {
 	Resume() (interface{}, error)
 	Cancel() error
 }
+
+// ProgressReportingAction is implemented by asynchronous actions
+// that report progress of their work while running
+type ProgressReportingAction interface {
+	Action
+
+	// WithProgressReporter returns a copy of the action
+	// that reports its progress to the given reporter
+	WithProgressReporter(boshtask.ProgressReporter) Action
+}
+
+// CancellableAction is implemented by asynchronous actions
+// that stop their work when Cancel is called
+type CancellableAction interface {
+	Action
+
+	IsCancellable() bool
+}
+
+// InterruptibleAction is implemented by cancellable actions
+// that stop their work via per task cancel signal
+type InterruptibleAction interface {
+	Action
+
+	// WithCancelSignal returns a copy of the action
+	// whose Cancel fires the given signal
+	WithCancelSignal(*boshtask.CancelSignal) Action
+}
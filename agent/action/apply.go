@@ -7,6 +7,7 @@ import (
 
 	boshappl "github.com/cloudfoundry/bosh-agent/agent/applier"
 	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
 	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 	boshsys "github.com/cloudfoundry/bosh-utils/system"
@@ -17,12 +18,17 @@ const (
 	userInstanceFilePermissions = os.FileMode(0644)
 )
 
+// Instance data files written by writeInstanceData
+var instanceDataFilenames = []string{"id", "az", "name", "deployment"}
+
 type ApplyAction struct {
 	applier         boshappl.Applier
 	specService     boshas.V1Service
 	settingsService boshsettings.Service
 	instanceDir     string
 	fs              boshsys.FileSystem
+	progress        boshtask.ProgressReporter
+	cancelSignal    *boshtask.CancelSignal
 }
 
 func NewApply(
@@ -37,6 +43,8 @@ func NewApply(
 	action.settingsService = settingsService
 	action.instanceDir = instanceDir
 	action.fs = fs
+	action.progress = boshtask.NewNoopProgressReporter()
+	action.cancelSignal = boshtask.NewCancelSignal()
 	return
 }
 
@@ -48,6 +56,24 @@ func (a ApplyAction) IsPersistent() bool {
 	return false
 }
 
+func (a ApplyAction) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return boshtask.ConcurrencyExclusive
+}
+
+func (a ApplyAction) WithProgressReporter(progress boshtask.ProgressReporter) Action {
+	a.progress = progress
+	return a
+}
+
+func (a ApplyAction) IsCancellable() bool {
+	return true
+}
+
+func (a ApplyAction) WithCancelSignal(cancelSignal *boshtask.CancelSignal) Action {
+	a.cancelSignal = cancelSignal
+	return a
+}
+
 func (a ApplyAction) Run(desiredSpec boshas.V1ApplySpec) (string, error) {
 	settings := a.settingsService.GetSettings()
 
@@ -56,29 +82,119 @@ func (a ApplyAction) Run(desiredSpec boshas.V1ApplySpec) (string, error) {
 		return "", bosherr.WrapError(err, "Resolving dynamic networks")
 	}
 
-	if desiredSpec.ConfigurationHash != "" {
-		currentSpec, err := a.specService.Get()
+	currentSpec, err := a.specService.Get()
+	if err != nil {
+		return "", bosherr.WrapError(err, "Getting current spec")
+	}
+
+	applyJobs := desiredSpec.ConfigurationHash != ""
+
+	if applyJobs {
+		err = a.applier.Apply(currentSpec, resolvedDesiredSpec, a.progress, a.cancelSignal)
 		if err != nil {
-			return "", bosherr.WrapError(err, "Getting current spec")
+			return "", bosherr.WrapError(err, "Applying")
 		}
+	}
+
+	err = a.record(currentSpec, resolvedDesiredSpec)
+	if err != nil {
+		if applyJobs {
+			rollBackErr := a.applier.RollBack(currentSpec)
+			if rollBackErr != nil {
+				return "", bosherr.NewMultiError(err, bosherr.WrapError(rollBackErr, "Rolling back to previous apply spec"))
+			}
+		}
+
+		return "", err
+	}
 
-		err = a.applier.Apply(currentSpec, resolvedDesiredSpec)
+	if applyJobs {
+		err = a.applier.Commit()
 		if err != nil {
-			return "", bosherr.WrapError(err, "Applying")
+			return "", bosherr.WrapError(err, "Committing apply")
 		}
 	}
 
-	err = a.specService.Set(resolvedDesiredSpec)
+	return "applied", nil
+}
+
+// record persists desired spec and instance data; current spec and
+// previous instance data are written back when either cannot be persisted
+func (a ApplyAction) record(currentSpec, desiredSpec boshas.V1ApplySpec) error {
+	previousInstanceData, err := a.readInstanceData()
 	if err != nil {
-		return "", bosherr.WrapError(err, "Persisting apply spec")
+		return bosherr.WrapError(err, "Reading instance data")
 	}
 
-	err = a.writeInstanceData(resolvedDesiredSpec)
+	err = a.specService.Set(desiredSpec)
 	if err != nil {
-		return "", err
+		err = bosherr.WrapError(err, "Persisting apply spec")
+	} else {
+		err = a.writeInstanceData(desiredSpec)
 	}
 
-	return "applied", nil
+	if err == nil {
+		return nil
+	}
+
+	errs := []error{err}
+
+	setErr := a.specService.Set(currentSpec)
+	if setErr != nil {
+		errs = append(errs, bosherr.WrapError(setErr, "Restoring apply spec"))
+	}
+
+	restoreErr := a.restoreInstanceData(previousInstanceData)
+	if restoreErr != nil {
+		errs = append(errs, bosherr.WrapError(restoreErr, "Restoring instance data"))
+	}
+
+	if len(errs) == 1 {
+		return err
+	}
+
+	return bosherr.NewMultiError(errs...)
+}
+
+// readInstanceData returns contents of instance data files that exist
+func (a ApplyAction) readInstanceData() (map[string]string, error) {
+	instanceData := map[string]string{}
+
+	for _, filename := range instanceDataFilenames {
+		instanceFieldFilePath := path.Join(a.instanceDir, filename)
+		if !a.fs.FileExists(instanceFieldFilePath) {
+			continue
+		}
+
+		content, err := a.fs.ReadFileString(instanceFieldFilePath)
+		if err != nil {
+			return nil, err
+		}
+
+		instanceData[filename] = content
+	}
+
+	return instanceData, nil
+}
+
+func (a ApplyAction) restoreInstanceData(instanceData map[string]string) error {
+	for _, filename := range instanceDataFilenames {
+		content, found := instanceData[filename]
+		if !found {
+			err := a.fs.RemoveAll(path.Join(a.instanceDir, filename))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err := a.writeInstanceField(filename, content)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func (a ApplyAction) writeInstanceData(spec boshas.V1ApplySpec) error {
@@ -127,5 +243,6 @@ func (a ApplyAction) Resume() (interface{}, error) {
 }
 
 func (a ApplyAction) Cancel() error {
-	return errors.New("not supported")
+	a.cancelSignal.Cancel()
+	return nil
 }
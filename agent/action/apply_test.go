@@ -11,10 +11,10 @@ import (
 	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
 	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
 	fakeappl "github.com/cloudfoundry/bosh-agent/agent/applier/fakes"
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
 	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
 	boshdir "github.com/cloudfoundry/bosh-agent/settings/directories"
 	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
-	boshsys "github.com/cloudfoundry/bosh-utils/system"
 	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
 )
 
@@ -26,7 +26,7 @@ func init() {
 			settingsService *fakesettings.FakeSettingsService
 			dirProvider     boshdir.Provider
 			action          ApplyAction
-			fs              boshsys.FileSystem
+			fs              *fakesys.FakeFileSystem
 		)
 
 		BeforeEach(func() {
@@ -46,6 +46,14 @@ func init() {
 			Expect(action.IsPersistent()).To(BeFalse())
 		})
 
+		It("runs in exclusive concurrency class", func() {
+			Expect(action.ConcurrencyClass()).To(Equal(boshtask.ConcurrencyExclusive))
+		})
+
+		It("is cancellable", func() {
+			Expect(action.IsCancellable()).To(BeTrue())
+		})
+
 		Describe("Run", func() {
 			settings := boshsettings.Settings{AgentID: "fake-agent-id"}
 
@@ -85,6 +93,19 @@ func init() {
 							Expect(applier.ApplyDesiredApplySpec).To(Equal(populatedDesiredApplySpec))
 						})
 
+						It("passes cancel signal fired by Cancel to the applier", func() {
+							cancelSignal := boshtask.NewCancelSignal()
+							cancellableAction := action.WithCancelSignal(cancelSignal).(ApplyAction)
+
+							_, err := cancellableAction.Run(desiredApplySpec)
+							Expect(err).ToNot(HaveOccurred())
+							Expect(applier.ApplyCancelSignal).To(Equal(cancelSignal))
+
+							err = cancellableAction.Cancel()
+							Expect(err).ToNot(HaveOccurred())
+							Expect(cancelSignal.Err()).To(HaveOccurred())
+						})
+
 						Context("when applier succeeds applying desired spec", func() {
 							Context("when saving desires spec as current spec succeeds", func() {
 								It("returns 'applied' after setting populated desired spec as current spec", func() {
@@ -95,6 +116,22 @@ func init() {
 									Expect(specService.Spec).To(Equal(populatedDesiredApplySpec))
 								})
 
+								It("commits applied jobs and packages after recording desired spec", func() {
+									_, err := action.Run(desiredApplySpec)
+									Expect(err).ToNot(HaveOccurred())
+
+									Expect(applier.Committed).To(BeTrue())
+									Expect(applier.RolledBack).To(BeFalse())
+								})
+
+								It("returns error if committing applied jobs and packages fails", func() {
+									applier.CommitError = errors.New("fake-commit-error")
+
+									_, err := action.Run(desiredApplySpec)
+									Expect(err).To(HaveOccurred())
+									Expect(err.Error()).To(ContainSubstring("fake-commit-error"))
+								})
+
 								Context("desired spec has id, instance name, deployment name, and az", func() {
 
 									BeforeEach(func() {
@@ -136,6 +173,55 @@ func init() {
 									Expect(err).To(HaveOccurred())
 									Expect(err.Error()).To(ContainSubstring("fake-set-error"))
 								})
+
+								It("rolls back to current spec without committing", func() {
+									specService.SetErr = errors.New("fake-set-error")
+
+									_, err := action.Run(desiredApplySpec)
+									Expect(err).To(HaveOccurred())
+
+									Expect(applier.RolledBack).To(BeTrue())
+									Expect(applier.RollBackCurrentApplySpec).To(Equal(currentApplySpec))
+									Expect(applier.Committed).To(BeFalse())
+								})
+
+								It("returns rollback error next to the original error", func() {
+									specService.SetErr = errors.New("fake-set-error")
+									applier.RollBackError = errors.New("fake-roll-back-error")
+
+									_, err := action.Run(desiredApplySpec)
+									Expect(err).To(HaveOccurred())
+									Expect(err.Error()).To(ContainSubstring("fake-set-error"))
+									Expect(err.Error()).To(ContainSubstring("Rolling back to previous apply spec: fake-roll-back-error"))
+								})
+							})
+
+							Context("when writing instance data fails", func() {
+								BeforeEach(func() {
+									desiredApplySpec = boshas.V1ApplySpec{ConfigurationHash: "fake-desired-config-hash", NodeID: "fake-node-id", Name: "fake-name"}
+									specService.PopulateDHCPNetworksResultSpec = desiredApplySpec
+
+									err := fs.WriteFileString(path.Join(dirProvider.InstanceDir(), "id"), "fake-current-node-id")
+									Expect(err).ToNot(HaveOccurred())
+
+									fs.WriteFileErrors[path.Join(dirProvider.InstanceDir(), "name")] = errors.New("fake-write-error")
+								})
+
+								It("restores current spec and instance data and rolls back jobs and packages", func() {
+									_, err := action.Run(desiredApplySpec)
+									Expect(err).To(HaveOccurred())
+									Expect(err.Error()).To(ContainSubstring("fake-write-error"))
+
+									Expect(specService.Spec).To(Equal(currentApplySpec))
+
+									id, err := fs.ReadFileString(path.Join(dirProvider.InstanceDir(), "id"))
+									Expect(err).ToNot(HaveOccurred())
+									Expect(id).To(Equal("fake-current-node-id"))
+									Expect(fs.FileExists(path.Join(dirProvider.InstanceDir(), "az"))).To(BeFalse())
+
+									Expect(applier.RolledBack).To(BeTrue())
+									Expect(applier.Committed).To(BeFalse())
+								})
 							})
 						})
 
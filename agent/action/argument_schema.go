@@ -0,0 +1,250 @@
+package action
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+// JSONSchema describes JSON representation of a Go type.
+// Schema without a type accepts any value.
+type JSONSchema struct {
+	Type                 string                 `json:"type,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	AdditionalProperties *JSONSchema            `json:"additionalProperties,omitempty"`
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// NewJSONSchema builds schema of values that can be unmarshalled into given type
+func NewJSONSchema(t reflect.Type) *JSONSchema {
+	return newJSONSchema(t, map[reflect.Type]bool{})
+}
+
+func newJSONSchema(t reflect.Type, visiting map[reflect.Type]bool) *JSONSchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	// Types with custom unmarshalling and recursive types accept any value
+	ptrType := reflect.PtrTo(t)
+	if ptrType.Implements(jsonUnmarshalerType) || ptrType.Implements(textUnmarshalerType) || visiting[t] {
+		return &JSONSchema{}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &JSONSchema{Type: "string"}
+
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}
+
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+
+	case reflect.Slice, reflect.Array:
+		// Byte slices are base64 encoded strings
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return &JSONSchema{Type: "string"}
+		}
+		return &JSONSchema{Type: "array", Items: newJSONSchema(t.Elem(), visiting)}
+
+	case reflect.Map:
+		return &JSONSchema{Type: "object", AdditionalProperties: newJSONSchema(t.Elem(), visiting)}
+
+	case reflect.Struct:
+		visiting[t] = true
+		defer delete(visiting, t)
+
+		schema := &JSONSchema{Type: "object", Properties: map[string]*JSONSchema{}}
+		addStructProperties(schema, t, visiting)
+		return schema
+
+	default:
+		return &JSONSchema{}
+	}
+}
+
+func addStructProperties(schema *JSONSchema, t reflect.Type, visiting map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name := strings.Split(tag, ",")[0]
+
+		// Fields of embedded structs are promoted unless they are named
+		if field.Anonymous && name == "" {
+			fieldType := field.Type
+			if fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct {
+				addStructProperties(schema, fieldType, visiting)
+				continue
+			}
+		}
+
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		schema.Properties[name] = newJSONSchema(field.Type, visiting)
+	}
+}
+
+// Validate checks that value decoded from JSON (with numbers as json.Number)
+// matches the schema; error refers to the location of the mismatched value
+func (s *JSONSchema) Validate(value interface{}) error {
+	return s.validate(value, "")
+}
+
+func (s *JSONSchema) validate(value interface{}, path string) error {
+	// Null leaves Go value untouched when unmarshalling
+	if s.Type == "" || value == nil {
+		return nil
+	}
+
+	switch s.Type {
+	case "string":
+		if _, ok := value.(string); !ok {
+			return s.mismatchErr(value, path)
+		}
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return s.mismatchErr(value, path)
+		}
+
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			return s.mismatchErr(value, path)
+		}
+
+	case "integer":
+		number, ok := value.(json.Number)
+		if !ok || strings.ContainsAny(number.String(), ".eE") {
+			return s.mismatchErr(value, path)
+		}
+
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return s.mismatchErr(value, path)
+		}
+
+		for i, item := range items {
+			err := s.Items.validate(item, s.itemPath(path, i))
+			if err != nil {
+				return err
+			}
+		}
+
+	case "object":
+		properties, ok := value.(map[string]interface{})
+		if !ok {
+			return s.mismatchErr(value, path)
+		}
+
+		return s.validateProperties(properties, path)
+	}
+
+	return nil
+}
+
+func (s *JSONSchema) validateProperties(properties map[string]interface{}, path string) error {
+	keys := []string{}
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		propertySchema := s.AdditionalProperties
+		if s.Properties != nil {
+			propertySchema = s.property(key)
+		}
+
+		// Unknown properties are ignored when unmarshalling
+		if propertySchema == nil {
+			continue
+		}
+
+		err := propertySchema.validate(properties[key], s.propertyPath(path, key))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// property matches keys case-insensitively just like encoding/json does
+func (s *JSONSchema) property(key string) *JSONSchema {
+	if schema, found := s.Properties[key]; found {
+		return schema
+	}
+
+	for name, schema := range s.Properties {
+		if strings.EqualFold(name, key) {
+			return schema
+		}
+	}
+
+	return nil
+}
+
+func (s *JSONSchema) mismatchErr(value interface{}, path string) error {
+	if path == "" {
+		return bosherr.Errorf("Expected %s, got %s", s.Type, jsonTypeName(value))
+	}
+	return bosherr.Errorf("Expected %s at '%s', got %s", s.Type, path, jsonTypeName(value))
+}
+
+func (s *JSONSchema) itemPath(path string, index int) string {
+	return fmt.Sprintf("%s[%d]", path, index)
+}
+
+func (s *JSONSchema) propertyPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return "null"
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"errors"
 
 	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
-	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 )
 
 type CancelTaskAction struct {
@@ -24,13 +23,17 @@ func (a CancelTaskAction) IsPersistent() bool {
 	return false
 }
 
+func (a CancelTaskAction) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return boshtask.ConcurrencyShared
+}
+
 func (a CancelTaskAction) Run(taskID string) (string, error) {
 	task, found := a.taskService.FindTaskWithID(taskID)
 	if !found {
-		return "", bosherr.Errorf("Task with id %s could not be found", taskID)
+		return "", newTaskNotFoundError(taskID)
 	}
 
-	return "canceled", task.Cancel()
+	return "canceled", a.taskService.CancelTask(task)
 }
 
 func (a CancelTaskAction) Resume() (interface{}, error) {
@@ -5,15 +5,20 @@ import (
 
 	boshmodels "github.com/cloudfoundry/bosh-agent/agent/applier/models"
 	boshcomp "github.com/cloudfoundry/bosh-agent/agent/compiler"
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 )
 
 type CompilePackageAction struct {
-	compiler boshcomp.Compiler
+	compiler     boshcomp.Compiler
+	progress     boshtask.ProgressReporter
+	cancelSignal *boshtask.CancelSignal
 }
 
 func NewCompilePackage(compiler boshcomp.Compiler) (compilePackage CompilePackageAction) {
 	compilePackage.compiler = compiler
+	compilePackage.progress = boshtask.NewNoopProgressReporter()
+	compilePackage.cancelSignal = boshtask.NewCancelSignal()
 	return
 }
 
@@ -25,6 +30,24 @@ func (a CompilePackageAction) IsPersistent() bool {
 	return false
 }
 
+func (a CompilePackageAction) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return boshtask.ConcurrencyCompilation
+}
+
+func (a CompilePackageAction) WithProgressReporter(progress boshtask.ProgressReporter) Action {
+	a.progress = progress
+	return a
+}
+
+func (a CompilePackageAction) IsCancellable() bool {
+	return true
+}
+
+func (a CompilePackageAction) WithCancelSignal(cancelSignal *boshtask.CancelSignal) Action {
+	a.cancelSignal = cancelSignal
+	return a
+}
+
 func (a CompilePackageAction) Run(blobID, sha1, name, version string, deps boshcomp.Dependencies) (val map[string]interface{}, err error) {
 	pkg := boshcomp.Package{
 		BlobstoreID: blobID,
@@ -46,7 +69,7 @@ func (a CompilePackageAction) Run(blobID, sha1, name, version string, deps boshc
 		})
 	}
 
-	uploadedBlobID, uploadedSha1, err := a.compiler.Compile(pkg, modelsDeps)
+	uploadedBlobID, uploadedSha1, err := a.compiler.Compile(pkg, modelsDeps, a.progress, a.cancelSignal)
 	if err != nil {
 		err = bosherr.WrapErrorf(err, "Compiling package %s", pkg.Name)
 		return
@@ -68,5 +91,6 @@ func (a CompilePackageAction) Resume() (interface{}, error) {
 }
 
 func (a CompilePackageAction) Cancel() error {
-	return errors.New("not supported")
+	a.cancelSignal.Cancel()
+	return nil
 }
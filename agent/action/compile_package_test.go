@@ -10,6 +10,8 @@ import (
 	boshmodels "github.com/cloudfoundry/bosh-agent/agent/applier/models"
 	boshcomp "github.com/cloudfoundry/bosh-agent/agent/compiler"
 	fakecomp "github.com/cloudfoundry/bosh-agent/agent/compiler/fakes"
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
+	faketask "github.com/cloudfoundry/bosh-agent/agent/task/fakes"
 )
 
 func getCompileActionArguments() (blobID, sha1, name, version string, deps boshcomp.Dependencies) {
@@ -53,6 +55,10 @@ var _ = Describe("CompilePackageAction", func() {
 		Expect(action.IsPersistent()).To(BeFalse())
 	})
 
+	It("runs in compilation concurrency class", func() {
+		Expect(action.ConcurrencyClass()).To(Equal(boshtask.ConcurrencyCompilation))
+	})
+
 	Describe("Run", func() {
 		It("compile package compiles the package abd returns blob id", func() {
 			compiler.CompileBlobID = "my-blob-id"
@@ -108,5 +114,32 @@ var _ = Describe("CompilePackageAction", func() {
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring("fake-compile-error"))
 		})
+
+		It("passes progress reporter to the compiler", func() {
+			progress := faketask.NewFakeProgressReporter()
+
+			_, err := action.WithProgressReporter(progress).(CompilePackageAction).Run(getCompileActionArguments())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(compiler.CompileProgress).To(Equal(progress))
+		})
+	})
+
+	Describe("Cancel", func() {
+		It("is cancellable", func() {
+			Expect(action.IsCancellable()).To(BeTrue())
+		})
+
+		It("fires cancel signal passed to the compiler", func() {
+			cancelSignal := boshtask.NewCancelSignal()
+			cancellableAction := action.WithCancelSignal(cancelSignal).(CompilePackageAction)
+
+			_, err := cancellableAction.Run(getCompileActionArguments())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(compiler.CompileSignal).To(Equal(cancelSignal))
+
+			err = cancellableAction.Cancel()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cancelSignal.Err()).To(HaveOccurred())
+		})
 	})
 })
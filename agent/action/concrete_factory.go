@@ -3,10 +3,12 @@ package action
 import (
 	boshappl "github.com/cloudfoundry/bosh-agent/agent/applier"
 	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	boshaudit "github.com/cloudfoundry/bosh-agent/agent/audit"
 	boshcomp "github.com/cloudfoundry/bosh-agent/agent/compiler"
 	boshscript "github.com/cloudfoundry/bosh-agent/agent/script"
 	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
 	boshjobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor"
+	boshmbus "github.com/cloudfoundry/bosh-agent/mbus"
 	boshnotif "github.com/cloudfoundry/bosh-agent/notification"
 	boshplatform "github.com/cloudfoundry/bosh-agent/platform"
 	boshntp "github.com/cloudfoundry/bosh-agent/platform/ntp"
@@ -15,6 +17,7 @@ import (
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
 	boshsys "github.com/cloudfoundry/bosh-utils/system"
+	"github.com/pivotal-golang/clock"
 )
 
 type concreteFactory struct {
@@ -27,12 +30,16 @@ func NewFactory(
 	blobstore boshblob.Blobstore,
 	taskService boshtask.Service,
 	notifier boshnotif.Notifier,
+	mbusCertUpdater boshmbus.CertUpdater,
+	mbusStatusReporter boshmbus.EndpointStatusReporter,
 	applier boshappl.Applier,
 	compiler boshcomp.Compiler,
 	jobSupervisor boshjobsuper.JobSupervisor,
 	specService boshas.V1Service,
 	jobScriptProvider boshscript.JobScriptProvider,
 	scriptCommandFactory boshsys.ScriptCommandFactory,
+	auditLog boshaudit.Log,
+	timeService clock.Clock,
 	logger boshlog.Logger,
 ) (factory Factory) {
 	compressor := platform.GetCompressor()
@@ -42,47 +49,55 @@ func NewFactory(
 	certManager := platform.GetCertManager()
 	ntpService := boshntp.NewConcreteService(platform.GetFs(), dirProvider)
 
-	factory = concreteFactory{
-		availableActions: map[string]Action{
-			// Task management
-			"ping":        NewPing(),
-			"get_task":    NewGetTask(taskService),
-			"cancel_task": NewCancelTask(taskService),
+	availableActions := map[string]Action{
+		// Task management
+		"ping":        NewPing(),
+		"get_task":    NewGetTask(taskService),
+		"cancel_task": NewCancelTask(taskService),
+		"list_tasks":  NewListTasks(taskService, timeService),
+		"task_events": NewTaskEvents(taskService),
 
-			// VM admin
-			"ssh":             NewSSH(settingsService, platform, dirProvider, logger),
-			"fetch_logs":      NewFetchLogs(compressor, copier, blobstore, dirProvider),
-			"update_settings": NewUpdateSettings(certManager, logger),
+		// VM admin
+		"ssh":             NewSSH(settingsService, platform, dirProvider, logger),
+		"fetch_logs":      NewFetchLogs(compressor, copier, blobstore, dirProvider),
+		"update_settings": NewUpdateSettings(certManager, mbusCertUpdater, logger),
 
-			// Job management
-			"prepare":    NewPrepare(applier),
-			"apply":      NewApply(applier, specService, settingsService, dirProvider.InstanceDir(), platform.GetFs()),
-			"start":      NewStart(jobSupervisor, applier, specService),
-			"stop":       NewStop(jobSupervisor),
-			"drain":      NewDrain(notifier, specService, jobScriptProvider, jobSupervisor, logger),
-			"get_state":  NewGetState(settingsService, specService, jobSupervisor, vitalsService, ntpService),
-			"run_errand": NewRunErrand(specService, dirProvider.JobsDir(), scriptCommandFactory, platform.GetRunner(), logger),
-			"run_script": NewRunScript(jobScriptProvider, specService, logger),
+		// Auditing
+		"verify_audit_log": NewVerifyAuditLog(auditLog),
 
-			// Compilation
-			"compile_package":    NewCompilePackage(compiler),
-			"release_apply_spec": NewReleaseApplySpec(platform),
+		// Job management
+		"prepare":    NewPrepare(applier),
+		"apply":      NewApply(applier, specService, settingsService, dirProvider.InstanceDir(), platform.GetFs()),
+		"start":      NewStart(jobSupervisor, applier, specService),
+		"stop":       NewStop(jobSupervisor),
+		"drain":      NewDrain(notifier, specService, jobScriptProvider, jobSupervisor, logger),
+		"get_state":  NewGetState(settingsService, specService, jobSupervisor, vitalsService, ntpService, mbusStatusReporter),
+		"run_errand": NewRunErrand(specService, dirProvider.JobsDir(), scriptCommandFactory, platform.GetRunner(), logger),
+		"run_script": NewRunScript(jobScriptProvider, specService, logger),
 
-			// Disk management
-			"list_disk":    NewListDisk(settingsService, platform, logger),
-			"migrate_disk": NewMigrateDisk(platform, dirProvider),
-			"mount_disk":   NewMountDisk(settingsService, platform, dirProvider, logger),
-			"unmount_disk": NewUnmountDisk(settingsService, platform),
+		// Compilation
+		"compile_package":    NewCompilePackage(compiler),
+		"release_apply_spec": NewReleaseApplySpec(platform),
 
-			// ARP cache management
-			"delete_arp_entries": NewDeleteARPEntries(platform),
+		// Disk management
+		"list_disk":    NewListDisk(settingsService, platform, logger),
+		"migrate_disk": NewMigrateDisk(platform, dirProvider),
+		"mount_disk":   NewMountDisk(settingsService, platform, dirProvider, logger),
+		"unmount_disk": NewUnmountDisk(settingsService, platform),
 
-			// Networkingconcrete_factory_test.go
-			"prepare_network_change":     NewPrepareNetworkChange(platform.GetFs(), settingsService, NewAgentKiller()),
-			"prepare_configure_networks": NewPrepareConfigureNetworks(platform, settingsService),
-			"configure_networks":         NewConfigureNetworks(NewAgentKiller()),
-		},
+		// ARP cache management
+		"delete_arp_entries": NewDeleteARPEntries(platform),
+
+		// Networkingconcrete_factory_test.go
+		"prepare_network_change":     NewPrepareNetworkChange(platform.GetFs(), settingsService, NewAgentKiller()),
+		"prepare_configure_networks": NewPrepareConfigureNetworks(platform, settingsService),
+		"configure_networks":         NewConfigureNetworks(NewAgentKiller()),
 	}
+
+	// Catalog of all available actions including itself
+	availableActions["describe_actions"] = NewDescribeActions(availableActions)
+
+	factory = concreteFactory{availableActions: availableActions}
 	return
 }
 
@@ -5,8 +5,10 @@ import (
 	. "github.com/onsi/gomega"
 
 	. "github.com/cloudfoundry/bosh-agent/agent/action"
+	fakeaction "github.com/cloudfoundry/bosh-agent/agent/action/fakes"
 	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
 	fakeappl "github.com/cloudfoundry/bosh-agent/agent/applier/fakes"
+	fakeaudit "github.com/cloudfoundry/bosh-agent/agent/audit/fakes"
 	fakecomp "github.com/cloudfoundry/bosh-agent/agent/compiler/fakes"
 	boshscript "github.com/cloudfoundry/bosh-agent/agent/script"
 
@@ -16,7 +18,6 @@ import (
 	fakenotif "github.com/cloudfoundry/bosh-agent/notification/fakes"
 	fakeplatform "github.com/cloudfoundry/bosh-agent/platform/fakes"
 	boshntp "github.com/cloudfoundry/bosh-agent/platform/ntp"
-	boshdir "github.com/cloudfoundry/bosh-agent/settings/directories"
 	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
 	fakeblobstore "github.com/cloudfoundry/bosh-utils/blobstore/fakes"
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
@@ -37,6 +38,8 @@ var _ = Describe("concreteFactory", func() {
 		jobSupervisor     *fakejobsuper.FakeJobSupervisor
 		specService       *fakeas.FakeV1Service
 		jobScriptProvider boshscript.JobScriptProvider
+		auditLog          *fakeaudit.FakeLog
+		timeService       *fakeaction.FakeClock
 		factory           Factory
 		logger            boshlog.Logger
 	)
@@ -52,6 +55,8 @@ var _ = Describe("concreteFactory", func() {
 		jobSupervisor = fakejobsuper.NewFakeJobSupervisor()
 		specService = fakeas.NewFakeV1Service()
 		jobScriptProvider = &fakescript.FakeJobScriptProvider{}
+		auditLog = fakeaudit.NewFakeLog()
+		timeService = &fakeaction.FakeClock{}
 		logger = boshlog.NewLogger(boshlog.LevelNone)
 
 		factory = NewFactory(
@@ -60,12 +65,16 @@ var _ = Describe("concreteFactory", func() {
 			blobstore,
 			taskService,
 			notifier,
+			nil,
+			nil,
 			applier,
 			compiler,
 			jobSupervisor,
 			specService,
 			jobScriptProvider,
 			boshsys.NewScriptCommandFactory("linux"),
+			auditLog,
+			timeService,
 			logger,
 		)
 	})
@@ -79,7 +88,9 @@ var _ = Describe("concreteFactory", func() {
 	It("apply", func() {
 		action, err := factory.Create("apply")
 		Expect(err).ToNot(HaveOccurred())
-		Expect(action).To(Equal(NewApply(applier, specService, settingsService, boshdir.NewProvider("/var/vcap").InstanceDir(), platform.GetFs())))
+
+		// Cannot do equality check since cancel signal is created in initializer
+		Expect(action).To(BeAssignableToTypeOf(ApplyAction{}))
 	})
 
 	It("drain", func() {
@@ -92,7 +103,9 @@ var _ = Describe("concreteFactory", func() {
 	It("fetch_logs", func() {
 		action, err := factory.Create("fetch_logs")
 		Expect(err).ToNot(HaveOccurred())
-		Expect(action).To(Equal(NewFetchLogs(platform.GetCompressor(), platform.GetCopier(), blobstore, platform.GetDirProvider())))
+
+		// Cannot do equality check since cancel signal is created in initializer
+		Expect(action).To(BeAssignableToTypeOf(FetchLogsAction{}))
 	})
 
 	It("get_task", func() {
@@ -107,11 +120,29 @@ var _ = Describe("concreteFactory", func() {
 		Expect(action).To(Equal(NewCancelTask(taskService)))
 	})
 
+	It("list_tasks", func() {
+		action, err := factory.Create("list_tasks")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(action).To(Equal(NewListTasks(taskService, timeService)))
+	})
+
+	It("verify_audit_log", func() {
+		action, err := factory.Create("verify_audit_log")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(action).To(Equal(NewVerifyAuditLog(auditLog)))
+	})
+
+	It("task_events", func() {
+		action, err := factory.Create("task_events")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(action).To(Equal(NewTaskEvents(taskService)))
+	})
+
 	It("get_state", func() {
 		ntpService := boshntp.NewConcreteService(platform.GetFs(), platform.GetDirProvider())
 		action, err := factory.Create("get_state")
 		Expect(err).ToNot(HaveOccurred())
-		Expect(action).To(Equal(NewGetState(settingsService, specService, jobSupervisor, platform.GetVitalsService(), ntpService)))
+		Expect(action).To(Equal(NewGetState(settingsService, specService, jobSupervisor, platform.GetVitalsService(), ntpService, nil)))
 	})
 
 	It("list_disk", func() {
@@ -183,7 +214,9 @@ var _ = Describe("concreteFactory", func() {
 	It("compile_package", func() {
 		action, err := factory.Create("compile_package")
 		Expect(err).ToNot(HaveOccurred())
-		Expect(action).To(Equal(NewCompilePackage(compiler)))
+
+		// Cannot do equality check since cancel signal is created in initializer
+		Expect(action).To(BeAssignableToTypeOf(CompilePackageAction{}))
 	})
 
 	It("run_errand", func() {
@@ -197,7 +230,9 @@ var _ = Describe("concreteFactory", func() {
 	It("run_script", func() {
 		action, err := factory.Create("run_script")
 		Expect(err).ToNot(HaveOccurred())
-		Expect(action).To(Equal(NewRunScript(jobScriptProvider, specService, logger)))
+
+		// Cannot do equality check since cancel signal is created in initializer
+		Expect(action).To(BeAssignableToTypeOf(RunScriptAction{}))
 	})
 
 	It("prepare", func() {
@@ -206,6 +241,18 @@ var _ = Describe("concreteFactory", func() {
 		Expect(action).To(Equal(NewPrepare(applier)))
 	})
 
+	It("describe_actions", func() {
+		action, err := factory.Create("describe_actions")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(action).To(BeAssignableToTypeOf(DescribeActionsAction{}))
+
+		descriptions, err := action.(DescribeActionsAction).Run()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(descriptions).To(HaveKey("describe_actions"))
+		Expect(descriptions).To(HaveKey("compile_package"))
+		Expect(descriptions["apply"].Asynchronous).To(BeTrue())
+	})
+
 	It("delete_arp_entries", func() {
 		action, err := factory.Create("delete_arp_entries")
 		Expect(err).ToNot(HaveOccurred())
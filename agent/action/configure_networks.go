@@ -3,6 +3,8 @@ package action
 import (
 	"errors"
 	"time"
+
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
 )
 
 type ConfigureNetworksAction struct {
@@ -24,6 +26,10 @@ func (a ConfigureNetworksAction) IsPersistent() bool {
 	return true
 }
 
+func (a ConfigureNetworksAction) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return boshtask.ConcurrencyExclusive
+}
+
 func (a ConfigureNetworksAction) Run() (interface{}, error) {
 	// Two possible ways to implement this action:
 	// (1) Restart agent which will in turn fetch infrastructure settings
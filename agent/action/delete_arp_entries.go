@@ -3,6 +3,7 @@ package action
 import (
 	"errors"
 
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
 	boshplatform "github.com/cloudfoundry/bosh-agent/platform"
 )
 
@@ -28,6 +29,10 @@ func (a DeleteARPEntriesAction) IsPersistent() bool {
 	return false
 }
 
+func (a DeleteARPEntriesAction) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return boshtask.ConcurrencyShared
+}
+
 func (a DeleteARPEntriesAction) Run(args DeleteARPEntriesActionArgs) (interface{}, error) {
 	addresses := args.Ips
 	for _, address := range addresses {
@@ -0,0 +1,130 @@
+package action
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+type DescribeActionsAction struct {
+	actions map[string]Action
+}
+
+type ActionDescription struct {
+	// Go does not keep names of Run arguments hence they are described in order
+	Arguments []ArgumentDescription `json:"arguments"`
+	Returns   ReturnDescription     `json:"returns"`
+
+	Asynchronous bool `json:"asynchronous"`
+	Persistent   bool `json:"persistent"`
+	Cancellable  bool `json:"cancellable"`
+}
+
+type ArgumentDescription struct {
+	Type     string      `json:"type"`
+	Schema   *JSONSchema `json:"schema"`
+	Required bool        `json:"required"`
+
+	// Variadic argument accepts any number of values
+	Variadic bool `json:"variadic,omitempty"`
+}
+
+type ReturnDescription struct {
+	Type   string      `json:"type"`
+	Schema *JSONSchema `json:"schema"`
+}
+
+func NewDescribeActions(actions map[string]Action) (action DescribeActionsAction) {
+	action.actions = actions
+	return
+}
+
+func (a DescribeActionsAction) IsAsynchronous() bool {
+	return false
+}
+
+func (a DescribeActionsAction) IsPersistent() bool {
+	return false
+}
+
+func (a DescribeActionsAction) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return boshtask.ConcurrencyShared
+}
+
+func (a DescribeActionsAction) Run() (map[string]ActionDescription, error) {
+	methods := []string{}
+	for method := range a.actions {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	descriptions := map[string]ActionDescription{}
+
+	for _, method := range methods {
+		description, err := DescribeAction(a.actions[method])
+		if err != nil {
+			return nil, bosherr.WrapErrorf(err, "Describing action %s", method)
+		}
+
+		descriptions[method] = description
+	}
+
+	return descriptions, nil
+}
+
+func (a DescribeActionsAction) Resume() (interface{}, error) {
+	return nil, errors.New("not supported")
+}
+
+func (a DescribeActionsAction) Cancel() error {
+	return errors.New("not supported")
+}
+
+// DescribeAction describes arguments and return value of action's Run method
+func DescribeAction(action Action) (ActionDescription, error) {
+	description := ActionDescription{
+		Arguments:    []ArgumentDescription{},
+		Asynchronous: action.IsAsynchronous(),
+		Persistent:   action.IsPersistent(),
+	}
+
+	if cancellableAction, ok := action.(CancellableAction); ok {
+		description.Cancellable = cancellableAction.IsCancellable()
+	}
+
+	runMethodValue := reflect.ValueOf(action).MethodByName("Run")
+	if runMethodValue.Kind() != reflect.Func {
+		return description, bosherr.Error("Run method not found")
+	}
+
+	runMethodType := runMethodValue.Type()
+
+	for i := 0; i < runMethodType.NumIn(); i++ {
+		argType := runMethodType.In(i)
+		argDescription := ArgumentDescription{Required: true}
+
+		if runMethodType.IsVariadic() && i == runMethodType.NumIn()-1 {
+			argType = argType.Elem()
+			argDescription.Required = false
+			argDescription.Variadic = true
+		}
+
+		argDescription.Type = argType.String()
+		argDescription.Schema = NewJSONSchema(argType)
+
+		description.Arguments = append(description.Arguments, argDescription)
+	}
+
+	if runMethodType.NumOut() > 0 {
+		returnType := runMethodType.Out(0)
+		description.Returns = ReturnDescription{
+			Type:   returnType.String(),
+			Schema: NewJSONSchema(returnType),
+		}
+	}
+
+	return description, nil
+}
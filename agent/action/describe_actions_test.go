@@ -0,0 +1,138 @@
+package action_test
+
+import (
+	"encoding/json"
+	"reflect"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/agent/action"
+)
+
+var _ = Describe("DescribeActions", func() {
+	var (
+		action DescribeActionsAction
+	)
+
+	BeforeEach(func() {
+		action = NewDescribeActions(map[string]Action{
+			"fake-good-action":     &actionWithGoodRunMethod{},
+			"fake-optional-action": &actionWithOptionalRunArgument{},
+			"fake-drain-action":    DrainAction{},
+		})
+	})
+
+	It("is synchronous", func() {
+		Expect(action.IsAsynchronous()).To(BeFalse())
+	})
+
+	It("is not persistent", func() {
+		Expect(action.IsPersistent()).To(BeFalse())
+	})
+
+	It("describes arguments and return value of each action", func() {
+		descriptions, err := action.Run()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(descriptions).To(HaveLen(3))
+
+		descriptionJSON, err := json.Marshal(descriptions["fake-good-action"])
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(descriptionJSON).To(MatchJSON(`{
+			"arguments": [
+				{"type":"string","schema":{"type":"string"},"required":true},
+				{"type":"int","schema":{"type":"integer"},"required":true},
+				{
+					"type":"action_test.argsType",
+					"schema":{"type":"object","properties":{"user":{"type":"string"},"pwd":{"type":"string"},"id":{"type":"integer"}}},
+					"required":true
+				},
+				{"type":"[]string","schema":{"type":"array","items":{"type":"string"}},"required":true}
+			],
+			"returns": {
+				"type":"action_test.valueType",
+				"schema":{"type":"object","properties":{"ID":{"type":"integer"},"Success":{"type":"boolean"}}}
+			},
+			"asynchronous": false,
+			"persistent": false,
+			"cancellable": false
+		}`))
+	})
+
+	It("describes variadic arguments as optional", func() {
+		descriptions, err := action.Run()
+		Expect(err).ToNot(HaveOccurred())
+
+		arguments := descriptions["fake-optional-action"].Arguments
+		Expect(arguments).To(HaveLen(2))
+		Expect(arguments[1].Type).To(Equal("action_test.argsType"))
+		Expect(arguments[1].Required).To(BeFalse())
+		Expect(arguments[1].Variadic).To(BeTrue())
+	})
+
+	It("describes flags of the action", func() {
+		descriptions, err := action.Run()
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(descriptions["fake-drain-action"].Asynchronous).To(BeTrue())
+		Expect(descriptions["fake-drain-action"].Persistent).To(BeFalse())
+		Expect(descriptions["fake-drain-action"].Cancellable).To(BeTrue())
+	})
+
+	It("returns error when action does not implement run", func() {
+		action = NewDescribeActions(map[string]Action{"fake-action": &actionWithoutRunMethod{}})
+
+		_, err := action.Run()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(Equal("Describing action fake-action: Run method not found"))
+	})
+})
+
+var _ = Describe("JSONSchema", func() {
+	type nestedType struct {
+		Names    []string          `json:"names"`
+		Labels   map[string]string `json:"labels,omitempty"`
+		Ignored  string            `json:"-"`
+		Untagged int
+	}
+
+	type embeddingType struct {
+		nestedType
+		Nested *nestedType `json:"nested"`
+	}
+
+	It("builds schema of struct fields following encoding/json rules", func() {
+		schema := NewJSONSchema(reflect.TypeOf(embeddingType{}))
+
+		nestedSchema := &JSONSchema{
+			Type: "object",
+			Properties: map[string]*JSONSchema{
+				"names":    {Type: "array", Items: &JSONSchema{Type: "string"}},
+				"labels":   {Type: "object", AdditionalProperties: &JSONSchema{Type: "string"}},
+				"Untagged": {Type: "integer"},
+			},
+		}
+
+		expectedSchema := &JSONSchema{
+			Type: "object",
+			Properties: map[string]*JSONSchema{
+				"names":    nestedSchema.Properties["names"],
+				"labels":   nestedSchema.Properties["labels"],
+				"Untagged": nestedSchema.Properties["Untagged"],
+				"nested":   nestedSchema,
+			},
+		}
+
+		Expect(schema).To(Equal(expectedSchema))
+	})
+
+	It("accepts any value for interfaces", func() {
+		var value interface{}
+		Expect(NewJSONSchema(reflect.TypeOf(&value).Elem())).To(Equal(&JSONSchema{}))
+	})
+
+	It("describes byte slices as strings", func() {
+		Expect(NewJSONSchema(reflect.TypeOf([]byte{}))).To(Equal(&JSONSchema{Type: "string"}))
+	})
+})
@@ -6,6 +6,7 @@ import (
 	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
 	boshscript "github.com/cloudfoundry/bosh-agent/agent/script"
 	boshdrain "github.com/cloudfoundry/bosh-agent/agent/script/drain"
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
 	boshjobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor"
 	boshnotif "github.com/cloudfoundry/bosh-agent/notification"
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
@@ -17,6 +18,7 @@ type DrainAction struct {
 	notifier          boshnotif.Notifier
 	specService       boshas.V1Service
 	jobSupervisor     boshjobsuper.JobSupervisor
+	progress          boshtask.ProgressReporter
 
 	logTag   string
 	logger   boshlog.Logger
@@ -43,6 +45,7 @@ func NewDrain(
 		specService:       specService,
 		jobScriptProvider: jobScriptProvider,
 		jobSupervisor:     jobSupervisor,
+		progress:          boshtask.NewNoopProgressReporter(),
 
 		logTag:   "Drain Action",
 		logger:   logger,
@@ -58,6 +61,19 @@ func (a DrainAction) IsPersistent() bool {
 	return false
 }
 
+func (a DrainAction) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return boshtask.ConcurrencyExclusive
+}
+
+func (a DrainAction) WithProgressReporter(progress boshtask.ProgressReporter) Action {
+	a.progress = progress
+	return a
+}
+
+func (a DrainAction) IsCancellable() bool {
+	return true
+}
+
 func (a DrainAction) Run(drainType DrainType, newSpecs ...boshas.V1ApplySpec) (int, error) {
 	currentSpec, err := a.specService.Get()
 	if err != nil {
@@ -70,6 +86,7 @@ func (a DrainAction) Run(drainType DrainType, newSpecs ...boshas.V1ApplySpec) (i
 	}
 
 	a.logger.Debug(a.logTag, "Unmonitoring")
+	a.progress.ReportProgress(boshtask.Progress{Phase: "Unmonitoring jobs"})
 
 	err = a.jobSupervisor.Unmonitor()
 	if err != nil {
@@ -85,6 +102,8 @@ func (a DrainAction) Run(drainType DrainType, newSpecs ...boshas.V1ApplySpec) (i
 
 	script := a.jobScriptProvider.NewParallelScript("drain", scripts)
 
+	a.progress.ReportProgress(boshtask.Progress{Phase: "Running drain scripts"})
+
 	resultsCh := make(chan error, 1)
 	go func() { resultsCh <- script.Run() }()
 	select {
@@ -14,6 +14,7 @@ import (
 	boshdrain "github.com/cloudfoundry/bosh-agent/agent/script/drain"
 	fakedrain "github.com/cloudfoundry/bosh-agent/agent/script/drain/fakes"
 	fakescript "github.com/cloudfoundry/bosh-agent/agent/script/fakes"
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
 	fakejobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor/fakes"
 	fakenotif "github.com/cloudfoundry/bosh-agent/notification/fakes"
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
@@ -60,6 +61,10 @@ var _ = Describe("DrainAction", func() {
 		Expect(action.IsPersistent()).To(BeFalse())
 	})
 
+	It("runs in exclusive concurrency class", func() {
+		Expect(action.ConcurrencyClass()).To(Equal(boshtask.ConcurrencyExclusive))
+	})
+
 	Describe("Run", func() {
 		var (
 			parallelScript *fakescript.FakeCancellableScript
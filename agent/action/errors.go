@@ -0,0 +1,26 @@
+package action
+
+import (
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+func newTaskNotFoundError(taskID string) error {
+	err := bosherr.Errorf("Task with id %s could not be found", taskID)
+
+	return boshcodederr.NewCodedError(boshcodederr.ErrorCodeTaskNotFound, err).WithDetails(map[string]interface{}{
+		"agent_task_id": taskID,
+	})
+}
+
+func newDiskNotFoundError(diskCID string) error {
+	err := bosherr.Errorf("Persistent disk with volume id '%s' could not be found", diskCID)
+
+	return boshcodederr.NewCodedError(boshcodederr.ErrorCodeDiskNotFound, err).WithDetails(map[string]interface{}{
+		"disk_cid": diskCID,
+	})
+}
+
+func newBlobstoreUnavailableError(err error) error {
+	return boshcodederr.NewCodedError(boshcodederr.ErrorCodeBlobstoreUnavailable, err)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	boshaction "github.com/cloudfoundry/bosh-agent/agent/action"
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
 )
 
 type FakeFactory struct {
@@ -46,6 +47,7 @@ func (f *FakeFactory) RegisterActionErr(method string, err error) {
 type TestAction struct {
 	Asynchronous bool
 	Persistent   bool
+	Concurrency  boshtask.ConcurrencyClass
 
 	ResumeValue interface{}
 	ResumeErr   error
@@ -53,6 +55,19 @@ type TestAction struct {
 
 	Canceled  bool
 	CancelErr error
+
+	ProgressReporter boshtask.ProgressReporter
+	CancelSignal     *boshtask.CancelSignal
+}
+
+func (a *TestAction) WithProgressReporter(progress boshtask.ProgressReporter) boshaction.Action {
+	a.ProgressReporter = progress
+	return a
+}
+
+func (a *TestAction) WithCancelSignal(cancelSignal *boshtask.CancelSignal) boshaction.Action {
+	a.CancelSignal = cancelSignal
+	return a
 }
 
 func (a *TestAction) IsAsynchronous() bool {
@@ -63,6 +78,10 @@ func (a *TestAction) IsPersistent() bool {
 	return a.Persistent
 }
 
+func (a *TestAction) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return a.Concurrency
+}
+
 func (a *TestAction) Run(payload []byte) (interface{}, error) {
 	return nil, nil
 }
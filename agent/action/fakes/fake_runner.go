@@ -14,6 +14,10 @@ type FakeRunner struct {
 	ResumePayload []byte
 	ResumeValue   interface{}
 	ResumeErr     error
+
+	ValidateAction  boshaction.Action
+	ValidatePayload []byte
+	ValidateErr     error
 }
 
 func (runner *FakeRunner) Run(action boshaction.Action, payload []byte) (interface{}, error) {
@@ -27,3 +31,9 @@ func (runner *FakeRunner) Resume(action boshaction.Action, payload []byte) (inte
 	runner.ResumePayload = payload
 	return runner.ResumeValue, runner.ResumeErr
 }
+
+func (runner *FakeRunner) Validate(action boshaction.Action, payload []byte) error {
+	runner.ValidateAction = action
+	runner.ValidatePayload = payload
+	return runner.ValidateErr
+}
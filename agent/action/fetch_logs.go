@@ -3,6 +3,7 @@ package action
 import (
 	"errors"
 
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
 	boshdirs "github.com/cloudfoundry/bosh-agent/settings/directories"
 	boshblob "github.com/cloudfoundry/bosh-utils/blobstore"
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
@@ -14,6 +15,9 @@ type FetchLogsAction struct {
 	copier      boshcmd.Copier
 	blobstore   boshblob.Blobstore
 	settingsDir boshdirs.Provider
+
+	progress     boshtask.ProgressReporter
+	cancelSignal *boshtask.CancelSignal
 }
 
 func NewFetchLogs(
@@ -26,6 +30,8 @@ func NewFetchLogs(
 	action.copier = copier
 	action.blobstore = blobstore
 	action.settingsDir = settingsDir
+	action.progress = boshtask.NewNoopProgressReporter()
+	action.cancelSignal = boshtask.NewCancelSignal()
 	return
 }
 
@@ -37,6 +43,24 @@ func (a FetchLogsAction) IsPersistent() bool {
 	return false
 }
 
+func (a FetchLogsAction) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return boshtask.ConcurrencyShared
+}
+
+func (a FetchLogsAction) WithProgressReporter(progress boshtask.ProgressReporter) Action {
+	a.progress = progress
+	return a
+}
+
+func (a FetchLogsAction) IsCancellable() bool {
+	return true
+}
+
+func (a FetchLogsAction) WithCancelSignal(cancelSignal *boshtask.CancelSignal) Action {
+	a.cancelSignal = cancelSignal
+	return a
+}
+
 func (a FetchLogsAction) Run(logType string, filters []string) (value map[string]string, err error) {
 	var logsDir string
 
@@ -56,17 +80,27 @@ func (a FetchLogsAction) Run(logType string, filters []string) (value map[string
 		return
 	}
 
-	tmpDir, err := a.copier.FilteredCopyToTemp(logsDir, filters)
+	a.progress.ReportProgress(boshtask.Progress{Phase: "Copying logs"})
+
+	// Copying and compressing shell out to commands that are terminated once cancelled
+	tmpDir, err := a.cancelSignal.Copier(a.copier).FilteredCopyToTemp(logsDir, filters)
 	if err != nil {
-		err = bosherr.WrapError(err, "Copying filtered files to temp directory")
+		err = bosherr.WrapError(a.cancelSignal.ErrOr(err), "Copying filtered files to temp directory")
 		return
 	}
 
 	defer a.copier.CleanUp(tmpDir)
 
-	tarball, err := a.compressor.CompressFilesInDir(tmpDir)
+	a.progress.ReportProgress(boshtask.Progress{Phase: "Compressing logs"})
+
+	err = a.cancelSignal.Err()
+	if err != nil {
+		return
+	}
+
+	tarball, err := a.cancelSignal.Compressor(a.compressor).CompressFilesInDir(tmpDir)
 	if err != nil {
-		err = bosherr.WrapError(err, "Making logs tarball")
+		err = bosherr.WrapError(a.cancelSignal.ErrOr(err), "Making logs tarball")
 		return
 	}
 
@@ -74,9 +108,16 @@ func (a FetchLogsAction) Run(logType string, filters []string) (value map[string
 		_ = a.compressor.CleanUp(tarball)
 	}()
 
+	err = a.cancelSignal.Err()
+	if err != nil {
+		return
+	}
+
+	a.progress.ReportProgress(boshtask.Progress{Phase: "Uploading logs"})
+
 	blobID, _, err := a.blobstore.Create(tarball)
 	if err != nil {
-		err = bosherr.WrapError(err, "Create file on blobstore")
+		err = newBlobstoreUnavailableError(bosherr.WrapError(err, "Create file on blobstore"))
 		return
 	}
 
@@ -89,5 +130,6 @@ func (a FetchLogsAction) Resume() (interface{}, error) {
 }
 
 func (a FetchLogsAction) Cancel() error {
-	return errors.New("not supported")
+	a.cancelSignal.Cancel()
+	return nil
 }
@@ -7,6 +7,9 @@ import (
 	. "github.com/onsi/gomega"
 
 	. "github.com/cloudfoundry/bosh-agent/agent/action"
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
+	faketask "github.com/cloudfoundry/bosh-agent/agent/task/fakes"
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
 	boshdirs "github.com/cloudfoundry/bosh-agent/settings/directories"
 	boshassert "github.com/cloudfoundry/bosh-utils/assert"
 	fakeblobstore "github.com/cloudfoundry/bosh-utils/blobstore/fakes"
@@ -38,6 +41,10 @@ var _ = Describe("FetchLogsAction", func() {
 		Expect(action.IsPersistent()).To(BeFalse())
 	})
 
+	It("runs in shared concurrency class", func() {
+		Expect(action.ConcurrencyClass()).To(Equal(boshtask.ConcurrencyShared))
+	})
+
 	Describe("Run", func() {
 		testLogs := func(logType string, filters []string, expectedFilters []string) {
 			copier.FilteredCopyToTempTempDir = "/fake-temp-dir"
@@ -114,5 +121,51 @@ var _ = Describe("FetchLogsAction", func() {
 			afterCleanUpTarballPath = compressor.CleanUpTarballPath
 			Expect(afterCleanUpTarballPath).To(Equal("/fake-compressed-logs.tar"))
 		})
+
+		It("reports progress of each phase", func() {
+			progress := faketask.NewFakeProgressReporter()
+
+			_, err := action.WithProgressReporter(progress).(FetchLogsAction).Run("job", []string{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(progress.Phases()).To(Equal([]string{"Copying logs", "Compressing logs", "Uploading logs"}))
+		})
+	})
+
+	Describe("Cancel", func() {
+		It("is cancellable", func() {
+			Expect(action.IsCancellable()).To(BeTrue())
+		})
+
+		It("stops before the next phase and cleans up copied logs", func() {
+			copier.FilteredCopyToTempTempDir = "/fake-temp-dir"
+
+			cancellableAction := action.WithCancelSignal(boshtask.NewCancelSignal()).(FetchLogsAction)
+
+			progress := faketask.NewFakeProgressReporter()
+			progress.ReportProgressCallBack = func(progress boshtask.Progress) {
+				if progress.Phase == "Compressing logs" {
+					Expect(cancellableAction.Cancel()).ToNot(HaveOccurred())
+				}
+			}
+
+			_, err := cancellableAction.WithProgressReporter(progress).(FetchLogsAction).Run("job", []string{})
+			Expect(err).To(HaveOccurred())
+
+			codedErr, found := boshcodederr.FindCodedError(err)
+			Expect(found).To(BeTrue())
+			Expect(codedErr.Code).To(Equal(boshcodederr.ErrorCodeCancelled))
+
+			Expect(compressor.CompressFilesInDirDir).To(BeEmpty())
+			Expect(blobstore.CreateFileNames).To(BeEmpty())
+			Expect(copier.CleanUpTempDir).To(Equal("/fake-temp-dir"))
+		})
+
+		It("does not affect copies of the action with other cancel signals", func() {
+			cancelledAction := action.WithCancelSignal(boshtask.NewCancelSignal())
+			Expect(cancelledAction.Cancel()).ToNot(HaveOccurred())
+
+			_, err := action.WithCancelSignal(boshtask.NewCancelSignal()).(FetchLogsAction).Run("job", []string{})
+			Expect(err).ToNot(HaveOccurred())
+		})
 	})
 })
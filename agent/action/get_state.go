@@ -4,7 +4,9 @@ import (
 	"errors"
 
 	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
 	boshjobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor"
+	boshmbus "github.com/cloudfoundry/bosh-agent/mbus"
 	boshntp "github.com/cloudfoundry/bosh-agent/platform/ntp"
 	boshvitals "github.com/cloudfoundry/bosh-agent/platform/vitals"
 	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
@@ -17,6 +19,9 @@ type GetStateAction struct {
 	jobSupervisor   boshjobsuper.JobSupervisor
 	vitalsService   boshvitals.Service
 	ntpService      boshntp.Service
+
+	// Set when agent fails over between several mbus endpoints
+	mbusStatusReporter boshmbus.EndpointStatusReporter
 }
 
 func NewGetState(
@@ -25,12 +30,14 @@ func NewGetState(
 	jobSupervisor boshjobsuper.JobSupervisor,
 	vitalsService boshvitals.Service,
 	ntpService boshntp.Service,
+	mbusStatusReporter boshmbus.EndpointStatusReporter,
 ) (action GetStateAction) {
 	action.settingsService = settingsService
 	action.specService = specService
 	action.jobSupervisor = jobSupervisor
 	action.vitalsService = vitalsService
 	action.ntpService = ntpService
+	action.mbusStatusReporter = mbusStatusReporter
 	return
 }
 
@@ -42,6 +49,10 @@ func (a GetStateAction) IsPersistent() bool {
 	return false
 }
 
+func (a GetStateAction) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return boshtask.ConcurrencyShared
+}
+
 type GetStateV1ApplySpec struct {
 	boshas.V1ApplySpec
 
@@ -52,6 +63,8 @@ type GetStateV1ApplySpec struct {
 	Processes    []boshjobsuper.Process `json:"processes,omitempty"`
 	VM           boshsettings.VM        `json:"vm"`
 	Ntp          boshntp.Info           `json:"ntp"`
+
+	Mbus *boshmbus.EndpointStatus `json:"mbus,omitempty"`
 }
 
 func (a GetStateAction) Run(filters ...string) (GetStateV1ApplySpec, error) {
@@ -87,6 +100,12 @@ func (a GetStateAction) Run(filters ...string) (GetStateV1ApplySpec, error) {
 		processes,
 		settings.VM,
 		a.ntpService.GetInfo(),
+		nil,
+	}
+
+	if a.mbusStatusReporter != nil {
+		mbusStatus := a.mbusStatusReporter.EndpointStatus()
+		value.Mbus = &mbusStatus
 	}
 
 	if value.NetworkSpecs == nil {
@@ -11,6 +11,8 @@ import (
 	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
 	boshjobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor"
 	fakejobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor/fakes"
+	boshmbus "github.com/cloudfoundry/bosh-agent/mbus"
+	fakembus "github.com/cloudfoundry/bosh-agent/mbus/fakes"
 	boshntp "github.com/cloudfoundry/bosh-agent/platform/ntp"
 	fakentp "github.com/cloudfoundry/bosh-agent/platform/ntp/fakes"
 	boshvitals "github.com/cloudfoundry/bosh-agent/platform/vitals"
@@ -26,6 +28,7 @@ var _ = Describe("GetState", func() {
 		specService     *fakeas.FakeV1Service
 		jobSupervisor   *fakejobsuper.FakeJobSupervisor
 		vitalsService   *fakevitals.FakeService
+		ntpService      *fakentp.FakeService
 		action          GetStateAction
 	)
 
@@ -34,13 +37,13 @@ var _ = Describe("GetState", func() {
 		jobSupervisor = fakejobsuper.NewFakeJobSupervisor()
 		specService = fakeas.NewFakeV1Service()
 		vitalsService = fakevitals.NewFakeService()
-		ntpService := &fakentp.FakeService{
+		ntpService = &fakentp.FakeService{
 			GetOffsetNTPOffset: boshntp.Info{
 				Offset:    "0.34958",
 				Timestamp: "12 Oct 17:37:58",
 			},
 		}
-		action = NewGetState(settingsService, specService, jobSupervisor, vitalsService, ntpService)
+		action = NewGetState(settingsService, specService, jobSupervisor, vitalsService, ntpService, nil)
 	})
 
 	It("get state should be synchronous", func() {
@@ -92,6 +95,32 @@ var _ = Describe("GetState", func() {
 					Expect(state).To(Equal(expectedSpec))
 				})
 
+				It("returns status of mbus endpoints when agent fails over between them", func() {
+					mbusStatus := boshmbus.EndpointStatus{
+						Active: "nats://fake-nats-2:4222",
+						Endpoints: []boshmbus.EndpointHealth{
+							{URL: "nats://fake-nats-1:4222", Healthy: false},
+							{URL: "nats://fake-nats-2:4222", Healthy: true},
+						},
+					}
+
+					mbusStatusReporter := &fakembus.FakeEndpointStatusReporter{Status: mbusStatus}
+					action = NewGetState(settingsService, specService, jobSupervisor, vitalsService, ntpService, mbusStatusReporter)
+
+					state, err := action.Run()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(state.Mbus).To(Equal(&mbusStatus))
+
+					boshassert.MatchesJSONString(GinkgoT(), state.Mbus, `{"active":"nats://fake-nats-2:4222","endpoints":[`+
+						`{"url":"nats://fake-nats-1:4222","healthy":false},{"url":"nats://fake-nats-2:4222","healthy":true}]}`)
+				})
+
+				It("does not return status of mbus endpoints when agent uses single endpoint", func() {
+					state, err := action.Run()
+					Expect(err).ToNot(HaveOccurred())
+					boshassert.LacksJSONKey(GinkgoT(), state, "mbus")
+				})
+
 				It("returns state in full format", func() {
 					settingsService.Settings.AgentID = "my-agent-id"
 					settingsService.Settings.VM.Name = "vm-abc-def"
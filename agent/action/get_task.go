@@ -24,16 +24,22 @@ func (a GetTaskAction) IsPersistent() bool {
 	return false
 }
 
+func (a GetTaskAction) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return boshtask.ConcurrencyShared
+}
+
 func (a GetTaskAction) Run(taskID string) (interface{}, error) {
 	task, found := a.taskService.FindTaskWithID(taskID)
 	if !found {
-		return nil, bosherr.Errorf("Task with id %s could not be found", taskID)
+		return nil, newTaskNotFoundError(taskID)
 	}
 
-	if task.State == boshtask.StateRunning {
+	// Cancelled task is reported by its state rather than as a failure
+	if task.State == boshtask.StateRunning || task.State == boshtask.StateCancelled {
 		return boshtask.StateValue{
 			AgentTaskID: task.ID,
 			State:       task.State,
+			Progress:    task.LatestProgress(),
 		}, nil
 	}
 
@@ -2,6 +2,7 @@ package action_test
 
 import (
 	"errors"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -45,6 +46,25 @@ var _ = Describe("GetTask", func() {
 			`{"agent_task_id":"fake-task-id","state":"running"}`)
 	})
 
+	It("returns latest progress of a running task", func() {
+		percentage := 50
+
+		taskService.StartedTasks["fake-task-id"] = boshtask.Task{
+			ID:    "fake-task-id",
+			State: boshtask.StateRunning,
+			Events: []boshtask.Progress{
+				{Sequence: 1, Time: time.Unix(100, 0).UTC(), Phase: "fake-phase-1"},
+				{Sequence: 2, Time: time.Unix(200, 0).UTC(), Phase: "fake-phase-2", Percentage: &percentage},
+			},
+		}
+
+		taskValue, err := action.Run("fake-task-id")
+		Expect(err).ToNot(HaveOccurred())
+
+		boshassert.MatchesJSONString(GinkgoT(), taskValue,
+			`{"agent_task_id":"fake-task-id","state":"running","progress":{"sequence":2,"time":"1970-01-01T00:03:20Z","phase":"fake-phase-2","percentage":50}}`)
+	})
+
 	It("returns a failed task", func() {
 		taskService.StartedTasks["fake-task-id"] = boshtask.Task{
 			ID:    "fake-task-id",
@@ -58,6 +78,20 @@ var _ = Describe("GetTask", func() {
 		Expect(taskValue).To(BeNil())
 	})
 
+	It("returns a cancelled task by its state", func() {
+		taskService.StartedTasks["fake-task-id"] = boshtask.Task{
+			ID:    "fake-task-id",
+			State: boshtask.StateCancelled,
+			Error: errors.New("Task was cancelled"),
+		}
+
+		taskValue, err := action.Run("fake-task-id")
+		Expect(err).ToNot(HaveOccurred())
+
+		boshassert.MatchesJSONString(GinkgoT(), taskValue,
+			`{"agent_task_id":"fake-task-id","state":"cancelled"}`)
+	})
+
 	It("returns a successful task", func() {
 		taskService.StartedTasks["fake-task-id"] = boshtask.Task{
 			ID:    "fake-task-id",
@@ -0,0 +1,102 @@
+package action
+
+import (
+	"errors"
+	"time"
+
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
+	"github.com/pivotal-golang/clock"
+)
+
+type ListTasksAction struct {
+	taskService boshtask.Service
+	timeService clock.Clock
+}
+
+type TaskListEntry struct {
+	AgentTaskID string         `json:"agent_task_id"`
+	Method      string         `json:"method"`
+	State       boshtask.State `json:"state"`
+	StartedAt   *time.Time     `json:"started_at,omitempty"`
+	FinishedAt  *time.Time     `json:"finished_at,omitempty"`
+
+	// Duration in seconds; for running tasks it is measured until now
+	// and it is zero for queued tasks that did not start yet
+	Duration float64 `json:"duration"`
+}
+
+func NewListTasks(taskService boshtask.Service, timeService clock.Clock) (action ListTasksAction) {
+	action.taskService = taskService
+	action.timeService = timeService
+	return
+}
+
+func (a ListTasksAction) IsAsynchronous() bool {
+	return false
+}
+
+func (a ListTasksAction) IsPersistent() bool {
+	return false
+}
+
+func (a ListTasksAction) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return boshtask.ConcurrencyShared
+}
+
+// Run lists known tasks; if states are given only tasks in those states are included
+func (a ListTasksAction) Run(states ...boshtask.State) ([]TaskListEntry, error) {
+	entries := []TaskListEntry{}
+
+	for _, task := range a.taskService.ListTasks() {
+		if !a.matchesStates(task, states) {
+			continue
+		}
+
+		entry := TaskListEntry{
+			AgentTaskID: task.ID,
+			Method:      task.Method,
+			State:       task.State,
+		}
+
+		if !task.StartedAt.IsZero() {
+			startedAt := task.StartedAt
+			entry.StartedAt = &startedAt
+
+			endedAt := a.timeService.Now()
+
+			if !task.FinishedAt.IsZero() {
+				finishedAt := task.FinishedAt
+				entry.FinishedAt = &finishedAt
+				endedAt = finishedAt
+			}
+
+			entry.Duration = endedAt.Sub(task.StartedAt).Seconds()
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func (a ListTasksAction) matchesStates(task boshtask.Task, states []boshtask.State) bool {
+	if len(states) == 0 {
+		return true
+	}
+
+	for _, state := range states {
+		if task.State == state {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a ListTasksAction) Resume() (interface{}, error) {
+	return nil, errors.New("not supported")
+}
+
+func (a ListTasksAction) Cancel() error {
+	return errors.New("not supported")
+}
@@ -0,0 +1,114 @@
+package action_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/agent/action"
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
+	faketask "github.com/cloudfoundry/bosh-agent/agent/task/fakes"
+	"github.com/pivotal-golang/clock/fakeclock"
+)
+
+var _ = Describe("ListTasks", func() {
+	var (
+		taskService *faketask.FakeService
+		timeService *fakeclock.FakeClock
+		action      ListTasksAction
+	)
+
+	BeforeEach(func() {
+		taskService = faketask.NewFakeService()
+		timeService = fakeclock.NewFakeClock(time.Unix(1100, 0))
+		action = NewListTasks(taskService, timeService)
+
+		taskService.StartedTasks["fake-running-task-id"] = boshtask.Task{
+			ID:        "fake-running-task-id",
+			Method:    "fake-running-method",
+			State:     boshtask.StateRunning,
+			StartedAt: time.Unix(1000, 0),
+		}
+
+		taskService.StartedTasks["fake-done-task-id"] = boshtask.Task{
+			ID:         "fake-done-task-id",
+			Method:     "fake-done-method",
+			State:      boshtask.StateDone,
+			StartedAt:  time.Unix(900, 0),
+			FinishedAt: time.Unix(950, 0),
+		}
+	})
+
+	It("is synchronous", func() {
+		Expect(action.IsAsynchronous()).To(BeFalse())
+	})
+
+	It("is not persistent", func() {
+		Expect(action.IsPersistent()).To(BeFalse())
+	})
+
+	It("returns all tasks when no states are given", func() {
+		entries, err := action.Run()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(HaveLen(2))
+	})
+
+	It("reports duration until now for running tasks", func() {
+		startedAt := time.Unix(1000, 0)
+
+		entries, err := action.Run(boshtask.StateRunning)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(Equal([]TaskListEntry{
+			{
+				AgentTaskID: "fake-running-task-id",
+				Method:      "fake-running-method",
+				State:       boshtask.StateRunning,
+				StartedAt:   &startedAt,
+				Duration:    100,
+			},
+		}))
+	})
+
+	It("reports finish time and duration for finished tasks", func() {
+		startedAt := time.Unix(900, 0)
+		finishedAt := time.Unix(950, 0)
+
+		entries, err := action.Run(boshtask.StateDone, boshtask.StateFailed)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(Equal([]TaskListEntry{
+			{
+				AgentTaskID: "fake-done-task-id",
+				Method:      "fake-done-method",
+				State:       boshtask.StateDone,
+				StartedAt:   &startedAt,
+				FinishedAt:  &finishedAt,
+				Duration:    50,
+			},
+		}))
+	})
+
+	It("reports neither start time nor duration for queued tasks", func() {
+		taskService.StartedTasks["fake-running-task-id"] = boshtask.Task{
+			ID:     "fake-running-task-id",
+			Method: "fake-running-method",
+			State:  boshtask.StateRunning,
+		}
+
+		entries, err := action.Run(boshtask.StateRunning)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(Equal([]TaskListEntry{
+			{
+				AgentTaskID: "fake-running-task-id",
+				Method:      "fake-running-method",
+				State:       boshtask.StateRunning,
+			},
+		}))
+	})
+
+	It("returns empty list when no tasks match", func() {
+		entries, err := action.Run(boshtask.StateFailed)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(BeEmpty())
+	})
+})
@@ -3,6 +3,7 @@ package action
 import (
 	"errors"
 
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
 	boshplatform "github.com/cloudfoundry/bosh-agent/platform"
 	boshdirs "github.com/cloudfoundry/bosh-agent/settings/directories"
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
@@ -30,6 +31,10 @@ func (a MigrateDiskAction) IsPersistent() bool {
 	return false
 }
 
+func (a MigrateDiskAction) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return boshtask.ConcurrencyExclusive
+}
+
 func (a MigrateDiskAction) Run() (value interface{}, err error) {
 	err = a.platform.MigratePersistentDisk(a.dirProvider.StoreDir(), a.dirProvider.StoreMigrationDir())
 	if err != nil {
@@ -3,6 +3,7 @@ package action
 import (
 	"errors"
 
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
 	boshdpresolv "github.com/cloudfoundry/bosh-agent/infrastructure/devicepathresolver"
 	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
 	boshdirs "github.com/cloudfoundry/bosh-agent/settings/directories"
@@ -45,6 +46,10 @@ func (a MountDiskAction) IsPersistent() bool {
 	return false
 }
 
+func (a MountDiskAction) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return boshtask.ConcurrencyExclusive
+}
+
 func (a MountDiskAction) Run(diskCid string) (interface{}, error) {
 	err := a.settingsService.LoadSettings()
 	if err != nil {
@@ -55,7 +60,7 @@ func (a MountDiskAction) Run(diskCid string) (interface{}, error) {
 
 	diskSettings, found := settings.PersistentDiskSettings(diskCid)
 	if !found {
-		return nil, bosherr.Errorf("Persistent disk with volume id '%s' could not be found", diskCid)
+		return nil, newDiskNotFoundError(diskCid)
 	}
 
 	mountPoint := a.dirProvider.StoreDir()
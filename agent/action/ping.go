@@ -2,6 +2,8 @@ package action
 
 import (
 	"errors"
+
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
 )
 
 type PingAction struct{}
@@ -18,6 +20,10 @@ func (a PingAction) IsPersistent() bool {
 	return false
 }
 
+func (a PingAction) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return boshtask.ConcurrencyShared
+}
+
 func (a PingAction) Run() (string, error) {
 	return "pong", nil
 }
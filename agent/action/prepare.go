@@ -5,15 +5,18 @@ import (
 
 	boshappl "github.com/cloudfoundry/bosh-agent/agent/applier"
 	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 )
 
 type PrepareAction struct {
-	applier boshappl.Applier
+	applier  boshappl.Applier
+	progress boshtask.ProgressReporter
 }
 
 func NewPrepare(applier boshappl.Applier) (action PrepareAction) {
 	action.applier = applier
+	action.progress = boshtask.NewNoopProgressReporter()
 	return action
 }
 
@@ -25,8 +28,17 @@ func (a PrepareAction) IsPersistent() bool {
 	return false
 }
 
+func (a PrepareAction) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return boshtask.ConcurrencyExclusive
+}
+
+func (a PrepareAction) WithProgressReporter(progress boshtask.ProgressReporter) Action {
+	a.progress = progress
+	return a
+}
+
 func (a PrepareAction) Run(desiredSpec boshas.V1ApplySpec) (string, error) {
-	err := a.applier.Prepare(desiredSpec)
+	err := a.applier.Prepare(desiredSpec, a.progress)
 	if err != nil {
 		return "", bosherr.WrapError(err, "Preparing apply spec")
 	}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"time"
 
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
 	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 	boshsys "github.com/cloudfoundry/bosh-utils/system"
@@ -36,6 +37,10 @@ func (a PrepareNetworkChangeAction) IsPersistent() bool {
 	return false
 }
 
+func (a PrepareNetworkChangeAction) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return boshtask.ConcurrencyShared
+}
+
 func (a PrepareNetworkChangeAction) Run() (interface{}, error) {
 
 	err := a.settingsService.InvalidateSettings()
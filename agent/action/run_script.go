@@ -5,6 +5,7 @@ import (
 
 	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
 	boshscript "github.com/cloudfoundry/bosh-agent/agent/script"
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
 )
@@ -12,6 +13,7 @@ import (
 type RunScriptAction struct {
 	scriptProvider boshscript.JobScriptProvider
 	specService    boshas.V1Service
+	cancelSignal   *boshtask.CancelSignal
 
 	logTag string
 	logger boshlog.Logger
@@ -25,6 +27,7 @@ func NewRunScript(
 	return RunScriptAction{
 		scriptProvider: scriptProvider,
 		specService:    specService,
+		cancelSignal:   boshtask.NewCancelSignal(),
 
 		logTag: "RunScript Action",
 		logger: logger,
@@ -39,6 +42,19 @@ func (a RunScriptAction) IsPersistent() bool {
 	return false
 }
 
+func (a RunScriptAction) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return boshtask.ConcurrencyExclusive
+}
+
+func (a RunScriptAction) IsCancellable() bool {
+	return true
+}
+
+func (a RunScriptAction) WithCancelSignal(cancelSignal *boshtask.CancelSignal) Action {
+	a.cancelSignal = cancelSignal
+	return a
+}
+
 func (a RunScriptAction) Run(scriptName string, options map[string]interface{}) (map[string]string, error) {
 	// May be used in future to return more information
 	emptyResults := map[string]string{}
@@ -57,7 +73,24 @@ func (a RunScriptAction) Run(scriptName string, options map[string]interface{})
 
 	parallelScript := a.scriptProvider.NewParallelScript(scriptName, scripts)
 
-	return emptyResults, parallelScript.Run()
+	resultCh := make(chan error, 1)
+	go func() { resultCh <- parallelScript.Run() }()
+
+	select {
+	case err = <-resultCh:
+	case <-a.cancelSignal.Done():
+		a.logger.Debug(a.logTag, "Got a cancel request")
+
+		// Terminated scripts make Run return
+		cancelErr := parallelScript.Cancel()
+		if cancelErr != nil {
+			a.logger.Error(a.logTag, "Failed to cancel %s scripts: %s", scriptName, cancelErr.Error())
+		}
+
+		err = <-resultCh
+	}
+
+	return emptyResults, err
 }
 
 func (a RunScriptAction) Resume() (interface{}, error) {
@@ -65,5 +98,6 @@ func (a RunScriptAction) Resume() (interface{}, error) {
 }
 
 func (a RunScriptAction) Cancel() error {
-	return errors.New("not supported")
+	a.cancelSignal.Cancel()
+	return nil
 }
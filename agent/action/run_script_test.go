@@ -11,6 +11,7 @@ import (
 	fakeapplyspec "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
 	boshscript "github.com/cloudfoundry/bosh-agent/agent/script"
 	fakescript "github.com/cloudfoundry/bosh-agent/agent/script/fakes"
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
 )
 
@@ -36,6 +37,10 @@ var _ = Describe("RunScript", func() {
 		Expect(action.IsPersistent()).To(BeFalse())
 	})
 
+	It("is cancellable", func() {
+		Expect(action.IsCancellable()).To(BeTrue())
+	})
+
 	Describe("Run", func() {
 		act := func() (map[string]string, error) { return action.Run("run-me", map[string]interface{}{}) }
 
@@ -94,6 +99,28 @@ var _ = Describe("RunScript", func() {
 				Expect(err.Error()).To(ContainSubstring("fake-error"))
 				Expect(results).To(Equal(map[string]string{}))
 			})
+
+			It("cancels running parallel script when cancelled", func() {
+				cancellableAction := action.WithCancelSignal(boshtask.NewCancelSignal()).(RunScriptAction)
+
+				cancelledCh := make(chan struct{})
+
+				parallelScript.RunStub = func() error {
+					Expect(cancellableAction.Cancel()).ToNot(HaveOccurred())
+					<-cancelledCh
+					return errors.New("fake-cancelled-error")
+				}
+
+				parallelScript.CancelStub = func() error {
+					close(cancelledCh)
+					return nil
+				}
+
+				_, err := cancellableAction.Run("run-me", map[string]interface{}{})
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("fake-cancelled-error"))
+				Expect(parallelScript.CancelCallCount()).To(Equal(1))
+			})
 		})
 
 		Context("when current spec cannot be retrieved", func() {
@@ -5,12 +5,17 @@ import (
 	"encoding/json"
 	"reflect"
 
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 )
 
 type Runner interface {
 	Run(action Action, payload []byte) (value interface{}, err error)
 	Resume(action Action, payload []byte) (value interface{}, err error)
+
+	// Validate checks that payload includes arguments
+	// expected by action's Run method without running it
+	Validate(action Action, payload []byte) (err error)
 }
 
 func NewRunner() Runner {
@@ -20,14 +25,28 @@ func NewRunner() Runner {
 type concreteRunner struct{}
 
 func (r concreteRunner) Run(action Action, payloadBytes []byte) (value interface{}, err error) {
+	runMethodValue, methodArgs, err := r.prepareRun(action, payloadBytes)
+	if err != nil {
+		return
+	}
+
+	values := runMethodValue.Call(methodArgs)
+	return r.extractReturns(values)
+}
+
+func (r concreteRunner) Validate(action Action, payloadBytes []byte) (err error) {
+	_, _, err = r.prepareRun(action, payloadBytes)
+	return
+}
+
+func (r concreteRunner) prepareRun(action Action, payloadBytes []byte) (runMethodValue reflect.Value, methodArgs []reflect.Value, err error) {
 	payloadArgs, err := r.extractJSONArguments(payloadBytes)
 	if err != nil {
-		err = bosherr.WrapError(err, "Extracting json arguments")
+		err = boshcodederr.NewCodedError(boshcodederr.ErrorCodeInvalidArguments, bosherr.WrapError(err, "Extracting json arguments"))
 		return
 	}
 
-	actionValue := reflect.ValueOf(action)
-	runMethodValue := actionValue.MethodByName("Run")
+	runMethodValue = reflect.ValueOf(action).MethodByName("Run")
 	if runMethodValue.Kind() != reflect.Func {
 		err = bosherr.Error("Run method not found")
 		return
@@ -39,14 +58,13 @@ func (r concreteRunner) Run(action Action, payloadBytes []byte) (value interface
 		return
 	}
 
-	methodArgs, err := r.extractMethodArgs(runMethodType, payloadArgs)
+	methodArgs, err = r.extractMethodArgs(runMethodType, payloadArgs)
 	if err != nil {
-		err = bosherr.WrapError(err, "Extracting method arguments from payload")
+		err = boshcodederr.NewCodedError(boshcodederr.ErrorCodeInvalidArguments, bosherr.WrapError(err, "Extracting method arguments from payload"))
 		return
 	}
 
-	values := runMethodValue.Call(methodArgs)
-	return r.extractReturns(values)
+	return
 }
 
 func (r concreteRunner) Resume(action Action, payloadBytes []byte) (value interface{}, err error) {
@@ -114,6 +132,12 @@ func (r concreteRunner) extractMethodArgs(runMethodType reflect.Type, args []int
 			continue
 		}
 
+		err = NewJSONSchema(argType).Validate(argFromPayload)
+		if err != nil {
+			err = bosherr.WrapErrorf(err, "Invalid argument %d of type %s", i+1, argType)
+			return
+		}
+
 		argValuePtr := reflect.New(argType)
 
 		err = json.Unmarshal(rawArgBytes, argValuePtr.Interface())
@@ -147,8 +171,13 @@ func (r concreteRunner) getMethodArgType(methodType reflect.Type, index int) (ar
 func (r concreteRunner) extractReturns(values []reflect.Value) (value interface{}, err error) {
 	errValue := values[1]
 	if !errValue.IsNil() {
-		errorValues := errValue.MethodByName("Error").Call([]reflect.Value{})
-		err = bosherr.Error(errorValues[0].String())
+		// Keep returned error as is so that its code is not lost
+		if typedErr, ok := errValue.Interface().(error); ok {
+			err = typedErr
+		} else {
+			errorValues := errValue.MethodByName("Error").Call([]reflect.Value{})
+			err = bosherr.Error(errorValues[0].String())
+		}
 	}
 
 	value = values[0].Interface()
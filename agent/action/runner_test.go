@@ -10,6 +10,7 @@ import (
 
 	. "github.com/cloudfoundry/bosh-agent/agent/action"
 	fakeaction "github.com/cloudfoundry/bosh-agent/agent/action/fakes"
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
 )
 
 type valueType struct {
@@ -53,6 +54,10 @@ func (a *actionWithTypes) IsPersistent() bool {
 	return false
 }
 
+func (a *actionWithTypes) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return boshtask.ConcurrencyShared
+}
+
 func (a *actionWithTypes) Run(arg argumentWithTypes) (valueType, error) {
 	a.Arg = arg
 	return a.Value, a.Err
@@ -84,6 +89,10 @@ func (a *actionWithGoodRunMethod) IsPersistent() bool {
 	return false
 }
 
+func (a *actionWithGoodRunMethod) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return boshtask.ConcurrencyShared
+}
+
 func (a *actionWithGoodRunMethod) Run(subAction string, someID int, extraArgs argsType, sliceArgs []string) (valueType, error) {
 	a.SubAction = subAction
 	a.SomeID = someID
@@ -116,6 +125,10 @@ func (a *actionWithOptionalRunArgument) IsPersistent() bool {
 	return false
 }
 
+func (a *actionWithOptionalRunArgument) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return boshtask.ConcurrencyShared
+}
+
 func (a *actionWithOptionalRunArgument) Run(subAction string, optionalArgs ...argsType) (valueType, error) {
 	a.SubAction = subAction
 	a.OptionalArgs = optionalArgs
@@ -140,6 +153,10 @@ func (a *actionWithoutRunMethod) IsPersistent() bool {
 	return false
 }
 
+func (a *actionWithoutRunMethod) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return boshtask.ConcurrencyShared
+}
+
 func (a *actionWithoutRunMethod) Resume() (interface{}, error) {
 	return nil, nil
 }
@@ -158,6 +175,10 @@ func (a *actionWithOneRunReturnValue) IsPersistent() bool {
 	return false
 }
 
+func (a *actionWithOneRunReturnValue) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return boshtask.ConcurrencyShared
+}
+
 func (a *actionWithOneRunReturnValue) Run() error {
 	return nil
 }
@@ -180,6 +201,10 @@ func (a *actionWithSecondReturnValueNotError) IsPersistent() bool {
 	return false
 }
 
+func (a *actionWithSecondReturnValueNotError) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return boshtask.ConcurrencyShared
+}
+
 func (a *actionWithSecondReturnValueNotError) Run() (interface{}, string) {
 	return nil, ""
 }
@@ -248,6 +273,68 @@ func init() {
 			Expect(err).To(HaveOccurred())
 		})
 
+		It("runner run errs with location of mismatched value in the argument", func() {
+			runner := NewRunner()
+
+			action := &actionWithGoodRunMethod{}
+			payload := `{"arguments":["setup", 123, {"user":"rob","pwd":"rob123","id":"12"}, ["a"]]}`
+
+			_, err := runner.Run(action, []byte(payload))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Invalid argument 3 of type action_test.argsType: Expected integer at 'id', got string"))
+		})
+
+		It("runner run errs when number is given for integer argument", func() {
+			runner := NewRunner()
+
+			action := &actionWithGoodRunMethod{}
+			payload := `{"arguments":["setup", 1.5, {}, ["a"]]}`
+
+			_, err := runner.Run(action, []byte(payload))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Invalid argument 2 of type int: Expected integer, got number"))
+		})
+
+		It("runner run errs with location of mismatched value in optional arguments", func() {
+			runner := NewRunner()
+
+			action := &actionWithOptionalRunArgument{}
+			payload := `{"arguments":["setup", {"user":"rob"}, {"user":["bob"]}]}`
+
+			_, err := runner.Run(action, []byte(payload))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Invalid argument 3 of type action_test.argsType: Expected string at 'user', got array"))
+			Expect(action.SubAction).To(BeEmpty())
+		})
+
+		Describe("Validate", func() {
+			It("validates arguments without running action", func() {
+				runner := NewRunner()
+
+				action := &actionWithGoodRunMethod{}
+				payload := `{"arguments":["setup", 123, {"user":"rob","pwd":"rob123","id":12}, ["a"]]}`
+
+				err := runner.Validate(action, []byte(payload))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(action.SubAction).To(BeEmpty())
+			})
+
+			It("returns error when arguments do not match", func() {
+				runner := NewRunner()
+
+				err := runner.Validate(&actionWithGoodRunMethod{}, []byte(`{"arguments":["setup", 123, {}, [1]]}`))
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("Invalid argument 4 of type []string: Expected string at '[0]', got number"))
+			})
+
+			It("returns error when action does not implement run", func() {
+				runner := NewRunner()
+
+				err := runner.Validate(&actionWithoutRunMethod{}, []byte(`{"arguments":[]}`))
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
 		It("extracts argument types correctly", func() {
 			runner := NewRunner()
 
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"path"
 
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
 	boshplatform "github.com/cloudfoundry/bosh-agent/platform"
 	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
 	boshdirs "github.com/cloudfoundry/bosh-agent/settings/directories"
@@ -43,6 +44,10 @@ func (a SSHAction) IsPersistent() bool {
 	return false
 }
 
+func (a SSHAction) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return boshtask.ConcurrencyShared
+}
+
 type SSHParams struct {
 	UserRegex string `json:"user_regex"`
 	User      string
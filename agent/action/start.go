@@ -5,6 +5,7 @@ import (
 
 	boshappl "github.com/cloudfoundry/bosh-agent/agent/applier"
 	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
 	boshjobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor"
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 )
@@ -32,6 +33,10 @@ func (a StartAction) IsPersistent() bool {
 	return false
 }
 
+func (a StartAction) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return boshtask.ConcurrencyExclusive
+}
+
 func (a StartAction) Run() (value string, err error) {
 	desiredApplySpec, err := a.specService.Get()
 	if err != nil {
@@ -0,0 +1,70 @@
+package action
+
+import (
+	"errors"
+
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
+)
+
+type TaskEventsAction struct {
+	taskService boshtask.Service
+}
+
+type TaskEventsValue struct {
+	AgentTaskID string              `json:"agent_task_id"`
+	State       boshtask.State      `json:"state"`
+	Events      []boshtask.Progress `json:"events"`
+}
+
+func NewTaskEvents(taskService boshtask.Service) (action TaskEventsAction) {
+	action.taskService = taskService
+	return
+}
+
+func (a TaskEventsAction) IsAsynchronous() bool {
+	return false
+}
+
+func (a TaskEventsAction) IsPersistent() bool {
+	return false
+}
+
+func (a TaskEventsAction) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return boshtask.ConcurrencyShared
+}
+
+// Run returns progress events of the task; if afterSequence is given
+// only events with a greater sequence are included so that callers can poll
+func (a TaskEventsAction) Run(taskID string, afterSequence ...int) (TaskEventsValue, error) {
+	task, found := a.taskService.FindTaskWithID(taskID)
+	if !found {
+		return TaskEventsValue{}, newTaskNotFoundError(taskID)
+	}
+
+	after := 0
+	if len(afterSequence) > 0 {
+		after = afterSequence[0]
+	}
+
+	events := []boshtask.Progress{}
+
+	for _, event := range task.Events {
+		if event.Sequence > after {
+			events = append(events, event)
+		}
+	}
+
+	return TaskEventsValue{
+		AgentTaskID: task.ID,
+		State:       task.State,
+		Events:      events,
+	}, nil
+}
+
+func (a TaskEventsAction) Resume() (interface{}, error) {
+	return nil, errors.New("not supported")
+}
+
+func (a TaskEventsAction) Cancel() error {
+	return errors.New("not supported")
+}
@@ -0,0 +1,76 @@
+package action_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/agent/action"
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
+	faketask "github.com/cloudfoundry/bosh-agent/agent/task/fakes"
+)
+
+var _ = Describe("TaskEvents", func() {
+	var (
+		taskService *faketask.FakeService
+		action      TaskEventsAction
+	)
+
+	BeforeEach(func() {
+		taskService = faketask.NewFakeService()
+		action = NewTaskEvents(taskService)
+
+		taskService.StartedTasks["fake-task-id"] = boshtask.Task{
+			ID:    "fake-task-id",
+			State: boshtask.StateRunning,
+			Events: []boshtask.Progress{
+				{Sequence: 1, Phase: "fake-phase-1"},
+				{Sequence: 2, Phase: "fake-phase-2"},
+				{Sequence: 3, Phase: "fake-phase-3"},
+			},
+		}
+	})
+
+	It("is synchronous", func() {
+		Expect(action.IsAsynchronous()).To(BeFalse())
+	})
+
+	It("is not persistent", func() {
+		Expect(action.IsPersistent()).To(BeFalse())
+	})
+
+	It("returns all events of the task", func() {
+		value, err := action.Run("fake-task-id")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value.AgentTaskID).To(Equal("fake-task-id"))
+		Expect(value.State).To(Equal(boshtask.StateRunning))
+		Expect(value.Events).To(HaveLen(3))
+	})
+
+	It("returns only events after given sequence", func() {
+		value, err := action.Run("fake-task-id", 2)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value.Events).To(Equal([]boshtask.Progress{
+			{Sequence: 3, Phase: "fake-phase-3"},
+		}))
+	})
+
+	It("returns events of a finished task", func() {
+		taskService.StartedTasks = map[string]boshtask.Task{}
+		taskService.FinishedTasks["fake-task-id"] = boshtask.Task{
+			ID:     "fake-task-id",
+			State:  boshtask.StateDone,
+			Events: []boshtask.Progress{{Sequence: 1, Phase: "fake-phase-1"}},
+		}
+
+		value, err := action.Run("fake-task-id")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value.State).To(Equal(boshtask.StateDone))
+		Expect(value.Events).To(HaveLen(1))
+	})
+
+	It("returns error when task is not found", func() {
+		_, err := action.Run("fake-unknown-task-id")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(Equal("Task with id fake-unknown-task-id could not be found"))
+	})
+})
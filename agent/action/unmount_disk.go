@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
 	boshplatform "github.com/cloudfoundry/bosh-agent/platform"
 	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
@@ -31,12 +32,16 @@ func (a UnmountDiskAction) IsPersistent() bool {
 	return false
 }
 
+func (a UnmountDiskAction) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return boshtask.ConcurrencyExclusive
+}
+
 func (a UnmountDiskAction) Run(diskID string) (value interface{}, err error) {
 	settings := a.settingsService.GetSettings()
 
 	diskSettings, found := settings.PersistentDiskSettings(diskID)
 	if !found {
-		err = bosherr.Errorf("Persistent disk with volume id '%s' could not be found", diskID)
+		err = newDiskNotFoundError(diskID)
 		return
 	}
 
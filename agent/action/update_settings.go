@@ -3,19 +3,31 @@ package action
 import (
 	"errors"
 
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
+	boshmbus "github.com/cloudfoundry/bosh-agent/mbus"
 	"github.com/cloudfoundry/bosh-agent/platform/cert"
 	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 	"github.com/cloudfoundry/bosh-utils/logger"
 )
 
 type UpdateSettingsAction struct {
 	trustedCertManager cert.Manager
-	logger             logger.Logger
+
+	// Nil when mbus handler does not use certificates
+	mbusCertUpdater boshmbus.CertUpdater
+
+	logger logger.Logger
 }
 
-func NewUpdateSettings(trustedCertManager cert.Manager, logger logger.Logger) UpdateSettingsAction {
+func NewUpdateSettings(
+	trustedCertManager cert.Manager,
+	mbusCertUpdater boshmbus.CertUpdater,
+	logger logger.Logger,
+) UpdateSettingsAction {
 	return UpdateSettingsAction{
 		trustedCertManager: trustedCertManager,
+		mbusCertUpdater:    mbusCertUpdater,
 		logger:             logger,
 	}
 }
@@ -28,6 +40,10 @@ func (a UpdateSettingsAction) IsPersistent() bool {
 	return false
 }
 
+func (a UpdateSettingsAction) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return boshtask.ConcurrencyExclusive
+}
+
 func (a UpdateSettingsAction) Run(newSettings boshsettings.Settings) (string, error) {
 	a.logger.Info("update-settings-action", "Running Update Settings command")
 
@@ -36,6 +52,16 @@ func (a UpdateSettingsAction) Run(newSettings boshsettings.Settings) (string, er
 		return "", err
 	}
 
+	// Settings without mbus certificates keep the current ones
+	mbusCert := newSettings.Env.GetMbusCert()
+
+	if a.mbusCertUpdater != nil && !mbusCert.IsEmpty() {
+		err = a.mbusCertUpdater.UpdateCert(mbusCert)
+		if err != nil {
+			return "", bosherr.WrapError(err, "Updating mbus certificates")
+		}
+	}
+
 	return "updated", nil
 }
 
@@ -7,6 +7,7 @@ import (
 	"errors"
 
 	"github.com/cloudfoundry/bosh-agent/agent/action"
+	fakembus "github.com/cloudfoundry/bosh-agent/mbus/fakes"
 	"github.com/cloudfoundry/bosh-agent/platform/cert/fakes"
 	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
 	"github.com/cloudfoundry/bosh-utils/logger"
@@ -14,15 +15,17 @@ import (
 
 var _ = Describe("UpdateSettings", func() {
 	var (
-		updateAction action.UpdateSettingsAction
-		certManager  *fakes.FakeManager
-		log          logger.Logger
+		updateAction    action.UpdateSettingsAction
+		certManager     *fakes.FakeManager
+		mbusCertUpdater *fakembus.FakeCertUpdater
+		log             logger.Logger
 	)
 
 	BeforeEach(func() {
 		log = logger.NewLogger(logger.LevelNone)
 		certManager = new(fakes.FakeManager)
-		updateAction = action.NewUpdateSettings(certManager, log)
+		mbusCertUpdater = &fakembus.FakeCertUpdater{}
+		updateAction = action.NewUpdateSettings(certManager, mbusCertUpdater, log)
 	})
 
 	It("is asynchronous", func() {
@@ -45,7 +48,7 @@ var _ = Describe("UpdateSettings", func() {
 			log = logger.NewLogger(logger.LevelNone)
 			certManager = new(fakes.FakeManager)
 			certManager.UpdateCertificatesReturns(errors.New("Error"))
-			updateAction = action.NewUpdateSettings(certManager, log)
+			updateAction = action.NewUpdateSettings(certManager, mbusCertUpdater, log)
 		})
 
 		It("returns the error", func() {
@@ -55,4 +58,46 @@ var _ = Describe("UpdateSettings", func() {
 			Expect(result).To(BeEmpty())
 		})
 	})
+
+	Context("when settings include mbus certificates", func() {
+		var newSettings boshsettings.Settings
+
+		BeforeEach(func() {
+			newSettings = boshsettings.Settings{}
+			newSettings.Env.Bosh.Mbus.Cert = boshsettings.CertKeyPair{
+				CA:          "fake-ca",
+				Certificate: "fake-cert",
+				PrivateKey:  "fake-key",
+			}
+		})
+
+		It("updates mbus certificates", func() {
+			_, err := updateAction.Run(newSettings)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mbusCertUpdater.UpdateCertCert).To(Equal(newSettings.Env.Bosh.Mbus.Cert))
+		})
+
+		It("returns error if updating mbus certificates fails", func() {
+			mbusCertUpdater.UpdateCertErr = errors.New("fake-update-cert-err")
+
+			result, err := updateAction.Run(newSettings)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("fake-update-cert-err"))
+			Expect(result).To(BeEmpty())
+		})
+
+		It("does not update mbus certificates when mbus does not use them", func() {
+			updateAction = action.NewUpdateSettings(certManager, nil, log)
+
+			result, err := updateAction.Run(newSettings)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(Equal("updated"))
+		})
+	})
+
+	It("keeps current mbus certificates when settings do not include them", func() {
+		_, err := updateAction.Run(boshsettings.Settings{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mbusCertUpdater.UpdateCertCalled).To(BeFalse())
+	})
 })
@@ -0,0 +1,49 @@
+package action
+
+import (
+	"errors"
+
+	boshaudit "github.com/cloudfoundry/bosh-agent/agent/audit"
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+type VerifyAuditLogAction struct {
+	auditLog boshaudit.Log
+}
+
+func NewVerifyAuditLog(auditLog boshaudit.Log) (action VerifyAuditLogAction) {
+	action.auditLog = auditLog
+	return
+}
+
+func (a VerifyAuditLogAction) IsAsynchronous() bool {
+	return false
+}
+
+func (a VerifyAuditLogAction) IsPersistent() bool {
+	return false
+}
+
+func (a VerifyAuditLogAction) ConcurrencyClass() boshtask.ConcurrencyClass {
+	return boshtask.ConcurrencyShared
+}
+
+// Run checks that records of the audit log were neither edited nor removed;
+// a broken chain is reported in the result rather than as an error
+func (a VerifyAuditLogAction) Run() (boshaudit.Verification, error) {
+	verification, err := a.auditLog.Verify()
+	if err != nil {
+		return verification, bosherr.WrapError(err, "Verifying audit log")
+	}
+
+	return verification, nil
+}
+
+func (a VerifyAuditLogAction) Resume() (interface{}, error) {
+	return nil, errors.New("not supported")
+}
+
+func (a VerifyAuditLogAction) Cancel() error {
+	return errors.New("not supported")
+}
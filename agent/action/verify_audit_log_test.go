@@ -0,0 +1,54 @@
+package action_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/agent/action"
+	boshaudit "github.com/cloudfoundry/bosh-agent/agent/audit"
+	fakeaudit "github.com/cloudfoundry/bosh-agent/agent/audit/fakes"
+)
+
+var _ = Describe("VerifyAuditLogAction", func() {
+	var (
+		auditLog *fakeaudit.FakeLog
+		action   VerifyAuditLogAction
+	)
+
+	BeforeEach(func() {
+		auditLog = fakeaudit.NewFakeLog()
+		action = NewVerifyAuditLog(auditLog)
+	})
+
+	It("is synchronous", func() {
+		Expect(action.IsAsynchronous()).To(BeFalse())
+	})
+
+	It("is not persistent", func() {
+		Expect(action.IsPersistent()).To(BeFalse())
+	})
+
+	It("returns result of verification including a broken chain", func() {
+		auditLog.VerifyVerification = boshaudit.Verification{
+			Valid:   false,
+			Records: 2,
+			File:    "/fake-audit.log",
+			Line:    3,
+			Problem: "fake-problem",
+		}
+
+		verification, err := action.Run()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(verification).To(Equal(auditLog.VerifyVerification))
+	})
+
+	It("returns error when audit log cannot be verified", func() {
+		auditLog.VerifyErr = errors.New("fake-verify-error")
+
+		_, err := action.Run()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("fake-verify-error"))
+	})
+})
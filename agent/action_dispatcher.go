@@ -1,15 +1,31 @@
 package agent
 
 import (
+	"time"
+
 	boshaction "github.com/cloudfoundry/bosh-agent/agent/action"
+	boshaudit "github.com/cloudfoundry/bosh-agent/agent/audit"
 	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
 	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	"github.com/pivotal-golang/clock"
 )
 
 const actionDispatcherLogTag = "Action Dispatcher"
 
+// pollingMethods only read agent or task state; director sends them
+// repeatedly while it waits on tasks hence their successful requests
+// are not recorded in audit log and their responses are not remembered
+var pollingMethods = map[string]bool{
+	"ping":        true,
+	"get_state":   true,
+	"get_task":    true,
+	"task_events": true,
+	"list_tasks":  true,
+}
+
 type ActionDispatcher interface {
 	ResumePreviouslyDispatchedTasks()
 	Dispatch(req boshhandler.Request) (resp boshhandler.Response)
@@ -21,6 +37,11 @@ type concreteActionDispatcher struct {
 	taskManager   boshtask.Manager
 	actionFactory boshaction.Factory
 	actionRunner  boshaction.Runner
+	actionPolicy  ActionPolicy
+	auditLog      boshaudit.Log
+	timeService   clock.Clock
+
+	dispatchedRequests *dispatchedRequests
 }
 
 func NewActionDispatcher(
@@ -29,6 +50,9 @@ func NewActionDispatcher(
 	taskManager boshtask.Manager,
 	actionFactory boshaction.Factory,
 	actionRunner boshaction.Runner,
+	actionPolicy ActionPolicy,
+	auditLog boshaudit.Log,
+	timeService clock.Clock,
 ) (dispatcher ActionDispatcher) {
 	return concreteActionDispatcher{
 		logger:        logger,
@@ -36,6 +60,11 @@ func NewActionDispatcher(
 		taskManager:   taskManager,
 		actionFactory: actionFactory,
 		actionRunner:  actionRunner,
+		actionPolicy:  actionPolicy,
+		auditLog:      auditLog,
+		timeService:   timeService,
+
+		dispatchedRequests: newDispatchedRequests(maxDispatchedRequests, maxDispatchedResponsesSize),
 	}
 }
 
@@ -51,6 +80,13 @@ func (dispatcher concreteActionDispatcher) ResumePreviouslyDispatchedTasks() {
 	}
 
 	for _, taskInfo := range taskInfos {
+		// Results of tasks that finished before agent restart are
+		// kept so that API consumers can still fetch them via get_task
+		if taskInfo.IsFinished() {
+			dispatcher.taskService.RecordFinishedTask(dispatcher.finishedTask(taskInfo))
+			continue
+		}
+
 		action, err := dispatcher.actionFactory.Create(taskInfo.Method)
 		if err != nil {
 			dispatcher.logger.Error(actionDispatcherLogTag, "Unknown action %s", taskInfo.Method)
@@ -60,45 +96,105 @@ func (dispatcher concreteActionDispatcher) ResumePreviouslyDispatchedTasks() {
 			continue
 		}
 
+		action = dispatcher.withCancelSignal(action)
+
 		taskID := taskInfo.TaskID
 		payload := taskInfo.Payload
 
 		task := dispatcher.taskService.CreateTaskWithID(
 			taskID,
-			func() (interface{}, error) { return dispatcher.actionRunner.Resume(action, payload) },
+			func() (interface{}, error) {
+				return dispatcher.actionRunner.Resume(dispatcher.withProgressReporter(action, taskID), payload)
+			},
 			func(_ boshtask.Task) error { return action.Cancel() },
-			dispatcher.removeInfo,
+			dispatcher.endTask(boshhandler.Request{Method: taskInfo.Method, Payload: payload}),
 		)
+		task.Method = taskInfo.Method
+		task.ConcurrencyClass = action.ConcurrencyClass()
 
 		dispatcher.taskService.StartTask(task)
 	}
 }
 
+// Dispatch performs requested action. Requests that carry an id are performed
+// only once; repeated requests with the same id get the original response
+// (for asynchronous actions it refers to the already created task).
+// Actions not allowed by the action policy are not performed at all.
+// Each performed or refused request is recorded in the audit log;
+// asynchronous actions are recorded once dispatched and once their task ends.
+// Unknown actions are left to the other handler funcs in the chain
+// and recorded once they were handled (or refused as unknown).
 func (dispatcher concreteActionDispatcher) Dispatch(req boshhandler.Request) boshhandler.Response {
+	if !dispatcher.actionPolicy.Allows(req.Source, req.Method) {
+		err := bosherr.Errorf("Action %s is forbidden for requests received via %s", req.Method, req.Source)
+		dispatcher.logger.Error(actionDispatcherLogTag, err.Error())
+		dispatcher.audit(boshaudit.Entry{Request: req, Outcome: boshaudit.OutcomeForbidden, StartedAt: dispatcher.timeService.Now()}, err)
+		return boshhandler.NewExceptionResponse(boshcodederr.NewCodedError(boshcodederr.ErrorCodeForbidden, err))
+	}
+
 	action, err := dispatcher.actionFactory.Create(req.Method)
 	if err != nil {
-		dispatcher.logger.Error(actionDispatcherLogTag, "Unknown action %s", req.Method)
-		return boshhandler.NewExceptionResponse(bosherr.Errorf("unknown message %s", req.Method))
+		// Method may be handled by other handler funcs (e.g. added by plugins)
+		dispatcher.logger.Debug(actionDispatcherLogTag, "Not handling unknown action %s", req.Method)
+		return boshhandler.Delegate(dispatcher.delegated(req, dispatcher.timeService.Now()))
 	}
 
+	// Repeating polling requests does no harm
+	if req.RequestID == "" || pollingMethods[req.Method] {
+		return dispatcher.dispatch(action, req)
+	}
+
+	request, isNew := dispatcher.dispatchedRequests.Add(req)
+	if !isNew {
+		if request.method != req.Method {
+			err := bosherr.Errorf("Request id %s was already used for action %s", req.RequestID, request.method)
+			dispatcher.logger.Error(actionDispatcherLogTag, err.Error())
+			return boshhandler.NewExceptionResponse(err)
+		}
+
+		dispatcher.logger.Info(actionDispatcherLogTag, "Responding to repeated request %s of action %s", req.RequestID, req.Method)
+		return request.Response()
+	}
+
+	resp := dispatcher.dispatch(action, req)
+	dispatcher.dispatchedRequests.Finish(request, resp)
+
+	return resp
+}
+
+func (dispatcher concreteActionDispatcher) dispatch(action boshaction.Action, req boshhandler.Request) boshhandler.Response {
+	startedAt := dispatcher.timeService.Now()
+
 	if action.IsAsynchronous() {
-		return dispatcher.dispatchAsynchronousAction(action, req)
+		return dispatcher.dispatchAsynchronousAction(action, req, startedAt)
 	}
 
-	return dispatcher.dispatchSynchronousAction(action, req)
+	return dispatcher.dispatchSynchronousAction(action, req, startedAt)
 }
 
 func (dispatcher concreteActionDispatcher) dispatchAsynchronousAction(
 	action boshaction.Action,
 	req boshhandler.Request,
+	startedAt time.Time,
 ) boshhandler.Response {
 	dispatcher.logger.Info(actionDispatcherLogTag, "Running async action %s", req.Method)
 
+	// Invalid arguments are reported right away instead of via a failed task
+	err := dispatcher.actionRunner.Validate(action, req.GetPayload())
+	if err != nil {
+		err = bosherr.WrapErrorf(err, "Action Failed %s", req.Method)
+		return dispatcher.failedDispatch(req, startedAt, err)
+	}
+
+	// Each task is cancelled separately from other tasks of the same action
+	action = dispatcher.withCancelSignal(action)
+
 	var task boshtask.Task
-	var err error
 
+	// Task is created below and only run after it is started,
+	// hence its ID is known by the time runTask is called
 	runTask := func() (interface{}, error) {
-		return dispatcher.actionRunner.Run(action, req.GetPayload())
+		return dispatcher.actionRunner.Run(dispatcher.withProgressReporter(action, task.ID), req.GetPayload())
 	}
 
 	cancelTask := func(_ boshtask.Task) error { return action.Cancel() }
@@ -108,11 +204,10 @@ func (dispatcher concreteActionDispatcher) dispatchAsynchronousAction(
 	// if agent is restarted midway through the task.
 	if action.IsPersistent() {
 		dispatcher.logger.Info(actionDispatcherLogTag, "Running persistent action %s", req.Method)
-		task, err = dispatcher.taskService.CreateTask(runTask, cancelTask, dispatcher.removeInfo)
+		task, err = dispatcher.taskService.CreateTask(runTask, cancelTask, dispatcher.endTask(req))
 		if err != nil {
 			err = bosherr.WrapErrorf(err, "Create Task Failed %s", req.Method)
-			dispatcher.logger.Error(actionDispatcherLogTag, err.Error())
-			return boshhandler.NewExceptionResponse(err)
+			return dispatcher.failedDispatch(req, startedAt, err)
 		}
 
 		taskInfo := boshtask.Info{
@@ -124,18 +219,22 @@ func (dispatcher concreteActionDispatcher) dispatchAsynchronousAction(
 		err = dispatcher.taskManager.AddInfo(taskInfo)
 		if err != nil {
 			err = bosherr.WrapErrorf(err, "Action Failed %s", req.Method)
-			dispatcher.logger.Error(actionDispatcherLogTag, err.Error())
-			return boshhandler.NewExceptionResponse(err)
+			return dispatcher.failedDispatch(req, startedAt, err)
 		}
 	} else {
-		task, err = dispatcher.taskService.CreateTask(runTask, cancelTask, nil)
+		task, err = dispatcher.taskService.CreateTask(runTask, cancelTask, dispatcher.endTask(req))
 		if err != nil {
 			err = bosherr.WrapErrorf(err, "Create Task Failed %s", req.Method)
-			dispatcher.logger.Error(actionDispatcherLogTag, err.Error())
-			return boshhandler.NewExceptionResponse(err)
+			return dispatcher.failedDispatch(req, startedAt, err)
 		}
 	}
 
+	task.Method = req.Method
+	task.ConcurrencyClass = action.ConcurrencyClass()
+
+	// Recorded before the task is started so that it precedes the outcome of the task
+	dispatcher.audit(boshaudit.Entry{Request: req, TaskID: task.ID, Outcome: boshaudit.OutcomeDispatched, StartedAt: startedAt}, nil)
+
 	dispatcher.taskService.StartTask(task)
 
 	return boshhandler.NewValueResponse(boshtask.StateValue{
@@ -147,24 +246,156 @@ func (dispatcher concreteActionDispatcher) dispatchAsynchronousAction(
 func (dispatcher concreteActionDispatcher) dispatchSynchronousAction(
 	action boshaction.Action,
 	req boshhandler.Request,
+	startedAt time.Time,
 ) boshhandler.Response {
 	dispatcher.logger.Info(actionDispatcherLogTag, "Running sync action %s", req.Method)
 
 	value, err := dispatcher.actionRunner.Run(action, req.GetPayload())
 	if err != nil {
 		err = bosherr.WrapErrorf(err, "Action Failed %s", req.Method)
-		dispatcher.logger.Error(actionDispatcherLogTag, err.Error())
-		return boshhandler.NewExceptionResponse(err)
+		return dispatcher.failedDispatch(req, startedAt, err)
 	}
 
+	dispatcher.audit(boshaudit.Entry{Request: req, StartedAt: startedAt}, nil)
+
 	return boshhandler.NewValueResponse(value)
 }
 
-func (dispatcher concreteActionDispatcher) removeInfo(task boshtask.Task) {
-	err := dispatcher.taskManager.RemoveInfo(task.ID)
+// failedDispatch responds with exception and records failure of the request
+func (dispatcher concreteActionDispatcher) failedDispatch(req boshhandler.Request, startedAt time.Time, err error) boshhandler.Response {
+	dispatcher.logger.Error(actionDispatcherLogTag, err.Error())
+	dispatcher.audit(boshaudit.Entry{Request: req, StartedAt: startedAt}, err)
+	return boshhandler.NewExceptionResponse(err)
+}
+
+// endTask returns func that records result of the task performing the request
+func (dispatcher concreteActionDispatcher) endTask(req boshhandler.Request) boshtask.EndFunc {
+	return func(task boshtask.Task) {
+		dispatcher.recordResult(task)
+
+		entry := boshaudit.Entry{
+			Request:    req,
+			TaskID:     task.ID,
+			StartedAt:  task.StartedAt,
+			FinishedAt: task.FinishedAt,
+		}
+
+		if task.State == boshtask.StateCancelled {
+			entry.Outcome = boshaudit.OutcomeCancelled
+		}
+
+		dispatcher.audit(entry, task.Error)
+	}
+}
+
+// delegated returns func that records outcome of the request
+// handled by the other handler funcs in the chain
+func (dispatcher concreteActionDispatcher) delegated(req boshhandler.Request, startedAt time.Time) func(boshhandler.Response) {
+	return func(resp boshhandler.Response) {
+		dispatcher.audit(boshaudit.Entry{Request: req, StartedAt: startedAt}, boshhandler.ResponseError(resp))
+	}
+}
+
+// audit records outcome of the request; request is not failed
+// when it cannot be recorded
+func (dispatcher concreteActionDispatcher) audit(entry boshaudit.Entry, err error) {
+	if entry.FinishedAt.IsZero() {
+		entry.FinishedAt = dispatcher.timeService.Now()
+	}
+
+	if entry.Outcome == "" {
+		entry.Outcome = boshaudit.OutcomeDone
+		if err != nil {
+			entry.Outcome = boshaudit.OutcomeFailed
+		}
+	}
+
+	if entry.Outcome == boshaudit.OutcomeDone && pollingMethods[entry.Request.Method] {
+		return
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	recordErr := dispatcher.auditLog.Record(entry)
+	if recordErr != nil {
+		dispatcher.logger.Error(actionDispatcherLogTag, "Recording audit log entry: %s", recordErr.Error())
+	}
+}
+
+func (dispatcher concreteActionDispatcher) withProgressReporter(action boshaction.Action, taskID string) boshaction.Action {
+	progressAction, ok := action.(boshaction.ProgressReportingAction)
+	if !ok {
+		return action
+	}
+
+	return progressAction.WithProgressReporter(boshtask.NewTaskProgressReporter(taskID, dispatcher.taskService))
+}
+
+func (dispatcher concreteActionDispatcher) withCancelSignal(action boshaction.Action) boshaction.Action {
+	interruptibleAction, ok := action.(boshaction.InterruptibleAction)
+	if !ok {
+		return action
+	}
+
+	return interruptibleAction.WithCancelSignal(boshtask.NewCancelSignal())
+}
+
+func (dispatcher concreteActionDispatcher) recordResult(task boshtask.Task) {
+	taskInfo := boshtask.Info{
+		TaskID:     task.ID,
+		Method:     task.Method,
+		State:      task.State,
+		Value:      task.Value,
+		StartedAt:  task.StartedAt,
+		FinishedAt: task.FinishedAt,
+	}
+
+	if task.Error != nil {
+		taskInfo.Error = task.Error.Error()
+
+		if codedErr, found := boshcodederr.FindCodedError(task.Error); found {
+			taskInfo.ErrorCode = codedErr.Code
+			taskInfo.Retryable = codedErr.Retryable
+			taskInfo.ErrorDetails = codedErr.Details
+		}
+	}
+
+	err := dispatcher.taskManager.AddInfo(taskInfo)
 	if err != nil {
 		// There is not much we can do about failing to write state of a finished task.
-		// On next agent restart, task will be Resume()d again so it must be idempotent.
+		// On next agent restart, persistent task will be Resume()d again so it must be idempotent.
 		dispatcher.logger.Error(actionDispatcherLogTag, err.Error())
 	}
 }
+
+func (dispatcher concreteActionDispatcher) finishedTask(taskInfo boshtask.Info) boshtask.Task {
+	task := boshtask.Task{
+		ID:         taskInfo.TaskID,
+		Method:     taskInfo.Method,
+		State:      taskInfo.State,
+		Value:      taskInfo.Value,
+		StartedAt:  taskInfo.StartedAt,
+		FinishedAt: taskInfo.FinishedAt,
+	}
+
+	if taskInfo.State == boshtask.StateFailed || taskInfo.State == boshtask.StateCancelled {
+		task.Error = bosherr.Error(taskInfo.Error)
+
+		if taskInfo.ErrorCode != "" {
+			codedErr := boshcodederr.NewCodedError(taskInfo.ErrorCode, task.Error).WithDetails(taskInfo.ErrorDetails)
+			codedErr.Retryable = taskInfo.Retryable
+			task.Error = codedErr
+		}
+	}
+
+	if taskInfo.ValueDropped && task.Error == nil {
+		task.Error = boshcodederr.NewCodedError(
+			boshcodederr.ErrorCodeResponseTooLarge,
+			bosherr.Errorf("Result of task %s was too large to be kept across agent restart", taskInfo.TaskID),
+		)
+	}
+
+	return task
+}
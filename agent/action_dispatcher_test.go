@@ -4,17 +4,24 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
 	. "github.com/cloudfoundry/bosh-agent/agent"
 	fakeaction "github.com/cloudfoundry/bosh-agent/agent/action/fakes"
+	boshaudit "github.com/cloudfoundry/bosh-agent/agent/audit"
+	fakeaudit "github.com/cloudfoundry/bosh-agent/agent/audit/fakes"
 	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
 	faketask "github.com/cloudfoundry/bosh-agent/agent/task/fakes"
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
 	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
 	boshassert "github.com/cloudfoundry/bosh-utils/assert"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	"github.com/pivotal-golang/clock/fakeclock"
 )
 
 func init() {
@@ -25,6 +32,8 @@ func init() {
 			taskManager   *faketask.FakeManager
 			actionFactory *fakeaction.FakeFactory
 			actionRunner  *fakeaction.FakeRunner
+			auditLog      *fakeaudit.FakeLog
+			timeService   *fakeclock.FakeClock
 			dispatcher    ActionDispatcher
 		)
 
@@ -34,15 +43,110 @@ func init() {
 			taskManager = faketask.NewFakeManager()
 			actionFactory = fakeaction.NewFakeFactory()
 			actionRunner = &fakeaction.FakeRunner{}
-			dispatcher = NewActionDispatcher(logger, taskService, taskManager, actionFactory, actionRunner)
+			auditLog = fakeaudit.NewFakeLog()
+			timeService = fakeclock.NewFakeClock(time.Unix(1000, 0))
+			dispatcher = NewActionDispatcher(logger, taskService, taskManager, actionFactory, actionRunner, ActionPolicy{}, auditLog, timeService)
 		})
 
-		It("responds with exception when the method is unknown", func() {
-			actionFactory.RegisterActionErr("fake-action", errors.New("fake-create-error"))
+		Context("when action is unknown", func() {
+			var (
+				chain       *boshhandler.Chain
+				pluginCalls int
+			)
+
+			BeforeEach(func() {
+				actionFactory.RegisterActionErr("fake-action", errors.New("fake-create-error"))
+				actionFactory.RegisterActionErr("fake-plugin-action", errors.New("fake-create-error"))
+
+				pluginCalls = 0
+
+				chain = boshhandler.NewChain()
+				chain.Register(func(req boshhandler.Request) boshhandler.Response {
+					if req.Method != "fake-plugin-action" {
+						return boshhandler.NotHandled
+					}
+					pluginCalls++
+					return boshhandler.NewValueResponse("fake-plugin-value")
+				})
+			})
+
+			It("leaves request to other handler funcs and records how they handled it", func() {
+				req := boshhandler.NewRequest("fake-reply", "fake-plugin-action", []byte{})
+
+				resp := chain.Func(dispatcher.Dispatch)(req)
+				Expect(resp).To(Equal(boshhandler.NewValueResponse("fake-plugin-value")))
+				Expect(pluginCalls).To(Equal(1))
+
+				Expect(auditLog.RecordedEntries()).To(Equal([]boshaudit.Entry{
+					{
+						Request:    req,
+						Outcome:    boshaudit.OutcomeDone,
+						StartedAt:  time.Unix(1000, 0),
+						FinishedAt: time.Unix(1000, 0),
+					},
+				}))
+			})
+
+			It("records request that no handler func handled as failed", func() {
+				req := boshhandler.NewRequest("fake-reply", "fake-action", []byte{})
+
+				chain.Func(dispatcher.Dispatch)(req)
+
+				Expect(auditLog.RecordedEntries()).To(HaveLen(1))
+				Expect(auditLog.RecordedEntries()[0].Outcome).To(Equal(boshaudit.OutcomeFailed))
+				Expect(auditLog.RecordedEntries()[0].Error).To(Equal("unknown message fake-action"))
+			})
+
+			It("leaves requests with request id to other handler funcs each time", func() {
+				req := boshhandler.NewRequest("fake-reply", "fake-plugin-action", []byte{})
+				req.RequestID = "fake-request-id"
+
+				chain.Func(dispatcher.Dispatch)(req)
+				chain.Func(dispatcher.Dispatch)(req)
+
+				Expect(pluginCalls).To(Equal(2))
+				Expect(auditLog.RecordedEntries()).To(HaveLen(2))
+			})
+		})
+
+		Context("when action policy forbids action", func() {
+			BeforeEach(func() {
+				actionPolicy := ActionPolicy{
+					Rules: []ActionPolicyRule{
+						{Transport: "https", Allow: []string{"ping"}},
+					},
+				}
+				dispatcher = NewActionDispatcher(logger, taskService, taskManager, actionFactory, actionRunner, actionPolicy, auditLog, timeService)
+				actionFactory.RegisterAction("fake-action", &fakeaction.TestAction{Asynchronous: false})
+			})
+
+			It("responds with forbidden exception without running action", func() {
+				req := boshhandler.NewRequest("fake-reply", "fake-action", []byte("fake-payload"))
+				req.Source = boshhandler.RequestSource{Transport: "https", Identity: "fake-user"}
+
+				resp := dispatcher.Dispatch(req)
+				boshassert.MatchesJSONString(GinkgoT(), resp,
+					`{"exception":{"message":"Action fake-action is forbidden for requests received via https as fake-user","code":"forbidden"}}`)
+				Expect(actionRunner.RunAction).To(BeNil())
+			})
 
-			req := boshhandler.NewRequest("fake-reply", "fake-action", []byte{})
-			resp := dispatcher.Dispatch(req)
-			boshassert.MatchesJSONString(GinkgoT(), resp, `{"exception":{"message":"unknown message fake-action"}}`)
+			It("records refused request in audit log", func() {
+				req := boshhandler.NewRequest("fake-reply", "fake-action", []byte("fake-payload"))
+				req.Source = boshhandler.RequestSource{Transport: "https"}
+
+				dispatcher.Dispatch(req)
+				Expect(auditLog.Entries).To(HaveLen(1))
+				Expect(auditLog.Entries[0].Request).To(Equal(req))
+				Expect(auditLog.Entries[0].Outcome).To(Equal(boshaudit.OutcomeForbidden))
+			})
+
+			It("runs action received via other transport", func() {
+				req := boshhandler.NewRequest("fake-reply", "fake-action", []byte("fake-payload"))
+				req.Source = boshhandler.RequestSource{Transport: "nats"}
+
+				dispatcher.Dispatch(req)
+				Expect(actionRunner.RunAction).ToNot(BeNil())
+			})
 		})
 
 		Context("when action is synchronous", func() {
@@ -70,6 +174,162 @@ func init() {
 				expectedJSON := fmt.Sprintf("{\"exception\":{\"message\":\"Action Failed %s: fake-run-error\"}}", req.Method)
 				boshassert.MatchesJSONString(GinkgoT(), resp, expectedJSON)
 			})
+
+			It("records outcome of synchronous action in audit log", func() {
+				dispatcher.Dispatch(req)
+				Expect(auditLog.Entries).To(Equal([]boshaudit.Entry{
+					{
+						Request:    req,
+						Outcome:    boshaudit.OutcomeDone,
+						StartedAt:  time.Unix(1000, 0),
+						FinishedAt: time.Unix(1000, 0),
+					},
+				}))
+			})
+
+			It("records failure of synchronous action in audit log", func() {
+				actionRunner.RunErr = errors.New("fake-run-error")
+
+				dispatcher.Dispatch(req)
+				Expect(auditLog.Entries).To(HaveLen(1))
+				Expect(auditLog.Entries[0].Outcome).To(Equal(boshaudit.OutcomeFailed))
+				Expect(auditLog.Entries[0].Error).To(Equal("Action Failed fake-action: fake-run-error"))
+			})
+
+			It("does not record successful polling requests in audit log", func() {
+				for _, method := range []string{"ping", "get_state", "get_task", "task_events", "list_tasks"} {
+					actionFactory.RegisterAction(method, &fakeaction.TestAction{Asynchronous: false})
+					dispatcher.Dispatch(boshhandler.NewRequest("fake-reply", method, []byte("fake-payload")))
+				}
+
+				Expect(auditLog.Entries).To(BeEmpty())
+			})
+
+			It("records failed polling requests in audit log", func() {
+				actionFactory.RegisterAction("get_task", &fakeaction.TestAction{Asynchronous: false})
+				actionRunner.RunErr = errors.New("fake-run-error")
+
+				dispatcher.Dispatch(boshhandler.NewRequest("fake-reply", "get_task", []byte("fake-payload")))
+				Expect(auditLog.Entries).To(HaveLen(1))
+				Expect(auditLog.Entries[0].Outcome).To(Equal(boshaudit.OutcomeFailed))
+			})
+
+			It("still responds when request cannot be recorded in audit log", func() {
+				auditLog.RecordErr = errors.New("fake-record-error")
+				actionRunner.RunValue = "fake-value"
+
+				resp := dispatcher.Dispatch(req)
+				Expect(resp).To(Equal(boshhandler.NewValueResponse("fake-value")))
+			})
+		})
+
+		Context("when request has an id", func() {
+			var (
+				req    boshhandler.Request
+				action *fakeaction.TestAction
+			)
+
+			BeforeEach(func() {
+				req = boshhandler.NewRequest("fake-reply", "fake-action", []byte("fake-payload"))
+				req.RequestID = "fake-request-id"
+				action = &fakeaction.TestAction{}
+				actionFactory.RegisterAction("fake-action", action)
+			})
+
+			It("responds to repeated synchronous request with cached response without running action again", func() {
+				actionRunner.RunValue = "fake-value"
+				resp := dispatcher.Dispatch(req)
+				Expect(resp).To(Equal(boshhandler.NewValueResponse("fake-value")))
+
+				actionRunner.RunValue = "fake-other-value"
+				resp = dispatcher.Dispatch(req)
+				Expect(resp).To(Equal(boshhandler.NewValueResponse("fake-value")))
+			})
+
+			It("responds to repeated asynchronous request with existing task id without creating new task", func() {
+				action.Asynchronous = true
+
+				resp := dispatcher.Dispatch(req)
+				boshassert.MatchesJSONString(GinkgoT(), resp,
+					`{"value":{"agent_task_id":"fake-generated-task-id","state":"running"}}`)
+				delete(taskService.StartedTasks, "fake-generated-task-id")
+
+				resp = dispatcher.Dispatch(req)
+				boshassert.MatchesJSONString(GinkgoT(), resp,
+					`{"value":{"agent_task_id":"fake-generated-task-id","state":"running"}}`)
+				Expect(taskService.StartedTasks).To(BeEmpty())
+			})
+
+			It("runs requests with different ids", func() {
+				actionRunner.RunValue = "fake-value"
+				dispatcher.Dispatch(req)
+
+				actionRunner.RunValue = "fake-other-value"
+				req.RequestID = "fake-other-request-id"
+				resp := dispatcher.Dispatch(req)
+				Expect(resp).To(Equal(boshhandler.NewValueResponse("fake-other-value")))
+			})
+
+			It("responds with exception when request id was used for another action", func() {
+				dispatcher.Dispatch(req)
+
+				actionFactory.RegisterAction("fake-other-action", &fakeaction.TestAction{})
+				req.Method = "fake-other-action"
+				resp := dispatcher.Dispatch(req)
+				boshassert.MatchesJSONString(GinkgoT(), resp,
+					`{"exception":{"message":"Request id fake-request-id was already used for action fake-action"}}`)
+			})
+
+			It("forgets oldest requests once too many requests were dispatched", func() {
+				actionRunner.RunValue = "fake-value"
+				dispatcher.Dispatch(req)
+
+				for i := 0; i < 1000; i++ {
+					otherReq := req
+					otherReq.RequestID = fmt.Sprintf("fake-request-id-%d", i)
+					dispatcher.Dispatch(otherReq)
+				}
+
+				actionRunner.RunValue = "fake-other-value"
+				resp := dispatcher.Dispatch(req)
+				Expect(resp).To(Equal(boshhandler.NewValueResponse("fake-other-value")))
+			})
+
+			It("runs requests with the same id received from different sources", func() {
+				actionRunner.RunValue = "fake-value"
+				req.Source = boshhandler.RequestSource{Transport: "https", Identity: "fake-director"}
+				dispatcher.Dispatch(req)
+
+				actionRunner.RunValue = "fake-other-value"
+				req.Source = boshhandler.RequestSource{Transport: "https", Identity: "fake-other-director"}
+				resp := dispatcher.Dispatch(req)
+				Expect(resp).To(Equal(boshhandler.NewValueResponse("fake-other-value")))
+			})
+
+			It("runs repeated polling requests each time", func() {
+				actionFactory.RegisterAction("get_state", &fakeaction.TestAction{})
+				req.Method = "get_state"
+
+				actionRunner.RunValue = "fake-value"
+				dispatcher.Dispatch(req)
+
+				actionRunner.RunValue = "fake-other-value"
+				resp := dispatcher.Dispatch(req)
+				Expect(resp).To(Equal(boshhandler.NewValueResponse("fake-other-value")))
+			})
+
+			It("forgets oldest requests once their responses take up too much space", func() {
+				actionRunner.RunValue = strings.Repeat("a", 5*1024*1024)
+				dispatcher.Dispatch(req)
+
+				otherReq := req
+				otherReq.RequestID = "fake-other-request-id"
+				dispatcher.Dispatch(otherReq)
+
+				actionRunner.RunValue = "fake-other-value"
+				resp := dispatcher.Dispatch(req)
+				Expect(resp).To(Equal(boshhandler.NewValueResponse("fake-other-value")))
+			})
 		})
 
 		Context("when action is asynchronous", func() {
@@ -94,6 +354,16 @@ func init() {
 					Expect(action.Canceled).To(BeTrue())
 				})
 
+				It("gives each task its own cancel signal", func() {
+					dispatcher.Dispatch(req)
+					firstSignal := action.CancelSignal
+					Expect(firstSignal).ToNot(BeNil())
+
+					dispatcher.Dispatch(req)
+					Expect(action.CancelSignal).ToNot(BeNil())
+					Expect(action.CancelSignal == firstSignal).To(BeFalse())
+				})
+
 				It("returns error from cancelling task if canceling task fails", func() {
 					action.CancelErr = errors.New("fake-cancel-err")
 					dispatcher.Dispatch(req)
@@ -121,6 +391,138 @@ func init() {
 					Expect(taskService.StartedTasks["fake-generated-task-id"]).ToNot(BeNil())
 				})
 
+				It("records method of created task", func() {
+					dispatcher.Dispatch(req)
+					Expect(taskService.StartedTasks["fake-generated-task-id"].Method).To(Equal("fake-action"))
+				})
+
+				It("records task result in task manager after task finishes", func() {
+					dispatcher.Dispatch(req)
+					taskService.StartedTasks["fake-generated-task-id"].EndFunc(boshtask.Task{
+						ID:         "fake-generated-task-id",
+						Method:     "fake-action",
+						State:      boshtask.StateFailed,
+						Error:      errors.New("fake-task-error"),
+						StartedAt:  time.Unix(900, 0),
+						FinishedAt: time.Unix(950, 0),
+					})
+
+					taskInfos, _ := taskManager.GetInfos()
+					Expect(taskInfos).To(Equal([]boshtask.Info{
+						boshtask.Info{
+							TaskID:     "fake-generated-task-id",
+							Method:     "fake-action",
+							State:      boshtask.StateFailed,
+							Error:      "fake-task-error",
+							StartedAt:  time.Unix(900, 0),
+							FinishedAt: time.Unix(950, 0),
+						},
+					}))
+				})
+
+				It("records dispatched request in audit log before task is started", func() {
+					dispatcher.Dispatch(req)
+
+					Expect(auditLog.Entries).To(Equal([]boshaudit.Entry{
+						{
+							Request:    req,
+							TaskID:     "fake-generated-task-id",
+							Outcome:    boshaudit.OutcomeDispatched,
+							StartedAt:  time.Unix(1000, 0),
+							FinishedAt: time.Unix(1000, 0),
+						},
+					}))
+				})
+
+				It("records outcome of the task in audit log after task finishes", func() {
+					dispatcher.Dispatch(req)
+					Expect(auditLog.Entries).To(HaveLen(1))
+					auditLog.Entries = nil
+
+					taskService.StartedTasks["fake-generated-task-id"].EndFunc(boshtask.Task{
+						ID:         "fake-generated-task-id",
+						State:      boshtask.StateFailed,
+						Error:      errors.New("fake-task-error"),
+						StartedAt:  time.Unix(900, 0),
+						FinishedAt: time.Unix(950, 0),
+					})
+
+					Expect(auditLog.Entries).To(Equal([]boshaudit.Entry{
+						{
+							Request:    req,
+							TaskID:     "fake-generated-task-id",
+							Outcome:    boshaudit.OutcomeFailed,
+							Error:      "fake-task-error",
+							StartedAt:  time.Unix(900, 0),
+							FinishedAt: time.Unix(950, 0),
+						},
+					}))
+				})
+
+				It("records cancelled outcome of cancelled task in audit log", func() {
+					dispatcher.Dispatch(req)
+					auditLog.Entries = nil
+
+					taskService.StartedTasks["fake-generated-task-id"].EndFunc(boshtask.Task{
+						ID:    "fake-generated-task-id",
+						State: boshtask.StateCancelled,
+						Error: boshcodederr.NewCodedError(boshcodederr.ErrorCodeCancelled, errors.New("fake-cancel-error")),
+					})
+
+					Expect(auditLog.Entries).To(HaveLen(1))
+					Expect(auditLog.Entries[0].Outcome).To(Equal(boshaudit.OutcomeCancelled))
+					Expect(auditLog.Entries[0].Error).To(Equal("fake-cancel-error"))
+				})
+
+				It("records error code of failed task in task manager", func() {
+					dispatcher.Dispatch(req)
+					taskService.StartedTasks["fake-generated-task-id"].EndFunc(boshtask.Task{
+						ID:    "fake-generated-task-id",
+						State: boshtask.StateFailed,
+						Error: bosherr.WrapError(
+							boshcodederr.NewCodedError(boshcodederr.ErrorCodeTimeout, errors.New("fake-task-error")).
+								WithDetails(map[string]interface{}{"fake-key": "fake-value"}),
+							"fake-wrap",
+						),
+					})
+
+					taskInfos, _ := taskManager.GetInfos()
+					Expect(taskInfos).To(HaveLen(1))
+					Expect(taskInfos[0].ErrorCode).To(Equal(boshcodederr.ErrorCodeTimeout))
+					Expect(taskInfos[0].Retryable).To(BeTrue())
+					Expect(taskInfos[0].ErrorDetails).To(Equal(map[string]interface{}{"fake-key": "fake-value"}))
+				})
+
+				It("records progress reported by the action as events of the task", func() {
+					dispatcher.Dispatch(req)
+
+					_, err := taskService.StartedTasks["fake-generated-task-id"].Func()
+					Expect(err).ToNot(HaveOccurred())
+
+					action.ProgressReporter.ReportProgress(boshtask.Progress{Phase: "fake-phase"})
+					Expect(taskService.StartedTasks["fake-generated-task-id"].Events).To(Equal([]boshtask.Progress{
+						{Sequence: 1, Phase: "fake-phase"},
+					}))
+				})
+
+				It("responds with exception without creating task when arguments are invalid", func() {
+					actionRunner.ValidateErr = errors.New("fake-validate-error")
+
+					resp := dispatcher.Dispatch(req)
+					boshassert.MatchesJSONString(GinkgoT(), resp,
+						`{"exception":{"message":"Action Failed fake-action: fake-validate-error"}}`)
+
+					Expect(actionRunner.ValidateAction).To(Equal(action))
+					Expect(string(actionRunner.ValidatePayload)).To(Equal("fake-payload"))
+					Expect(taskService.StartedTasks).To(BeEmpty())
+				})
+
+				It("starts created task in the action's concurrency class", func() {
+					action.Concurrency = boshtask.ConcurrencyShared
+					dispatcher.Dispatch(req)
+					Expect(taskService.StartedTasks["fake-generated-task-id"].ConcurrencyClass).To(Equal(boshtask.ConcurrencyShared))
+				})
+
 				It("returns create task error", func() {
 					taskService.CreateTaskErr = errors.New("fake-create-task-error")
 					resp := dispatcher.Dispatch(req)
@@ -161,11 +563,6 @@ func init() {
 					taskInfos, _ := taskManager.GetInfos()
 					Expect(taskInfos).To(BeEmpty())
 				})
-
-				It("does not do anything after task finishes", func() {
-					dispatcher.Dispatch(req)
-					Expect(taskService.StartedTasks["fake-generated-task-id"].EndFunc).To(BeNil())
-				})
 			})
 
 			Context("when action is persistent", func() {
@@ -232,12 +629,24 @@ func init() {
 					}))
 				})
 
-				It("removes task from task manager after task finishes", func() {
+				It("replaces task info with task result after task finishes", func() {
 					dispatcher.Dispatch(req)
-					taskService.StartedTasks["fake-generated-task-id"].EndFunc(boshtask.Task{ID: "fake-generated-task-id"})
+					taskService.StartedTasks["fake-generated-task-id"].EndFunc(boshtask.Task{
+						ID:     "fake-generated-task-id",
+						Method: "fake-action",
+						State:  boshtask.StateDone,
+						Value:  "fake-value",
+					})
 
 					taskInfos, _ := taskManager.GetInfos()
-					Expect(taskInfos).To(BeEmpty())
+					Expect(taskInfos).To(Equal([]boshtask.Info{
+						boshtask.Info{
+							TaskID: "fake-generated-task-id",
+							Method: "fake-action",
+							State:  boshtask.StateDone,
+							Value:  "fake-value",
+						},
+					}))
 				})
 
 				It("does not start running created task if task manager cannot add task", func() {
@@ -300,20 +709,128 @@ func init() {
 				}
 			})
 
-			It("removes tasks from task manager after each task finishes", func() {
+			It("replaces task infos with task results after each task finishes", func() {
 				actionFactory.RegisterAction("fake-action-1", firstAction)
 				actionFactory.RegisterAction("fake-action-2", secondAction)
 
 				dispatcher.ResumePreviouslyDispatchedTasks()
 				Expect(len(taskService.StartedTasks)).To(Equal(2))
+				Expect(taskService.StartedTasks["fake-task-id-1"].Method).To(Equal("fake-action-1"))
 
 				// Simulate all tasks ending
-				taskService.StartedTasks["fake-task-id-1"].EndFunc(boshtask.Task{ID: "fake-task-id-1"})
-				taskService.StartedTasks["fake-task-id-2"].EndFunc(boshtask.Task{ID: "fake-task-id-2"})
+				taskService.StartedTasks["fake-task-id-1"].EndFunc(boshtask.Task{ID: "fake-task-id-1", Method: "fake-action-1", State: boshtask.StateDone})
+				taskService.StartedTasks["fake-task-id-2"].EndFunc(boshtask.Task{ID: "fake-task-id-2", Method: "fake-action-2", State: boshtask.StateDone})
 
 				taskInfos, err := taskManager.GetInfos()
 				Expect(err).ToNot(HaveOccurred())
-				Expect(taskInfos).To(BeEmpty())
+				Expect(taskInfos).To(ConsistOf(
+					boshtask.Info{TaskID: "fake-task-id-1", Method: "fake-action-1", State: boshtask.StateDone},
+					boshtask.Info{TaskID: "fake-task-id-2", Method: "fake-action-2", State: boshtask.StateDone},
+				))
+			})
+
+			It("records results of previously finished tasks without running them", func() {
+				actionFactory.RegisterAction("fake-action-1", firstAction)
+				actionFactory.RegisterAction("fake-action-2", secondAction)
+
+				err := taskManager.AddInfo(boshtask.Info{
+					TaskID:     "fake-task-id-3",
+					Method:     "fake-action-3",
+					State:      boshtask.StateFailed,
+					Error:      "fake-task-error",
+					StartedAt:  time.Unix(900, 0),
+					FinishedAt: time.Unix(950, 0),
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				err = taskManager.AddInfo(boshtask.Info{
+					TaskID: "fake-task-id-4",
+					Method: "fake-action-4",
+					State:  boshtask.StateDone,
+					Value:  "fake-value",
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				dispatcher.ResumePreviouslyDispatchedTasks()
+				Expect(len(taskService.StartedTasks)).To(Equal(2))
+				Expect(len(taskService.FinishedTasks)).To(Equal(2))
+
+				failedTask := taskService.FinishedTasks["fake-task-id-3"]
+				Expect(failedTask.Method).To(Equal("fake-action-3"))
+				Expect(failedTask.State).To(Equal(boshtask.StateFailed))
+				Expect(failedTask.Error).To(MatchError("fake-task-error"))
+				Expect(failedTask.StartedAt).To(Equal(time.Unix(900, 0)))
+				Expect(failedTask.FinishedAt).To(Equal(time.Unix(950, 0)))
+
+				doneTask := taskService.FinishedTasks["fake-task-id-4"]
+				Expect(doneTask.State).To(Equal(boshtask.StateDone))
+				Expect(doneTask.Value).To(Equal("fake-value"))
+				Expect(doneTask.Error).To(BeNil())
+			})
+
+			It("keeps error codes of previously failed tasks", func() {
+				err := taskManager.AddInfo(boshtask.Info{
+					TaskID:       "fake-task-id-3",
+					Method:       "fake-action-3",
+					State:        boshtask.StateFailed,
+					Error:        "fake-task-error",
+					ErrorCode:    boshcodederr.ErrorCodeScriptFailed,
+					ErrorDetails: map[string]interface{}{"exit_status": 2},
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				dispatcher.ResumePreviouslyDispatchedTasks()
+
+				failedTask := taskService.FinishedTasks["fake-task-id-3"]
+				Expect(failedTask.Error).To(MatchError("fake-task-error"))
+
+				codedErr, found := boshcodederr.FindCodedError(failedTask.Error)
+				Expect(found).To(BeTrue())
+				Expect(codedErr.Code).To(Equal(boshcodederr.ErrorCodeScriptFailed))
+				Expect(codedErr.Retryable).To(BeFalse())
+				Expect(codedErr.Details).To(Equal(map[string]interface{}{"exit_status": 2}))
+			})
+
+			It("keeps previously cancelled tasks cancelled", func() {
+				err := taskManager.AddInfo(boshtask.Info{
+					TaskID:    "fake-task-id-3",
+					Method:    "fake-action-3",
+					State:     boshtask.StateCancelled,
+					Error:     "fake-cancel-error",
+					ErrorCode: boshcodederr.ErrorCodeCancelled,
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				dispatcher.ResumePreviouslyDispatchedTasks()
+
+				cancelledTask := taskService.FinishedTasks["fake-task-id-3"]
+				Expect(cancelledTask.State).To(Equal(boshtask.StateCancelled))
+				Expect(cancelledTask.Error).To(MatchError("fake-cancel-error"))
+
+				codedErr, found := boshcodederr.FindCodedError(cancelledTask.Error)
+				Expect(found).To(BeTrue())
+				Expect(codedErr.Code).To(Equal(boshcodederr.ErrorCodeCancelled))
+			})
+
+			It("reports that result of previously finished task was too large to be kept", func() {
+				err := taskManager.AddInfo(boshtask.Info{
+					TaskID:       "fake-task-id-3",
+					Method:       "fake-action-3",
+					State:        boshtask.StateDone,
+					ValueDropped: true,
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				dispatcher.ResumePreviouslyDispatchedTasks()
+
+				doneTask := taskService.FinishedTasks["fake-task-id-3"]
+				Expect(doneTask.State).To(Equal(boshtask.StateDone))
+				Expect(doneTask.Value).To(BeNil())
+				Expect(doneTask.Error).To(MatchError("Result of task fake-task-id-3 was too large to be kept across agent restart"))
+
+				codedErr, found := boshcodederr.FindCodedError(doneTask.Error)
+				Expect(found).To(BeTrue())
+				Expect(codedErr.Code).To(Equal(boshcodederr.ErrorCodeResponseTooLarge))
 			})
 
 			It("return resume error to each task", func() {
@@ -0,0 +1,75 @@
+package agent
+
+import (
+	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
+)
+
+// ActionPolicy restricts which actions may be dispatched depending on
+// how request was received. Rules are checked in order and the first
+// rule that matches request source decides; requests not matched
+// by any rule are checked against default rules and allowed otherwise.
+type ActionPolicy struct {
+	Rules []ActionPolicyRule
+}
+
+// Any local user let in by the unix socket handler may send requests, so
+// unless configured rules say otherwise they may only run actions that do
+// not change anything. Actions such as run_script (runs job scripts as root)
+// or fetch_logs (uploads logs to the blobstore) have to be allowed explicitly.
+var defaultActionPolicyRules = []ActionPolicyRule{
+	{Transport: "unix", Allow: []string{"ping", "get_state", "get_task", "list_tasks", "describe_actions"}},
+}
+
+// ActionPolicyRule matches requests by mbus scheme and sender identity;
+// empty Transport or Identity matches any value.
+// Deny takes precedence over Allow; empty Allow allows all actions
+// that are not denied. "*" stands for all actions.
+type ActionPolicyRule struct {
+	Transport string
+	Identity  string
+
+	Allow []string
+	Deny  []string
+}
+
+func (p ActionPolicy) Allows(source boshhandler.RequestSource, method string) bool {
+	for _, rules := range [][]ActionPolicyRule{p.Rules, defaultActionPolicyRules} {
+		for _, rule := range rules {
+			if rule.matches(source) {
+				return rule.allows(method)
+			}
+		}
+	}
+
+	return true
+}
+
+func (r ActionPolicyRule) matches(source boshhandler.RequestSource) bool {
+	if r.Transport != "" && r.Transport != source.Transport {
+		return false
+	}
+
+	if r.Identity != "" && r.Identity != source.Identity {
+		return false
+	}
+
+	return true
+}
+
+func (r ActionPolicyRule) allows(method string) bool {
+	if containsAction(r.Deny, method) {
+		return false
+	}
+
+	return len(r.Allow) == 0 || containsAction(r.Allow, method)
+}
+
+func containsAction(actions []string, method string) bool {
+	for _, action := range actions {
+		if action == "*" || action == method {
+			return true
+		}
+	}
+
+	return false
+}
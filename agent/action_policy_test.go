@@ -0,0 +1,75 @@
+package agent_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/agent"
+	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
+)
+
+func init() {
+	Describe("ActionPolicy", func() {
+		var (
+			policy ActionPolicy
+			https  = boshhandler.RequestSource{Transport: "https", Identity: "fake-user"}
+			admin  = boshhandler.RequestSource{Transport: "https", Identity: "admin"}
+			nats   = boshhandler.RequestSource{Transport: "nats"}
+			unix   = boshhandler.RequestSource{Transport: "unix", Identity: "fake-user"}
+		)
+
+		BeforeEach(func() {
+			policy = ActionPolicy{
+				Rules: []ActionPolicyRule{
+					{Transport: "https", Identity: "admin", Deny: []string{"ssh"}},
+					{Transport: "https", Allow: []string{"ping", "get_state", "fetch_logs"}},
+					{Deny: []string{"*"}, Allow: []string{"ping"}},
+				},
+			}
+		})
+
+		It("allows all actions when there are no rules", func() {
+			Expect(ActionPolicy{}.Allows(https, "apply")).To(BeTrue())
+		})
+
+		It("allows only listed actions", func() {
+			Expect(policy.Allows(https, "get_state")).To(BeTrue())
+			Expect(policy.Allows(https, "apply")).To(BeFalse())
+		})
+
+		It("applies first rule that matches request source", func() {
+			Expect(policy.Allows(admin, "apply")).To(BeTrue())
+			Expect(policy.Allows(admin, "ssh")).To(BeFalse())
+		})
+
+		It("prefers denying over allowing", func() {
+			Expect(policy.Allows(nats, "ping")).To(BeFalse())
+		})
+
+		It("allows actions when no rule matches request source", func() {
+			policy.Rules = policy.Rules[:2]
+			Expect(policy.Allows(nats, "apply")).To(BeTrue())
+		})
+
+		It("allows only actions that do not change anything over unix socket by default", func() {
+			for _, method := range []string{"ping", "get_state", "get_task", "list_tasks", "describe_actions"} {
+				Expect(ActionPolicy{}.Allows(unix, method)).To(BeTrue())
+			}
+
+			for _, method := range []string{"run_script", "fetch_logs", "apply", "ssh"} {
+				Expect(ActionPolicy{}.Allows(unix, method)).To(BeFalse())
+			}
+		})
+
+		It("allows run_script over unix socket when configured rule allows it", func() {
+			policy.Rules = []ActionPolicyRule{{Transport: "unix", Allow: []string{"get_state", "run_script"}}}
+			Expect(policy.Allows(unix, "run_script")).To(BeTrue())
+			Expect(policy.Allows(unix, "fetch_logs")).To(BeFalse())
+		})
+
+		It("applies configured rule that matches unix socket requests before default rules", func() {
+			policy.Rules = []ActionPolicyRule{{Transport: "unix", Identity: "fake-user"}}
+			Expect(policy.Allows(unix, "apply")).To(BeTrue())
+		})
+	})
+}
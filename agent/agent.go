@@ -24,6 +24,7 @@ const (
 type Agent struct {
 	logger            boshlog.Logger
 	mbusHandler       boshhandler.Handler
+	localHandler      boshhandler.Handler
 	platform          boshplatform.Platform
 	actionDispatcher  ActionDispatcher
 	heartbeatInterval time.Duration
@@ -38,6 +39,7 @@ type Agent struct {
 func New(
 	logger boshlog.Logger,
 	mbusHandler boshhandler.Handler,
+	localHandler boshhandler.Handler,
 	platform boshplatform.Platform,
 	actionDispatcher ActionDispatcher,
 	jobSupervisor boshjobsuper.JobSupervisor,
@@ -51,6 +53,7 @@ func New(
 	return Agent{
 		logger:            logger,
 		mbusHandler:       mbusHandler,
+		localHandler:      localHandler,
 		platform:          platform,
 		actionDispatcher:  actionDispatcher,
 		heartbeatInterval: heartbeatInterval,
@@ -70,6 +73,10 @@ func (a Agent) Run() error {
 
 	go a.subscribeActionDispatcher(errCh)
 
+	if a.localHandler != nil {
+		go a.subscribeLocalActionDispatcher()
+	}
+
 	go a.generateHeartbeats(errCh)
 
 	go func() {
@@ -103,6 +110,17 @@ func (a Agent) subscribeActionDispatcher(errCh chan error) {
 	errCh <- err
 }
 
+// subscribeLocalActionDispatcher does not stop the agent when local handler
+// fails since the director can still reach the agent over mbus
+func (a Agent) subscribeLocalActionDispatcher() {
+	defer a.logger.HandlePanic("Agent Local Handler")
+
+	err := a.localHandler.Run(a.actionDispatcher.Dispatch)
+	if err != nil {
+		a.logger.Error(agentLogTag, "Local handler: %s", err.Error())
+	}
+}
+
 func (a Agent) generateHeartbeats(errCh chan error) {
 	a.logger.Debug(agentLogTag, "Generating heartbeat")
 	defer a.logger.HandlePanic("Agent Generate Heartbeats")
@@ -157,6 +175,12 @@ func (a Agent) getHeartbeat() (Heartbeat, error) {
 		Vitals:     vitals,
 		NodeID:     spec.NodeID,
 	}
+
+	if statsProvider, ok := a.mbusHandler.(boshhandler.QueueStatsProvider); ok {
+		queueStats := statsProvider.QueueStats()
+		hb.MbusQueue = &queueStats
+	}
+
 	return hb, nil
 }
 
@@ -56,6 +56,7 @@ func init() {
 			agent = New(
 				logger,
 				handler,
+				nil,
 				platform,
 				actionDispatcher,
 				jobSupervisor,
@@ -83,6 +84,73 @@ func init() {
 				Expect(resp).To(Equal(expectedResp))
 			})
 
+			It("lets dispatcher handle requests arriving via local handler", func() {
+				localHandler := fakembus.NewFakeHandler()
+
+				localHandlerRunning := make(chan struct{})
+				localHandler.RunCallBack = func() { close(localHandlerRunning) }
+
+				agent = New(
+					logger,
+					handler,
+					localHandler,
+					platform,
+					actionDispatcher,
+					jobSupervisor,
+					specService,
+					syslogServer,
+					5*time.Millisecond,
+					settingsService,
+					uuidGenerator,
+					timeService,
+				)
+
+				handler.KeepOnRunning()
+				go agent.Run()
+
+				Eventually(localHandlerRunning).Should(BeClosed())
+
+				expectedResp := boshhandler.NewValueResponse("pong")
+				actionDispatcher.DispatchResp = expectedResp
+
+				req := boshhandler.NewRequest("fake-reply", "fake-action", []byte("fake-payload"))
+				resp := localHandler.RunFunc(req)
+
+				Expect(actionDispatcher.DispatchReq).To(Equal(req))
+				Expect(resp).To(Equal(expectedResp))
+			})
+
+			It("keeps running when local handler fails", func() {
+				localHandler := fakembus.NewFakeHandler()
+				localHandler.RunErr = errors.New("fake-local-handler-err")
+
+				localHandlerRunning := make(chan struct{})
+				localHandler.RunCallBack = func() { close(localHandlerRunning) }
+
+				handler.RunCallBack = func() {
+					Eventually(localHandlerRunning).Should(BeClosed())
+				}
+
+				agent = New(
+					logger,
+					handler,
+					localHandler,
+					platform,
+					actionDispatcher,
+					jobSupervisor,
+					specService,
+					syslogServer,
+					5*time.Millisecond,
+					settingsService,
+					uuidGenerator,
+					timeService,
+				)
+
+				// Agent stops with the mbus handler, not with the local handler
+				err := agent.Run()
+				Expect(err).ToNot(HaveOccurred())
+			})
+
 			It("resumes persistent actions *before* dispatching new requests", func() {
 				resumedBeforeStartingToDispatch := false
 				handler.RunCallBack = func() {
@@ -135,6 +203,7 @@ func init() {
 					agent = New(
 						logger,
 						handler,
+						nil,
 						platform,
 						actionDispatcher,
 						jobSupervisor,
@@ -162,6 +231,44 @@ func init() {
 					}))
 				})
 
+				It("includes stats of queued outbound messages when handler queues them", func() {
+					queueingHandler := &queueingFakeHandler{
+						FakeHandler: handler,
+						stats:       boshhandler.QueueStats{Depth: 2, Dropped: 3, Coalesced: 4},
+					}
+
+					agent = New(
+						logger,
+						queueingHandler,
+						nil,
+						platform,
+						actionDispatcher,
+						jobSupervisor,
+						specService,
+						syslogServer,
+						5*time.Hour,
+						settingsService,
+						uuidGenerator,
+						timeService,
+					)
+
+					handler.SendErr = errors.New("stop")
+
+					err := agent.Run()
+					Expect(err).To(HaveOccurred())
+
+					hb := expectedHb
+					hb.MbusQueue = &boshhandler.QueueStats{Depth: 2, Dropped: 3, Coalesced: 4}
+
+					Expect(handler.SendInputs()).To(Equal([]fakembus.SendInput{
+						{
+							Target:  boshhandler.HealthMonitor,
+							Topic:   boshhandler.Heartbeat,
+							Message: hb,
+						},
+					}))
+				})
+
 				It("sends periodic heartbeats", func() {
 					sentRequests := 0
 					handler.SendCallback = func(_ fakembus.SendInput) {
@@ -296,3 +403,12 @@ func init() {
 		})
 	})
 }
+
+type queueingFakeHandler struct {
+	*fakembus.FakeHandler
+	stats boshhandler.QueueStats
+}
+
+func (h *queueingFakeHandler) QueueStats() boshhandler.QueueStats {
+	return h.stats
+}
@@ -2,10 +2,17 @@ package applier
 
 import (
 	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
 )
 
 type Applier interface {
-	Prepare(desiredApplySpec boshas.ApplySpec) error
+	Prepare(desiredApplySpec boshas.ApplySpec, progress boshtask.ProgressReporter) error
 	ConfigureJobs(desiredApplySpec boshas.ApplySpec) error
-	Apply(currentApplySpec, desiredApplySpec boshas.ApplySpec) error
+	// Apply stops before applying next job or package once cancel signal is fired
+	// and rolls back to currentApplySpec whenever it fails halfway
+	Apply(currentApplySpec, desiredApplySpec boshas.ApplySpec, progress boshtask.ProgressReporter, cancelSignal *boshtask.CancelSignal) error
+	// Commit forgets state of the current apply spec kept by successful Apply
+	Commit() error
+	// RollBack restores state of the current apply spec kept by successful Apply
+	RollBack(currentApplySpec boshas.ApplySpec) error
 }
@@ -2,6 +2,7 @@ package applyspec
 
 import models "github.com/cloudfoundry/bosh-agent/agent/applier/models"
 
+// Sha1 has the same format as PackageSpec.Sha1
 type JobTemplateSpec struct {
 	Name        string `json:"name"`
 	Version     string `json:"version"`
@@ -2,6 +2,7 @@ package applyspec
 
 import models "github.com/cloudfoundry/bosh-agent/agent/applier/models"
 
+// Sha1 is either a plain SHA-1 or several digests, e.g. 'sha1:abc;sha256:def'
 type PackageSpec struct {
 	Name        string `json:"name"`
 	Version     string `json:"version"`
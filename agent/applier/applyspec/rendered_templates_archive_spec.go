@@ -4,6 +4,7 @@ import (
 	"github.com/cloudfoundry/bosh-agent/agent/applier/models"
 )
 
+// Sha1 has the same format as PackageSpec.Sha1
 type RenderedTemplatesArchiveSpec struct {
 	Sha1        string `json:"sha1"`
 	BlobstoreID string `json:"blobstore_id"`
@@ -1,88 +1,308 @@
 package applier
 
 import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
 	as "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	"github.com/cloudfoundry/bosh-agent/agent/applier/downloads"
 	"github.com/cloudfoundry/bosh-agent/agent/applier/jobs"
 	"github.com/cloudfoundry/bosh-agent/agent/applier/packages"
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
 	boshjobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor"
 	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
 	boshdirs "github.com/cloudfoundry/bosh-agent/settings/directories"
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshsys "github.com/cloudfoundry/bosh-utils/system"
+)
+
+const (
+	// Suffixes of directories that keep contents of switched
+	// directories of the current and of the failed apply spec
+	previousDirSuffix = ".previous"
+	failedDirSuffix   = ".failed"
+
+	switchedDirPerms = os.FileMode(0755)
 )
 
 type concreteApplier struct {
 	jobApplier        jobs.Applier
 	packageApplier    packages.Applier
+	downloadScheduler downloads.Scheduler
+
+	// Appliers that enable jobs and packages in the apply staging dir
+	stagingJobApplier     jobs.Applier
+	stagingPackageApplier packages.Applier
+
 	logrotateDelegate LogrotateDelegate
 	jobSupervisor     boshjobsuper.JobSupervisor
 	dirProvider       boshdirs.Provider
+	fs                boshsys.FileSystem
 }
 
 func NewConcreteApplier(
 	jobApplier jobs.Applier,
 	packageApplier packages.Applier,
+	stagingJobApplier jobs.Applier,
+	stagingPackageApplier packages.Applier,
+	downloadScheduler downloads.Scheduler,
 	logrotateDelegate LogrotateDelegate,
 	jobSupervisor boshjobsuper.JobSupervisor,
 	dirProvider boshdirs.Provider,
+	fs boshsys.FileSystem,
 ) Applier {
 	return &concreteApplier{
 		jobApplier:        jobApplier,
 		packageApplier:    packageApplier,
+		downloadScheduler: downloadScheduler,
+
+		stagingJobApplier:     stagingJobApplier,
+		stagingPackageApplier: stagingPackageApplier,
+
 		logrotateDelegate: logrotateDelegate,
 		jobSupervisor:     jobSupervisor,
 		dirProvider:       dirProvider,
+		fs:                fs,
 	}
 }
 
-func (a *concreteApplier) Prepare(desiredApplySpec as.ApplySpec) error {
-	for _, job := range desiredApplySpec.Jobs() {
-		err := a.jobApplier.Prepare(job)
+// Prepare downloads and installs jobs and packages at the same time;
+// returned error names every job and package that failed
+func (a *concreteApplier) Prepare(desiredApplySpec as.ApplySpec, progress boshtask.ProgressReporter) error {
+	jobs := desiredApplySpec.Jobs()
+	packages := desiredApplySpec.Packages()
+	total := len(jobs) + len(packages)
+
+	var started int
+	var progressLock sync.Mutex
+
+	reportStarted := func(phase string) {
+		progressLock.Lock()
+		defer progressLock.Unlock()
+
+		progress.ReportProgress(boshtask.Progress{
+			Phase:      phase,
+			Percentage: boshtask.PercentageOf(started, total),
+		})
+
+		started++
+	}
+
+	var toDownload []downloads.Download
+
+	for _, job := range jobs {
+		job := job
+
+		toDownload = append(toDownload, downloads.Download{
+			Name: fmt.Sprintf("job %s", job.Name),
+			Prepare: func() error {
+				reportStarted(fmt.Sprintf("Preparing job %s", job.Name))
+				return a.jobApplier.Prepare(job)
+			},
+		})
+	}
+
+	for _, pkg := range packages {
+		pkg := pkg
+
+		toDownload = append(toDownload, downloads.Download{
+			Name: fmt.Sprintf("package %s", pkg.Name),
+			Prepare: func() error {
+				reportStarted(fmt.Sprintf("Preparing package %s", pkg.Name))
+				return a.packageApplier.Prepare(pkg)
+			},
+		})
+	}
+
+	return a.downloadScheduler.Run(toDownload)
+}
+
+// Apply builds job and package symlinks of desiredApplySpec and empty job supervisor
+// configuration in staging directories while jobs of currentApplySpec keep running.
+// Staged directories are then renamed over current ones which are kept aside,
+// jobs that are no longer needed are removed and job supervisor is reloaded.
+// Any failure restores currentApplySpec (see RollBack) and returned error details
+// record whether that succeeded. Directories kept aside stay until Commit or RollBack.
+func (a *concreteApplier) Apply(currentApplySpec, desiredApplySpec as.ApplySpec, progress boshtask.ProgressReporter, cancelSignal *boshtask.CancelSignal) error {
+	progress.ReportProgress(boshtask.Progress{
+		Phase:      "Downloading jobs and packages",
+		Percentage: boshtask.PercentageOf(0, 100),
+	})
+
+	// Jobs keep running while jobs and packages are downloaded in parallel;
+	// applying them afterwards finds them already installed
+	err := a.Prepare(desiredApplySpec, boshtask.NewNoopProgressReporter())
+	if err != nil {
+		return err
+	}
+
+	// Nothing was changed yet hence there is nothing to roll back
+	if err := cancelSignal.Err(); err != nil {
+		return err
+	}
+
+	// Directories kept by a previous apply that was neither committed
+	// nor rolled back (e.g. agent was restarted) must not be restored
+	err = a.removePreviousDirs()
+	if err != nil {
+		return err
+	}
+
+	err = a.stage(desiredApplySpec, progress, cancelSignal)
+	if err == nil {
+		err = a.switchOver(currentApplySpec, desiredApplySpec, progress)
+	}
+
+	removeErr := a.fs.RemoveAll(a.dirProvider.ApplyStagingDir())
+	if err == nil && removeErr != nil {
+		err = bosherr.WrapError(removeErr, "Removing apply staging dir")
+	}
+
+	if err != nil {
+		progress.ReportProgress(boshtask.Progress{Phase: "Rolling back to previous apply spec"})
+
+		return a.rollBackError(err, a.RollBack(currentApplySpec))
+	}
+
+	return nil
+}
+
+// Commit removes symlinks and job supervisor configuration moved aside by Apply
+func (a *concreteApplier) Commit() error {
+	return a.removePreviousDirs()
+}
+
+// RollBack moves symlinks and job supervisor configuration of currentApplySpec
+// back in place with renames and reloads job supervisor. Directory that cannot
+// be moved back keeps its previous contents aside so rolling back again restores it.
+// Jobs and packages of currentApplySpec stay installed until apply is committed.
+func (a *concreteApplier) RollBack(currentApplySpec as.ApplySpec) error {
+	var errs []error
+
+	for _, dir := range a.switchedDirs() {
+		err := a.moveBack(dir)
 		if err != nil {
-			return bosherr.WrapErrorf(err, "Preparing job %s", job.Name)
+			errs = append(errs, err)
 		}
 	}
 
-	for _, pkg := range desiredApplySpec.Packages() {
-		err := a.packageApplier.Prepare(pkg)
+	// Unchanged jobs share install dir with desired jobs
+	// hence their package symlinks were switched over as well
+	for _, job := range currentApplySpec.Jobs() {
+		err := a.jobApplier.Apply(job)
 		if err != nil {
-			return bosherr.WrapErrorf(err, "Preparing package %s", pkg.Name)
+			errs = append(errs, bosherr.WrapErrorf(err, "Restoring job %s", job.Name))
 		}
 	}
 
-	return nil
+	if len(errs) > 0 {
+		return bosherr.NewMultiError(errs...)
+	}
+
+	err := a.jobSupervisor.Reload()
+	if err != nil {
+		return bosherr.WrapError(err, "Reloading jobSupervisor")
+	}
+
+	return a.setUpLogrotate(currentApplySpec)
 }
 
-func (a *concreteApplier) Apply(currentApplySpec, desiredApplySpec as.ApplySpec) error {
-	err := a.jobSupervisor.RemoveAllJobs()
+// stage enables jobs and packages of desiredApplySpec in staging directories
+func (a *concreteApplier) stage(desiredApplySpec as.ApplySpec, progress boshtask.ProgressReporter, cancelSignal *boshtask.CancelSignal) error {
+	err := a.fs.RemoveAll(a.dirProvider.ApplyStagingDir())
 	if err != nil {
-		return bosherr.WrapError(err, "Removing all jobs")
+		return bosherr.WrapError(err, "Removing apply staging dir")
+	}
+
+	for _, dir := range a.switchedDirs() {
+		err = a.fs.MkdirAll(a.stagedDir(dir), switchedDirPerms)
+		if err != nil {
+			return bosherr.WrapErrorf(err, "Creating staged %s", dir)
+		}
 	}
 
 	jobs := desiredApplySpec.Jobs()
-	for _, job := range jobs {
-		err = a.jobApplier.Apply(job)
+	packages := desiredApplySpec.Packages()
+	total := len(jobs) + len(packages)
+
+	for i, job := range jobs {
+		if err := cancelSignal.Err(); err != nil {
+			return err
+		}
+
+		progress.ReportProgress(boshtask.Progress{
+			Phase:      fmt.Sprintf("Applying job %s", job.Name),
+			Percentage: boshtask.PercentageOf(i, total),
+		})
+
+		err = a.stagingJobApplier.Apply(job)
 		if err != nil {
 			return bosherr.WrapErrorf(err, "Applying job %s", job.Name)
 		}
 	}
 
-	err = a.jobApplier.KeepOnly(append(currentApplySpec.Jobs(), desiredApplySpec.Jobs()...))
-	if err != nil {
-		return bosherr.WrapError(err, "Keeping only needed jobs")
-	}
+	for i, pkg := range packages {
+		if err := cancelSignal.Err(); err != nil {
+			return err
+		}
+
+		progress.ReportProgress(boshtask.Progress{
+			Phase:      fmt.Sprintf("Applying package %s", pkg.Name),
+			Percentage: boshtask.PercentageOf(len(jobs)+i, total),
+		})
 
-	for _, pkg := range desiredApplySpec.Packages() {
-		err = a.packageApplier.Apply(pkg)
+		err = a.stagingPackageApplier.Apply(pkg)
 		if err != nil {
 			return bosherr.WrapErrorf(err, "Applying package %s", pkg.Name)
 		}
 	}
 
+	// Last chance to stop before current jobs are replaced
+	return cancelSignal.Err()
+}
+
+// switchOver renames staged directories over current ones, removes
+// jobs and packages that are no longer needed and reloads job supervisor
+func (a *concreteApplier) switchOver(currentApplySpec, desiredApplySpec as.ApplySpec, progress boshtask.ProgressReporter) error {
+	for _, dir := range a.switchedDirs() {
+		// Directory is created so that RollBack knows it has to be emptied
+		err := a.fs.MkdirAll(dir, switchedDirPerms)
+		if err != nil {
+			return bosherr.WrapErrorf(err, "Creating %s", dir)
+		}
+
+		err = a.fs.Rename(dir, dir+previousDirSuffix)
+		if err != nil {
+			return bosherr.WrapErrorf(err, "Moving aside %s", dir)
+		}
+
+		err = a.fs.Rename(a.stagedDir(dir), dir)
+		if err != nil {
+			return bosherr.WrapErrorf(err, "Switching over to staged %s", dir)
+		}
+	}
+
+	err := a.jobApplier.KeepOnly(append(currentApplySpec.Jobs(), desiredApplySpec.Jobs()...))
+	if err != nil {
+		return bosherr.WrapError(err, "Keeping only needed jobs")
+	}
+
 	err = a.packageApplier.KeepOnly(append(currentApplySpec.Packages(), desiredApplySpec.Packages()...))
 	if err != nil {
 		return bosherr.WrapError(err, "Keeping only needed packages")
 	}
 
+	total := len(desiredApplySpec.Jobs()) + len(desiredApplySpec.Packages())
+
+	progress.ReportProgress(boshtask.Progress{
+		Phase:      "Reloading job supervisor",
+		Percentage: boshtask.PercentageOf(total, total),
+	})
+
 	err = a.jobSupervisor.Reload()
 	if err != nil {
 		return bosherr.WrapError(err, "Reloading jobSupervisor")
@@ -91,6 +311,95 @@ func (a *concreteApplier) Apply(currentApplySpec, desiredApplySpec as.ApplySpec)
 	return a.setUpLogrotate(desiredApplySpec)
 }
 
+// switchedDirs returns directories with job and package symlinks
+// and job supervisor configuration that are replaced by Apply
+func (a *concreteApplier) switchedDirs() []string {
+	return []string{
+		a.dirProvider.JobsDir(),
+		path.Join(a.dirProvider.BaseDir(), "packages"),
+		a.dirProvider.MonitJobsDir(),
+	}
+}
+
+// stagedDir returns directory in the apply staging dir that replaces dir
+// (e.g. /var/vcap/apply_staging/jobs replaces /var/vcap/jobs)
+func (a *concreteApplier) stagedDir(dir string) string {
+	return path.Join(a.dirProvider.ApplyStagingDir(), strings.TrimPrefix(dir, a.dirProvider.BaseDir()))
+}
+
+func (a *concreteApplier) removePreviousDirs() error {
+	for _, dir := range a.switchedDirs() {
+		err := a.fs.RemoveAll(dir + previousDirSuffix)
+		if err != nil {
+			return bosherr.WrapErrorf(err, "Removing previous %s", dir)
+		}
+	}
+
+	return nil
+}
+
+// moveBack replaces dir with its previous contents; partially switched
+// contents are only removed once previous contents are back in place
+func (a *concreteApplier) moveBack(dir string) error {
+	previousDir := dir + previousDirSuffix
+	failedDir := dir + failedDirSuffix
+
+	if !a.fs.FileExists(previousDir) {
+		return nil
+	}
+
+	err := a.fs.RemoveAll(failedDir)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Removing %s", failedDir)
+	}
+
+	if a.fs.FileExists(dir) {
+		err = a.fs.Rename(dir, failedDir)
+		if err != nil {
+			return bosherr.WrapErrorf(err, "Moving aside %s", dir)
+		}
+	}
+
+	err = a.fs.Rename(previousDir, dir)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Moving back %s", dir)
+	}
+
+	err = a.fs.RemoveAll(failedDir)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Removing %s", failedDir)
+	}
+
+	return nil
+}
+
+// rollBackError keeps classification of applyErr so that e.g. cancelled apply
+// is still reported as cancelled and adds details about the rollback
+func (a *concreteApplier) rollBackError(applyErr, restoreErr error) error {
+	details := map[string]interface{}{}
+
+	code := boshcodederr.ErrorCodeApplyFailed
+
+	if codedErr, found := boshcodederr.FindCodedError(applyErr); found {
+		code = codedErr.Code
+
+		for name, value := range codedErr.Details {
+			details[name] = value
+		}
+	}
+
+	err := applyErr
+	details["rolled_back"] = restoreErr == nil
+
+	if restoreErr != nil {
+		restoreErr = bosherr.WrapError(restoreErr, "Rolling back to previous apply spec")
+		details["rollback_error"] = restoreErr.Error()
+		err = bosherr.NewMultiError(applyErr, restoreErr)
+	}
+
+	return boshcodederr.NewCodedError(code, err).WithDetails(details)
+}
+
 func (a *concreteApplier) ConfigureJobs(desiredApplySpec as.ApplySpec) error {
 
 	jobs := desiredApplySpec.Jobs()
@@ -10,12 +10,18 @@ import (
 
 	. "github.com/cloudfoundry/bosh-agent/agent/applier"
 	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
+	"github.com/cloudfoundry/bosh-agent/agent/applier/downloads"
 	fakejobs "github.com/cloudfoundry/bosh-agent/agent/applier/jobs/fakes"
 	models "github.com/cloudfoundry/bosh-agent/agent/applier/models"
 	fakepackages "github.com/cloudfoundry/bosh-agent/agent/applier/packages/fakes"
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
+	faketask "github.com/cloudfoundry/bosh-agent/agent/task/fakes"
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
 	fakejobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor/fakes"
 	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
 	boshdirs "github.com/cloudfoundry/bosh-agent/settings/directories"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
 	boshuuid "github.com/cloudfoundry/bosh-utils/uuid"
 )
 
@@ -52,24 +58,42 @@ func buildPackage() models.Package {
 func init() {
 	Describe("concreteApplier", func() {
 		var (
-			jobApplier        *fakejobs.FakeApplier
-			packageApplier    *fakepackages.FakeApplier
+			jobApplier     *fakejobs.FakeApplier
+			packageApplier *fakepackages.FakeApplier
+
+			stagingJobApplier     *fakejobs.FakeApplier
+			stagingPackageApplier *fakepackages.FakeApplier
+
 			logRotateDelegate *FakeLogRotateDelegate
 			jobSupervisor     *fakejobsuper.FakeJobSupervisor
+			progress          *faketask.FakeProgressReporter
+			cancelSignal      *boshtask.CancelSignal
+			fs                *fakesys.FakeFileSystem
 			applier           Applier
 		)
 
 		BeforeEach(func() {
 			jobApplier = fakejobs.NewFakeApplier()
 			packageApplier = fakepackages.NewFakeApplier()
+			stagingJobApplier = fakejobs.NewFakeApplier()
+			stagingPackageApplier = fakepackages.NewFakeApplier()
 			logRotateDelegate = &FakeLogRotateDelegate{}
 			jobSupervisor = fakejobsuper.NewFakeJobSupervisor()
+			progress = faketask.NewFakeProgressReporter()
+			cancelSignal = boshtask.NewCancelSignal()
+			fs = fakesys.NewFakeFileSystem()
+			fs.MkdirAll("/fake-base-dir/monit", 0755)
+			fs.MkdirAll("/fake-base-dir", 0755)
 			applier = NewConcreteApplier(
 				jobApplier,
 				packageApplier,
+				stagingJobApplier,
+				stagingPackageApplier,
+				downloads.NewScheduler(downloads.Options{Parallelism: 1}, boshlog.NewLogger(boshlog.LevelNone)),
 				logRotateDelegate,
 				jobSupervisor,
 				boshdirs.NewProvider("/fake-base-dir"),
+				fs,
 			)
 		})
 
@@ -79,6 +103,7 @@ func init() {
 
 				err := applier.Prepare(
 					&fakeas.FakeApplySpec{JobResults: []models.Job{job}},
+					progress,
 				)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(jobApplier.PreparedJobs).To(Equal([]models.Job{job}))
@@ -91,6 +116,7 @@ func init() {
 
 				err := applier.Prepare(
 					&fakeas.FakeApplySpec{JobResults: []models.Job{job}},
+					progress,
 				)
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("fake-prepare-job-error"))
@@ -102,6 +128,7 @@ func init() {
 
 				err := applier.Prepare(
 					&fakeas.FakeApplySpec{PackageResults: []models.Package{pkg1, pkg2}},
+					progress,
 				)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(packageApplier.PreparedPackages).To(Equal([]models.Package{pkg1, pkg2}))
@@ -114,10 +141,47 @@ func init() {
 
 				err := applier.Prepare(
 					&fakeas.FakeApplySpec{PackageResults: []models.Package{pkg}},
+					progress,
 				)
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("fake-prepare-package-error"))
 			})
+
+			It("prepares remaining jobs and packages and names every one that failed", func() {
+				job := buildJob()
+				pkg1 := buildPackage()
+				pkg2 := buildPackage()
+
+				jobApplier.PrepareError = errors.New("fake-prepare-job-error")
+				packageApplier.PrepareError = errors.New("fake-prepare-package-error")
+
+				err := applier.Prepare(
+					&fakeas.FakeApplySpec{JobResults: []models.Job{job}, PackageResults: []models.Package{pkg1, pkg2}},
+					progress,
+				)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("Failed to prepare job %s, package %s, package %s", job.Name, pkg1.Name, pkg2.Name))
+				Expect(err.Error()).To(ContainSubstring("Preparing package %s: fake-prepare-package-error", pkg2.Name))
+
+				Expect(packageApplier.PreparedPackages).To(Equal([]models.Package{pkg1, pkg2}))
+			})
+
+			It("keeps blobstore error code when several jobs and packages fail", func() {
+				blobstoreErr := boshcodederr.NewCodedError(boshcodederr.ErrorCodeBlobstoreUnavailable, errors.New("fake-blobstore-error"))
+
+				jobApplier.PrepareError = blobstoreErr
+				packageApplier.PrepareError = blobstoreErr
+
+				err := applier.Prepare(
+					&fakeas.FakeApplySpec{JobResults: []models.Job{buildJob()}, PackageResults: []models.Package{buildPackage()}},
+					progress,
+				)
+				Expect(err).To(HaveOccurred())
+
+				codedErr, found := boshcodederr.FindCodedError(err)
+				Expect(found).To(BeTrue())
+				Expect(codedErr.Code).To(Equal(boshcodederr.ErrorCodeBlobstoreUnavailable))
+			})
 		})
 
 		Describe("Configure jobs", func() {
@@ -143,36 +207,130 @@ func init() {
 
 				Expect(jobApplier.ConfiguredJobs).To(ConsistOf(job1, job2))
 			})
+			It("reports progress before preparing each job and package", func() {
+				job := buildJob()
+				pkg := buildPackage()
+
+				err := applier.Prepare(
+					&fakeas.FakeApplySpec{JobResults: []models.Job{job}, PackageResults: []models.Package{pkg}},
+					progress,
+				)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(progress.Phases()).To(Equal([]string{
+					"Preparing job " + job.Name,
+					"Preparing package " + pkg.Name,
+				}))
+				Expect(*progress.Reported[0].Percentage).To(Equal(0))
+				Expect(*progress.Reported[1].Percentage).To(Equal(50))
+			})
 		})
 
 		Describe("Apply", func() {
-			It("removes all jobs from job supervisor", func() {
-				err := applier.Apply(&fakeas.FakeApplySpec{}, &fakeas.FakeApplySpec{})
+			It("reports progress while applying jobs and packages", func() {
+				job := buildJob()
+				pkg := buildPackage()
+
+				err := applier.Apply(
+					&fakeas.FakeApplySpec{},
+					&fakeas.FakeApplySpec{JobResults: []models.Job{job}, PackageResults: []models.Package{pkg}},
+					progress,
+					cancelSignal,
+				)
 				Expect(err).ToNot(HaveOccurred())
+				Expect(progress.Phases()).To(Equal([]string{
+					"Downloading jobs and packages",
+					"Applying job " + job.Name,
+					"Applying package " + pkg.Name,
+					"Reloading job supervisor",
+				}))
+				Expect(*progress.Reported[3].Percentage).To(Equal(100))
+			})
 
-				Expect(jobSupervisor.RemovedAllJobs).To(BeTrue())
+			It("downloads all jobs and packages before applying them", func() {
+				job := buildJob()
+				pkg := buildPackage()
+
+				stagingJobApplier.ApplyError = errors.New("fake-apply-job-error")
+
+				err := applier.Apply(
+					&fakeas.FakeApplySpec{},
+					&fakeas.FakeApplySpec{JobResults: []models.Job{job}, PackageResults: []models.Package{pkg}},
+					progress,
+					cancelSignal,
+				)
+				Expect(err).To(HaveOccurred())
+
+				Expect(jobApplier.PreparedJobs).To(Equal([]models.Job{job}))
+				Expect(packageApplier.PreparedPackages).To(Equal([]models.Package{pkg}))
 			})
 
-			It("removes all previous jobs from job supervisor before starting to apply jobs", func() {
-				// force remove all error
-				jobSupervisor.RemovedAllJobsErr = errors.New("fake-remove-all-jobs-error")
+			It("keeps jobs running when downloading fails", func() {
+				packageApplier.PrepareError = errors.New("fake-prepare-package-error")
 
+				err := applier.Apply(
+					&fakeas.FakeApplySpec{},
+					&fakeas.FakeApplySpec{PackageResults: []models.Package{buildPackage()}},
+					progress,
+					cancelSignal,
+				)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("fake-prepare-package-error"))
+
+				Expect(fs.RenameOldPaths).To(BeEmpty())
+				Expect(stagingPackageApplier.AppliedPackages).To(BeEmpty())
+			})
+
+			It("stops before applying next job or package once cancelled", func() {
 				job := buildJob()
-				applier.Apply(
+				pkg := buildPackage()
+
+				stagingJobApplier.ApplyCallBack = func() { cancelSignal.Cancel() }
+
+				err := applier.Apply(
 					&fakeas.FakeApplySpec{},
-					&fakeas.FakeApplySpec{JobResults: []models.Job{job}},
+					&fakeas.FakeApplySpec{JobResults: []models.Job{job}, PackageResults: []models.Package{pkg}},
+					progress,
+					cancelSignal,
 				)
+				Expect(err).To(HaveOccurred())
 
-				// check that jobs were not applied before removing all other jobs
-				Expect(jobApplier.AppliedJobs).To(Equal([]models.Job{}))
+				codedErr, found := boshcodederr.FindCodedError(err)
+				Expect(found).To(BeTrue())
+				Expect(codedErr.Code).To(Equal(boshcodederr.ErrorCodeCancelled))
+
+				Expect(stagingJobApplier.AppliedJobs).To(Equal([]models.Job{job}))
+				Expect(stagingPackageApplier.AppliedPackages).To(BeEmpty())
+				Expect(codedErr.Details).To(HaveKeyWithValue("rolled_back", true))
+
+				// Current jobs were never replaced
+				Expect(fs.RenameOldPaths).To(BeEmpty())
+				Expect(fs.FileExists("/fake-base-dir/apply_staging")).To(BeFalse())
 			})
 
-			It("returns error if removing all jobs from job supervisor fails", func() {
-				jobSupervisor.RemovedAllJobsErr = errors.New("fake-remove-all-jobs-error")
+			It("does not change anything when cancelled while downloading", func() {
+				jobApplier.PrepareCallBack = func() { cancelSignal.Cancel() }
 
-				err := applier.Apply(&fakeas.FakeApplySpec{}, &fakeas.FakeApplySpec{})
+				err := applier.Apply(
+					&fakeas.FakeApplySpec{},
+					&fakeas.FakeApplySpec{JobResults: []models.Job{buildJob()}},
+					progress,
+					cancelSignal,
+				)
 				Expect(err).To(HaveOccurred())
-				Expect(err.Error()).To(ContainSubstring("fake-remove-all-jobs-error"))
+
+				Expect(fs.RenameOldPaths).To(BeEmpty())
+				Expect(stagingJobApplier.AppliedJobs).To(BeEmpty())
+			})
+
+			It("replaces job supervisor configuration of current jobs with empty one", func() {
+				fs.WriteFileString("/fake-base-dir/monit/job/fake-current-job.monitrc", "fake-monitrc")
+
+				err := applier.Apply(&fakeas.FakeApplySpec{}, &fakeas.FakeApplySpec{}, progress, cancelSignal)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(fs.FileExists("/fake-base-dir/monit/job")).To(BeTrue())
+				Expect(fs.FileExists("/fake-base-dir/monit/job/fake-current-job.monitrc")).To(BeFalse())
+				Expect(jobSupervisor.Reloaded).To(BeTrue())
 			})
 
 			It("apply applies jobs", func() {
@@ -181,19 +339,24 @@ func init() {
 				err := applier.Apply(
 					&fakeas.FakeApplySpec{},
 					&fakeas.FakeApplySpec{JobResults: []models.Job{job}},
+					progress,
+					cancelSignal,
 				)
 				Expect(err).ToNot(HaveOccurred())
-				Expect(jobApplier.AppliedJobs).To(Equal([]models.Job{job}))
+				Expect(stagingJobApplier.AppliedJobs).To(Equal([]models.Job{job}))
+				Expect(jobApplier.AppliedJobs).To(BeEmpty())
 			})
 
 			It("apply errs when applying jobs errs", func() {
 				job := buildJob()
 
-				jobApplier.ApplyError = errors.New("fake-apply-job-error")
+				stagingJobApplier.ApplyError = errors.New("fake-apply-job-error")
 
 				err := applier.Apply(
 					&fakeas.FakeApplySpec{},
 					&fakeas.FakeApplySpec{JobResults: []models.Job{job}},
+					progress,
+					cancelSignal,
 				)
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("fake-apply-job-error"))
@@ -206,6 +369,8 @@ func init() {
 				err := applier.Apply(
 					&fakeas.FakeApplySpec{JobResults: []models.Job{currentJob}},
 					&fakeas.FakeApplySpec{JobResults: []models.Job{desiredJob}},
+					progress,
+					cancelSignal,
 				)
 				Expect(err).ToNot(HaveOccurred())
 
@@ -221,6 +386,8 @@ func init() {
 				err := applier.Apply(
 					&fakeas.FakeApplySpec{JobResults: []models.Job{currentJob}},
 					&fakeas.FakeApplySpec{JobResults: []models.Job{desiredJob}},
+					progress,
+					cancelSignal,
 				)
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("fake-keep-only-error"))
@@ -233,19 +400,24 @@ func init() {
 				err := applier.Apply(
 					&fakeas.FakeApplySpec{},
 					&fakeas.FakeApplySpec{PackageResults: []models.Package{pkg1, pkg2}},
+					progress,
+					cancelSignal,
 				)
 				Expect(err).ToNot(HaveOccurred())
-				Expect(packageApplier.AppliedPackages).To(Equal([]models.Package{pkg1, pkg2}))
+				Expect(stagingPackageApplier.AppliedPackages).To(Equal([]models.Package{pkg1, pkg2}))
+				Expect(packageApplier.AppliedPackages).To(BeEmpty())
 			})
 
 			It("apply errs when applying packages errs", func() {
 				pkg := buildPackage()
 
-				packageApplier.ApplyError = errors.New("fake-apply-package-error")
+				stagingPackageApplier.ApplyError = errors.New("fake-apply-package-error")
 
 				err := applier.Apply(
 					&fakeas.FakeApplySpec{},
 					&fakeas.FakeApplySpec{PackageResults: []models.Package{pkg}},
+					progress,
+					cancelSignal,
 				)
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("fake-apply-package-error"))
@@ -258,6 +430,8 @@ func init() {
 				err := applier.Apply(
 					&fakeas.FakeApplySpec{PackageResults: []models.Package{currentPkg}},
 					&fakeas.FakeApplySpec{PackageResults: []models.Package{desiredPkg}},
+					progress,
+					cancelSignal,
 				)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(packageApplier.KeptOnlyPackages).To(Equal([]models.Package{currentPkg, desiredPkg}))
@@ -272,6 +446,8 @@ func init() {
 				err := applier.Apply(
 					&fakeas.FakeApplySpec{PackageResults: []models.Package{currentPkg}},
 					&fakeas.FakeApplySpec{PackageResults: []models.Package{desiredPkg}},
+					progress,
+					cancelSignal,
 				)
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("fake-keep-only-error"))
@@ -282,7 +458,7 @@ func init() {
 				job2 := models.Job{Name: "fake-job-name-2", Version: "fake-version-name-2"}
 				jobs := []models.Job{job1, job2}
 
-				err := applier.Apply(&fakeas.FakeApplySpec{}, &fakeas.FakeApplySpec{JobResults: jobs})
+				err := applier.Apply(&fakeas.FakeApplySpec{}, &fakeas.FakeApplySpec{JobResults: jobs}, progress, cancelSignal)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(jobApplier.ConfiguredJobs).To(BeEmpty())
 
@@ -293,7 +469,7 @@ func init() {
 				jobs := []models.Job{}
 				jobSupervisor.ReloadErr = errors.New("error reloading monit")
 
-				err := applier.Apply(&fakeas.FakeApplySpec{}, &fakeas.FakeApplySpec{JobResults: jobs})
+				err := applier.Apply(&fakeas.FakeApplySpec{}, &fakeas.FakeApplySpec{JobResults: jobs}, progress, cancelSignal)
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("error reloading monit"))
 			})
@@ -302,6 +478,8 @@ func init() {
 				err := applier.Apply(
 					&fakeas.FakeApplySpec{},
 					&fakeas.FakeApplySpec{MaxLogFileSizeResult: "fake-size"},
+					progress,
+					cancelSignal,
 				)
 				Expect(err).ToNot(HaveOccurred())
 
@@ -312,10 +490,235 @@ func init() {
 				})
 			})
 
+			It("applies jobs and packages in staging directories and renames them over current ones", func() {
+				stagingJobApplier.ApplyCallBack = func() {
+					Expect(fs.RenameOldPaths).To(BeEmpty())
+					Expect(fs.FileExists("/fake-base-dir/apply_staging/jobs")).To(BeTrue())
+					Expect(fs.FileExists("/fake-base-dir/apply_staging/packages")).To(BeTrue())
+					Expect(fs.FileExists("/fake-base-dir/apply_staging/monit/job")).To(BeTrue())
+				}
+
+				err := applier.Apply(
+					&fakeas.FakeApplySpec{},
+					&fakeas.FakeApplySpec{JobResults: []models.Job{buildJob()}},
+					progress,
+					cancelSignal,
+				)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(fs.RenameOldPaths).To(Equal([]string{
+					"/fake-base-dir/jobs",
+					"/fake-base-dir/apply_staging/jobs",
+					"/fake-base-dir/packages",
+					"/fake-base-dir/apply_staging/packages",
+					"/fake-base-dir/monit/job",
+					"/fake-base-dir/apply_staging/monit/job",
+				}))
+				Expect(fs.RenameNewPaths).To(Equal([]string{
+					"/fake-base-dir/jobs.previous",
+					"/fake-base-dir/jobs",
+					"/fake-base-dir/packages.previous",
+					"/fake-base-dir/packages",
+					"/fake-base-dir/monit/job.previous",
+					"/fake-base-dir/monit/job",
+				}))
+				Expect(fs.FileExists("/fake-base-dir/jobs")).To(BeTrue())
+				Expect(fs.FileExists("/fake-base-dir/apply_staging")).To(BeFalse())
+			})
+
+			It("keeps moved aside directories until apply is committed", func() {
+				err := applier.Apply(&fakeas.FakeApplySpec{}, &fakeas.FakeApplySpec{}, progress, cancelSignal)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fs.FileExists("/fake-base-dir/jobs.previous")).To(BeTrue())
+
+				err = applier.Commit()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fs.FileExists("/fake-base-dir/jobs.previous")).To(BeFalse())
+				Expect(fs.FileExists("/fake-base-dir/packages.previous")).To(BeFalse())
+				Expect(fs.FileExists("/fake-base-dir/monit/job.previous")).To(BeFalse())
+			})
+
+			It("rolls back when staged directories cannot be renamed over current ones", func() {
+				fs.RenameError = errors.New("fake-rename-error")
+
+				err := applier.Apply(
+					&fakeas.FakeApplySpec{},
+					&fakeas.FakeApplySpec{JobResults: []models.Job{buildJob()}},
+					progress,
+					cancelSignal,
+				)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("Moving aside /fake-base-dir/jobs"))
+				Expect(err.Error()).To(ContainSubstring("fake-rename-error"))
+
+				Expect(fs.FileExists("/fake-base-dir/jobs")).To(BeTrue())
+				Expect(fs.FileExists("/fake-base-dir/apply_staging")).To(BeFalse())
+
+				codedErr, found := boshcodederr.FindCodedError(err)
+				Expect(found).To(BeTrue())
+				Expect(codedErr.Details).To(HaveKeyWithValue("rolled_back", true))
+			})
+
+			It("drops directories kept aside by previous apply that was not committed", func() {
+				fs.MkdirAll("/fake-base-dir/jobs.previous", 0755)
+				stagingJobApplier.ApplyError = errors.New("fake-apply-job-error")
+
+				err := applier.Apply(
+					&fakeas.FakeApplySpec{},
+					&fakeas.FakeApplySpec{JobResults: []models.Job{buildJob()}},
+					progress,
+					cancelSignal,
+				)
+				Expect(err).To(HaveOccurred())
+
+				Expect(fs.FileExists("/fake-base-dir/jobs.previous")).To(BeFalse())
+				Expect(fs.RenameOldPaths).To(BeEmpty())
+			})
+
+			Context("when switching over to desired jobs and packages fails", func() {
+				var (
+					currentJob, desiredJob models.Job
+					currentPkg, desiredPkg models.Package
+					currentSpec            *fakeas.FakeApplySpec
+					desiredSpec            *fakeas.FakeApplySpec
+				)
+
+				BeforeEach(func() {
+					currentJob = buildJob()
+					desiredJob = buildJob()
+					currentPkg = buildPackage()
+					desiredPkg = buildPackage()
+
+					currentSpec = &fakeas.FakeApplySpec{
+						JobResults:           []models.Job{currentJob},
+						PackageResults:       []models.Package{currentPkg},
+						MaxLogFileSizeResult: "fake-current-size",
+					}
+					desiredSpec = &fakeas.FakeApplySpec{
+						JobResults:           []models.Job{desiredJob},
+						PackageResults:       []models.Package{desiredPkg},
+						MaxLogFileSizeResult: "fake-desired-size",
+					}
+
+					stagingPackageApplier.ApplyError = errors.New("fake-apply-package-error")
+				})
+
+				It("keeps current directories in place when applying desired jobs or packages fails", func() {
+					err := applier.Apply(currentSpec, desiredSpec, progress, cancelSignal)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("fake-apply-package-error"))
+
+					Expect(fs.RenameOldPaths).To(BeEmpty())
+					Expect(fs.FileExists("/fake-base-dir/apply_staging")).To(BeFalse())
+
+					// Package symlinks of unchanged jobs are switched back
+					Expect(stagingJobApplier.AppliedJobs).To(Equal([]models.Job{desiredJob}))
+					Expect(jobApplier.AppliedJobs).To(Equal([]models.Job{currentJob}))
+
+					Expect(jobSupervisor.Reloaded).To(BeTrue())
+					Expect(logRotateDelegate.SetupLogrotateArgs.Size).To(Equal("fake-current-size"))
+
+					Expect(progress.Phases()).To(ContainElement("Rolling back to previous apply spec"))
+				})
+
+				It("moves back symlinks and job supervisor configuration of current spec", func() {
+					stagingPackageApplier.ApplyError = nil
+					packageApplier.KeepOnlyErr = errors.New("fake-keep-only-error")
+
+					err := applier.Apply(currentSpec, desiredSpec, progress, cancelSignal)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("fake-keep-only-error"))
+
+					Expect(fs.RenameOldPaths[6:]).To(Equal([]string{
+						"/fake-base-dir/jobs",
+						"/fake-base-dir/jobs.previous",
+						"/fake-base-dir/packages",
+						"/fake-base-dir/packages.previous",
+						"/fake-base-dir/monit/job",
+						"/fake-base-dir/monit/job.previous",
+					}))
+					Expect(fs.RenameNewPaths[6:]).To(Equal([]string{
+						"/fake-base-dir/jobs.failed",
+						"/fake-base-dir/jobs",
+						"/fake-base-dir/packages.failed",
+						"/fake-base-dir/packages",
+						"/fake-base-dir/monit/job.failed",
+						"/fake-base-dir/monit/job",
+					}))
+					Expect(fs.FileExists("/fake-base-dir/jobs.failed")).To(BeFalse())
+
+					// Package symlinks of unchanged jobs are switched back
+					Expect(jobApplier.AppliedJobs).To(Equal([]models.Job{currentJob}))
+
+					Expect(jobSupervisor.Reloaded).To(BeTrue())
+					Expect(logRotateDelegate.SetupLogrotateArgs.Size).To(Equal("fake-current-size"))
+
+					Expect(progress.Phases()).To(ContainElement("Rolling back to previous apply spec"))
+				})
+
+				It("records that apply was rolled back", func() {
+					err := applier.Apply(currentSpec, desiredSpec, progress, cancelSignal)
+					Expect(err).To(HaveOccurred())
+
+					codedErr, found := boshcodederr.FindCodedError(err)
+					Expect(found).To(BeTrue())
+					Expect(codedErr.Code).To(Equal(boshcodederr.ErrorCodeApplyFailed))
+					Expect(codedErr.Details).To(Equal(map[string]interface{}{"rolled_back": true}))
+				})
+
+				It("rolls back when reloading job supervisor fails", func() {
+					stagingPackageApplier.ApplyError = nil
+					jobSupervisor.ReloadErr = errors.New("fake-reload-error")
+
+					err := applier.Apply(currentSpec, desiredSpec, progress, cancelSignal)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("fake-reload-error"))
+
+					Expect(fs.RenameNewPaths[6:]).To(ContainElement("/fake-base-dir/monit/job"))
+					Expect(jobApplier.AppliedJobs).To(Equal([]models.Job{currentJob}))
+				})
+
+				It("records rollback failure next to the original error", func() {
+					stagingPackageApplier.ApplyError = nil
+					packageApplier.KeepOnlyErr = errors.New("fake-keep-only-error")
+					fs.RegisterRemoveAllError("/fake-base-dir/packages.failed", errors.New("fake-remove-all-error"))
+
+					err := applier.Apply(currentSpec, desiredSpec, progress, cancelSignal)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("fake-keep-only-error"))
+					Expect(err.Error()).To(ContainSubstring("Removing /fake-base-dir/packages.failed"))
+
+					// Other directories are still moved back
+					Expect(fs.RenameNewPaths).To(ContainElement("/fake-base-dir/jobs"))
+					Expect(fs.RenameNewPaths).To(ContainElement("/fake-base-dir/monit/job"))
+
+					codedErr, found := boshcodederr.FindCodedError(err)
+					Expect(found).To(BeTrue())
+					Expect(codedErr.Details["rolled_back"]).To(BeFalse())
+					Expect(codedErr.Details["rollback_error"]).To(ContainSubstring("Rolling back to previous apply spec"))
+				})
+
+				It("keeps code and details of the original error", func() {
+					stagingPackageApplier.ApplyError = nil
+					jobSupervisor.ReloadErr = boshcodederr.NewCodedError(boshcodederr.ErrorCodeTimeout, errors.New("fake-reload-error")).
+						WithDetails(map[string]interface{}{"fake-key": "fake-value"})
+
+					err := applier.Apply(currentSpec, desiredSpec, progress, cancelSignal)
+					Expect(err).To(HaveOccurred())
+
+					codedErr, found := boshcodederr.FindCodedError(err)
+					Expect(found).To(BeTrue())
+					Expect(codedErr.Code).To(Equal(boshcodederr.ErrorCodeTimeout))
+					Expect(codedErr.Retryable).To(BeTrue())
+					Expect(codedErr.Details).To(HaveKeyWithValue("fake-key", "fake-value"))
+					Expect(codedErr.Details).To(HaveKey("rolled_back"))
+				})
+			})
+
 			It("apply errs if setup logrotate fails", func() {
 				logRotateDelegate.SetupLogrotateErr = errors.New("fake-set-up-logrotate-error")
 
-				err := applier.Apply(&fakeas.FakeApplySpec{}, &fakeas.FakeApplySpec{})
+				err := applier.Apply(&fakeas.FakeApplySpec{}, &fakeas.FakeApplySpec{}, progress, cancelSignal)
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("fake-set-up-logrotate-error"))
 			})
@@ -0,0 +1,160 @@
+package downloads
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pivotal-golang/clock"
+
+	boshblob "github.com/cloudfoundry/bosh-utils/blobstore"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+)
+
+const deduplicatingBlobstoreLogTag = "deduplicatingBlobstore"
+
+type blobKey struct {
+	blobID      string
+	fingerprint string
+}
+
+type blobDownload struct {
+	key      blobKey
+	done     chan struct{}
+	fileName string
+	err      error
+
+	// Number of callers that have not cleaned up the file yet
+	refs int
+}
+
+// deduplicatingBlobstore downloads identical blobs requested by jobs and
+// packages prepared at the same time only once; file is cleaned up once
+// every caller has cleaned it up. Failed downloads are retried with backoff.
+type deduplicatingBlobstore struct {
+	blobstore boshblob.Blobstore
+
+	maxAttempts int
+	retryDelay  time.Duration
+
+	downloads map[blobKey]*blobDownload
+	files     map[string]*blobDownload
+	lock      sync.Mutex
+
+	clock  clock.Clock
+	logger boshlog.Logger
+}
+
+func NewDeduplicatingBlobstore(
+	blobstore boshblob.Blobstore,
+	options Options,
+	clock clock.Clock,
+	logger boshlog.Logger,
+) boshblob.Blobstore {
+	return &deduplicatingBlobstore{
+		blobstore:   blobstore,
+		maxAttempts: options.maxAttempts(),
+		retryDelay:  options.retryDelay(),
+		downloads:   map[blobKey]*blobDownload{},
+		files:       map[string]*blobDownload{},
+		clock:       clock,
+		logger:      logger,
+	}
+}
+
+func (b *deduplicatingBlobstore) Get(blobID, fingerprint string) (string, error) {
+	key := blobKey{blobID: blobID, fingerprint: fingerprint}
+
+	b.lock.Lock()
+
+	if download, found := b.downloads[key]; found {
+		download.refs++
+		b.lock.Unlock()
+
+		b.logger.Debug(deduplicatingBlobstoreLogTag, "Waiting for blob %s that is already being downloaded", blobID)
+
+		<-download.done
+
+		return download.fileName, download.err
+	}
+
+	download := &blobDownload{key: key, done: make(chan struct{}), refs: 1}
+	b.downloads[key] = download
+
+	b.lock.Unlock()
+
+	download.fileName, download.err = b.get(blobID, fingerprint)
+
+	b.lock.Lock()
+
+	if download.err != nil {
+		// Following callers try to download blob again
+		delete(b.downloads, key)
+	} else {
+		b.files[download.fileName] = download
+	}
+
+	b.lock.Unlock()
+
+	close(download.done)
+
+	return download.fileName, download.err
+}
+
+func (b *deduplicatingBlobstore) get(blobID, fingerprint string) (string, error) {
+	delay := b.retryDelay
+
+	var lastErr error
+
+	for i := 1; i <= b.maxAttempts; i++ {
+		fileName, err := b.blobstore.Get(blobID, fingerprint)
+		if err == nil {
+			return fileName, nil
+		}
+
+		lastErr = err
+
+		if i < b.maxAttempts {
+			b.logger.Info(deduplicatingBlobstoreLogTag,
+				"Failed to get blob %s with error '%s', attempt %d out of %d; retrying in %s", blobID, err.Error(), i, b.maxAttempts, delay)
+
+			b.clock.Sleep(delay)
+			delay *= 2
+		}
+	}
+
+	return "", bosherr.WrapErrorf(lastErr, "Getting blob %s after %d attempts", blobID, b.maxAttempts)
+}
+
+func (b *deduplicatingBlobstore) CleanUp(fileName string) error {
+	b.lock.Lock()
+
+	download, found := b.files[fileName]
+	if found {
+		download.refs--
+
+		if download.refs > 0 {
+			b.lock.Unlock()
+			return nil
+		}
+
+		delete(b.files, fileName)
+		delete(b.downloads, download.key)
+	}
+
+	b.lock.Unlock()
+
+	return b.blobstore.CleanUp(fileName)
+}
+
+func (b *deduplicatingBlobstore) Delete(blobID string) error {
+	return b.blobstore.Delete(blobID)
+}
+
+func (b *deduplicatingBlobstore) Create(fileName string) (string, string, error) {
+	return b.blobstore.Create(fileName)
+}
+
+func (b *deduplicatingBlobstore) Validate() error {
+	return b.blobstore.Validate()
+}
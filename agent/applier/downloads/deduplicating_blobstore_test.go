@@ -0,0 +1,215 @@
+package downloads_test
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/pivotal-golang/clock/fakeclock"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/agent/applier/downloads"
+	boshblob "github.com/cloudfoundry/bosh-utils/blobstore"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+)
+
+// blockingBlobstore returns file named after blob ID once released
+type blockingBlobstore struct {
+	getErrs  []error
+	getCount int
+	release  chan struct{}
+
+	cleanedUp []string
+	lock      sync.Mutex
+}
+
+func (b *blockingBlobstore) Get(blobID, fingerprint string) (string, error) {
+	if b.release != nil {
+		<-b.release
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.getCount++
+
+	if len(b.getErrs) > 0 {
+		err := b.getErrs[0]
+		b.getErrs = b.getErrs[1:]
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return "/fake-file-" + blobID + "-" + fingerprint, nil
+}
+
+func (b *blockingBlobstore) GetCount() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.getCount
+}
+
+func (b *blockingBlobstore) CleanUp(fileName string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.cleanedUp = append(b.cleanedUp, fileName)
+	return nil
+}
+
+func (b *blockingBlobstore) CleanedUp() []string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return append([]string{}, b.cleanedUp...)
+}
+
+func (b *blockingBlobstore) Delete(blobID string) error                     { return nil }
+func (b *blockingBlobstore) Create(fileName string) (string, string, error) { return "", "", nil }
+func (b *blockingBlobstore) Validate() error                                { return nil }
+
+type getResult struct {
+	fileName string
+	err      error
+}
+
+var _ = Describe("deduplicatingBlobstore", func() {
+	var (
+		innerBlobstore *blockingBlobstore
+		fakeClock      *fakeclock.FakeClock
+		blobstore      boshblob.Blobstore
+	)
+
+	BeforeEach(func() {
+		innerBlobstore = &blockingBlobstore{}
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		blobstore = NewDeduplicatingBlobstore(innerBlobstore, Options{}, fakeClock, boshlog.NewLogger(boshlog.LevelNone))
+	})
+
+	getAsync := func(blobID, fingerprint string) chan getResult {
+		resultCh := make(chan getResult, 1)
+
+		go func() {
+			fileName, err := blobstore.Get(blobID, fingerprint)
+			resultCh <- getResult{fileName: fileName, err: err}
+		}()
+
+		return resultCh
+	}
+
+	Describe("Get", func() {
+		It("downloads identical blobs requested at the same time once", func() {
+			innerBlobstore.release = make(chan struct{})
+
+			resultCh1 := getAsync("fake-blob-id", "fake-sha1")
+			resultCh2 := getAsync("fake-blob-id", "fake-sha1")
+
+			Consistently(resultCh1).ShouldNot(Receive())
+			close(innerBlobstore.release)
+
+			var result1, result2 getResult
+			Eventually(resultCh1).Should(Receive(&result1))
+			Eventually(resultCh2).Should(Receive(&result2))
+
+			Expect(result1).To(Equal(getResult{fileName: "/fake-file-fake-blob-id-fake-sha1"}))
+			Expect(result2).To(Equal(result1))
+			Expect(innerBlobstore.GetCount()).To(Equal(1))
+		})
+
+		It("downloads blobs with different fingerprints separately", func() {
+			fileName1, err := blobstore.Get("fake-blob-id", "fake-sha1-1")
+			Expect(err).ToNot(HaveOccurred())
+
+			fileName2, err := blobstore.Get("fake-blob-id", "fake-sha1-2")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(fileName1).ToNot(Equal(fileName2))
+			Expect(innerBlobstore.GetCount()).To(Equal(2))
+		})
+
+		It("retries failed download with doubling delay", func() {
+			innerBlobstore.getErrs = []error{errors.New("fake-get-err-1"), errors.New("fake-get-err-2")}
+
+			resultCh := getAsync("fake-blob-id", "fake-sha1")
+
+			Eventually(fakeClock.WatcherCount).Should(Equal(1))
+			Expect(innerBlobstore.GetCount()).To(Equal(1))
+			fakeClock.Increment(time.Second)
+
+			Eventually(innerBlobstore.GetCount).Should(Equal(2))
+			Eventually(fakeClock.WatcherCount).Should(Equal(1))
+
+			fakeClock.Increment(time.Second)
+			Consistently(resultCh).ShouldNot(Receive())
+
+			fakeClock.Increment(time.Second)
+
+			var result getResult
+			Eventually(resultCh).Should(Receive(&result))
+			Expect(result).To(Equal(getResult{fileName: "/fake-file-fake-blob-id-fake-sha1"}))
+			Expect(innerBlobstore.GetCount()).To(Equal(3))
+		})
+
+		It("returns error once all attempts fail", func() {
+			blobstore = NewDeduplicatingBlobstore(innerBlobstore, Options{MaxAttempts: 1}, fakeClock, boshlog.NewLogger(boshlog.LevelNone))
+			innerBlobstore.getErrs = []error{errors.New("fake-get-err")}
+
+			_, err := blobstore.Get("fake-blob-id", "fake-sha1")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("Getting blob fake-blob-id after 1 attempts: fake-get-err"))
+		})
+
+		It("downloads blob again when previous download failed", func() {
+			blobstore = NewDeduplicatingBlobstore(innerBlobstore, Options{MaxAttempts: 1}, fakeClock, boshlog.NewLogger(boshlog.LevelNone))
+			innerBlobstore.getErrs = []error{errors.New("fake-get-err")}
+
+			_, err := blobstore.Get("fake-blob-id", "fake-sha1")
+			Expect(err).To(HaveOccurred())
+
+			fileName, err := blobstore.Get("fake-blob-id", "fake-sha1")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fileName).To(Equal("/fake-file-fake-blob-id-fake-sha1"))
+		})
+	})
+
+	Describe("CleanUp", func() {
+		It("cleans up shared file once every caller cleaned it up", func() {
+			innerBlobstore.release = make(chan struct{})
+
+			resultCh1 := getAsync("fake-blob-id", "fake-sha1")
+			resultCh2 := getAsync("fake-blob-id", "fake-sha1")
+
+			Consistently(resultCh1).ShouldNot(Receive())
+			close(innerBlobstore.release)
+
+			var result1, result2 getResult
+			Eventually(resultCh1).Should(Receive(&result1))
+			Eventually(resultCh2).Should(Receive(&result2))
+
+			Expect(blobstore.CleanUp(result1.fileName)).To(Succeed())
+			Expect(innerBlobstore.CleanedUp()).To(BeEmpty())
+
+			Expect(blobstore.CleanUp(result2.fileName)).To(Succeed())
+			Expect(innerBlobstore.CleanedUp()).To(Equal([]string{"/fake-file-fake-blob-id-fake-sha1"}))
+		})
+
+		It("downloads blob again once it was cleaned up", func() {
+			fileName, err := blobstore.Get("fake-blob-id", "fake-sha1")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(blobstore.CleanUp(fileName)).To(Succeed())
+
+			_, err = blobstore.Get("fake-blob-id", "fake-sha1")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(innerBlobstore.GetCount()).To(Equal(2))
+		})
+
+		It("cleans up files it did not download", func() {
+			Expect(blobstore.CleanUp("/fake-other-file")).To(Succeed())
+			Expect(innerBlobstore.CleanedUp()).To(Equal([]string{"/fake-other-file"}))
+		})
+	})
+})
@@ -0,0 +1,13 @@
+package downloads_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestDownloads(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Downloads Suite")
+}
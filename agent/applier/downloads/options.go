@@ -0,0 +1,45 @@
+package downloads
+
+import (
+	"time"
+)
+
+const (
+	defaultParallelism       = 5
+	defaultMaxAttempts       = 3
+	defaultRetryDelaySeconds = 1
+)
+
+// Options configure how job and package blobs are downloaded during apply
+type Options struct {
+	// Number of jobs and packages prepared at the same time; defaults to 5
+	Parallelism int
+
+	// Attempts made to download each blob; defaults to 3
+	MaxAttempts int
+
+	// Delay after the first failed attempt; it doubles
+	// after each following attempt; defaults to 1 second
+	RetryDelaySeconds int
+}
+
+func (o Options) parallelism() int {
+	if o.Parallelism > 0 {
+		return o.Parallelism
+	}
+	return defaultParallelism
+}
+
+func (o Options) maxAttempts() int {
+	if o.MaxAttempts > 0 {
+		return o.MaxAttempts
+	}
+	return defaultMaxAttempts
+}
+
+func (o Options) retryDelay() time.Duration {
+	if o.RetryDelaySeconds > 0 {
+		return time.Duration(o.RetryDelaySeconds) * time.Second
+	}
+	return defaultRetryDelaySeconds * time.Second
+}
@@ -0,0 +1,93 @@
+package downloads
+
+import (
+	"strings"
+	"sync"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+)
+
+const schedulerLogTag = "downloadScheduler"
+
+// Download is a job or package that is downloaded and installed by Prepare
+type Download struct {
+	// Name is used in errors, e.g. 'package foo'
+	Name    string
+	Prepare func() error
+}
+
+type Scheduler interface {
+	// Run prepares all downloads and returns an error
+	// naming every download that failed once all are done
+	Run(downloads []Download) error
+}
+
+type scheduler struct {
+	parallelism int
+	logger      boshlog.Logger
+}
+
+func NewScheduler(options Options, logger boshlog.Logger) Scheduler {
+	return scheduler{
+		parallelism: options.parallelism(),
+		logger:      logger,
+	}
+}
+
+func (s scheduler) Run(downloads []Download) error {
+	errs := make([]error, len(downloads))
+
+	// Downloads are started in given order
+	downloadCh := make(chan int)
+
+	workers := s.parallelism
+	if workers > len(downloads) {
+		workers = len(downloads)
+	}
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range downloadCh {
+				s.logger.Debug(schedulerLogTag, "Preparing %s", downloads[i].Name)
+
+				err := downloads[i].Prepare()
+				if err != nil {
+					errs[i] = bosherr.WrapErrorf(err, "Preparing %s", downloads[i].Name)
+				}
+			}
+		}()
+	}
+
+	for i := range downloads {
+		downloadCh <- i
+	}
+
+	close(downloadCh)
+	wg.Wait()
+
+	var failedNames []string
+	var failedErrs []error
+
+	for i, err := range errs {
+		if err != nil {
+			failedNames = append(failedNames, downloads[i].Name)
+			failedErrs = append(failedErrs, err)
+		}
+	}
+
+	switch len(failedErrs) {
+	case 0:
+		return nil
+	case 1:
+		return failedErrs[0]
+	default:
+		return bosherr.WrapErrorf(bosherr.NewMultiError(failedErrs...), "Failed to prepare %s", strings.Join(failedNames, ", "))
+	}
+}
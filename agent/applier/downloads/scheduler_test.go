@@ -0,0 +1,136 @@
+package downloads_test
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/agent/applier/downloads"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+)
+
+var _ = Describe("scheduler", func() {
+	var (
+		logger boshlog.Logger
+	)
+
+	BeforeEach(func() {
+		logger = boshlog.NewLogger(boshlog.LevelNone)
+	})
+
+	It("prepares all downloads", func() {
+		var prepared []string
+		var lock sync.Mutex
+
+		prepare := func(name string) func() error {
+			return func() error {
+				lock.Lock()
+				defer lock.Unlock()
+
+				prepared = append(prepared, name)
+				return nil
+			}
+		}
+
+		err := NewScheduler(Options{}, logger).Run([]Download{
+			{Name: "job a", Prepare: prepare("job a")},
+			{Name: "package b", Prepare: prepare("package b")},
+			{Name: "package c", Prepare: prepare("package c")},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(prepared).To(ConsistOf("job a", "package b", "package c"))
+	})
+
+	It("prepares downloads at the same time", func() {
+		started := make(chan struct{}, 3)
+		allStarted := make(chan struct{})
+
+		prepare := func() error {
+			started <- struct{}{}
+
+			select {
+			case <-allStarted:
+				return nil
+			case <-time.After(5 * time.Second):
+				return errors.New("fake-not-parallel-err")
+			}
+		}
+
+		go func() {
+			for i := 0; i < 3; i++ {
+				<-started
+			}
+			close(allStarted)
+		}()
+
+		err := NewScheduler(Options{Parallelism: 3}, logger).Run([]Download{
+			{Name: "package a", Prepare: prepare},
+			{Name: "package b", Prepare: prepare},
+			{Name: "package c", Prepare: prepare},
+		})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("does not prepare more downloads at the same time than configured", func() {
+		var running, maxRunning int
+		var lock sync.Mutex
+
+		prepare := func() error {
+			lock.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			lock.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			lock.Lock()
+			running--
+			lock.Unlock()
+
+			return nil
+		}
+
+		var downloads []Download
+		for i := 0; i < 10; i++ {
+			downloads = append(downloads, Download{Name: "package", Prepare: prepare})
+		}
+
+		err := NewScheduler(Options{Parallelism: 2}, logger).Run(downloads)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(maxRunning).To(Equal(2))
+	})
+
+	It("returns error of the only failed download", func() {
+		err := NewScheduler(Options{}, logger).Run([]Download{
+			{Name: "package a", Prepare: func() error { return nil }},
+			{Name: "package b", Prepare: func() error { return errors.New("fake-prepare-err") }},
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(Equal("Preparing package b: fake-prepare-err"))
+	})
+
+	It("prepares remaining downloads and names every download that failed", func() {
+		var preparedC bool
+
+		err := NewScheduler(Options{Parallelism: 1}, logger).Run([]Download{
+			{Name: "package a", Prepare: func() error { return errors.New("fake-prepare-a-err") }},
+			{Name: "package b", Prepare: func() error { return errors.New("fake-prepare-b-err") }},
+			{Name: "package c", Prepare: func() error { preparedC = true; return nil }},
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(Equal("Failed to prepare package a, package b: " +
+			"Preparing package a: fake-prepare-a-err\nPreparing package b: fake-prepare-b-err"))
+
+		Expect(preparedC).To(BeTrue())
+	})
+
+	It("does nothing when there is nothing to download", func() {
+		err := NewScheduler(Options{}, logger).Run(nil)
+		Expect(err).ToNot(HaveOccurred())
+	})
+})
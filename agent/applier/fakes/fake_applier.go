@@ -3,18 +3,29 @@ package fakes
 import (
 	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
 	"github.com/cloudfoundry/bosh-agent/agent/applier/models"
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
 )
 
 type FakeApplier struct {
 	Prepared                bool
 	PrepareDesiredApplySpec boshas.ApplySpec
+	PrepareProgress         boshtask.ProgressReporter
 	PrepareError            error
 
 	Applied               bool
 	ApplyCurrentApplySpec boshas.ApplySpec
 	ApplyDesiredApplySpec boshas.ApplySpec
+	ApplyProgress         boshtask.ProgressReporter
+	ApplyCancelSignal     *boshtask.CancelSignal
 	ApplyError            error
 
+	Committed   bool
+	CommitError error
+
+	RolledBack               bool
+	RollBackCurrentApplySpec boshas.ApplySpec
+	RollBackError            error
+
 	Configured                 bool
 	ConfiguredDesiredApplySpec boshas.ApplySpec
 	ConfiguredJobs             []models.Job
@@ -25,9 +36,10 @@ func NewFakeApplier() *FakeApplier {
 	return &FakeApplier{}
 }
 
-func (s *FakeApplier) Prepare(desiredApplySpec boshas.ApplySpec) error {
+func (s *FakeApplier) Prepare(desiredApplySpec boshas.ApplySpec, progress boshtask.ProgressReporter) error {
 	s.Prepared = true
 	s.PrepareDesiredApplySpec = desiredApplySpec
+	s.PrepareProgress = progress
 	return s.PrepareError
 }
 
@@ -37,9 +49,22 @@ func (s *FakeApplier) ConfigureJobs(desiredApplySpec boshas.ApplySpec) error {
 	return s.ConfiguredError
 }
 
-func (s *FakeApplier) Apply(currentApplySpec, desiredApplySpec boshas.ApplySpec) error {
+func (s *FakeApplier) Apply(currentApplySpec, desiredApplySpec boshas.ApplySpec, progress boshtask.ProgressReporter, cancelSignal *boshtask.CancelSignal) error {
 	s.Applied = true
 	s.ApplyCurrentApplySpec = currentApplySpec
 	s.ApplyDesiredApplySpec = desiredApplySpec
+	s.ApplyProgress = progress
+	s.ApplyCancelSignal = cancelSignal
 	return s.ApplyError
 }
+
+func (s *FakeApplier) Commit() error {
+	s.Committed = true
+	return s.CommitError
+}
+
+func (s *FakeApplier) RollBack(currentApplySpec boshas.ApplySpec) error {
+	s.RolledBack = true
+	s.RollBackCurrentApplySpec = currentApplySpec
+	return s.RollBackError
+}
@@ -5,11 +5,13 @@ import (
 )
 
 type FakeApplier struct {
-	PreparedJobs []models.Job
-	PrepareError error
+	PreparedJobs    []models.Job
+	PrepareError    error
+	PrepareCallBack func()
 
-	AppliedJobs []models.Job
-	ApplyError  error
+	AppliedJobs   []models.Job
+	ApplyError    error
+	ApplyCallBack func()
 
 	ConfiguredJobs       []models.Job
 	ConfiguredJobIndices []int
@@ -27,11 +29,17 @@ func NewFakeApplier() *FakeApplier {
 
 func (s *FakeApplier) Prepare(job models.Job) error {
 	s.PreparedJobs = append(s.PreparedJobs, job)
+	if s.PrepareCallBack != nil {
+		s.PrepareCallBack()
+	}
 	return s.PrepareError
 }
 
 func (s *FakeApplier) Apply(job models.Job) error {
 	s.AppliedJobs = append(s.AppliedJobs, job)
+	if s.ApplyCallBack != nil {
+		s.ApplyCallBack()
+	}
 	return s.ApplyError
 }
 
@@ -9,6 +9,7 @@ import (
 	boshbc "github.com/cloudfoundry/bosh-agent/agent/applier/bundlecollection"
 	models "github.com/cloudfoundry/bosh-agent/agent/applier/models"
 	"github.com/cloudfoundry/bosh-agent/agent/applier/packages"
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
 	boshjobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor"
 	boshblob "github.com/cloudfoundry/bosh-utils/blobstore"
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
@@ -107,7 +108,8 @@ func (s *renderedJobApplier) downloadAndInstall(job models.Job, jobBundle boshbc
 
 	file, err := s.blobstore.Get(job.Source.BlobstoreID, job.Source.Sha1)
 	if err != nil {
-		return bosherr.WrapError(err, "Getting job source from blobstore")
+		err = bosherr.WrapError(err, "Getting job source from blobstore")
+		return boshcodederr.NewCodedError(boshcodederr.ErrorCodeBlobstoreUnavailable, err)
 	}
 
 	defer func() {
@@ -18,5 +18,5 @@ func (s Job) BundleVersion() string {
 	// Job template is not unique per version because
 	// Source contains files with interpolated values
 	// which might be different across job versions.
-	return s.Version + "-" + s.Source.Sha1
+	return s.Version + "-" + s.Source.stableDigest()
 }
@@ -23,5 +23,13 @@ var _ = Describe("Job", func() {
 			}
 			Expect(job.BundleVersion()).To(Equal("fake-version-fake-sha1"))
 		})
+
+		It("stays the same when source has several digests", func() {
+			job := Job{
+				Version: "fake-version",
+				Source:  Source{Sha1: "sha1:fake-sha1;sha256:fake-sha256"},
+			}
+			Expect(job.BundleVersion()).To(Equal("fake-version-fake-sha1"))
+		})
 	})
 })
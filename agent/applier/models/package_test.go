@@ -23,5 +23,21 @@ var _ = Describe("Package", func() {
 			}
 			Expect(pkg.BundleVersion()).To(Equal("fake-version-fake-sha1"))
 		})
+
+		It("stays the same when source has several digests", func() {
+			pkg := Package{
+				Version: "fake-version",
+				Source:  Source{Sha1: "sha1:fake-sha1;sha256:fake-sha256"},
+			}
+			Expect(pkg.BundleVersion()).To(Equal("fake-version-fake-sha1"))
+		})
+
+		It("uses the strongest digest when source has no SHA-1 digest", func() {
+			pkg := Package{
+				Version: "fake-version",
+				Source:  Source{Sha1: "sha256:fake-sha256;sha512:fake-sha512"},
+			}
+			Expect(pkg.BundleVersion()).To(Equal("fake-version-fake-sha512"))
+		})
 	})
 })
@@ -1,7 +1,18 @@
 package models
 
+import (
+	boshcrypto "github.com/cloudfoundry/bosh-agent/crypto"
+)
+
 type Source struct {
+	// Sha1 is either a plain SHA-1 or several digests, e.g. 'sha1:abc;sha256:def'
 	Sha1          string
 	BlobstoreID   string
 	PathInArchive string
 }
+
+// stableDigest returns value that does not change when director
+// switches from sending plain SHA-1 to sending several digests
+func (s Source) stableDigest() string {
+	return boshcrypto.StableDigestValue(s.Sha1)
+}
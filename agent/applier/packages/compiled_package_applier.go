@@ -3,6 +3,7 @@ package packages
 import (
 	bc "github.com/cloudfoundry/bosh-agent/agent/applier/bundlecollection"
 	models "github.com/cloudfoundry/bosh-agent/agent/applier/models"
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
 	boshblob "github.com/cloudfoundry/bosh-utils/blobstore"
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 	boshcmd "github.com/cloudfoundry/bosh-utils/fileutil"
@@ -100,7 +101,8 @@ func (s *compiledPackageApplier) downloadAndInstall(pkg models.Package, pkgBundl
 
 	file, err := s.blobstore.Get(pkg.Source.BlobstoreID, pkg.Source.Sha1)
 	if err != nil {
-		return bosherr.WrapError(err, "Fetching package blob")
+		err = bosherr.WrapError(err, "Fetching package blob")
+		return boshcodederr.NewCodedError(boshcodederr.ErrorCodeBlobstoreUnavailable, err)
 	}
 
 	defer func() {
@@ -12,6 +12,7 @@ type FakeApplier struct {
 
 	AppliedPackages []models.Package
 	ApplyError      error
+	ApplyCallBack   func(pkg models.Package)
 
 	KeptOnlyPackages []models.Package
 	KeepOnlyErr      error
@@ -32,6 +33,9 @@ func (s *FakeApplier) Prepare(pkg models.Package) error {
 func (s *FakeApplier) Apply(pkg models.Package) error {
 	s.ActionsCalled = append(s.ActionsCalled, "Apply")
 	s.AppliedPackages = append(s.AppliedPackages, pkg)
+	if s.ApplyCallBack != nil {
+		s.ApplyCallBack(pkg)
+	}
 	return s.ApplyError
 }
 
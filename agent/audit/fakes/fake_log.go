@@ -0,0 +1,40 @@
+package fakes
+
+import (
+	"sync"
+
+	boshaudit "github.com/cloudfoundry/bosh-agent/agent/audit"
+)
+
+type FakeLog struct {
+	Entries   []boshaudit.Entry
+	RecordErr error
+
+	VerifyVerification boshaudit.Verification
+	VerifyErr          error
+
+	lock sync.Mutex
+}
+
+func NewFakeLog() *FakeLog {
+	return &FakeLog{}
+}
+
+func (l *FakeLog) Record(entry boshaudit.Entry) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.Entries = append(l.Entries, entry)
+	return l.RecordErr
+}
+
+func (l *FakeLog) Verify() (boshaudit.Verification, error) {
+	return l.VerifyVerification, l.VerifyErr
+}
+
+func (l *FakeLog) RecordedEntries() []boshaudit.Entry {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return append([]boshaudit.Entry{}, l.Entries...)
+}
@@ -0,0 +1,531 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	boshsys "github.com/cloudfoundry/bosh-utils/system"
+)
+
+const fileLogLogTag = "auditFileLog"
+
+// head is written next to the log after each record. It anchors both ends
+// of the chain so that records removed from the end of the log or rotated
+// files removed from its beginning are detected.
+type head struct {
+	FirstSequence int64  `json:"first_sequence"`
+	FirstPrevHash string `json:"first_prev_hash"`
+	LastSequence  int64  `json:"last_sequence"`
+	LastHash      string `json:"last_hash"`
+	Hash          string `json:"hash,omitempty"`
+}
+
+type fileLog struct {
+	path    string
+	options Options
+	fs      boshsys.FileSystem
+	logger  boshlog.Logger
+
+	// Chain state is loaded before the first record is written
+	loaded bool
+	head   head
+	size   int64
+
+	lock sync.Mutex
+}
+
+// NewFileLog returns log that appends JSON lines to the file at given path.
+// Once the file grows over the size limit it is rotated to path.1, path.2...;
+// the chain of hashes continues across rotated files and is anchored
+// by the head kept in path.head. Records and head are hashed with
+// HMAC-SHA256 when HMAC key is configured.
+func NewFileLog(path string, options Options, fs boshsys.FileSystem, logger boshlog.Logger) Log {
+	return &fileLog{
+		path:    path,
+		options: options,
+		fs:      fs,
+		logger:  logger,
+	}
+}
+
+func (l *fileLog) Record(entry Entry) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if !l.loaded {
+		err := l.load()
+		if err != nil {
+			return bosherr.WrapError(err, "Loading audit log")
+		}
+	}
+
+	record := newRecord(entry)
+	record.Sequence = l.head.LastSequence + 1
+	record.PrevHash = l.head.LastHash
+
+	hash, err := l.hashRecord(record)
+	if err != nil {
+		return err
+	}
+	record.Hash = hash
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return bosherr.WrapError(err, "Marshalling audit record")
+	}
+	line = append(line, '\n')
+
+	if l.size > 0 && l.size+int64(len(line)) > l.options.maxFileSizeBytes() {
+		err = l.rotate()
+		if err != nil {
+			return bosherr.WrapError(err, "Rotating audit log")
+		}
+	}
+
+	err = l.appendLine(line)
+	if err != nil {
+		return err
+	}
+
+	l.head.LastSequence = record.Sequence
+	l.head.LastHash = record.Hash
+	l.size += int64(len(line))
+
+	return l.writeHead()
+}
+
+func (l *fileLog) Verify() (Verification, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	verification := Verification{Valid: true}
+
+	paths, err := l.filePaths()
+	if err != nil {
+		return verification, err
+	}
+
+	logHead, headFound, err := l.readHead()
+	if err != nil {
+		return verification, err
+	}
+
+	if headFound {
+		hash, err := l.hashHead(logHead)
+		if err != nil || hash != logHead.Hash {
+			return l.invalid(verification, l.headPath(), 0, "Head hash does not match its contents"), nil
+		}
+	} else {
+		// Chain of records written without head must start with the first record
+		logHead = head{FirstSequence: 1}
+	}
+
+	var prev *Record
+
+	for _, path := range paths {
+		contents, err := l.fs.ReadFile(path)
+		if err != nil {
+			return verification, bosherr.WrapErrorf(err, "Reading audit log file %s", path)
+		}
+
+		for i, line := range splitLines(contents) {
+			problem := l.verifyLine(line, prev, logHead)
+			if problem != "" {
+				return l.invalid(verification, path, i+1, problem), nil
+			}
+
+			var record Record
+			_ = json.Unmarshal(line, &record)
+			prev = &record
+
+			verification.Records++
+		}
+	}
+
+	if !headFound {
+		if prev != nil {
+			return l.invalid(verification, l.headPath(), 0, "Head is missing"), nil
+		}
+		return verification, nil
+	}
+
+	problem := verifyEnd(prev, logHead)
+	if problem != "" {
+		return l.invalid(verification, l.path, 0, problem), nil
+	}
+
+	return verification, nil
+}
+
+func (l *fileLog) invalid(verification Verification, path string, line int, problem string) Verification {
+	verification.Valid = false
+	verification.File = path
+	verification.Line = line
+	verification.Problem = problem
+	return verification
+}
+
+// verifyLine returns description of the problem with the record
+// or empty string if record is intact and follows the previous one;
+// the oldest record must follow the one recorded as first in the head
+func (l *fileLog) verifyLine(line []byte, prev *Record, logHead head) string {
+	var record Record
+
+	err := json.Unmarshal(line, &record)
+	if err != nil {
+		return "Record is not valid JSON"
+	}
+
+	hash, err := l.hashRecord(record)
+	if err != nil || hash != record.Hash {
+		return "Record hash does not match its contents"
+	}
+
+	if prev == nil {
+		if record.Sequence != logHead.FirstSequence {
+			return fmt.Sprintf("Expected oldest record with sequence %d but found %d", logHead.FirstSequence, record.Sequence)
+		}
+
+		if record.PrevHash != logHead.FirstPrevHash {
+			return "Oldest record does not follow the records rotated away"
+		}
+
+		return ""
+	}
+
+	if record.PrevHash != prev.Hash {
+		return "Record does not follow the previous record"
+	}
+
+	if record.Sequence != prev.Sequence+1 {
+		return fmt.Sprintf("Expected record with sequence %d but found %d", prev.Sequence+1, record.Sequence)
+	}
+
+	return ""
+}
+
+// verifyEnd returns description of the problem if the last record is not
+// the one recorded as last in the head. Head may lag one record behind
+// when agent stopped right after appending the record.
+func verifyEnd(last *Record, logHead head) string {
+	if last == nil {
+		if logHead.LastSequence > 0 {
+			return fmt.Sprintf("Log ends before record with sequence %d", logHead.LastSequence)
+		}
+		return ""
+	}
+
+	if last.Sequence == logHead.LastSequence && last.Hash == logHead.LastHash {
+		return ""
+	}
+
+	if last.Sequence == logHead.LastSequence+1 && last.PrevHash == logHead.LastHash {
+		return ""
+	}
+
+	return fmt.Sprintf("Log ends with record %d but record %d was written last", last.Sequence, logHead.LastSequence)
+}
+
+// load continues the chain from the head; records written after
+// the head (e.g. right before agent stopped) are taken into account
+func (l *fileLog) load() error {
+	paths, err := l.filePaths()
+	if err != nil {
+		return err
+	}
+
+	logHead, headFound, err := l.readHead()
+	if err != nil {
+		return err
+	}
+
+	if !headFound {
+		logHead = head{FirstSequence: 1}
+	}
+
+	for i := len(paths) - 1; i >= 0; i-- {
+		contents, err := l.fs.ReadFile(paths[i])
+		if err != nil {
+			return bosherr.WrapErrorf(err, "Reading audit log file %s", paths[i])
+		}
+
+		if paths[i] == l.path {
+			l.size = int64(len(contents))
+		}
+
+		lines := splitLines(contents)
+		if len(lines) == 0 {
+			continue
+		}
+
+		lastLine := lines[len(lines)-1]
+
+		var record Record
+
+		err = json.Unmarshal(lastLine, &record)
+		if err != nil {
+			// Damaged record (e.g. partially written before a crash) is reported
+			// by verification; new records are chained to its raw contents
+			l.logger.Error(fileLogLogTag, "Unmarshalling last audit record: %s", err.Error())
+			sum := sha256.Sum256(lastLine)
+			record = Record{Sequence: logHead.LastSequence + 1, Hash: hex.EncodeToString(sum[:])}
+		}
+
+		// Records removed from the end of the log are not
+		// forgotten since the chain continues from the head
+		if !headFound || (record.Sequence == logHead.LastSequence+1 && record.PrevHash == logHead.LastHash) {
+			logHead.LastSequence = record.Sequence
+			logHead.LastHash = record.Hash
+		}
+
+		break
+	}
+
+	l.head = logHead
+	l.loaded = true
+
+	return nil
+}
+
+func (l *fileLog) headPath() string {
+	return l.path + ".head"
+}
+
+func (l *fileLog) readHead() (head, bool, error) {
+	var logHead head
+
+	if !l.fs.FileExists(l.headPath()) {
+		return logHead, false, nil
+	}
+
+	contents, err := l.fs.ReadFile(l.headPath())
+	if err != nil {
+		return logHead, false, bosherr.WrapError(err, "Reading audit log head")
+	}
+
+	err = json.Unmarshal(contents, &logHead)
+	if err != nil {
+		// Damaged head is reported by verification
+		l.logger.Error(fileLogLogTag, "Unmarshalling audit log head: %s", err.Error())
+		return head{Hash: "[damaged]"}, true, nil
+	}
+
+	return logHead, true, nil
+}
+
+// writeHead replaces the head with a rename so that it is never partially written
+func (l *fileLog) writeHead() error {
+	hash, err := l.hashHead(l.head)
+	if err != nil {
+		return err
+	}
+
+	l.head.Hash = hash
+
+	headJSON, err := json.Marshal(l.head)
+	if err != nil {
+		return bosherr.WrapError(err, "Marshalling audit log head")
+	}
+
+	tmpPath := l.headPath() + ".tmp"
+
+	err = l.fs.WriteFile(tmpPath, headJSON)
+	if err != nil {
+		return bosherr.WrapError(err, "Writing audit log head")
+	}
+
+	err = l.fs.Chmod(tmpPath, os.FileMode(0600))
+	if err != nil {
+		return bosherr.WrapError(err, "Writing audit log head")
+	}
+
+	err = l.fs.Rename(tmpPath, l.headPath())
+	if err != nil {
+		return bosherr.WrapError(err, "Replacing audit log head")
+	}
+
+	return nil
+}
+
+// filePaths returns paths of existing log files, oldest first
+func (l *fileLog) filePaths() ([]string, error) {
+	matches, err := l.fs.Glob(l.path + ".*")
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Finding rotated audit log files")
+	}
+
+	indexes := []int{}
+	for _, match := range matches {
+		index, err := strconv.Atoi(strings.TrimPrefix(match, l.path+"."))
+		if err == nil && index > 0 {
+			indexes = append(indexes, index)
+		}
+	}
+
+	sort.Sort(sort.Reverse(sort.IntSlice(indexes)))
+
+	paths := []string{}
+	for _, index := range indexes {
+		paths = append(paths, l.rotatedPath(index))
+	}
+
+	if l.fs.FileExists(l.path) {
+		paths = append(paths, l.path)
+	}
+
+	return paths, nil
+}
+
+// rotate moves log files by one; when the oldest file is removed
+// head records the oldest kept record as the first one
+func (l *fileLog) rotate() error {
+	maxRotatedFiles := l.options.maxRotatedFiles()
+
+	if l.fs.FileExists(l.rotatedPath(maxRotatedFiles)) {
+		nextOldestPath := l.path
+		if maxRotatedFiles > 1 {
+			nextOldestPath = l.rotatedPath(maxRotatedFiles - 1)
+		}
+
+		err := l.recordFirst(nextOldestPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := l.fs.RemoveAll(l.rotatedPath(maxRotatedFiles))
+	if err != nil {
+		return err
+	}
+
+	for i := maxRotatedFiles - 1; i > 0; i-- {
+		if !l.fs.FileExists(l.rotatedPath(i)) {
+			continue
+		}
+
+		err = l.fs.Rename(l.rotatedPath(i), l.rotatedPath(i+1))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = l.fs.Rename(l.path, l.rotatedPath(1))
+	if err != nil {
+		return err
+	}
+
+	l.size = 0
+
+	return nil
+}
+
+func (l *fileLog) recordFirst(path string) error {
+	contents, err := l.fs.ReadFile(path)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Reading audit log file %s", path)
+	}
+
+	lines := splitLines(contents)
+	if len(lines) == 0 {
+		return nil
+	}
+
+	var record Record
+
+	err = json.Unmarshal(lines[0], &record)
+	if err != nil {
+		// Damaged record is reported by verification of the kept files
+		l.logger.Error(fileLogLogTag, "Unmarshalling oldest kept audit record: %s", err.Error())
+		return nil
+	}
+
+	l.head.FirstSequence = record.Sequence
+	l.head.FirstPrevHash = record.PrevHash
+
+	return nil
+}
+
+func (l *fileLog) rotatedPath(index int) string {
+	return fmt.Sprintf("%s.%d", l.path, index)
+}
+
+func (l *fileLog) appendLine(line []byte) error {
+	err := l.fs.MkdirAll(filepath.Dir(l.path), os.FileMode(0750))
+	if err != nil {
+		return bosherr.WrapError(err, "Creating audit log directory")
+	}
+
+	file, err := l.fs.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, os.FileMode(0600))
+	if err != nil {
+		return bosherr.WrapError(err, "Opening audit log")
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	_, err = file.Write(line)
+	if err != nil {
+		return bosherr.WrapError(err, "Writing audit record")
+	}
+
+	return nil
+}
+
+func (l *fileLog) hashRecord(record Record) (string, error) {
+	record.Hash = ""
+
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return "", bosherr.WrapError(err, "Marshalling audit record")
+	}
+
+	return l.hash(recordJSON), nil
+}
+
+func (l *fileLog) hashHead(logHead head) (string, error) {
+	logHead.Hash = ""
+
+	headJSON, err := json.Marshal(logHead)
+	if err != nil {
+		return "", bosherr.WrapError(err, "Marshalling audit log head")
+	}
+
+	return l.hash(headJSON), nil
+}
+
+func (l *fileLog) hash(contents []byte) string {
+	if len(l.options.HMACKey) == 0 {
+		sum := sha256.Sum256(contents)
+		return hex.EncodeToString(sum[:])
+	}
+
+	mac := hmac.New(sha256.New, l.options.HMACKey)
+	_, _ = mac.Write(contents)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func splitLines(contents []byte) [][]byte {
+	lines := [][]byte{}
+
+	for _, line := range bytes.Split(contents, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) > 0 {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
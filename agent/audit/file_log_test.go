@@ -0,0 +1,368 @@
+package audit_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/agent/audit"
+	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	boshsys "github.com/cloudfoundry/bosh-utils/system"
+)
+
+var _ = Describe("fileLog", func() {
+	var (
+		logsDir string
+		logPath string
+		fs      boshsys.FileSystem
+		logger  boshlog.Logger
+		options Options
+		log     Log
+	)
+
+	BeforeEach(func() {
+		var err error
+		logsDir, err = ioutil.TempDir("", "audit-log-test")
+		Expect(err).ToNot(HaveOccurred())
+
+		logPath = filepath.Join(logsDir, "agent", "audit.log")
+		logger = boshlog.NewLogger(boshlog.LevelNone)
+		fs = boshsys.NewOsFileSystem(logger)
+		options = Options{}
+	})
+
+	JustBeforeEach(func() {
+		log = NewFileLog(logPath, options, fs, logger)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(logsDir)
+	})
+
+	entry := func(method string, payload string) Entry {
+		req := boshhandler.NewRequest("fake-reply-to", method, []byte(payload))
+		req.Source = boshhandler.RequestSource{Transport: "https", Identity: "fake-user"}
+
+		return Entry{
+			Request:    req,
+			TaskID:     "fake-task-id",
+			Outcome:    OutcomeDone,
+			StartedAt:  time.Unix(100, 0),
+			FinishedAt: time.Unix(102, 0),
+		}
+	}
+
+	readRecords := func(path string) []Record {
+		contents, err := ioutil.ReadFile(path)
+		Expect(err).ToNot(HaveOccurred())
+
+		records := []Record{}
+		for _, line := range strings.Split(strings.TrimSpace(string(contents)), "\n") {
+			var record Record
+			Expect(json.Unmarshal([]byte(line), &record)).To(Succeed())
+			records = append(records, record)
+		}
+		return records
+	}
+
+	Describe("Record", func() {
+		It("appends one JSON line per entry describing the request", func() {
+			err := log.Record(entry("ssh", `{"method":"ssh","arguments":["setup",{"user":"fake-user"}]}`))
+			Expect(err).ToNot(HaveOccurred())
+
+			records := readRecords(logPath)
+			Expect(records).To(HaveLen(1))
+			Expect(records[0].Sequence).To(Equal(int64(1)))
+			Expect(records[0].Time).To(Equal(time.Unix(102, 0).UTC()))
+			Expect(records[0].Method).To(Equal("ssh"))
+			Expect(records[0].Transport).To(Equal("https"))
+			Expect(records[0].Identity).To(Equal("fake-user"))
+			Expect(records[0].ReplyTo).To(Equal("fake-reply-to"))
+			Expect(records[0].Arguments).To(Equal([]interface{}{"setup", "[object]"}))
+			Expect(records[0].TaskID).To(Equal("fake-task-id"))
+			Expect(records[0].Outcome).To(Equal(OutcomeDone))
+			Expect(records[0].DurationMs).To(Equal(int64(2000)))
+			Expect(records[0].PrevHash).To(BeEmpty())
+			Expect(records[0].Hash).ToNot(BeEmpty())
+		})
+
+		It("chains each record to the previous one", func() {
+			Expect(log.Record(entry("ping", `{"method":"ping","arguments":[]}`))).To(Succeed())
+			Expect(log.Record(entry("get_state", `{"method":"get_state","arguments":[]}`))).To(Succeed())
+
+			records := readRecords(logPath)
+			Expect(records).To(HaveLen(2))
+			Expect(records[1].Sequence).To(Equal(int64(2)))
+			Expect(records[1].PrevHash).To(Equal(records[0].Hash))
+		})
+
+		It("continues the chain of records written before restart", func() {
+			Expect(log.Record(entry("ping", `{"method":"ping","arguments":[]}`))).To(Succeed())
+
+			restartedLog := NewFileLog(logPath, options, fs, logger)
+			Expect(restartedLog.Record(entry("ping", `{"method":"ping","arguments":[]}`))).To(Succeed())
+
+			records := readRecords(logPath)
+			Expect(records[1].Sequence).To(Equal(int64(2)))
+			Expect(records[1].PrevHash).To(Equal(records[0].Hash))
+		})
+
+		It("redacts secrets before hashing the payload", func() {
+			Expect(log.Record(entry("ssh", `{"arguments":["setup",{"user":"u","password":"secret-1"}]}`))).To(Succeed())
+			Expect(log.Record(entry("ssh", `{"arguments":["setup",{"user":"u","password":"secret-2"}]}`))).To(Succeed())
+			Expect(log.Record(entry("ssh", `{"arguments":["setup",{"user":"other","password":"secret-1"}]}`))).To(Succeed())
+
+			records := readRecords(logPath)
+			Expect(records[0].PayloadSHA256).To(Equal(records[1].PayloadSHA256))
+			Expect(records[0].PayloadSHA256).ToNot(Equal(records[2].PayloadSHA256))
+
+			contents, err := ioutil.ReadFile(logPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(contents)).ToNot(ContainSubstring("secret-1"))
+		})
+
+		Context("when log grows over the size limit", func() {
+			BeforeEach(func() {
+				options = Options{MaxFileSizeBytes: 1, MaxRotatedFiles: 2}
+			})
+
+			It("rotates log files keeping configured number of rotated files", func() {
+				for i := 0; i < 4; i++ {
+					Expect(log.Record(entry("ping", `{"method":"ping","arguments":[]}`))).To(Succeed())
+				}
+
+				Expect(readRecords(logPath)[0].Sequence).To(Equal(int64(4)))
+				Expect(readRecords(logPath + ".1")[0].Sequence).To(Equal(int64(3)))
+				Expect(readRecords(logPath + ".2")[0].Sequence).To(Equal(int64(2)))
+				Expect(fs.FileExists(logPath + ".3")).To(BeFalse())
+			})
+		})
+	})
+
+	Describe("Verify", func() {
+		It("reports empty log as valid", func() {
+			verification, err := log.Verify()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(verification).To(Equal(Verification{Valid: true}))
+		})
+
+		Context("when records were written", func() {
+			JustBeforeEach(func() {
+				for i := 0; i < 3; i++ {
+					Expect(log.Record(entry("ping", `{"method":"ping","arguments":[]}`))).To(Succeed())
+				}
+			})
+
+			It("reports intact log as valid", func() {
+				verification, err := log.Verify()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(verification).To(Equal(Verification{Valid: true, Records: 3}))
+			})
+
+			It("reports edited record", func() {
+				contents, err := fs.ReadFileString(logPath)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fs.WriteFileString(logPath, strings.Replace(contents, `"ping"`, `"ssh"`, 1))).To(Succeed())
+
+				verification, err := log.Verify()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(verification).To(Equal(Verification{
+					Valid:   false,
+					Records: 0,
+					File:    logPath,
+					Line:    1,
+					Problem: "Record hash does not match its contents",
+				}))
+			})
+
+			It("reports removed record", func() {
+				contents, err := fs.ReadFileString(logPath)
+				Expect(err).ToNot(HaveOccurred())
+				lines := strings.Split(contents, "\n")
+				Expect(fs.WriteFileString(logPath, lines[0]+"\n"+lines[2]+"\n")).To(Succeed())
+
+				verification, err := log.Verify()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(verification.Valid).To(BeFalse())
+				Expect(verification.Records).To(Equal(1))
+				Expect(verification.Line).To(Equal(2))
+				Expect(verification.Problem).To(Equal("Record does not follow the previous record"))
+			})
+
+			It("reports records removed from the end of the log", func() {
+				contents, err := fs.ReadFileString(logPath)
+				Expect(err).ToNot(HaveOccurred())
+				lines := strings.Split(contents, "\n")
+				Expect(fs.WriteFileString(logPath, lines[0]+"\n")).To(Succeed())
+
+				verification, err := log.Verify()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(verification).To(Equal(Verification{
+					Valid:   false,
+					Records: 1,
+					File:    logPath,
+					Problem: "Log ends with record 1 but record 3 was written last",
+				}))
+			})
+
+			It("reports removed oldest record", func() {
+				contents, err := fs.ReadFileString(logPath)
+				Expect(err).ToNot(HaveOccurred())
+				lines := strings.Split(contents, "\n")
+				Expect(fs.WriteFileString(logPath, lines[1]+"\n"+lines[2]+"\n")).To(Succeed())
+
+				verification, err := log.Verify()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(verification.Valid).To(BeFalse())
+				Expect(verification.Line).To(Equal(1))
+				Expect(verification.Problem).To(Equal("Expected oldest record with sequence 1 but found 2"))
+			})
+
+			It("reports emptied log", func() {
+				Expect(fs.WriteFileString(logPath, "")).To(Succeed())
+
+				verification, err := log.Verify()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(verification.Valid).To(BeFalse())
+				Expect(verification.Problem).To(Equal("Log ends before record with sequence 3"))
+			})
+
+			It("reports removed head", func() {
+				Expect(fs.RemoveAll(logPath + ".head")).To(Succeed())
+
+				verification, err := log.Verify()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(verification.Valid).To(BeFalse())
+				Expect(verification.File).To(Equal(logPath + ".head"))
+				Expect(verification.Problem).To(Equal("Head is missing"))
+			})
+
+			It("reports edited head", func() {
+				contents, err := fs.ReadFileString(logPath + ".head")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fs.WriteFileString(logPath+".head", strings.Replace(contents, `"last_sequence":3`, `"last_sequence":1`, 1))).To(Succeed())
+
+				verification, err := log.Verify()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(verification.Valid).To(BeFalse())
+				Expect(verification.Problem).To(Equal("Head hash does not match its contents"))
+			})
+
+			It("accepts one record written after the head when agent stopped before updating it", func() {
+				head, err := fs.ReadFileString(logPath + ".head")
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(log.Record(entry("ping", `{"method":"ping","arguments":[]}`))).To(Succeed())
+				Expect(fs.WriteFileString(logPath+".head", head)).To(Succeed())
+
+				verification, err := log.Verify()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(verification).To(Equal(Verification{Valid: true, Records: 4}))
+
+				log = NewFileLog(logPath, options, fs, logger)
+				Expect(log.Record(entry("ping", `{"method":"ping","arguments":[]}`))).To(Succeed())
+
+				records := readRecords(logPath)
+				Expect(records[4].Sequence).To(Equal(int64(5)))
+				Expect(records[4].PrevHash).To(Equal(records[3].Hash))
+			})
+
+			It("continues the chain from the head after records were removed from the end", func() {
+				contents, err := fs.ReadFileString(logPath)
+				Expect(err).ToNot(HaveOccurred())
+				lines := strings.Split(contents, "\n")
+				Expect(fs.WriteFileString(logPath, lines[0]+"\n")).To(Succeed())
+
+				log = NewFileLog(logPath, options, fs, logger)
+				Expect(log.Record(entry("ping", `{"method":"ping","arguments":[]}`))).To(Succeed())
+
+				verification, err := log.Verify()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(verification.Valid).To(BeFalse())
+				Expect(verification.Line).To(Equal(2))
+				Expect(verification.Problem).To(Equal("Record does not follow the previous record"))
+			})
+		})
+
+		Context("when HMAC key is configured", func() {
+			BeforeEach(func() {
+				options = Options{HMACKey: []byte("fake-key")}
+			})
+
+			JustBeforeEach(func() {
+				for i := 0; i < 2; i++ {
+					Expect(log.Record(entry("ping", `{"method":"ping","arguments":[]}`))).To(Succeed())
+				}
+			})
+
+			It("reports intact log as valid", func() {
+				verification, err := log.Verify()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(verification).To(Equal(Verification{Valid: true, Records: 2}))
+			})
+
+			It("reports log verified with a different key", func() {
+				log = NewFileLog(logPath, Options{HMACKey: []byte("other-key")}, fs, logger)
+
+				verification, err := log.Verify()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(verification.Valid).To(BeFalse())
+				Expect(verification.Problem).To(Equal("Head hash does not match its contents"))
+			})
+
+			It("reports record rehashed without the key", func() {
+				log = NewFileLog(logPath, Options{}, fs, logger)
+
+				verification, err := log.Verify()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(verification.Valid).To(BeFalse())
+			})
+		})
+
+		Context("when log was rotated", func() {
+			BeforeEach(func() {
+				options = Options{MaxFileSizeBytes: 1, MaxRotatedFiles: 2}
+			})
+
+			It("verifies the chain across kept files starting with the oldest kept record", func() {
+				for i := 0; i < 4; i++ {
+					Expect(log.Record(entry("ping", `{"method":"ping","arguments":[]}`))).To(Succeed())
+				}
+
+				verification, err := log.Verify()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(verification).To(Equal(Verification{Valid: true, Records: 3}))
+			})
+
+			It("reports removed oldest kept file", func() {
+				for i := 0; i < 4; i++ {
+					Expect(log.Record(entry("ping", `{"method":"ping","arguments":[]}`))).To(Succeed())
+				}
+
+				Expect(fs.RemoveAll(logPath + ".2")).To(Succeed())
+
+				verification, err := log.Verify()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(verification.Valid).To(BeFalse())
+				Expect(verification.File).To(Equal(logPath + ".1"))
+				Expect(verification.Line).To(Equal(1))
+				Expect(verification.Problem).To(Equal("Expected oldest record with sequence 2 but found 3"))
+			})
+		})
+
+		It("returns error when log cannot be read", func() {
+			Expect(fs.MkdirAll(logPath, os.FileMode(0750))).To(Succeed())
+
+			_, err := log.Verify()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Reading audit log file"))
+		})
+	})
+})
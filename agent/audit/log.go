@@ -0,0 +1,108 @@
+package audit
+
+import (
+	"time"
+
+	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
+)
+
+// Log keeps a tamper-evident record of dispatched requests
+type Log interface {
+	Record(Entry) error
+
+	// Verify checks that no records were edited or removed
+	Verify() (Verification, error)
+}
+
+type Outcome string
+
+const (
+	OutcomeDone      Outcome = "done"
+	OutcomeFailed    Outcome = "failed"
+	OutcomeForbidden Outcome = "forbidden"
+	OutcomeCancelled Outcome = "cancelled"
+
+	// Asynchronous action was accepted; its outcome is recorded once its task ends
+	OutcomeDispatched Outcome = "dispatched"
+)
+
+// Entry describes dispatched request and its outcome
+type Entry struct {
+	Request boshhandler.Request
+
+	// Set for asynchronous actions
+	TaskID string
+
+	Outcome Outcome
+	Error   string
+
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// Record is a single line of the audit log. Hash covers all other fields
+// including PrevHash which is the hash of the preceding record.
+type Record struct {
+	Sequence int64     `json:"sequence"`
+	Time     time.Time `json:"time"`
+
+	Method    string `json:"method"`
+	Transport string `json:"transport,omitempty"`
+	Identity  string `json:"identity,omitempty"`
+	ReplyTo   string `json:"reply_to,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+
+	// Top-level scalar arguments; objects and arrays are only summarized
+	Arguments []interface{} `json:"arguments,omitempty"`
+
+	// Hash of the request payload with secrets redacted
+	PayloadSHA256 string `json:"payload_sha256"`
+
+	TaskID     string  `json:"agent_task_id,omitempty"`
+	Outcome    Outcome `json:"outcome"`
+	Error      string  `json:"error,omitempty"`
+	DurationMs int64   `json:"duration_ms"`
+
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// Verification is the result of checking the chain of records
+type Verification struct {
+	Valid   bool `json:"valid"`
+	Records int  `json:"records"`
+
+	// Location of the first broken record; empty when log is valid
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Problem string `json:"problem,omitempty"`
+}
+
+// Options configure rotation of the audit log
+type Options struct {
+	MaxFileSizeBytes int64
+	MaxRotatedFiles  int
+
+	// Records are hashed with HMAC-SHA256 when key is given so that
+	// the chain cannot be recomputed without the key (base64 in config)
+	HMACKey []byte
+}
+
+const (
+	defaultMaxFileSizeBytes = 10 * 1024 * 1024
+	defaultMaxRotatedFiles  = 5
+)
+
+func (o Options) maxFileSizeBytes() int64 {
+	if o.MaxFileSizeBytes > 0 {
+		return o.MaxFileSizeBytes
+	}
+	return defaultMaxFileSizeBytes
+}
+
+func (o Options) maxRotatedFiles() int {
+	if o.MaxRotatedFiles > 0 {
+		return o.MaxRotatedFiles
+	}
+	return defaultMaxRotatedFiles
+}
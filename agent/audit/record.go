@@ -0,0 +1,129 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+)
+
+const redactedValue = "[redacted]"
+
+// Values of properties whose names contain any of these are never
+// written to the audit log, not even as part of the hashed payload
+var secretPropertyNames = []string{
+	"password",
+	"secret",
+	"private_key",
+	"token",
+	"credential",
+}
+
+type requestPayload struct {
+	Arguments []interface{} `json:"arguments"`
+}
+
+func newRecord(entry Entry) Record {
+	req := entry.Request
+
+	record := Record{
+		Time:          entry.FinishedAt.UTC(),
+		Method:        req.Method,
+		Transport:     req.Source.Transport,
+		Identity:      req.Source.Identity,
+		ReplyTo:       req.ReplyTo,
+		RequestID:     req.RequestID,
+		PayloadSHA256: hashPayload(req.GetPayload()),
+		TaskID:        entry.TaskID,
+		Outcome:       entry.Outcome,
+		Error:         entry.Error,
+		DurationMs:    int64(entry.FinishedAt.Sub(entry.StartedAt) / 1e6),
+	}
+
+	var payload requestPayload
+
+	err := json.Unmarshal(req.GetPayload(), &payload)
+	if err == nil {
+		record.Arguments = summarizeArguments(payload.Arguments)
+	}
+
+	return record
+}
+
+// summarizeArguments keeps scalar arguments (e.g. ssh command name)
+// and replaces objects and arrays which may carry secrets
+func summarizeArguments(arguments []interface{}) []interface{} {
+	summary := []interface{}{}
+
+	for _, argument := range arguments {
+		switch argument.(type) {
+		case map[string]interface{}:
+			summary = append(summary, "[object]")
+		case []interface{}:
+			summary = append(summary, "[array]")
+		default:
+			summary = append(summary, argument)
+		}
+	}
+
+	return summary
+}
+
+// hashPayload returns hex encoded SHA-256 of the payload with secrets redacted;
+// payload that is not JSON cannot be redacted hence it is hashed as is
+func hashPayload(payload []byte) string {
+	var value interface{}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+
+	err := decoder.Decode(&value)
+	if err == nil {
+		redactedPayload, err := json.Marshal(redactSecrets(value))
+		if err == nil {
+			payload = redactedPayload
+		}
+	}
+
+	sum := sha256.Sum256(payload)
+
+	return hex.EncodeToString(sum[:])
+}
+
+func redactSecrets(value interface{}) interface{} {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		redacted := map[string]interface{}{}
+		for key, propertyValue := range typedValue {
+			if isSecretPropertyName(key) {
+				redacted[key] = redactedValue
+			} else {
+				redacted[key] = redactSecrets(propertyValue)
+			}
+		}
+		return redacted
+
+	case []interface{}:
+		redacted := []interface{}{}
+		for _, item := range typedValue {
+			redacted = append(redacted, redactSecrets(item))
+		}
+		return redacted
+
+	default:
+		return value
+	}
+}
+
+func isSecretPropertyName(name string) bool {
+	name = strings.ToLower(name)
+
+	for _, secretName := range secretPropertyNames {
+		if strings.Contains(name, secretName) {
+			return true
+		}
+	}
+
+	return false
+}
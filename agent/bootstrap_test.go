@@ -33,6 +33,7 @@ import (
 	boshnet "github.com/cloudfoundry/bosh-agent/platform/net"
 	bosharp "github.com/cloudfoundry/bosh-agent/platform/net/arp"
 	boship "github.com/cloudfoundry/bosh-agent/platform/net/ip"
+	boshsandbox "github.com/cloudfoundry/bosh-agent/platform/sandbox"
 	boshudev "github.com/cloudfoundry/bosh-agent/platform/udevdevice"
 	boshvitals "github.com/cloudfoundry/bosh-agent/platform/vitals"
 	boshdirs "github.com/cloudfoundry/bosh-agent/settings/directories"
@@ -517,6 +518,7 @@ func init() {
 					diskManager,
 					ubuntuNetManager,
 					ubuntuCertManager,
+					boshsandbox.NewNoopSandbox(),
 					monitRetryStrategy,
 					devicePathResolver,
 					500*time.Millisecond,
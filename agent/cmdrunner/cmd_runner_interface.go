@@ -17,4 +17,7 @@ type CmdResult struct {
 
 type CmdRunner interface {
 	RunCommand(jobName, taskName string, cmd boshsys.Command) (*CmdResult, error)
+
+	// RunCancellableCommand terminates the command once cancelCh is closed
+	RunCancellableCommand(jobName, taskName string, cmd boshsys.Command, cancelCh <-chan struct{}) (*CmdResult, error)
 }
@@ -9,8 +9,12 @@ type FakeFileLoggingCmdRunner struct {
 	RunCommands        []boshsys.Command
 	RunCommandJobName  string
 	RunCommandTaskName string
+	RunCommandCancelCh <-chan struct{}
 	RunCommandResult   *boshcmdrunner.CmdResult
 	RunCommandErr      error
+
+	// When set to true RunCancellableCommand returns only once cancelCh is closed
+	RunCommandWaitsForCancel bool
 }
 
 func NewFakeFileLoggingCmdRunner() *FakeFileLoggingCmdRunner {
@@ -23,3 +27,11 @@ func (f *FakeFileLoggingCmdRunner) RunCommand(jobName, taskName string, cmd bosh
 	f.RunCommands = append(f.RunCommands, cmd)
 	return f.RunCommandResult, f.RunCommandErr
 }
+
+func (f *FakeFileLoggingCmdRunner) RunCancellableCommand(jobName, taskName string, cmd boshsys.Command, cancelCh <-chan struct{}) (*boshcmdrunner.CmdResult, error) {
+	f.RunCommandCancelCh = cancelCh
+	if f.RunCommandWaitsForCancel {
+		<-cancelCh
+	}
+	return f.RunCommand(jobName, taskName, cmd)
+}
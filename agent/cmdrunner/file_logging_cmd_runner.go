@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"time"
 	"unicode/utf8"
 
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 	boshsys "github.com/cloudfoundry/bosh-utils/system"
 )
@@ -14,6 +16,8 @@ import (
 const (
 	fileOpenFlag int         = os.O_RDWR | os.O_CREATE | os.O_TRUNC
 	fileOpenPerm os.FileMode = os.FileMode(0640)
+
+	terminateGracePeriod = 10 * time.Second
 )
 
 type FileLoggingCmdRunner struct {
@@ -61,7 +65,46 @@ func NewFileLoggingCmdRunner(
 	}
 }
 
+// runFunc runs command and returns its exit status and whether it was cancelled
+type runFunc func(cmd boshsys.Command) (int, bool, error)
+
 func (f FileLoggingCmdRunner) RunCommand(jobName string, taskName string, cmd boshsys.Command) (*CmdResult, error) {
+	return f.runCommand(jobName, taskName, cmd, func(cmd boshsys.Command) (int, bool, error) {
+		_, _, exitStatus, err := f.cmdRunner.RunComplexCommand(cmd)
+		return exitStatus, false, err
+	})
+}
+
+func (f FileLoggingCmdRunner) RunCancellableCommand(jobName string, taskName string, cmd boshsys.Command, cancelCh <-chan struct{}) (*CmdResult, error) {
+	return f.runCommand(jobName, taskName, cmd, func(cmd boshsys.Command) (int, bool, error) {
+		process, err := f.cmdRunner.RunComplexCommandAsync(cmd)
+		if err != nil {
+			return -1, false, err
+		}
+
+		var result boshsys.Result
+
+		isCancelled := false
+
+		// Can only wait once on a process but cancel channel stays closed once cancelled
+		for processExitedCh := process.Wait(); processExitedCh != nil; {
+			select {
+			case result = <-processExitedCh:
+				processExitedCh = nil
+			case <-cancelCh:
+				cancelCh = nil
+				isCancelled = true
+
+				// Process is killed once grace period is over hence it always exits
+				_ = process.TerminateNicely(terminateGracePeriod)
+			}
+		}
+
+		return result.ExitStatus, isCancelled, result.Error
+	})
+}
+
+func (f FileLoggingCmdRunner) runCommand(jobName string, taskName string, cmd boshsys.Command, run runFunc) (*CmdResult, error) {
 	logsDir := path.Join(f.baseDir, jobName)
 
 	err := f.fs.RemoveAll(logsDir)
@@ -98,7 +141,7 @@ func (f FileLoggingCmdRunner) RunCommand(jobName string, taskName string, cmd bo
 	cmd.Stderr = stderrFile
 
 	// Stdout/stderr are redirected to the files
-	_, _, exitStatus, runErr := f.cmdRunner.RunComplexCommand(cmd)
+	exitStatus, isCancelled, runErr := run(cmd)
 
 	stdout, isStdoutTruncated, err := f.getTruncatedOutput(stdoutFile, f.truncateLength)
 	if err != nil {
@@ -120,8 +163,18 @@ func (f FileLoggingCmdRunner) RunCommand(jobName string, taskName string, cmd bo
 		ExitStatus: exitStatus,
 	}
 
+	if isCancelled {
+		err := bosherr.WrapErrorf(FileLoggingExecErr{result}, "Command %s was cancelled", taskName)
+		return nil, boshcodederr.NewCodedError(boshcodederr.ErrorCodeCancelled, err)
+	}
+
 	if runErr != nil {
-		return nil, FileLoggingExecErr{result}
+		execErr := boshcodederr.NewCodedError(boshcodederr.ErrorCodeScriptFailed, FileLoggingExecErr{result})
+		return nil, execErr.WithDetails(map[string]interface{}{
+			"job":         jobName,
+			"task":        taskName,
+			"exit_status": result.ExitStatus,
+		})
 	}
 
 	return result, nil
@@ -3,11 +3,13 @@ package cmdrunner_test
 import (
 	"errors"
 	"os"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
 	. "github.com/cloudfoundry/bosh-agent/agent/cmdrunner"
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
 	boshsys "github.com/cloudfoundry/bosh-utils/system"
 	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
 )
@@ -146,6 +148,20 @@ var _ = Describe("FileLoggingCmdRunner", func() {
 				Expect(result).To(BeNil())
 			})
 
+			It("classifies error as script failure with exit status", func() {
+				_, err := runner.RunCommand("fake-log-dir-name", "fake-log-file-name", cmd)
+				Expect(err).To(HaveOccurred())
+
+				codedErr, found := boshcodederr.FindCodedError(err)
+				Expect(found).To(BeTrue())
+				Expect(codedErr.Code).To(Equal(boshcodederr.ErrorCodeScriptFailed))
+				Expect(codedErr.Details).To(Equal(map[string]interface{}{
+					"job":         "fake-log-dir-name",
+					"task":        "fake-log-file-name",
+					"exit_status": 1,
+				}))
+			})
+
 			It("saves stdout to log file", func() {
 				_, err := runner.RunCommand("fake-log-dir-name", "fake-log-file-name", cmd)
 				Expect(err).To(HaveOccurred())
@@ -291,4 +307,60 @@ var _ = Describe("FileLoggingCmdRunner", func() {
 			})
 		})
 	})
+
+	Describe("RunCancellableCommand", func() {
+		var (
+			process  *fakesys.FakeProcess
+			cancelCh chan struct{}
+		)
+
+		BeforeEach(func() {
+			process = &fakesys.FakeProcess{}
+			cmdRunner.AddProcess("fake-cmd fake-args", process)
+			cancelCh = make(chan struct{})
+		})
+
+		It("executes given command and returns its result", func() {
+			process.WaitResult = boshsys.Result{ExitStatus: 0}
+
+			result, err := runner.RunCancellableCommand("fake-log-dir-name", "fake-log-file-name", cmd, cancelCh)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.ExitStatus).To(Equal(0))
+
+			Expect(cmdRunner.RunComplexCommands).To(HaveLen(1))
+			Expect(cmdRunner.RunComplexCommands[0].WorkingDir).To(Equal("/fake-working-dir"))
+			Expect(process.TerminatedNicely).To(BeFalse())
+		})
+
+		It("returns script error when command fails", func() {
+			process.WaitResult = boshsys.Result{ExitStatus: 1, Error: errors.New("fake-result-error")}
+
+			_, err := runner.RunCancellableCommand("fake-log-dir-name", "fake-log-file-name", cmd, cancelCh)
+			Expect(err).To(HaveOccurred())
+
+			codedErr, found := boshcodederr.FindCodedError(err)
+			Expect(found).To(BeTrue())
+			Expect(codedErr.Code).To(Equal(boshcodederr.ErrorCodeScriptFailed))
+		})
+
+		It("terminates the command once cancelled and returns cancelled error", func() {
+			process.TerminatedNicelyCallBack = func(p *fakesys.FakeProcess) {
+				p.WaitCh <- boshsys.Result{ExitStatus: 143, Error: errors.New("fake-terminated-error")}
+			}
+
+			close(cancelCh)
+
+			result, err := runner.RunCancellableCommand("fake-log-dir-name", "fake-log-file-name", cmd, cancelCh)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Command fake-log-file-name was cancelled: Command exited with 143"))
+			Expect(result).To(BeNil())
+
+			codedErr, found := boshcodederr.FindCodedError(err)
+			Expect(found).To(BeTrue())
+			Expect(codedErr.Code).To(Equal(boshcodederr.ErrorCodeCancelled))
+
+			Expect(process.TerminatedNicely).To(BeTrue())
+			Expect(process.TerminateNicelyKillGracePeriod).To(Equal(10 * time.Second))
+		})
+	})
 })
@@ -0,0 +1,174 @@
+package compiler
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	boshmodels "github.com/cloudfoundry/bosh-agent/agent/applier/models"
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
+	boshcrypto "github.com/cloudfoundry/bosh-agent/crypto"
+	boshblob "github.com/cloudfoundry/bosh-utils/blobstore"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	boshsys "github.com/cloudfoundry/bosh-utils/system"
+)
+
+const cachingCompilerLogTag = "cachingCompiler"
+
+type compiledPackageCacheEntry struct {
+	// Key is kept to tell apart keys with the same hash
+	Key string `json:"key"`
+
+	BlobstoreID string `json:"blobstore_id"`
+	Sha1        string `json:"sha1"`
+}
+
+// cachingCompiler remembers packages compiled on this VM so that compiling
+// the same package with the same dependencies again (e.g. when director
+// retries a failed compilation batch) returns previously uploaded blob.
+type cachingCompiler struct {
+	compiler  Compiler
+	blobstore boshblob.Blobstore
+	fs        boshsys.FileSystem
+	cacheDir  string
+	logger    boshlog.Logger
+}
+
+func NewCachingCompiler(
+	compiler Compiler,
+	blobstore boshblob.Blobstore,
+	fs boshsys.FileSystem,
+	cacheDir string,
+	logger boshlog.Logger,
+) Compiler {
+	return cachingCompiler{
+		compiler:  compiler,
+		blobstore: blobstore,
+		fs:        fs,
+		cacheDir:  cacheDir,
+		logger:    logger,
+	}
+}
+
+func (c cachingCompiler) Compile(
+	pkg Package,
+	deps []boshmodels.Package,
+	progress boshtask.ProgressReporter,
+	cancelSignal *boshtask.CancelSignal,
+) (string, string, error) {
+	key := compiledPackageCacheKey(pkg, deps)
+	entryPath := path.Join(c.cacheDir, fmt.Sprintf("%x.json", sha256.Sum256([]byte(key))))
+
+	if entry, found := c.readEntry(entryPath, key); found {
+		progress.ReportProgress(boshtask.Progress{Phase: "Checking previously compiled package"})
+
+		if c.blobExists(entry) {
+			c.logger.Info(cachingCompilerLogTag, "Using previously compiled package %s/%s from blob %s", pkg.Name, pkg.Version, entry.BlobstoreID)
+			return entry.BlobstoreID, entry.Sha1, nil
+		}
+
+		c.removeEntry(entryPath)
+	}
+
+	blobID, sha1, err := c.compiler.Compile(pkg, deps, progress, cancelSignal)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Package was compiled and uploaded so failing to cache it is not fatal
+	err = c.writeEntry(entryPath, compiledPackageCacheEntry{Key: key, BlobstoreID: blobID, Sha1: sha1})
+	if err != nil {
+		c.logger.Warn(cachingCompilerLogTag, "Failed to cache compiled package %s/%s: %s", pkg.Name, pkg.Version, err.Error())
+	}
+
+	return blobID, sha1, nil
+}
+
+// compiledPackageCacheKey includes every digest of the package and its
+// dependencies so that blobs that only share SHA-1 are not mistaken for each
+// other; it does not change when director sends dependencies in a different order
+func compiledPackageCacheKey(pkg Package, deps []boshmodels.Package) string {
+	var depKeys []string
+
+	for _, dep := range deps {
+		depKeys = append(depKeys, fmt.Sprintf("%s/%s/%s", dep.Name, dep.Version, boshcrypto.CanonicalDigestValue(dep.Source.Sha1)))
+	}
+
+	sort.Strings(depKeys)
+
+	pkgKey := fmt.Sprintf("%s/%s/%s", pkg.Name, pkg.Version, boshcrypto.CanonicalDigestValue(pkg.Sha1))
+
+	return strings.Join(append([]string{pkgKey}, depKeys...), "\n")
+}
+
+func (c cachingCompiler) readEntry(entryPath, key string) (compiledPackageCacheEntry, bool) {
+	var entry compiledPackageCacheEntry
+
+	if !c.fs.FileExists(entryPath) {
+		return entry, false
+	}
+
+	bytes, err := c.fs.ReadFile(entryPath)
+	if err != nil {
+		c.logger.Warn(cachingCompilerLogTag, "Failed to read compiled package cache entry %s: %s", entryPath, err.Error())
+		return entry, false
+	}
+
+	err = json.Unmarshal(bytes, &entry)
+	if err != nil {
+		c.logger.Warn(cachingCompilerLogTag, "Failed to unmarshal compiled package cache entry %s: %s", entryPath, err.Error())
+		return entry, false
+	}
+
+	return entry, entry.Key == key && entry.BlobstoreID != ""
+}
+
+func (c cachingCompiler) writeEntry(entryPath string, entry compiledPackageCacheEntry) error {
+	bytes, err := json.Marshal(entry)
+	if err != nil {
+		return bosherr.WrapError(err, "Marshalling compiled package cache entry")
+	}
+
+	err = c.fs.MkdirAll(c.cacheDir, os.FileMode(0700))
+	if err != nil {
+		return bosherr.WrapError(err, "Creating compiled package cache directory")
+	}
+
+	err = c.fs.WriteFile(entryPath, bytes)
+	if err != nil {
+		return bosherr.WrapError(err, "Writing compiled package cache entry")
+	}
+
+	return nil
+}
+
+func (c cachingCompiler) removeEntry(entryPath string) {
+	err := c.fs.RemoveAll(entryPath)
+	if err != nil {
+		c.logger.Warn(cachingCompilerLogTag, "Failed to remove compiled package cache entry %s: %s", entryPath, err.Error())
+	}
+}
+
+// blobExists downloads the blob since blobstore cannot be asked whether
+// blob exists; downloading also verifies that blob was not changed.
+// It costs one download of the compiled package which is only paid
+// when the package was compiled before and is much cheaper than compiling it again.
+func (c cachingCompiler) blobExists(entry compiledPackageCacheEntry) bool {
+	fileName, err := c.blobstore.Get(entry.BlobstoreID, entry.Sha1)
+	if err != nil {
+		c.logger.Info(cachingCompilerLogTag, "Previously compiled package blob %s is not available: %s", entry.BlobstoreID, err.Error())
+		return false
+	}
+
+	err = c.blobstore.CleanUp(fileName)
+	if err != nil {
+		c.logger.Warn(cachingCompilerLogTag, "Failed to clean up previously compiled package blob %s: %s", entry.BlobstoreID, err.Error())
+	}
+
+	return true
+}
@@ -0,0 +1,208 @@
+package compiler_test
+
+import (
+	"errors"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	boshmodels "github.com/cloudfoundry/bosh-agent/agent/applier/models"
+	. "github.com/cloudfoundry/bosh-agent/agent/compiler"
+	fakecomp "github.com/cloudfoundry/bosh-agent/agent/compiler/fakes"
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
+	faketask "github.com/cloudfoundry/bosh-agent/agent/task/fakes"
+	fakeblobstore "github.com/cloudfoundry/bosh-utils/blobstore/fakes"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+)
+
+var _ = Describe("cachingCompiler", func() {
+	var (
+		innerCompiler *fakecomp.FakeCompiler
+		blobstore     *fakeblobstore.FakeBlobstore
+		fs            *fakesys.FakeFileSystem
+		compiler      Compiler
+
+		pkg          Package
+		deps         []boshmodels.Package
+		progress     *faketask.FakeProgressReporter
+		cancelSignal *boshtask.CancelSignal
+	)
+
+	BeforeEach(func() {
+		innerCompiler = fakecomp.NewFakeCompiler()
+		blobstore = fakeblobstore.NewFakeBlobstore()
+		fs = fakesys.NewFakeFileSystem()
+		compiler = NewCachingCompiler(innerCompiler, blobstore, fs, "/fake-cache-dir", boshlog.NewLogger(boshlog.LevelNone))
+
+		pkg, deps = getCompileArgs()
+		progress = faketask.NewFakeProgressReporter()
+		cancelSignal = boshtask.NewCancelSignal()
+
+		innerCompiler.CompileBlobID = "fake-blob-id"
+		innerCompiler.CompileSha1 = "fake-blob-sha1"
+
+		blobstore.GetFileName = "/fake-downloaded-blob"
+	})
+
+	compileTwice := func(secondPkg Package, secondDeps []boshmodels.Package) (string, string, error) {
+		_, _, err := compiler.Compile(pkg, deps, progress, cancelSignal)
+		Expect(err).ToNot(HaveOccurred())
+
+		innerCompiler.CompileBlobID = "fake-other-blob-id"
+		innerCompiler.CompileSha1 = "fake-other-blob-sha1"
+
+		return compiler.Compile(secondPkg, secondDeps, progress, cancelSignal)
+	}
+
+	It("compiles package that was not compiled before", func() {
+		blobID, sha1, err := compiler.Compile(pkg, deps, progress, cancelSignal)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(blobID).To(Equal("fake-blob-id"))
+		Expect(sha1).To(Equal("fake-blob-sha1"))
+
+		Expect(innerCompiler.CompilePkg).To(Equal(pkg))
+		Expect(innerCompiler.CompileDeps).To(Equal(deps))
+		Expect(innerCompiler.CompileProgress).To(Equal(progress))
+		Expect(innerCompiler.CompileSignal).To(Equal(cancelSignal))
+	})
+
+	It("returns previously uploaded blob when the same package is compiled again", func() {
+		blobID, sha1, err := compileTwice(pkg, deps)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(blobID).To(Equal("fake-blob-id"))
+		Expect(sha1).To(Equal("fake-blob-sha1"))
+
+		Expect(innerCompiler.CompileCallCount).To(Equal(1))
+		Expect(progress.Phases()).To(Equal([]string{"Checking previously compiled package"}))
+	})
+
+	It("checks that previously uploaded blob still exists and cleans it up", func() {
+		_, _, err := compileTwice(pkg, deps)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(blobstore.GetBlobIDs).To(Equal([]string{"fake-blob-id"}))
+		Expect(blobstore.GetFingerprints).To(Equal([]string{"fake-blob-sha1"}))
+		Expect(blobstore.CleanUpFileName).To(Equal("/fake-downloaded-blob"))
+	})
+
+	It("compiles package again when previously uploaded blob is gone", func() {
+		blobstore.GetError = errors.New("fake-get-err")
+
+		blobID, sha1, err := compileTwice(pkg, deps)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(blobID).To(Equal("fake-other-blob-id"))
+		Expect(sha1).To(Equal("fake-other-blob-sha1"))
+
+		Expect(innerCompiler.CompileCallCount).To(Equal(2))
+
+		// Newly compiled package is cached instead
+		blobstore.GetError = nil
+
+		blobID, _, err = compiler.Compile(pkg, deps, progress, cancelSignal)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(blobID).To(Equal("fake-other-blob-id"))
+		Expect(innerCompiler.CompileCallCount).To(Equal(2))
+	})
+
+	It("returns previously uploaded blob when dependencies and their digests come in a different order", func() {
+		deps[0].Source.Sha1 = "sha1:fake-sha1;sha256:fake-sha256"
+
+		reorderedDeps := []boshmodels.Package{deps[1], deps[0]}
+		reorderedDeps[1].Source.Sha1 = "sha256:fake-sha256;sha1:fake-sha1"
+
+		blobID, _, err := compileTwice(pkg, reorderedDeps)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(blobID).To(Equal("fake-blob-id"))
+		Expect(innerCompiler.CompileCallCount).To(Equal(1))
+	})
+
+	It("compiles package again when dependency has the same SHA-1 but different stronger digest", func() {
+		deps[0].Source.Sha1 = "sha1:fake-sha1;sha256:fake-sha256"
+
+		changedDeps := []boshmodels.Package{deps[0], deps[1]}
+		changedDeps[0].Source.Sha1 = "sha1:fake-sha1;sha256:fake-other-sha256"
+
+		blobID, _, err := compileTwice(pkg, changedDeps)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(blobID).To(Equal("fake-other-blob-id"))
+		Expect(innerCompiler.CompileCallCount).To(Equal(2))
+	})
+
+	It("compiles package again when package source has the same SHA-1 but different stronger digest", func() {
+		pkg.Sha1 = "sha1:fake-sha1;sha256:fake-sha256"
+
+		changedPkg := pkg
+		changedPkg.Sha1 = "sha1:fake-sha1;sha256:fake-other-sha256"
+
+		blobID, _, err := compileTwice(changedPkg, deps)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(blobID).To(Equal("fake-other-blob-id"))
+		Expect(innerCompiler.CompileCallCount).To(Equal(2))
+	})
+
+	It("compiles package again when package source changed", func() {
+		changedPkg := pkg
+		changedPkg.Sha1 = "fake-changed-sha1"
+
+		blobID, _, err := compileTwice(changedPkg, deps)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(blobID).To(Equal("fake-other-blob-id"))
+		Expect(innerCompiler.CompileCallCount).To(Equal(2))
+	})
+
+	It("compiles package again when dependencies changed", func() {
+		changedDeps := []boshmodels.Package{deps[0], deps[1]}
+		changedDeps[1].Version = "fake-changed-version"
+
+		blobID, _, err := compileTwice(pkg, changedDeps)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(blobID).To(Equal("fake-other-blob-id"))
+		Expect(innerCompiler.CompileCallCount).To(Equal(2))
+	})
+
+	It("does not cache package that failed to compile", func() {
+		innerCompiler.CompileErr = errors.New("fake-compile-err")
+
+		_, _, err := compiler.Compile(pkg, deps, progress, cancelSignal)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(Equal("fake-compile-err"))
+
+		innerCompiler.CompileErr = nil
+
+		_, _, err = compiler.Compile(pkg, deps, progress, cancelSignal)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(innerCompiler.CompileCallCount).To(Equal(2))
+	})
+
+	It("returns compiled package when it cannot be cached", func() {
+		fs.WriteFileError = errors.New("fake-write-err")
+
+		blobID, _, err := compiler.Compile(pkg, deps, progress, cancelSignal)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(blobID).To(Equal("fake-blob-id"))
+	})
+
+	It("compiles package when cache entry is corrupted", func() {
+		_, _, err := compiler.Compile(pkg, deps, progress, cancelSignal)
+		Expect(err).ToNot(HaveOccurred())
+
+		var entries []string
+
+		err = fs.Walk("/fake-cache-dir", func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				entries = append(entries, path)
+			}
+			return err
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+
+		fs.WriteFileString(entries[0], "fake-corrupted-entry")
+
+		_, _, err = compiler.Compile(pkg, deps, progress, cancelSignal)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(innerCompiler.CompileCallCount).To(Equal(2))
+	})
+})
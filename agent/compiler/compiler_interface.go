@@ -2,17 +2,27 @@ package compiler
 
 import (
 	boshmodels "github.com/cloudfoundry/bosh-agent/agent/applier/models"
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
 )
 
 type Compiler interface {
-	Compile(pkg Package, deps []boshmodels.Package) (blobID, sha1 string, err error)
+	Compile(pkg Package, deps []boshmodels.Package, progress boshtask.ProgressReporter, cancelSignal *boshtask.CancelSignal) (blobID, sha1 string, err error)
 }
 
 type Package struct {
 	BlobstoreID string `json:"blobstore_id"`
 	Name        string
-	Sha1        string
-	Version     string
+
+	// Digests in the same format as models.Source.Sha1
+	Sha1    string
+	Version string
+}
+
+type Options struct {
+	// Verify package sources with the strongest digest sent by director;
+	// sources are not verified by default since older directors
+	// might have stored digests that do not match
+	StrictSourceVerification bool
 }
 
 type Dependencies map[string]Package
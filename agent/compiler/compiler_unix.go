@@ -3,11 +3,12 @@
 package compiler
 
 import (
+	boshsandbox "github.com/cloudfoundry/bosh-agent/platform/sandbox"
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 	boshsys "github.com/cloudfoundry/bosh-utils/system"
 )
 
-func (c concreteCompiler) runPackagingCommand(compilePath, enablePath string, pkg Package) error {
+func (c concreteCompiler) runPackagingCommand(compilePath, installPath, enablePath string, pkg Package, cancelCh <-chan struct{}) error {
 	command := boshsys.Command{
 		Name: "bash",
 		Args: []string{"-x", PackagingScriptName},
@@ -19,7 +20,11 @@ func (c concreteCompiler) runPackagingCommand(compilePath, enablePath string, pk
 		},
 		WorkingDir: compilePath,
 	}
-	_, err := c.runner.RunCommand("compilation", PackagingScriptName, command)
+	// Packaging script installs into a private directory at the enable path
+	// instead of following the symlink shared with the rest of the system
+	bindMounts := []boshsandbox.BindMount{{Source: installPath, Target: enablePath}}
+
+	err := c.runInSandbox(pkg, command, []string{compilePath, installPath}, bindMounts, cancelCh)
 	if err != nil {
 		return bosherr.WrapError(err, "Running packaging script")
 	}
@@ -7,7 +7,7 @@ import (
 	boshsys "github.com/cloudfoundry/bosh-utils/system"
 )
 
-func (c concreteCompiler) runPackagingCommand(compilePath, enablePath string, pkg Package) error {
+func (c concreteCompiler) runPackagingCommand(compilePath, installPath, enablePath string, pkg Package, cancelCh <-chan struct{}) error {
 	runCommand := fmt.Sprintf("iex ((get-content %s) -join \"`n\")", PackagingScriptName)
 	command := boshsys.Command{
 		Name: "powershell",
@@ -21,7 +21,7 @@ func (c concreteCompiler) runPackagingCommand(compilePath, enablePath string, pk
 		WorkingDir: compilePath,
 	}
 
-	_, err := c.runner.RunCommand("compilation", PackagingScriptName, command)
+	err := c.runInSandbox(pkg, command, []string{compilePath, installPath}, nil, cancelCh)
 	if err != nil {
 		return bosherr.WrapError(err, "Running packaging script")
 	}
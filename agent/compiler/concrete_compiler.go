@@ -4,11 +4,15 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"time"
 
 	boshbc "github.com/cloudfoundry/bosh-agent/agent/applier/bundlecollection"
 	boshmodels "github.com/cloudfoundry/bosh-agent/agent/applier/models"
 	"github.com/cloudfoundry/bosh-agent/agent/applier/packages"
 	boshcmdrunner "github.com/cloudfoundry/bosh-agent/agent/cmdrunner"
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
+	boshsandbox "github.com/cloudfoundry/bosh-agent/platform/sandbox"
 	boshblob "github.com/cloudfoundry/bosh-utils/blobstore"
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 	boshcmd "github.com/cloudfoundry/bosh-utils/fileutil"
@@ -29,6 +33,8 @@ type concreteCompiler struct {
 	compileDirProvider CompileDirProvider
 	packageApplier     packages.Applier
 	packagesBc         boshbc.BundleCollection
+	sandbox            boshsandbox.Sandbox
+	options            Options
 }
 
 func NewConcreteCompiler(
@@ -39,6 +45,8 @@ func NewConcreteCompiler(
 	compileDirProvider CompileDirProvider,
 	packageApplier packages.Applier,
 	packagesBc boshbc.BundleCollection,
+	sandbox boshsandbox.Sandbox,
+	options Options,
 ) Compiler {
 	return concreteCompiler{
 		compressor:         compressor,
@@ -48,16 +56,35 @@ func NewConcreteCompiler(
 		compileDirProvider: compileDirProvider,
 		packageApplier:     packageApplier,
 		packagesBc:         packagesBc,
+		sandbox:            sandbox,
+		options:            options,
 	}
 }
 
-func (c concreteCompiler) Compile(pkg Package, deps []boshmodels.Package) (string, string, error) {
+// Compile stops before the next step once cancel signal is fired and terminates
+// packaging script if it is running. Compile directory, downloaded source
+// and compressed package are removed and new package bundle is uninstalled.
+func (c concreteCompiler) Compile(
+	pkg Package,
+	deps []boshmodels.Package,
+	progress boshtask.ProgressReporter,
+	cancelSignal *boshtask.CancelSignal,
+) (string, string, error) {
 	err := c.packageApplier.KeepOnly([]boshmodels.Package{})
 	if err != nil {
 		return "", "", bosherr.WrapError(err, "Removing packages")
 	}
 
-	for _, dep := range deps {
+	for i, dep := range deps {
+		if err := cancelSignal.Err(); err != nil {
+			return "", "", err
+		}
+
+		progress.ReportProgress(boshtask.Progress{
+			Phase:      fmt.Sprintf("Installing dependent package %s", dep.Name),
+			Percentage: boshtask.PercentageOf(i, len(deps)),
+		})
+
 		err := c.packageApplier.Apply(dep)
 		if err != nil {
 			return "", "", bosherr.WrapErrorf(err, "Installing dependent package: '%s'", dep.Name)
@@ -65,7 +92,7 @@ func (c concreteCompiler) Compile(pkg Package, deps []boshmodels.Package) (strin
 	}
 
 	compilePath := path.Join(c.compileDirProvider.CompileDir(), pkg.Name)
-	err = c.fetchAndUncompress(pkg, compilePath)
+	err = c.fetchAndUncompress(pkg, compilePath, progress, cancelSignal)
 	if err != nil {
 		return "", "", bosherr.WrapErrorf(err, "Fetching package %s", pkg.Name)
 	}
@@ -89,6 +116,16 @@ func (c concreteCompiler) Compile(pkg Package, deps []boshmodels.Package) (strin
 		return "", "", bosherr.WrapError(err, "Setting up new package bundle")
 	}
 
+	// New package bundle is only kept until compiled package is uploaded
+	isInstalled := true
+
+	defer func() {
+		if isInstalled {
+			_ = compiledPkgBundle.Disable()
+			_ = compiledPkgBundle.Uninstall()
+		}
+	}()
+
 	_, enablePath, err := compiledPkgBundle.Enable()
 	if err != nil {
 		return "", "", bosherr.WrapError(err, "Enabling new package bundle")
@@ -97,23 +134,40 @@ func (c concreteCompiler) Compile(pkg Package, deps []boshmodels.Package) (strin
 	scriptPath := path.Join(compilePath, PackagingScriptName)
 
 	if c.fs.FileExists(scriptPath) {
-		if err := c.runPackagingCommand(compilePath, enablePath, pkg); err != nil {
+		progress.ReportProgress(boshtask.Progress{Phase: "Running packaging script"})
+
+		if err := c.runPackagingCommand(compilePath, installPath, enablePath, pkg, cancelSignal.Done()); err != nil {
 			return "", "", bosherr.WrapError(err, "Running packaging script")
 		}
 	}
 
-	tmpPackageTar, err := c.compressor.CompressFilesInDir(installPath)
+	progress.ReportProgress(boshtask.Progress{Phase: "Compressing compiled package"})
+
+	if err := cancelSignal.Err(); err != nil {
+		return "", "", err
+	}
+
+	// tar is terminated once cancelled
+	tmpPackageTar, err := cancelSignal.Compressor(c.compressor).CompressFilesInDir(installPath)
 	if err != nil {
-		return "", "", bosherr.WrapError(err, "Compressing compiled package")
+		return "", "", bosherr.WrapError(cancelSignal.ErrOr(err), "Compressing compiled package")
 	}
 
 	defer func() {
 		_ = c.compressor.CleanUp(tmpPackageTar)
 	}()
 
+	// Uploaded blob would not be referenced by anyone
+	if err := cancelSignal.Err(); err != nil {
+		return "", "", err
+	}
+
+	progress.ReportProgress(boshtask.Progress{Phase: "Uploading compiled package"})
+
 	uploadedBlobID, sha1, err := c.blobstore.Create(tmpPackageTar)
 	if err != nil {
-		return "", "", bosherr.WrapError(err, "Uploading compiled package")
+		err = bosherr.WrapError(err, "Uploading compiled package")
+		return "", "", boshcodederr.NewCodedError(boshcodederr.ErrorCodeBlobstoreUnavailable, err)
 	}
 
 	err = compiledPkgBundle.Disable()
@@ -126,6 +180,8 @@ func (c concreteCompiler) Compile(pkg Package, deps []boshmodels.Package) (strin
 		return "", "", bosherr.WrapError(err, "Uninstalling compiled package")
 	}
 
+	isInstalled = false
+
 	err = c.packageApplier.KeepOnly([]boshmodels.Package{})
 	if err != nil {
 		return "", "", bosherr.WrapError(err, "Removing packages")
@@ -134,21 +190,107 @@ func (c concreteCompiler) Compile(pkg Package, deps []boshmodels.Package) (strin
 	return uploadedBlobID, sha1, nil
 }
 
-func (c concreteCompiler) fetchAndUncompress(pkg Package, targetDir string) error {
+// runInSandbox runs command confined to compilation sandbox where only writableDirs
+// could be modified. Command is terminated once sandbox timeout is reached.
+func (c concreteCompiler) runInSandbox(pkg Package, command boshsys.Command, writableDirs []string, bindMounts []boshsandbox.BindMount, cancelCh <-chan struct{}) (err error) {
+	wrappedCmd, err := c.sandbox.Wrap(pkg.Name, command, writableDirs, bindMounts)
+	if err != nil {
+		return bosherr.WrapError(err, "Preparing compilation sandbox")
+	}
+
+	defer func() {
+		// Compiled files must not be left owned by sandbox user
+		cleanUpErr := wrappedCmd.CleanUp()
+		if cleanUpErr != nil && err == nil {
+			err = bosherr.WrapError(cleanUpErr, "Cleaning up compilation sandbox")
+		}
+	}()
+
+	timedOutCh := make(chan struct{})
+
+	if wrappedCmd.Timeout > 0 {
+		doneCh := make(chan struct{})
+		defer close(doneCh)
+
+		parentCancelCh := cancelCh
+		timeoutCancelCh := make(chan struct{})
+		cancelCh = timeoutCancelCh
+
+		go func() {
+			timer := time.NewTimer(wrappedCmd.Timeout)
+			defer timer.Stop()
+
+			select {
+			case <-parentCancelCh:
+				close(timeoutCancelCh)
+			case <-timer.C:
+				close(timedOutCh)
+				close(timeoutCancelCh)
+			case <-doneCh:
+			}
+		}()
+	}
+
+	_, err = c.runner.RunCancellableCommand("compilation", PackagingScriptName, wrappedCmd.Command, cancelCh)
+
+	select {
+	case <-timedOutCh:
+		err = bosherr.WrapErrorf(err, "Packaging script timed out after %s", wrappedCmd.Timeout)
+		return boshcodederr.NewCodedError(boshcodederr.ErrorCodeScriptFailed, err)
+	default:
+		return err
+	}
+}
+
+func (c concreteCompiler) fetchAndUncompress(pkg Package, targetDir string, progress boshtask.ProgressReporter, cancelSignal *boshtask.CancelSignal) error {
 	if pkg.BlobstoreID == "" {
 		return bosherr.Error(fmt.Sprintf("Blobstore ID for package '%s' is empty", pkg.Name))
 	}
 
-	// Do not verify integrity of the download via SHA1
+	// Do not verify integrity of the download by default
 	// because Director might have stored non-matching SHA1.
-	// This will be fixed in future by explicitly asking to verify SHA1
-	// instead of doing that by default like all other downloads.
+	// Strict verification has to be explicitly turned on.
 	// (Ruby agent mistakenly never checked SHA1.)
-	depFilePath, err := c.blobstore.Get(pkg.BlobstoreID, "")
+	var digest string
+
+	if c.options.StrictSourceVerification {
+		if pkg.Sha1 == "" {
+			return bosherr.Errorf("Digest for package '%s' is empty", pkg.Name)
+		}
+		digest = pkg.Sha1
+	}
+
+	progress.ReportProgress(boshtask.Progress{Phase: "Downloading package source"})
+
+	// Blobstore cli downloading the blob is terminated once cancelled
+	blobstore, err := cancelSignal.Blobstore(c.blobstore)
+	if err != nil {
+		return bosherr.WrapError(err, "Preparing blobstore")
+	}
+
+	depFilePath, err := blobstore.Get(pkg.BlobstoreID, digest)
 	if err != nil {
-		return bosherr.WrapErrorf(err, "Fetching package blob %s", pkg.BlobstoreID)
+		if cancelErr := cancelSignal.Err(); cancelErr != nil {
+			return cancelErr
+		}
+
+		err = bosherr.WrapErrorf(err, "Fetching package blob %s", pkg.BlobstoreID)
+		return boshcodederr.NewCodedError(boshcodederr.ErrorCodeBlobstoreUnavailable, err)
+	}
+
+	defer func() {
+		_ = c.blobstore.CleanUp(depFilePath)
+	}()
+
+	if err := cancelSignal.Err(); err != nil {
+		return err
 	}
 
+	progress.ReportProgress(boshtask.Progress{
+		Phase:            "Uncompressing package source",
+		BytesTransferred: c.fileSize(depFilePath),
+	})
+
 	err = c.atomicDecompress(depFilePath, targetDir)
 	if err != nil {
 		return bosherr.WrapErrorf(err, "Uncompressing package %s", pkg.Name)
@@ -157,6 +299,25 @@ func (c concreteCompiler) fetchAndUncompress(pkg Package, targetDir string) erro
 	return nil
 }
 
+// fileSize returns size of the file or 0 if it cannot be determined
+func (c concreteCompiler) fileSize(path string) int64 {
+	file, err := c.fs.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return 0
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0
+	}
+
+	return info.Size()
+}
+
 func (c concreteCompiler) atomicDecompress(archivePath string, finalDir string) error {
 	tmpInstallPath := finalDir + "-bosh-agent-unpack"
 
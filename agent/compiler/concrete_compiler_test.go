@@ -4,6 +4,7 @@ import (
 	"errors"
 	"os"
 	"runtime"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -13,6 +14,13 @@ import (
 	fakepackages "github.com/cloudfoundry/bosh-agent/agent/applier/packages/fakes"
 	fakecmdrunner "github.com/cloudfoundry/bosh-agent/agent/cmdrunner/fakes"
 	. "github.com/cloudfoundry/bosh-agent/agent/compiler"
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
+	faketask "github.com/cloudfoundry/bosh-agent/agent/task/fakes"
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
+	boshcancel "github.com/cloudfoundry/bosh-agent/platform/cancellable"
+	boshsandbox "github.com/cloudfoundry/bosh-agent/platform/sandbox"
+	fakesandbox "github.com/cloudfoundry/bosh-agent/platform/sandbox/fakes"
+	boshblob "github.com/cloudfoundry/bosh-utils/blobstore"
 	fakeblobstore "github.com/cloudfoundry/bosh-utils/blobstore/fakes"
 	fakecmd "github.com/cloudfoundry/bosh-utils/fileutil/fakes"
 	boshsys "github.com/cloudfoundry/bosh-utils/system"
@@ -25,6 +33,16 @@ type FakeCompileDirProvider struct {
 
 func (cdp FakeCompileDirProvider) CompileDir() string { return cdp.Dir }
 
+type callbackBlobstore struct {
+	*fakeblobstore.FakeBlobstore
+	getCallBack func()
+}
+
+func (bs callbackBlobstore) Get(blobID, fingerprint string) (string, error) {
+	bs.getCallBack()
+	return bs.FakeBlobstore.Get(blobID, fingerprint)
+}
+
 func getCompileArgs() (Package, []boshmodels.Package) {
 	pkg := Package{
 		BlobstoreID: "blobstore_id",
@@ -65,6 +83,7 @@ func init() {
 			runner         *fakecmdrunner.FakeFileLoggingCmdRunner
 			packageApplier *fakepackages.FakeApplier
 			packagesBc     *fakebc.FakeBundleCollection
+			sandbox        *fakesandbox.FakeSandbox
 		)
 
 		BeforeEach(func() {
@@ -74,6 +93,7 @@ func init() {
 			runner = fakecmdrunner.NewFakeFileLoggingCmdRunner()
 			packageApplier = fakepackages.NewFakeApplier()
 			packagesBc = fakebc.NewFakeBundleCollection()
+			sandbox = fakesandbox.NewFakeSandbox()
 
 			compiler = NewConcreteCompiler(
 				compressor,
@@ -83,6 +103,8 @@ func init() {
 				FakeCompileDirProvider{Dir: "/fake-compile-dir"},
 				packageApplier,
 				packagesBc,
+				sandbox,
+				Options{},
 			)
 		})
 
@@ -92,9 +114,12 @@ func init() {
 
 		Describe("Compile", func() {
 			var (
-				bundle  *fakebc.FakeBundle
-				pkg     Package
-				pkgDeps []boshmodels.Package
+				bundle   *fakebc.FakeBundle
+				pkg      Package
+				pkgDeps  []boshmodels.Package
+				progress *faketask.FakeProgressReporter
+
+				cancelSignal *boshtask.CancelSignal
 			)
 
 			BeforeEach(func() {
@@ -109,13 +134,15 @@ func init() {
 				compressor.CompressFilesInDirTarballPath = "/tmp/compressed-compiled-package"
 
 				pkg, pkgDeps = getCompileArgs()
+				progress = faketask.NewFakeProgressReporter()
+				cancelSignal = boshtask.NewCancelSignal()
 			})
 
 			It("returns blob id and sha1 of created compiled package", func() {
 				blobstore.CreateBlobID = "fake-blob-id"
 				blobstore.CreateFingerprint = "fake-blob-sha1"
 
-				blobID, sha1, err := compiler.Compile(pkg, pkgDeps)
+				blobID, sha1, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
 				Expect(err).ToNot(HaveOccurred())
 
 				Expect(blobID).To(Equal("fake-blob-id"))
@@ -123,7 +150,7 @@ func init() {
 			})
 
 			It("cleans up all packages before and after applying dependent packages", func() {
-				_, _, err := compiler.Compile(pkg, pkgDeps)
+				_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(packageApplier.ActionsCalled).To(Equal([]string{"KeepOnly", "Apply", "Apply", "KeepOnly"}))
 				Expect(packageApplier.KeptOnlyPackages).To(BeEmpty())
@@ -132,13 +159,13 @@ func init() {
 			It("returns an error if cleaning up packages fails", func() {
 				packageApplier.KeepOnlyErr = errors.New("fake-keep-only-error")
 
-				_, _, err := compiler.Compile(pkg, pkgDeps)
+				_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("fake-keep-only-error"))
 			})
 
 			It("fetches source package from blobstore without checking SHA1 by default because of Director bug", func() {
-				_, _, err := compiler.Compile(pkg, pkgDeps)
+				_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
 				Expect(err).ToNot(HaveOccurred())
 
 				Expect(blobstore.GetBlobIDs[0]).To(Equal("blobstore_id"))
@@ -146,17 +173,53 @@ func init() {
 			})
 
 			PIt("(Pending Tracker Story: <https://www.pivotaltracker.com/story/show/94524232>) fetches source package from blobstore and checks SHA1 by default in future", func() {
-				_, _, err := compiler.Compile(pkg, pkgDeps)
+				_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
 				Expect(err).ToNot(HaveOccurred())
 
 				Expect(blobstore.GetBlobIDs[0]).To(Equal("blobstore_id"))
 				Expect(blobstore.GetFingerprints[0]).To(Equal("sha1"))
 			})
 
+			Context("when strict source verification is enabled", func() {
+				BeforeEach(func() {
+					compiler = NewConcreteCompiler(
+						compressor,
+						blobstore,
+						fs,
+						runner,
+						FakeCompileDirProvider{Dir: "/fake-compile-dir"},
+						packageApplier,
+						packagesBc,
+						sandbox,
+						Options{StrictSourceVerification: true},
+					)
+				})
+
+				It("fetches source package from blobstore verifying all of its digests", func() {
+					pkg.Sha1 = "sha1:fake-sha1;sha256:fake-sha256"
+
+					_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
+					Expect(err).ToNot(HaveOccurred())
+
+					Expect(blobstore.GetBlobIDs[0]).To(Equal("blobstore_id"))
+					Expect(blobstore.GetFingerprints[0]).To(Equal("sha1:fake-sha1;sha256:fake-sha256"))
+				})
+
+				It("returns an error without fetching source package when package has no digest", func() {
+					pkg.Sha1 = ""
+
+					_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("Digest for package 'pkg_name' is empty"))
+
+					Expect(blobstore.GetBlobIDs).To(BeEmpty())
+				})
+			})
+
 			It("returns an error if removing compile target directory during uncompression fails", func() {
 				fs.RegisterRemoveAllError("/fake-compile-dir/pkg_name", errors.New("fake-remove-error"))
 
-				_, _, err := compiler.Compile(pkg, pkgDeps)
+				_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("fake-remove-error"))
 			})
@@ -164,7 +227,7 @@ func init() {
 			It("returns an error if creating compile target directory during uncompression fails", func() {
 				fs.RegisterMkdirAllError("/fake-compile-dir/pkg_name", errors.New("fake-mkdir-error"))
 
-				_, _, err := compiler.Compile(pkg, pkgDeps)
+				_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("fake-mkdir-error"))
 			})
@@ -172,7 +235,7 @@ func init() {
 			It("returns an error if removing temporary compile target directory during uncompression fails", func() {
 				fs.RegisterRemoveAllError("/fake-compile-dir/pkg_name-bosh-agent-unpack", errors.New("fake-remove-error"))
 
-				_, _, err := compiler.Compile(pkg, pkgDeps)
+				_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("fake-remove-error"))
 			})
@@ -180,7 +243,7 @@ func init() {
 			It("returns an error if creating temporary compile target directory during uncompression fails", func() {
 				fs.RegisterMkdirAllError("/fake-compile-dir/pkg_name-bosh-agent-unpack", errors.New("fake-mkdir-error"))
 
-				_, _, err := compiler.Compile(pkg, pkgDeps)
+				_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("fake-mkdir-error"))
 			})
@@ -188,25 +251,47 @@ func init() {
 			It("returns an error if target directory is empty during uncompression", func() {
 				pkg.BlobstoreID = ""
 
-				_, _, err := compiler.Compile(pkg, pkgDeps)
+				_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("Blobstore ID for package '%s' is empty", pkg.Name))
 			})
 
 			It("installs dependent packages", func() {
-				_, _, err := compiler.Compile(pkg, pkgDeps)
+				_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(packageApplier.AppliedPackages).To(Equal(pkgDeps))
 			})
 
+			It("reports progress of each compilation phase", func() {
+				_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(progress.Phases()).To(Equal([]string{
+					"Installing dependent package first_dep_name",
+					"Installing dependent package sec_dep_name",
+					"Downloading package source",
+					"Uncompressing package source",
+					"Compressing compiled package",
+					"Uploading compiled package",
+				}))
+				Expect(*progress.Reported[1].Percentage).To(Equal(50))
+			})
+
+			It("cleans up downloaded package source", func() {
+				blobstore.GetFileName = "/tmp/fake-package-source"
+
+				_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(blobstore.CleanUpFileName).To(Equal("/tmp/fake-package-source"))
+			})
+
 			It("cleans up the compile directory", func() {
-				_, _, err := compiler.Compile(pkg, pkgDeps)
+				_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(fs.FileExists("/fake-compile-dir/pkg_name")).To(BeFalse())
 			})
 
 			It("installs, enables and later cleans up bundle", func() {
-				_, _, err := compiler.Compile(pkg, pkgDeps)
+				_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(bundle.ActionsCalled).To(Equal([]string{
 					"InstallWithoutContents",
@@ -226,7 +311,7 @@ func init() {
 
 				It("runs packaging script ", func() {
 
-					_, _, err := compiler.Compile(pkg, pkgDeps)
+					_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
 					Expect(err).ToNot(HaveOccurred())
 
 					expectedCmd := boshsys.Command{
@@ -253,23 +338,107 @@ func init() {
 					Expect(runner.RunCommandTaskName).To(Equal(PackagingScriptName))
 				})
 
+				It("terminates packaging script once cancelled", func() {
+					_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(runner.RunCommandCancelCh).To(Equal(cancelSignal.Done()))
+				})
+
 				It("propagates the error from packaging script", func() {
 					runner.RunCommandErr = errors.New("fake-packaging-error")
 
-					_, _, err := compiler.Compile(pkg, pkgDeps)
+					_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
 					Expect(err).To(HaveOccurred())
 					Expect(err.Error()).To(ContainSubstring("fake-packaging-error"))
 				})
+
+				It("runs packaging script in sandbox where only compile and install directories are writable", func() {
+					sandbox.WrapPrefix = "sandboxed-"
+
+					_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
+					Expect(err).ToNot(HaveOccurred())
+
+					Expect(sandbox.WrapName).To(Equal("pkg_name"))
+					Expect(sandbox.WrapCmd.WorkingDir).To(Equal("/fake-compile-dir/pkg_name"))
+					Expect(sandbox.WrapWritableDirs).To(Equal([]string{
+						"/fake-compile-dir/pkg_name",
+						"/fake-dir/data/packages/pkg_name/pkg_version",
+					}))
+					Expect(sandbox.WrapBindMounts).To(Equal([]boshsandbox.BindMount{
+						{Source: "/fake-dir/data/packages/pkg_name/pkg_version", Target: "/fake-dir/packages/pkg_name"},
+					}))
+
+					Expect(len(runner.RunCommands)).To(Equal(1))
+					Expect(runner.RunCommands[0].Name).To(HavePrefix("sandboxed-"))
+					Expect(sandbox.CleanUpCallCount).To(Equal(1))
+				})
+
+				It("returns an error without running packaging script if preparing sandbox fails", func() {
+					sandbox.WrapErr = errors.New("fake-wrap-err")
+
+					_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("fake-wrap-err"))
+					Expect(runner.RunCommands).To(BeEmpty())
+				})
+
+				It("returns an error if cleaning up sandbox fails", func() {
+					sandbox.CleanUpErr = errors.New("fake-clean-up-err")
+
+					_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("fake-clean-up-err"))
+					Expect(blobstore.CreateFileNames).To(BeEmpty())
+				})
+
+				It("keeps packaging script error if cleaning up sandbox also fails", func() {
+					runner.RunCommandErr = errors.New("fake-packaging-error")
+					sandbox.CleanUpErr = errors.New("fake-clean-up-err")
+
+					_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("fake-packaging-error"))
+				})
+
+				Context("when sandbox has a timeout", func() {
+					BeforeEach(func() {
+						sandbox.WrapTimeout = 10 * time.Millisecond
+						runner.RunCommandWaitsForCancel = true
+						runner.RunCommandErr = errors.New("fake-cancelled-err")
+					})
+
+					It("terminates packaging script once timeout is reached", func() {
+						_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
+						Expect(err).To(HaveOccurred())
+						Expect(err.Error()).To(ContainSubstring("Packaging script timed out after 10ms"))
+
+						codedErr, found := boshcodederr.FindCodedError(err)
+						Expect(found).To(BeTrue())
+						Expect(codedErr.Code).To(Equal(boshcodederr.ErrorCodeScriptFailed))
+
+						Expect(sandbox.CleanUpCallCount).To(Equal(1))
+					})
+
+					It("terminates packaging script once cancelled before timeout is reached", func() {
+						sandbox.WrapTimeout = time.Hour
+
+						go cancelSignal.Cancel()
+
+						_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
+						Expect(err).To(HaveOccurred())
+						Expect(err.Error()).ToNot(ContainSubstring("timed out"))
+					})
+				})
 			})
 
 			It("does not run packaging script when script does not exist", func() {
-				_, _, err := compiler.Compile(pkg, pkgDeps)
+				_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(runner.RunCommands).To(BeEmpty())
 			})
 
 			It("compresses compiled package", func() {
-				_, _, err := compiler.Compile(pkg, pkgDeps)
+				_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
 				Expect(err).ToNot(HaveOccurred())
 
 				// archive was downloaded from the blobstore and decompress to this temp dir
@@ -288,7 +457,7 @@ func init() {
 			It("uploads compressed package to blobstore", func() {
 				compressor.CompressFilesInDirTarballPath = "/tmp/compressed-compiled-package"
 
-				_, _, err := compiler.Compile(pkg, pkgDeps)
+				_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(blobstore.CreateFileNames[0]).To(Equal("/tmp/compressed-compiled-package"))
 			})
@@ -296,11 +465,91 @@ func init() {
 			It("returs error if uploading compressed package fails", func() {
 				blobstore.CreateErr = errors.New("fake-create-err")
 
-				_, _, err := compiler.Compile(pkg, pkgDeps)
+				_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("fake-create-err"))
 			})
 
+			It("classifies upload failure as blobstore unavailable", func() {
+				blobstore.CreateErr = errors.New("fake-create-err")
+
+				_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
+				codedErr, found := boshcodederr.FindCodedError(err)
+				Expect(found).To(BeTrue())
+				Expect(codedErr.Code).To(Equal(boshcodederr.ErrorCodeBlobstoreUnavailable))
+				Expect(codedErr.Retryable).To(BeTrue())
+			})
+
+			Context("when cancelled", func() {
+				It("stops before installing dependent packages", func() {
+					cancelSignal.Cancel()
+
+					_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(Equal("Task was cancelled"))
+					Expect(packageApplier.AppliedPackages).To(BeEmpty())
+					Expect(blobstore.GetBlobIDs).To(BeEmpty())
+				})
+
+				It("downloads package source with blobstore whose commands are terminated once cancelled", func() {
+					var builtRunners []boshsys.CmdRunner
+
+					blobstoreRunner := fakesys.NewFakeCmdRunner()
+					cancellableBlobstore, err := boshcancel.NewBlobstore(blobstoreRunner, func(runner boshsys.CmdRunner) (boshblob.Blobstore, error) {
+						builtRunners = append(builtRunners, runner)
+						return callbackBlobstore{blobstore, func() { cancelSignal.Cancel() }}, nil
+					})
+					Expect(err).ToNot(HaveOccurred())
+
+					blobstore.GetFileName = "/tmp/fake-package-source"
+					blobstore.GetError = errors.New("fake-get-err")
+
+					compiler = NewConcreteCompiler(
+						compressor,
+						cancellableBlobstore,
+						fs,
+						runner,
+						FakeCompileDirProvider{Dir: "/fake-compile-dir"},
+						packageApplier,
+						packagesBc,
+						sandbox,
+						Options{},
+					)
+
+					_, _, err = compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
+					Expect(err).To(HaveOccurred())
+
+					codedErr, found := boshcodederr.FindCodedError(err)
+					Expect(found).To(BeTrue())
+					Expect(codedErr.Code).To(Equal(boshcodederr.ErrorCodeCancelled))
+
+					Expect(builtRunners).To(HaveLen(2))
+					Expect(builtRunners[1]).To(Equal(boshcancel.NewCmdRunner(blobstoreRunner, cancelSignal.Done())))
+					Expect(compressor.DecompressFileToDirTarballPaths).To(BeEmpty())
+				})
+
+				It("does not compress or upload compiled package and cleans up compile directory and bundle", func() {
+					compressor.DecompressFileToDirCallBack = func() { cancelSignal.Cancel() }
+
+					_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
+					Expect(err).To(HaveOccurred())
+
+					codedErr, found := boshcodederr.FindCodedError(err)
+					Expect(found).To(BeTrue())
+					Expect(codedErr.Code).To(Equal(boshcodederr.ErrorCodeCancelled))
+
+					Expect(compressor.CompressFilesInDirDir).To(BeEmpty())
+					Expect(blobstore.CreateFileNames).To(BeEmpty())
+					Expect(fs.FileExists("/fake-compile-dir/pkg_name")).To(BeFalse())
+					Expect(bundle.ActionsCalled).To(Equal([]string{
+						"InstallWithoutContents",
+						"Enable",
+						"Disable",
+						"Uninstall",
+					}))
+				})
+			})
+
 			It("cleans up compressed package after uploading it to blobstore", func() {
 				var beforeCleanUpTarballPath, afterCleanUpTarballPath string
 
@@ -308,7 +557,7 @@ func init() {
 					beforeCleanUpTarballPath = compressor.CleanUpTarballPath
 				}
 
-				_, _, err := compiler.Compile(pkg, pkgDeps)
+				_, _, err := compiler.Compile(pkg, pkgDeps, progress, cancelSignal)
 				Expect(err).ToNot(HaveOccurred())
 
 				// Compressed package is not cleaned up before blobstore upload
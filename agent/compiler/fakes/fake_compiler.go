@@ -3,14 +3,19 @@ package fakes
 import (
 	boshmodels "github.com/cloudfoundry/bosh-agent/agent/applier/models"
 	boshcomp "github.com/cloudfoundry/bosh-agent/agent/compiler"
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
 )
 
 type FakeCompiler struct {
-	CompilePkg    boshcomp.Package
-	CompileDeps   []boshmodels.Package
-	CompileBlobID string
-	CompileSha1   string
-	CompileErr    error
+	CompilePkg      boshcomp.Package
+	CompileDeps     []boshmodels.Package
+	CompileProgress boshtask.ProgressReporter
+	CompileSignal   *boshtask.CancelSignal
+	CompileBlobID   string
+	CompileSha1     string
+	CompileErr      error
+
+	CompileCallCount int
 }
 
 func NewFakeCompiler() (c *FakeCompiler) {
@@ -18,9 +23,12 @@ func NewFakeCompiler() (c *FakeCompiler) {
 	return
 }
 
-func (c *FakeCompiler) Compile(pkg boshcomp.Package, deps []boshmodels.Package) (blobID, sha1 string, err error) {
+func (c *FakeCompiler) Compile(pkg boshcomp.Package, deps []boshmodels.Package, progress boshtask.ProgressReporter, cancelSignal *boshtask.CancelSignal) (blobID, sha1 string, err error) {
+	c.CompileCallCount++
 	c.CompilePkg = pkg
 	c.CompileDeps = deps
+	c.CompileProgress = progress
+	c.CompileSignal = cancelSignal
 	blobID = c.CompileBlobID
 	sha1 = c.CompileSha1
 	err = c.CompileErr
@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"encoding/json"
+	"sync"
+
+	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
+)
+
+const (
+	// Number of most recent request ids for which responses are remembered
+	maxDispatchedRequests = 1000
+
+	// Total size of remembered responses; e.g. fetch_logs or get_state
+	// responses may be large hence count alone does not bound memory
+	maxDispatchedResponsesSize = 8 * 1024 * 1024
+)
+
+// dispatchedRequests remembers responses of recently dispatched requests
+// so that a retried request with the same id is not performed twice
+type dispatchedRequests struct {
+	maxSize      int
+	maxTotalSize int
+
+	// Request keys in order of dispatch, oldest first
+	keys      []dispatchedRequestKey
+	requests  map[dispatchedRequestKey]*dispatchedRequest
+	totalSize int
+	lock      sync.Mutex
+}
+
+// Request ids are chosen by senders hence requests
+// from different sources may use the same id
+type dispatchedRequestKey struct {
+	source boshhandler.RequestSource
+	id     string
+}
+
+type dispatchedRequest struct {
+	key      dispatchedRequestKey
+	method   string
+	response boshhandler.Response
+	size     int
+	doneCh   chan struct{}
+}
+
+func newDispatchedRequests(maxSize, maxTotalSize int) *dispatchedRequests {
+	return &dispatchedRequests{
+		maxSize:      maxSize,
+		maxTotalSize: maxTotalSize,
+		requests:     make(map[dispatchedRequestKey]*dispatchedRequest),
+	}
+}
+
+// Add returns previously dispatched request with id of given request if there is one;
+// otherwise it records a new request that must be finished once it is dispatched
+func (r *dispatchedRequests) Add(req boshhandler.Request) (*dispatchedRequest, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	key := dispatchedRequestKey{source: req.Source, id: req.RequestID}
+
+	if request, found := r.requests[key]; found {
+		return request, false
+	}
+
+	request := &dispatchedRequest{
+		key:    key,
+		method: req.Method,
+		doneCh: make(chan struct{}),
+	}
+
+	r.requests[key] = request
+	r.keys = append(r.keys, key)
+
+	r.evict()
+
+	return request, true
+}
+
+// Finish records response of the request; oldest responses are
+// forgotten once remembered responses take up too much space
+func (r *dispatchedRequests) Finish(request *dispatchedRequest, response boshhandler.Response) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	request.response = response
+
+	// Request may have been forgotten while it was dispatched
+	if r.requests[request.key] == request {
+		request.size = responseSize(response)
+		r.totalSize += request.size
+		r.evict()
+	}
+
+	close(request.doneCh)
+}
+
+// evict must be called with the lock held
+func (r *dispatchedRequests) evict() {
+	for len(r.keys) > 0 && (len(r.keys) > r.maxSize || r.totalSize > r.maxTotalSize) {
+		oldest := r.requests[r.keys[0]]
+		r.totalSize -= oldest.size
+
+		delete(r.requests, r.keys[0])
+		r.keys = r.keys[1:]
+	}
+}
+
+// Response waits until request is finished and returns its response
+func (r *dispatchedRequest) Response() boshhandler.Response {
+	<-r.doneCh
+	return r.response
+}
+
+func responseSize(response boshhandler.Response) int {
+	bytes, err := json.Marshal(response)
+	if err != nil {
+		return 0
+	}
+	return len(bytes)
+}
@@ -1,6 +1,7 @@
 package agent
 
 import (
+	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
 	boshvitals "github.com/cloudfoundry/bosh-agent/platform/vitals"
 )
 
@@ -11,6 +12,9 @@ type Heartbeat struct {
 	JobState   string            `json:"job_state"`
 	Vitals     boshvitals.Vitals `json:"vitals"`
 	NodeID     string            `json:"node_id"`
+
+	// Included when message bus handler queues outbound messages
+	MbusQueue *boshhandler.QueueStats `json:"mbus_queue,omitempty"`
 }
 
 //Heartbeat payload example:
@@ -55,9 +55,15 @@ var _ = Describe("ConcreteJobScriptProvider", func() {
 
 	Describe("NewParallelScript", func() {
 		It("returns parallel script", func() {
-			scripts := []boshscript.Script{&fakescript.FakeScript{}}
-			script := scriptProvider.NewParallelScript("foo", scripts)
-			Expect(script).To(Equal(boshscript.NewParallelScript("foo", scripts, logger)))
+			fakeScript := &fakescript.FakeScript{}
+			fakeScript.ExistsReturns(true)
+
+			script := scriptProvider.NewParallelScript("foo", []boshscript.Script{fakeScript})
+			Expect(script).To(BeAssignableToTypeOf(boshscript.ParallelScript{}))
+
+			err := script.Run()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeScript.RunCallCount()).To(Equal(1))
 		})
 	})
 })
@@ -13,6 +13,7 @@ type FakeScript struct {
 	DidRun       bool
 	RunError     error
 	RunStub      func() error
+	CancelStub   func()
 	WasCanceled  bool
 }
 
@@ -26,6 +27,9 @@ func (s *FakeScript) Exists() bool { return s.ExistsBool }
 
 func (s *FakeScript) Cancel() error {
 	s.WasCanceled = true
+	if s.CancelStub != nil {
+		s.CancelStub()
+	}
 	return nil
 }
 
@@ -3,7 +3,10 @@ package script
 import (
 	"os"
 	"path/filepath"
+	"time"
 
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 	boshsys "github.com/cloudfoundry/bosh-utils/system"
 )
 
@@ -22,6 +25,8 @@ type GenericScript struct {
 
 	stdoutLogPath string
 	stderrLogPath string
+
+	cancelCh chan struct{}
 }
 
 func NewScript(
@@ -43,6 +48,8 @@ func NewScript(
 
 		stdoutLogPath: stdoutLogPath,
 		stderrLogPath: stderrLogPath,
+
+		cancelCh: make(chan struct{}, 1),
 	}
 }
 
@@ -84,9 +91,49 @@ func (s GenericScript) Run() error {
 	command.Stdout = stdoutFile
 	command.Stderr = stderrFile
 
-	_, _, _, err = s.runner.RunComplexCommand(command)
+	process, err := s.runner.RunComplexCommandAsync(command)
+	if err != nil {
+		return err
+	}
+
+	var result boshsys.Result
+
+	isCanceled := false
+
+	// Can only wait once on a process but cancelling can happen multiple times
+	for processExitedCh := process.Wait(); processExitedCh != nil; {
+		select {
+		case result = <-processExitedCh:
+			processExitedCh = nil
+		case <-s.cancelCh:
+			// Process is killed once grace period is over hence it always exits
+			_ = process.TerminateNicely(10 * time.Second)
+			isCanceled = true
+		}
+	}
+
+	if isCanceled {
+		err := bosherr.Errorf("Script %s was cancelled by user request", s.tag)
+		return boshcodederr.NewCodedError(boshcodederr.ErrorCodeCancelled, err)
+	}
+
+	// Exit status is -1 when script could not be run at all
+	if result.Error != nil && result.ExitStatus != -1 {
+		return boshcodederr.NewCodedError(boshcodederr.ErrorCodeScriptFailed, result.Error).WithDetails(map[string]interface{}{
+			"script":      s.tag,
+			"exit_status": result.ExitStatus,
+		})
+	}
+
+	return result.Error
+}
 
-	return err
+func (s GenericScript) Cancel() error {
+	select {
+	case s.cancelCh <- struct{}{}:
+	default:
+	}
+	return nil
 }
 
 func (s GenericScript) ensureContainingDir(fullLogFilename string) error {
@@ -3,11 +3,14 @@ package script_test
 import (
 	"errors"
 	"path/filepath"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
 	boshscript "github.com/cloudfoundry/bosh-agent/agent/script"
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
+	boshsys "github.com/cloudfoundry/bosh-utils/system"
 	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
 )
 
@@ -60,9 +63,28 @@ var _ = Describe("GenericScript", func() {
 	})
 
 	Describe("Run", func() {
+		var (
+			process *fakesys.FakeProcess
+		)
+
+		BeforeEach(func() {
+			process = &fakesys.FakeProcess{}
+			cmdRunner.AddProcess("/path-to-script", process)
+		})
+
+		// Output of the process is written by the command itself
+		writeOutput := func(stdout, stderr string) {
+			cmdRunner.SetCmdCallback("/path-to-script", func() {
+				cmd := cmdRunner.RunComplexCommands[len(cmdRunner.RunComplexCommands)-1]
+				cmd.Stdout.Write([]byte(stdout))
+				cmd.Stderr.Write([]byte(stderr))
+			})
+		}
+
 		It("executes given command", func() {
 			err := genericScript.Run()
 			Expect(err).ToNot(HaveOccurred())
+			Expect(cmdRunner.RunComplexCommands).To(HaveLen(1))
 		})
 
 		It("returns an error if it fails to create logs directory", func() {
@@ -83,12 +105,8 @@ var _ = Describe("GenericScript", func() {
 
 		Context("when command succeeds", func() {
 			BeforeEach(func() {
-				cmdRunner.AddCmdResult("/path-to-script", fakesys.FakeCmdResult{
-					Stdout:     "fake-stdout",
-					Stderr:     "fake-stderr",
-					ExitStatus: 0,
-					Error:      nil,
-				})
+				writeOutput("fake-stdout", "fake-stderr")
+				process.WaitResult = boshsys.Result{ExitStatus: 0}
 			})
 
 			It("saves stdout/stderr to log file", func() {
@@ -110,12 +128,8 @@ var _ = Describe("GenericScript", func() {
 
 		Context("when command fails", func() {
 			BeforeEach(func() {
-				cmdRunner.AddCmdResult("/path-to-script", fakesys.FakeCmdResult{
-					Stdout:     "fake-stdout",
-					Stderr:     "fake-stderr",
-					ExitStatus: 1,
-					Error:      errors.New("fake-command-error"),
-				})
+				writeOutput("fake-stdout", "fake-stderr")
+				process.WaitResult = boshsys.Result{ExitStatus: 1, Error: errors.New("fake-command-error")}
 			})
 
 			It("saves stdout/stderr to log file", func() {
@@ -134,6 +148,43 @@ var _ = Describe("GenericScript", func() {
 				Expect(err).ToNot(HaveOccurred())
 				Expect(stderr).To(Equal("fake-stderr"))
 			})
+
+			It("classifies error as script failure with exit status", func() {
+				err := genericScript.Run()
+				Expect(err).To(HaveOccurred())
+
+				codedErr, found := boshcodederr.FindCodedError(err)
+				Expect(found).To(BeTrue())
+				Expect(codedErr.Code).To(Equal(boshcodederr.ErrorCodeScriptFailed))
+				Expect(codedErr.Details).To(Equal(map[string]interface{}{
+					"script":      "my-tag",
+					"exit_status": 1,
+				}))
+			})
+		})
+
+		Context("when cancelled", func() {
+			BeforeEach(func() {
+				process.TerminatedNicelyCallBack = func(p *fakesys.FakeProcess) {
+					p.WaitCh <- boshsys.Result{ExitStatus: 143, Error: errors.New("fake-terminated-error")}
+				}
+			})
+
+			It("terminates the script and returns cancelled error", func() {
+				err := genericScript.Cancel()
+				Expect(err).ToNot(HaveOccurred())
+
+				err = genericScript.Run()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("Script my-tag was cancelled by user request"))
+
+				codedErr, found := boshcodederr.FindCodedError(err)
+				Expect(found).To(BeTrue())
+				Expect(codedErr.Code).To(Equal(boshcodederr.ErrorCodeCancelled))
+
+				Expect(process.TerminatedNicely).To(BeTrue())
+				Expect(process.TerminateNicelyKillGracePeriod).To(Equal(10 * time.Second))
+			})
 		})
 	})
 })
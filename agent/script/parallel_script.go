@@ -3,6 +3,7 @@ package script
 import (
 	"strings"
 
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
 )
@@ -13,6 +14,8 @@ type ParallelScript struct {
 
 	logTag string
 	logger boshlog.Logger
+
+	cancelCh chan struct{}
 }
 
 type scriptResult struct {
@@ -27,6 +30,8 @@ func NewParallelScript(name string, scripts []Script, logger boshlog.Logger) Par
 
 		logTag: "ParallelScript",
 		logger: logger,
+
+		cancelCh: make(chan struct{}, 1),
 	}
 }
 
@@ -48,6 +53,8 @@ func (s ParallelScript) Run() error {
 
 	var failedScripts, passedScripts []string
 
+	exitStatuses := map[string]interface{}{}
+
 	for i := 0; i < len(existingScripts); i++ {
 		select {
 		case r := <-resultsChan:
@@ -58,27 +65,54 @@ func (s ParallelScript) Run() error {
 				s.logger.Info(s.logTag, "'%s' script has successfully executed", r.Script.Path())
 			} else {
 				failedScripts = append(failedScripts, jobName)
+
+				if codedErr, found := boshcodederr.FindCodedError(r.Error); found && codedErr.Details["exit_status"] != nil {
+					exitStatuses[jobName] = codedErr.Details["exit_status"]
+				}
+
 				s.logger.Error(s.logTag, "'%s' script has failed with error: %s", r.Script.Path(), r.Error)
 			}
 		}
 	}
 
-	return s.summarizeErrs(passedScripts, failedScripts)
+	// Scripts that were terminated are reported as cancelled rather than failed
+	select {
+	case <-s.cancelCh:
+		err := bosherr.Errorf("%s scripts were cancelled by user request", s.name)
+		return boshcodederr.NewCodedError(boshcodederr.ErrorCodeCancelled, err)
+	default:
+	}
+
+	return s.summarizeErrs(passedScripts, failedScripts, exitStatuses)
 }
 
 func (s ParallelScript) Cancel() error {
 	s.logger.Debug(s.logTag, "Canceling a parallel script")
 	existingScripts := s.findExistingScripts(s.allScripts)
+
+	var cancellableScripts []CancellableScript
 	for _, script := range existingScripts {
 		if script, ok := script.(CancellableScript); ok {
-			err := script.Cancel()
-			if err != nil {
-				bosherr.WrapErrorf(err, "'%s' script did not cancel", s.name)
-			}
+			cancellableScripts = append(cancellableScripts, script)
 		} else {
 			return bosherr.Errorf("Script %s is not cancellable", s.name)
 		}
 	}
+
+	// Signal before terminating scripts so that Run sees cancellation
+	// once terminated scripts return instead of reporting them as failed
+	select {
+	case s.cancelCh <- struct{}{}:
+	default:
+	}
+
+	for _, script := range cancellableScripts {
+		err := script.Cancel()
+		if err != nil {
+			return bosherr.WrapErrorf(err, "'%s' script did not cancel", s.name)
+		}
+	}
+
 	return nil
 }
 
@@ -97,7 +131,7 @@ func (s ParallelScript) findExistingScripts(all []Script) []Script {
 	return existing
 }
 
-func (s ParallelScript) summarizeErrs(passedScripts, failedScripts []string) error {
+func (s ParallelScript) summarizeErrs(passedScripts, failedScripts []string, exitStatuses map[string]interface{}) error {
 	if len(failedScripts) > 0 {
 		errMsg := "Failed Jobs: " + strings.Join(failedScripts, ", ")
 
@@ -107,7 +141,12 @@ func (s ParallelScript) summarizeErrs(passedScripts, failedScripts []string) err
 
 		totalRan := len(passedScripts) + len(failedScripts)
 
-		return bosherr.Errorf("%d of %d %s scripts failed. %s.", len(failedScripts), totalRan, s.name, errMsg)
+		err := bosherr.Errorf("%d of %d %s scripts failed. %s.", len(failedScripts), totalRan, s.name, errMsg)
+
+		return boshcodederr.NewCodedError(boshcodederr.ErrorCodeScriptFailed, err).WithDetails(map[string]interface{}{
+			"failed_jobs":   failedScripts,
+			"exit_statuses": exitStatuses,
+		})
 	}
 
 	return nil
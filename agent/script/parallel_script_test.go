@@ -11,6 +11,7 @@ import (
 	boshscript "github.com/cloudfoundry/bosh-agent/agent/script"
 	fakedrainscript "github.com/cloudfoundry/bosh-agent/agent/script/drain/fakes"
 	fakescript "github.com/cloudfoundry/bosh-agent/agent/script/fakes"
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
 )
 
@@ -89,6 +90,22 @@ var _ = Describe("ParallelScript", func() {
 
 				Expect(existingScript.RunCallCount()).To(Equal(1))
 			})
+
+			It("classifies error as script failure with exit statuses of failed scripts", func() {
+				scriptErr := boshcodederr.NewCodedError(boshcodederr.ErrorCodeScriptFailed, errors.New("fake-error"))
+				existingScript.RunReturns(scriptErr.WithDetails(map[string]interface{}{"exit_status": 3}))
+
+				err := parallelScript.Run()
+				Expect(err).To(HaveOccurred())
+
+				codedErr, found := boshcodederr.FindCodedError(err)
+				Expect(found).To(BeTrue())
+				Expect(codedErr.Code).To(Equal(boshcodederr.ErrorCodeScriptFailed))
+				Expect(codedErr.Details).To(Equal(map[string]interface{}{
+					"failed_jobs":   []string{"fake-job-1"},
+					"exit_statuses": map[string]interface{}{"fake-job-1": 3},
+				}))
+			})
 		})
 
 		Context("when script does not exist", func() {
@@ -271,6 +288,31 @@ var _ = Describe("ParallelScript", func() {
 
 			})
 
+			It("reports scripts that were terminated when cancelled as cancelled", func() {
+				terminatedCh := make(chan struct{})
+
+				existingScript1.RunStub = func() error {
+					<-terminatedCh
+					return errors.New("fake-terminated-error")
+				}
+				existingScript1.CancelStub = func() { close(terminatedCh) }
+
+				go func() {
+					defer GinkgoRecover()
+
+					err := parallelScript.Cancel()
+					Expect(err).ToNot(HaveOccurred())
+				}()
+
+				err := parallelScript.Run()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("run-me scripts were cancelled by user request"))
+
+				codedErr, found := boshcodederr.FindCodedError(err)
+				Expect(found).To(BeTrue())
+				Expect(codedErr.Code).To(Equal(boshcodederr.ErrorCodeCancelled))
+			})
+
 		})
 
 	})
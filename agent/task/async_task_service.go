@@ -1,38 +1,70 @@
 package task
 
 import (
+	"sort"
+
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
 	boshuuid "github.com/cloudfoundry/bosh-utils/uuid"
+	"github.com/pivotal-golang/clock"
 )
 
-// Access to the currentTasks map should always be performed in the semaphore
+// Access to the currentTasks, finishedTaskIDs, queuedTasks and runningTasks
+// should always be performed in the semaphore
 // Use the taskSem channel for that
 
+// Maximum number of progress events kept per task; older events are dropped
+const maxTaskEvents = 100
+
 type asyncTaskService struct {
-	uuidGen boshuuid.Generator
-	logger  boshlog.Logger
+	uuidGen     boshuuid.Generator
+	timeService clock.Clock
+	logger      boshlog.Logger
+
+	concurrencyLimits ConcurrencyLimits
+	retentionPolicy   RetentionPolicy
 
 	currentTasks map[string]Task
-	taskChan     chan Task
+	queuedTasks  map[ConcurrencyClass][]Task
+	runningTasks map[ConcurrencyClass]int
 	taskSem      chan func()
+
+	// Finished task ids in order of completion, oldest first
+	finishedTaskIDs []string
 }
 
-func NewAsyncTaskService(uuidGen boshuuid.Generator, logger boshlog.Logger) (service Service) {
-	s := asyncTaskService{
-		uuidGen:      uuidGen,
-		logger:       logger,
-		currentTasks: make(map[string]Task),
-		taskChan:     make(chan Task),
-		taskSem:      make(chan func()),
+// NewAsyncTaskService returns a service that runs each task in the pool of
+// its concurrency class. Each pool runs at most as many tasks at once as its
+// limit allows; tasks without a known class run in the exclusive pool.
+// Exclusive tasks run one at a time once tasks of every pool finished and
+// no other task starts while an exclusive task is queued or running.
+// Finished tasks are evicted according to the retention policy.
+func NewAsyncTaskService(
+	uuidGen boshuuid.Generator,
+	concurrencyLimits ConcurrencyLimits,
+	retentionPolicy RetentionPolicy,
+	timeService clock.Clock,
+	logger boshlog.Logger,
+) (service Service) {
+	s := &asyncTaskService{
+		uuidGen:           uuidGen,
+		timeService:       timeService,
+		logger:            logger,
+		concurrencyLimits: concurrencyLimits,
+		retentionPolicy:   retentionPolicy,
+		currentTasks:      make(map[string]Task),
+		queuedTasks:       make(map[ConcurrencyClass][]Task),
+		runningTasks:      make(map[ConcurrencyClass]int),
+		taskSem:           make(chan func()),
 	}
 
-	go s.processTasks()
 	go s.processSemFuncs()
 
 	return s
 }
 
-func (service asyncTaskService) CreateTask(
+func (service *asyncTaskService) CreateTask(
 	taskFunc Func,
 	cancelFunc CancelFunc,
 	endFunc EndFunc,
@@ -45,7 +77,7 @@ func (service asyncTaskService) CreateTask(
 	return service.CreateTaskWithID(uuid, taskFunc, cancelFunc, endFunc), nil
 }
 
-func (service asyncTaskService) CreateTaskWithID(
+func (service *asyncTaskService) CreateTaskWithID(
 	id string,
 	taskFunc Func,
 	cancelFunc CancelFunc,
@@ -60,23 +92,78 @@ func (service asyncTaskService) CreateTaskWithID(
 	}
 }
 
-func (service asyncTaskService) StartTask(task Task) {
-	taskChan := make(chan Task)
+func (service *asyncTaskService) StartTask(task Task) {
+	task.ConcurrencyClass = service.concurrencyClass(task)
+
+	recordedCh := make(chan struct{})
 
 	service.taskSem <- func() {
 		service.currentTasks[task.ID] = task
-		taskChan <- task
+		service.queuedTasks[task.ConcurrencyClass] = append(service.queuedTasks[task.ConcurrencyClass], task)
+		service.runQueuedTasks()
+		close(recordedCh)
+	}
+
+	<-recordedCh
+}
+
+func (service *asyncTaskService) RecordFinishedTask(task Task) {
+	recordedCh := make(chan struct{})
+
+	service.taskSem <- func() {
+		service.currentTasks[task.ID] = task
+
+		// Keep finished task ids ordered by completion time
+		i := len(service.finishedTaskIDs)
+		for i > 0 && service.currentTasks[service.finishedTaskIDs[i-1]].FinishedAt.After(task.FinishedAt) {
+			i--
+		}
+		service.finishedTaskIDs = append(service.finishedTaskIDs, "")
+		copy(service.finishedTaskIDs[i+1:], service.finishedTaskIDs[i:])
+		service.finishedTaskIDs[i] = task.ID
+
+		service.evictFinishedTasks()
+		close(recordedCh)
 	}
 
-	recordedTask := <-taskChan
-	service.taskChan <- recordedTask
+	<-recordedCh
 }
 
-func (service asyncTaskService) FindTaskWithID(id string) (Task, bool) {
+func (service *asyncTaskService) RecordProgress(id string, progress Progress) {
+	progress.Time = service.timeService.Now()
+
+	recordedCh := make(chan struct{})
+
+	service.taskSem <- func() {
+		defer close(recordedCh)
+
+		task, found := service.currentTasks[id]
+		if !found || task.State != StateRunning {
+			return
+		}
+
+		progress.Sequence = 1
+		if latest := task.LatestProgress(); latest != nil {
+			progress.Sequence = latest.Sequence + 1
+		}
+
+		task.Events = append(task.Events, progress)
+		if len(task.Events) > maxTaskEvents {
+			task.Events = task.Events[len(task.Events)-maxTaskEvents:]
+		}
+
+		service.currentTasks[id] = task
+	}
+
+	<-recordedCh
+}
+
+func (service *asyncTaskService) FindTaskWithID(id string) (Task, bool) {
 	taskChan := make(chan Task)
 	foundChan := make(chan bool)
 
 	service.taskSem <- func() {
+		service.evictFinishedTasks()
 		task, found := service.currentTasks[id]
 		taskChan <- task
 		foundChan <- found
@@ -85,7 +172,65 @@ func (service asyncTaskService) FindTaskWithID(id string) (Task, bool) {
 	return <-taskChan, <-foundChan
 }
 
-func (service asyncTaskService) processSemFuncs() {
+func (service *asyncTaskService) CancelTask(task Task) error {
+	dequeuedCh := make(chan Task)
+	foundCh := make(chan bool)
+
+	service.taskSem <- func() {
+		queuedTask, found := service.dequeueTask(task.ID)
+		if found {
+			// Other tasks may have waited for cancelled exclusive task
+			service.runQueuedTasks()
+		}
+		dequeuedCh <- queuedTask
+		foundCh <- found
+	}
+
+	queuedTask, found := <-dequeuedCh, <-foundCh
+	if !found {
+		return task.Cancel()
+	}
+
+	// Task never started hence there is nothing to interrupt
+	queuedTask.Error = boshcodederr.NewCodedError(boshcodederr.ErrorCodeCancelled, bosherr.Error("Task was cancelled before it started"))
+	queuedTask.State = StateCancelled
+	queuedTask.FinishedAt = service.timeService.Now()
+	queuedTask.StartedAt = queuedTask.FinishedAt
+
+	if queuedTask.EndFunc != nil {
+		queuedTask.EndFunc(queuedTask)
+	}
+
+	service.taskSem <- func() {
+		service.currentTasks[queuedTask.ID] = queuedTask
+		service.finishedTaskIDs = append(service.finishedTaskIDs, queuedTask.ID)
+		service.evictFinishedTasks()
+	}
+
+	return nil
+}
+
+func (service *asyncTaskService) ListTasks() []Task {
+	tasksChan := make(chan []Task)
+
+	service.taskSem <- func() {
+		service.evictFinishedTasks()
+
+		tasks := []Task{}
+		for _, task := range service.currentTasks {
+			tasks = append(tasks, task)
+		}
+		tasksChan <- tasks
+	}
+
+	tasks := <-tasksChan
+
+	sort.Sort(tasksByStartedAt(tasks))
+
+	return tasks
+}
+
+func (service *asyncTaskService) processSemFuncs() {
 	defer service.logger.HandlePanic("Task Service Process Sem Funcs")
 
 	for {
@@ -94,28 +239,170 @@ func (service asyncTaskService) processSemFuncs() {
 	}
 }
 
-func (service asyncTaskService) processTasks() {
-	defer service.logger.HandlePanic("Task Service Process Tasks")
+func (service *asyncTaskService) concurrencyClass(task Task) ConcurrencyClass {
+	if _, found := service.concurrencyLimits[task.ConcurrencyClass]; found {
+		return task.ConcurrencyClass
+	}
+	return ConcurrencyExclusive
+}
 
-	for {
-		task := <-service.taskChan
-
-		value, err := task.Func()
-		if err != nil {
-			task.Error = err
-			task.State = StateFailed
-			service.logger.Error("Task Service", "Failed processing task #%s got: %s", task.ID, err.Error())
-		} else {
-			task.Value = value
-			task.State = StateDone
+// runQueuedTasks must be called in the semaphore
+func (service *asyncTaskService) runQueuedTasks() {
+	if len(service.queuedTasks[ConcurrencyExclusive]) > 0 {
+		// Tasks queued after exclusive task wait so that it is not starved
+		if service.runningTaskCount() == 0 {
+			service.runQueuedTask(ConcurrencyExclusive)
+		}
+		return
+	}
+
+	if service.runningTasks[ConcurrencyExclusive] > 0 {
+		return
+	}
+
+	for _, class := range service.sortedClasses() {
+		limit := service.concurrencyLimits[class]
+		if limit < 1 {
+			limit = 1
 		}
 
-		if task.EndFunc != nil {
-			task.EndFunc(task)
+		for service.runningTasks[class] < limit && len(service.queuedTasks[class]) > 0 {
+			service.runQueuedTask(class)
 		}
+	}
+}
+
+// runQueuedTask must be called in the semaphore
+func (service *asyncTaskService) runQueuedTask(class ConcurrencyClass) {
+	task := service.queuedTasks[class][0]
+	service.queuedTasks[class] = service.queuedTasks[class][1:]
+	service.runningTasks[class]++
+
+	task.StartedAt = service.timeService.Now()
+
+	// Keep progress events recorded while the task was queued
+	task.Events = service.currentTasks[task.ID].Events
+	service.currentTasks[task.ID] = task
+
+	go service.processTask(task)
+}
+
+// runningTaskCount must be called in the semaphore
+func (service *asyncTaskService) runningTaskCount() int {
+	var count int
+	for _, running := range service.runningTasks {
+		count += running
+	}
+	return count
+}
+
+func (service *asyncTaskService) sortedClasses() []ConcurrencyClass {
+	var classes []ConcurrencyClass
+	for class := range service.queuedTasks {
+		classes = append(classes, class)
+	}
+	sort.Sort(concurrencyClasses(classes))
+	return classes
+}
+
+// dequeueTask must be called in the semaphore
+func (service *asyncTaskService) dequeueTask(id string) (Task, bool) {
+	for class, queue := range service.queuedTasks {
+		for i, task := range queue {
+			if task.ID == id {
+				service.queuedTasks[class] = append(queue[:i:i], queue[i+1:]...)
+				return task, true
+			}
+		}
+	}
+	return Task{}, false
+}
+
+// evictFinishedTasks must be called in the semaphore
+func (service *asyncTaskService) evictFinishedTasks() {
+	policy := service.retentionPolicy
+	now := service.timeService.Now()
+
+	for len(service.finishedTaskIDs) > 0 {
+		oldestID := service.finishedTaskIDs[0]
+		oldest := service.currentTasks[oldestID]
+
+		tooMany := policy.MaxCount > 0 && len(service.finishedTaskIDs) > policy.MaxCount
+		tooOld := policy.MaxAge > 0 && now.Sub(oldest.FinishedAt) > policy.MaxAge
+
+		if !tooMany && !tooOld {
+			return
+		}
+
+		delete(service.currentTasks, oldestID)
+		service.finishedTaskIDs = service.finishedTaskIDs[1:]
+	}
+}
+
+func (service *asyncTaskService) processTask(task Task) {
+	defer service.logger.HandlePanic("Task Service Process Task")
+
+	value, err := task.Func()
+	if err != nil {
+		task.Error = err
+		task.State = StateFailed
 
-		service.taskSem <- func() {
-			service.currentTasks[task.ID] = task
+		if codedErr, found := boshcodederr.FindCodedError(err); found && codedErr.Code == boshcodederr.ErrorCodeCancelled {
+			task.State = StateCancelled
 		}
+
+		service.logger.Error("Task Service", "Failed processing task #%s got: %s", task.ID, err.Error())
+	} else {
+		task.Value = value
+		task.State = StateDone
 	}
+
+	task.FinishedAt = service.timeService.Now()
+
+	if task.EndFunc != nil {
+		task.EndFunc(task)
+	}
+
+	service.taskSem <- func() {
+		// Keep progress events recorded while the task was running
+		task.Events = service.currentTasks[task.ID].Events
+
+		service.currentTasks[task.ID] = task
+		service.finishedTaskIDs = append(service.finishedTaskIDs, task.ID)
+		service.runningTasks[task.ConcurrencyClass]--
+		service.runQueuedTasks()
+		service.evictFinishedTasks()
+	}
+}
+
+type tasksByStartedAt []Task
+
+func (tasks tasksByStartedAt) Len() int {
+	return len(tasks)
+}
+
+// Queued tasks that did not start yet come last
+func (tasks tasksByStartedAt) Less(i, j int) bool {
+	if tasks[i].StartedAt.IsZero() != tasks[j].StartedAt.IsZero() {
+		return tasks[j].StartedAt.IsZero()
+	}
+	return tasks[i].StartedAt.Before(tasks[j].StartedAt)
+}
+
+func (tasks tasksByStartedAt) Swap(i, j int) {
+	tasks[i], tasks[j] = tasks[j], tasks[i]
+}
+
+type concurrencyClasses []ConcurrencyClass
+
+func (classes concurrencyClasses) Len() int {
+	return len(classes)
+}
+
+func (classes concurrencyClasses) Less(i, j int) bool {
+	return classes[i] < classes[j]
+}
+
+func (classes concurrencyClasses) Swap(i, j int) {
+	classes[i], classes[j] = classes[j], classes[i]
 }
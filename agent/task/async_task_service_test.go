@@ -9,20 +9,26 @@ import (
 	. "github.com/onsi/gomega"
 
 	. "github.com/cloudfoundry/bosh-agent/agent/task"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
 	fakeuuid "github.com/cloudfoundry/bosh-utils/uuid/fakes"
+	"github.com/pivotal-golang/clock/fakeclock"
 )
 
 func init() {
 	Describe("asyncTaskService", func() {
 		var (
-			uuidGen *fakeuuid.FakeGenerator
-			service Service
+			uuidGen     *fakeuuid.FakeGenerator
+			timeService *fakeclock.FakeClock
+			logger      boshlog.Logger
+			service     Service
 		)
 
 		BeforeEach(func() {
 			uuidGen = &fakeuuid.FakeGenerator{}
-			service = NewAsyncTaskService(uuidGen, boshlog.NewLogger(boshlog.LevelNone))
+			timeService = fakeclock.NewFakeClock(time.Unix(1000, 0))
+			logger = boshlog.NewLogger(boshlog.LevelNone)
+			service = NewAsyncTaskService(uuidGen, DefaultConcurrencyLimits(), DefaultRetentionPolicy(), timeService, logger)
 		})
 
 		Describe("StartTask", func() {
@@ -60,6 +66,22 @@ func init() {
 				Expect(task.Error).To(Equal(err))
 			})
 
+			It("sets cancelled state on a task that failed because it was cancelled", func() {
+				cancelSignal := NewCancelSignal()
+				cancelSignal.Cancel()
+
+				runFunc := func() (interface{}, error) {
+					return nil, bosherr.WrapError(cancelSignal.Err(), "Compiling package")
+				}
+
+				task, createErr := service.CreateTask(runFunc, nil, nil)
+				Expect(createErr).ToNot(HaveOccurred())
+
+				task = startAndWaitForTaskCompletion(task)
+				Expect(task.State).To(BeEquivalentTo(StateCancelled))
+				Expect(task.Error.Error()).To(Equal("Compiling package: Task was cancelled"))
+			})
+
 			Describe("CreateTask", func() {
 				It("can run task created with CreateTask which does not have end func", func() {
 					ranFunc := false
@@ -156,6 +178,405 @@ func init() {
 			})
 		})
 
+		Describe("concurrency classes", func() {
+			blockingFunc := func(startedCh chan string, releaseCh chan struct{}, id string) Func {
+				return func() (interface{}, error) {
+					startedCh <- id
+					<-releaseCh
+					return nil, nil
+				}
+			}
+
+			It("runs tasks of different classes side by side", func() {
+				startedCh := make(chan string, 2)
+				releaseCh := make(chan struct{})
+				defer close(releaseCh)
+
+				compilationTask := service.CreateTaskWithID("fake-compilation-id", blockingFunc(startedCh, releaseCh, "fake-compilation-id"), nil, nil)
+				compilationTask.ConcurrencyClass = ConcurrencyCompilation
+				service.StartTask(compilationTask)
+				Eventually(startedCh).Should(Receive(Equal("fake-compilation-id")))
+
+				sharedTask := service.CreateTaskWithID("fake-shared-id", blockingFunc(startedCh, releaseCh, "fake-shared-id"), nil, nil)
+				sharedTask.ConcurrencyClass = ConcurrencyShared
+				service.StartTask(sharedTask)
+				Eventually(startedCh).Should(Receive(Equal("fake-shared-id")))
+			})
+
+			It("does not run more tasks of a class than its limit allows", func() {
+				service = NewAsyncTaskService(uuidGen, ConcurrencyLimits{ConcurrencyShared: 2}, DefaultRetentionPolicy(), timeService, logger)
+
+				startedCh := make(chan string, 3)
+				releaseCh := make(chan struct{})
+
+				for _, id := range []string{"fake-task-id-1", "fake-task-id-2", "fake-task-id-3"} {
+					task := service.CreateTaskWithID(id, blockingFunc(startedCh, releaseCh, id), nil, nil)
+					task.ConcurrencyClass = ConcurrencyShared
+					service.StartTask(task)
+				}
+
+				Eventually(startedCh).Should(Receive(Equal("fake-task-id-1")))
+				Eventually(startedCh).Should(Receive(Equal("fake-task-id-2")))
+				Consistently(startedCh).ShouldNot(Receive())
+
+				task, found := service.FindTaskWithID("fake-task-id-3")
+				Expect(found).To(BeTrue())
+				Expect(task.State).To(Equal(StateRunning))
+
+				releaseCh <- struct{}{}
+				Eventually(startedCh).Should(Receive(Equal("fake-task-id-3")))
+
+				close(releaseCh)
+			})
+
+			It("runs exclusive task once tasks of every pool finished", func() {
+				startedCh := make(chan string, 2)
+				sharedReleaseCh := make(chan struct{})
+				exclusiveReleaseCh := make(chan struct{})
+				defer close(exclusiveReleaseCh)
+
+				sharedTask := service.CreateTaskWithID("fake-shared-id", blockingFunc(startedCh, sharedReleaseCh, "fake-shared-id"), nil, nil)
+				sharedTask.ConcurrencyClass = ConcurrencyShared
+				service.StartTask(sharedTask)
+				Eventually(startedCh).Should(Receive(Equal("fake-shared-id")))
+
+				exclusiveTask := service.CreateTaskWithID("fake-exclusive-id", blockingFunc(startedCh, exclusiveReleaseCh, "fake-exclusive-id"), nil, nil)
+				exclusiveTask.ConcurrencyClass = ConcurrencyExclusive
+				service.StartTask(exclusiveTask)
+				Consistently(startedCh).ShouldNot(Receive())
+
+				close(sharedReleaseCh)
+				Eventually(startedCh).Should(Receive(Equal("fake-exclusive-id")))
+			})
+
+			It("does not start tasks of other pools while exclusive task is queued or running", func() {
+				startedCh := make(chan string, 3)
+				firstReleaseCh := make(chan struct{})
+				exclusiveReleaseCh := make(chan struct{})
+				secondReleaseCh := make(chan struct{})
+				defer close(secondReleaseCh)
+
+				firstTask := service.CreateTaskWithID("fake-shared-id-1", blockingFunc(startedCh, firstReleaseCh, "fake-shared-id-1"), nil, nil)
+				firstTask.ConcurrencyClass = ConcurrencyShared
+				service.StartTask(firstTask)
+				Eventually(startedCh).Should(Receive(Equal("fake-shared-id-1")))
+
+				exclusiveTask := service.CreateTaskWithID("fake-exclusive-id", blockingFunc(startedCh, exclusiveReleaseCh, "fake-exclusive-id"), nil, nil)
+				exclusiveTask.ConcurrencyClass = ConcurrencyExclusive
+				service.StartTask(exclusiveTask)
+
+				secondTask := service.CreateTaskWithID("fake-shared-id-2", blockingFunc(startedCh, secondReleaseCh, "fake-shared-id-2"), nil, nil)
+				secondTask.ConcurrencyClass = ConcurrencyShared
+				service.StartTask(secondTask)
+				Consistently(startedCh).ShouldNot(Receive())
+
+				close(firstReleaseCh)
+				Eventually(startedCh).Should(Receive(Equal("fake-exclusive-id")))
+				Consistently(startedCh).ShouldNot(Receive())
+
+				close(exclusiveReleaseCh)
+				Eventually(startedCh).Should(Receive(Equal("fake-shared-id-2")))
+			})
+
+			It("records start time once queued task starts running", func() {
+				startedCh := make(chan string, 2)
+				releaseCh := make(chan struct{})
+				defer close(releaseCh)
+
+				firstTask := service.CreateTaskWithID("fake-task-id-1", blockingFunc(startedCh, releaseCh, "fake-task-id-1"), nil, nil)
+				service.StartTask(firstTask)
+				Eventually(startedCh).Should(Receive(Equal("fake-task-id-1")))
+
+				secondTask := service.CreateTaskWithID("fake-task-id-2", blockingFunc(startedCh, releaseCh, "fake-task-id-2"), nil, nil)
+				service.StartTask(secondTask)
+
+				task, found := service.FindTaskWithID("fake-task-id-2")
+				Expect(found).To(BeTrue())
+				Expect(task.StartedAt.IsZero()).To(BeTrue())
+
+				timeService.Increment(time.Minute)
+				releaseCh <- struct{}{}
+				Eventually(startedCh).Should(Receive(Equal("fake-task-id-2")))
+
+				task, _ = service.FindTaskWithID("fake-task-id-2")
+				Expect(task.StartedAt).To(Equal(time.Unix(1060, 0)))
+			})
+
+			It("runs tasks without a known class in the exclusive pool", func() {
+				startedCh := make(chan string, 2)
+				releaseCh := make(chan struct{})
+
+				firstTask := service.CreateTaskWithID("fake-task-id-1", blockingFunc(startedCh, releaseCh, "fake-task-id-1"), nil, nil)
+				firstTask.ConcurrencyClass = ConcurrencyExclusive
+				service.StartTask(firstTask)
+
+				secondTask := service.CreateTaskWithID("fake-task-id-2", blockingFunc(startedCh, releaseCh, "fake-task-id-2"), nil, nil)
+				secondTask.ConcurrencyClass = "fake-unknown-class"
+				service.StartTask(secondTask)
+
+				Eventually(startedCh).Should(Receive(Equal("fake-task-id-1")))
+				Consistently(startedCh).ShouldNot(Receive())
+
+				releaseCh <- struct{}{}
+				Eventually(startedCh).Should(Receive(Equal("fake-task-id-2")))
+
+				close(releaseCh)
+			})
+		})
+
+		Describe("CancelTask", func() {
+			It("removes queued task from its queue and records it as cancelled", func() {
+				startedCh := make(chan string, 2)
+				releaseCh := make(chan struct{})
+
+				runningTask := service.CreateTaskWithID("fake-running-id", func() (interface{}, error) {
+					startedCh <- "fake-running-id"
+					<-releaseCh
+					return nil, nil
+				}, nil, nil)
+				service.StartTask(runningTask)
+				Eventually(startedCh).Should(Receive(Equal("fake-running-id")))
+
+				endedCh := make(chan Task, 1)
+				queuedTask := service.CreateTaskWithID("fake-queued-id", func() (interface{}, error) {
+					startedCh <- "fake-queued-id"
+					return nil, nil
+				}, func(_ Task) error {
+					return errors.New("fake-cancel-err")
+				}, func(task Task) { endedCh <- task })
+				service.StartTask(queuedTask)
+
+				err := service.CancelTask(queuedTask)
+				Expect(err).ToNot(HaveOccurred())
+
+				var endedTask Task
+				Eventually(endedCh).Should(Receive(&endedTask))
+				Expect(endedTask.State).To(Equal(StateCancelled))
+
+				task, found := service.FindTaskWithID("fake-queued-id")
+				Expect(found).To(BeTrue())
+				Expect(task.State).To(Equal(StateCancelled))
+				Expect(task.Error.Error()).To(Equal("Task was cancelled before it started"))
+
+				close(releaseCh)
+				Consistently(startedCh).ShouldNot(Receive())
+			})
+
+			It("asks running task to cancel", func() {
+				startedCh := make(chan struct{})
+				releaseCh := make(chan struct{})
+				defer close(releaseCh)
+
+				runningTask := service.CreateTaskWithID("fake-running-id", func() (interface{}, error) {
+					close(startedCh)
+					<-releaseCh
+					return nil, nil
+				}, func(_ Task) error {
+					return errors.New("fake-cancel-err")
+				}, nil)
+				service.StartTask(runningTask)
+				Eventually(startedCh).Should(BeClosed())
+
+				err := service.CancelTask(runningTask)
+				Expect(err).To(MatchError("fake-cancel-err"))
+
+				task, found := service.FindTaskWithID("fake-running-id")
+				Expect(found).To(BeTrue())
+				Expect(task.State).To(Equal(StateRunning))
+			})
+		})
+
+		Describe("retention of finished tasks", func() {
+			runAndWait := func(id string) {
+				task := service.CreateTaskWithID(id, func() (interface{}, error) { return nil, nil }, nil, nil)
+				task.ConcurrencyClass = ConcurrencyShared
+				service.StartTask(task)
+				Eventually(func() State {
+					task, _ := service.FindTaskWithID(id)
+					return task.State
+				}).Should(Equal(StateDone))
+			}
+
+			It("records start and finish times", func() {
+				runAndWait("fake-task-id")
+
+				task, found := service.FindTaskWithID("fake-task-id")
+				Expect(found).To(BeTrue())
+				Expect(task.StartedAt).To(Equal(time.Unix(1000, 0)))
+				Expect(task.FinishedAt).To(Equal(time.Unix(1000, 0)))
+			})
+
+			It("evicts finished tasks older than max age", func() {
+				service = NewAsyncTaskService(uuidGen, DefaultConcurrencyLimits(), RetentionPolicy{MaxAge: time.Minute}, timeService, logger)
+
+				runAndWait("fake-task-id-1")
+				timeService.Increment(30 * time.Second)
+				runAndWait("fake-task-id-2")
+				timeService.Increment(31 * time.Second)
+
+				_, found := service.FindTaskWithID("fake-task-id-1")
+				Expect(found).To(BeFalse())
+
+				_, found = service.FindTaskWithID("fake-task-id-2")
+				Expect(found).To(BeTrue())
+			})
+
+			It("evicts oldest finished tasks beyond max count", func() {
+				service = NewAsyncTaskService(uuidGen, DefaultConcurrencyLimits(), RetentionPolicy{MaxCount: 2}, timeService, logger)
+
+				runAndWait("fake-task-id-1")
+				runAndWait("fake-task-id-2")
+				runAndWait("fake-task-id-3")
+
+				_, found := service.FindTaskWithID("fake-task-id-1")
+				Expect(found).To(BeFalse())
+
+				Expect(service.ListTasks()).To(HaveLen(2))
+			})
+
+			It("does not evict running tasks", func() {
+				service = NewAsyncTaskService(uuidGen, DefaultConcurrencyLimits(), RetentionPolicy{MaxAge: time.Minute, MaxCount: 1}, timeService, logger)
+
+				releaseCh := make(chan struct{})
+				defer close(releaseCh)
+
+				task := service.CreateTaskWithID("fake-running-id", func() (interface{}, error) { <-releaseCh; return nil, nil }, nil, nil)
+				task.ConcurrencyClass = ConcurrencyShared
+				service.StartTask(task)
+
+				runAndWait("fake-task-id-1")
+				runAndWait("fake-task-id-2")
+				timeService.Increment(2 * time.Minute)
+
+				tasks := service.ListTasks()
+				Expect(tasks).To(HaveLen(1))
+				Expect(tasks[0].ID).To(Equal("fake-running-id"))
+			})
+		})
+
+		Describe("RecordFinishedTask", func() {
+			It("makes finished task findable without running it", func() {
+				service.RecordFinishedTask(Task{
+					ID:         "fake-task-id",
+					State:      StateDone,
+					Value:      "fake-value",
+					FinishedAt: timeService.Now(),
+				})
+
+				task, found := service.FindTaskWithID("fake-task-id")
+				Expect(found).To(BeTrue())
+				Expect(task.State).To(Equal(StateDone))
+				Expect(task.Value).To(Equal("fake-value"))
+			})
+
+			It("subjects recorded task to retention policy", func() {
+				service = NewAsyncTaskService(uuidGen, DefaultConcurrencyLimits(), RetentionPolicy{MaxAge: time.Minute}, timeService, logger)
+
+				service.RecordFinishedTask(Task{
+					ID:         "fake-task-id",
+					State:      StateDone,
+					FinishedAt: timeService.Now().Add(-2 * time.Minute),
+				})
+
+				_, found := service.FindTaskWithID("fake-task-id")
+				Expect(found).To(BeFalse())
+			})
+		})
+
+		Describe("RecordProgress", func() {
+			var releaseCh chan struct{}
+
+			BeforeEach(func() {
+				releaseCh = make(chan struct{})
+
+				// Task goroutine may outlive the test; next BeforeEach reassigns releaseCh
+				ch := releaseCh
+				task := service.CreateTaskWithID("fake-task-id", func() (interface{}, error) { <-ch; return nil, nil }, nil, nil)
+				service.StartTask(task)
+			})
+
+			It("records progress of running task with increasing sequence numbers", func() {
+				defer close(releaseCh)
+
+				service.RecordProgress("fake-task-id", Progress{Phase: "fake-phase-1"})
+				timeService.Increment(time.Second)
+				service.RecordProgress("fake-task-id", Progress{Phase: "fake-phase-2"})
+
+				task, _ := service.FindTaskWithID("fake-task-id")
+				Expect(task.Events).To(Equal([]Progress{
+					{Sequence: 1, Time: time.Unix(1000, 0), Phase: "fake-phase-1"},
+					{Sequence: 2, Time: time.Unix(1001, 0), Phase: "fake-phase-2"},
+				}))
+				Expect(task.LatestProgress().Phase).To(Equal("fake-phase-2"))
+			})
+
+			It("keeps only the most recent events", func() {
+				defer close(releaseCh)
+
+				for i := 0; i < 105; i++ {
+					service.RecordProgress("fake-task-id", Progress{Phase: "fake-phase"})
+				}
+
+				task, _ := service.FindTaskWithID("fake-task-id")
+				Expect(task.Events).To(HaveLen(100))
+				Expect(task.Events[0].Sequence).To(Equal(6))
+				Expect(task.LatestProgress().Sequence).To(Equal(105))
+			})
+
+			It("keeps recorded events after task finishes", func() {
+				service.RecordProgress("fake-task-id", Progress{Phase: "fake-phase"})
+				close(releaseCh)
+
+				Eventually(func() State {
+					task, _ := service.FindTaskWithID("fake-task-id")
+					return task.State
+				}).Should(Equal(StateDone))
+
+				task, _ := service.FindTaskWithID("fake-task-id")
+				Expect(task.Events).To(HaveLen(1))
+
+				service.RecordProgress("fake-task-id", Progress{Phase: "fake-late-phase"})
+
+				task, _ = service.FindTaskWithID("fake-task-id")
+				Expect(task.Events).To(HaveLen(1))
+			})
+
+			It("ignores progress of unknown tasks", func() {
+				defer close(releaseCh)
+
+				service.RecordProgress("fake-unknown-task-id", Progress{Phase: "fake-phase"})
+
+				_, found := service.FindTaskWithID("fake-unknown-task-id")
+				Expect(found).To(BeFalse())
+			})
+		})
+
+		Describe("ListTasks", func() {
+			It("returns recorded tasks ordered by start time", func() {
+				releaseCh := make(chan struct{})
+				defer close(releaseCh)
+
+				blockingFunc := func() (interface{}, error) { <-releaseCh; return nil, nil }
+
+				firstTask := service.CreateTaskWithID("fake-task-id-1", blockingFunc, nil, nil)
+				firstTask.Method = "fake-method-1"
+				service.StartTask(firstTask)
+
+				timeService.Increment(time.Second)
+
+				secondTask := service.CreateTaskWithID("fake-task-id-2", blockingFunc, nil, nil)
+				secondTask.Method = "fake-method-2"
+				service.StartTask(secondTask)
+
+				tasks := service.ListTasks()
+				Expect(tasks).To(HaveLen(2))
+				Expect(tasks[0].ID).To(Equal("fake-task-id-1"))
+				Expect(tasks[0].Method).To(Equal("fake-method-1"))
+				Expect(tasks[1].ID).To(Equal("fake-task-id-2"))
+				Expect(tasks[1].Method).To(Equal("fake-method-2"))
+			})
+		})
+
 		Describe("CreateTask", func() {
 			It("creates a task with auto-assigned id", func() {
 				uuidGen.GeneratedUUID = "fake-uuid"
@@ -0,0 +1,80 @@
+package task
+
+import (
+	"sync"
+
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
+	boshcancel "github.com/cloudfoundry/bosh-agent/platform/cancellable"
+	boshblob "github.com/cloudfoundry/bosh-utils/blobstore"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshcmd "github.com/cloudfoundry/bosh-utils/fileutil"
+)
+
+// CancelSignal is fired when a running task is cancelled.
+// Long running actions check it between steps and stop
+// processes they started once it is fired.
+type CancelSignal struct {
+	ch   chan struct{}
+	once sync.Once
+}
+
+func NewCancelSignal() *CancelSignal {
+	return &CancelSignal{ch: make(chan struct{})}
+}
+
+// Cancel fires the signal; it can be called multiple times
+func (s *CancelSignal) Cancel() {
+	s.once.Do(func() { close(s.ch) })
+}
+
+// Done returns channel that is closed once the signal is fired
+func (s *CancelSignal) Done() <-chan struct{} {
+	return s.ch
+}
+
+// Err returns error with cancelled code once the signal is fired and nil before.
+// Tasks failing with such error are reported in cancelled state.
+func (s *CancelSignal) Err() error {
+	select {
+	case <-s.ch:
+		return boshcodederr.NewCodedError(boshcodederr.ErrorCodeCancelled, bosherr.Error("Task was cancelled"))
+	default:
+		return nil
+	}
+}
+
+// ErrOr returns cancelled error once the signal is fired and given error otherwise.
+// Steps fail when their commands are terminated hence cancellation explains such failure.
+func (s *CancelSignal) ErrOr(err error) error {
+	if cancelErr := s.Err(); cancelErr != nil {
+		return cancelErr
+	}
+	return err
+}
+
+// Compressor returns compressor whose commands are terminated once the signal
+// is fired; compressors that cannot be interrupted are returned as they are
+func (s *CancelSignal) Compressor(compressor boshcmd.Compressor) boshcmd.Compressor {
+	if cancellableCompressor, ok := compressor.(boshcancel.Compressor); ok {
+		return cancellableCompressor.WithCancel(s.ch)
+	}
+	return compressor
+}
+
+// Copier returns copier whose commands are terminated once the signal
+// is fired; copiers that cannot be interrupted are returned as they are
+func (s *CancelSignal) Copier(copier boshcmd.Copier) boshcmd.Copier {
+	if cancellableCopier, ok := copier.(boshcancel.Copier); ok {
+		return cancellableCopier.WithCancel(s.ch)
+	}
+	return copier
+}
+
+// Blobstore returns blobstore whose commands are terminated once the signal
+// is fired; blobstores that cannot be interrupted are returned as they are
+func (s *CancelSignal) Blobstore(blobstore boshblob.Blobstore) (boshblob.Blobstore, error) {
+	if cancellableBlobstore, ok := blobstore.(boshcancel.Blobstore); ok {
+		return cancellableBlobstore.WithCancel(s.ch)
+	}
+	return blobstore, nil
+}
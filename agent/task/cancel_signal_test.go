@@ -0,0 +1,116 @@
+package task_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/agent/task"
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
+	boshcancel "github.com/cloudfoundry/bosh-agent/platform/cancellable"
+	boshblob "github.com/cloudfoundry/bosh-utils/blobstore"
+	fakeblob "github.com/cloudfoundry/bosh-utils/blobstore/fakes"
+	boshcmd "github.com/cloudfoundry/bosh-utils/fileutil"
+	fakecmd "github.com/cloudfoundry/bosh-utils/fileutil/fakes"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	boshsys "github.com/cloudfoundry/bosh-utils/system"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+)
+
+var _ = Describe("CancelSignal", func() {
+	var (
+		cancelSignal *CancelSignal
+	)
+
+	BeforeEach(func() {
+		cancelSignal = NewCancelSignal()
+	})
+
+	Describe("Err", func() {
+		It("returns nil before the signal is fired", func() {
+			Expect(cancelSignal.Err()).ToNot(HaveOccurred())
+		})
+
+		It("returns cancelled error once the signal is fired", func() {
+			cancelSignal.Cancel()
+			cancelSignal.Cancel()
+
+			err := cancelSignal.Err()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("Task was cancelled"))
+
+			codedErr, found := boshcodederr.FindCodedError(err)
+			Expect(found).To(BeTrue())
+			Expect(codedErr.Code).To(Equal(boshcodederr.ErrorCodeCancelled))
+			Expect(codedErr.Retryable).To(BeFalse())
+
+			Eventually(cancelSignal.Done()).Should(BeClosed())
+		})
+	})
+
+	Describe("ErrOr", func() {
+		It("returns given error before the signal is fired", func() {
+			err := cancelSignal.ErrOr(errors.New("fake-err"))
+			Expect(err).To(MatchError("fake-err"))
+		})
+
+		It("returns cancelled error once the signal is fired", func() {
+			cancelSignal.Cancel()
+
+			err := cancelSignal.ErrOr(errors.New("fake-err"))
+			Expect(err).To(Equal(cancelSignal.Err()))
+		})
+	})
+
+	Describe("Compressor", func() {
+		It("returns compressor whose commands are terminated once the signal is fired", func() {
+			runner := fakesys.NewFakeCmdRunner()
+			fs := fakesys.NewFakeFileSystem()
+
+			compressor := cancelSignal.Compressor(boshcancel.NewTarballCompressor(runner, fs))
+			Expect(compressor).To(Equal(boshcmd.NewTarballCompressor(boshcancel.NewCmdRunner(runner, cancelSignal.Done()), fs)))
+		})
+
+		It("returns compressor that cannot be interrupted as it is", func() {
+			compressor := fakecmd.NewFakeCompressor()
+			Expect(cancelSignal.Compressor(compressor)).To(Equal(compressor))
+		})
+	})
+
+	Describe("Copier", func() {
+		It("returns copier whose commands are terminated once the signal is fired", func() {
+			runner := fakesys.NewFakeCmdRunner()
+			fs := fakesys.NewFakeFileSystem()
+			logger := boshlog.NewLogger(boshlog.LevelNone)
+
+			copier := cancelSignal.Copier(boshcancel.NewCpCopier(runner, fs, logger))
+			Expect(copier).To(Equal(boshcmd.NewCpCopier(boshcancel.NewCmdRunner(runner, cancelSignal.Done()), fs, logger)))
+		})
+	})
+
+	Describe("Blobstore", func() {
+		It("returns blobstore whose commands are terminated once the signal is fired", func() {
+			runner := fakesys.NewFakeCmdRunner()
+
+			var builtRunner boshsys.CmdRunner
+			blobstore, err := boshcancel.NewBlobstore(runner, func(runner boshsys.CmdRunner) (boshblob.Blobstore, error) {
+				builtRunner = runner
+				return fakeblob.NewFakeBlobstore(), nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = cancelSignal.Blobstore(blobstore)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(builtRunner).To(Equal(boshcancel.NewCmdRunner(runner, cancelSignal.Done())))
+		})
+
+		It("returns blobstore that cannot be interrupted as it is", func() {
+			blobstore := fakeblob.NewFakeBlobstore()
+
+			cancellableBlobstore, err := cancelSignal.Blobstore(blobstore)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cancellableBlobstore).To(Equal(blobstore))
+		})
+	})
+})
@@ -3,10 +3,22 @@ package task
 import (
 	"encoding/json"
 	"path"
+	"sort"
 
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
 	boshsys "github.com/cloudfoundry/bosh-utils/system"
+	"github.com/pivotal-golang/clock"
+)
+
+const (
+	concreteManagerLogTag = "concreteTaskManager"
+
+	// Finished tasks are kept in memory for get_task; only most recent
+	// ones with values up to this size are persisted so that tasks json
+	// (rewritten whenever a task starts or finishes) stays within ~1MB
+	maxPersistedFinishedInfos = 64
+	maxPersistedValueLength   = 16 * 1024
 )
 
 type concreteManagerProvider struct{}
@@ -19,8 +31,10 @@ func (provider concreteManagerProvider) NewManager(
 	logger boshlog.Logger,
 	fs boshsys.FileSystem,
 	dir string,
+	retentionPolicy RetentionPolicy,
+	timeService clock.Clock,
 ) Manager {
-	return NewManager(logger, fs, path.Join(dir, "tasks.json"))
+	return NewManager(logger, fs, path.Join(dir, "tasks.json"), retentionPolicy, timeService)
 }
 
 type concreteManager struct {
@@ -30,17 +44,29 @@ type concreteManager struct {
 	fsSem     chan func()
 	tasksPath string
 
+	// Finished task infos are kept according to retentionPolicy
+	retentionPolicy RetentionPolicy
+	timeService     clock.Clock
+
 	// Access to taskInfos must be synchronized via fsSem
 	taskInfos map[string]Info
 }
 
-func NewManager(logger boshlog.Logger, fs boshsys.FileSystem, tasksPath string) Manager {
+func NewManager(
+	logger boshlog.Logger,
+	fs boshsys.FileSystem,
+	tasksPath string,
+	retentionPolicy RetentionPolicy,
+	timeService clock.Clock,
+) Manager {
 	m := &concreteManager{
-		logger:    logger,
-		fs:        fs,
-		fsSem:     make(chan func()),
-		tasksPath: tasksPath,
-		taskInfos: make(map[string]Info),
+		logger:          logger,
+		fs:              fs,
+		fsSem:           make(chan func()),
+		tasksPath:       tasksPath,
+		retentionPolicy: retentionPolicy,
+		timeService:     timeService,
+		taskInfos:       make(map[string]Info),
 	}
 
 	go m.processFsFuncs()
@@ -54,6 +80,9 @@ func (m *concreteManager) GetInfos() ([]Info, error) {
 
 	m.fsSem <- func() {
 		taskInfos, err := m.readInfos()
+		if err == nil {
+			m.evictFinishedInfos(taskInfos)
+		}
 		m.taskInfos = taskInfos
 		taskInfosChan <- taskInfos
 		errCh <- err
@@ -78,7 +107,8 @@ func (m *concreteManager) AddInfo(taskInfo Info) error {
 	errCh := make(chan error)
 
 	m.fsSem <- func() {
-		m.taskInfos[taskInfo.TaskID] = taskInfo
+		m.taskInfos[taskInfo.TaskID] = m.withPersistableValue(taskInfo)
+		m.evictFinishedInfos(m.taskInfos)
 		err := m.writeInfos(m.taskInfos)
 		errCh <- err
 	}
@@ -105,6 +135,66 @@ func (m *concreteManager) processFsFuncs() {
 	}
 }
 
+// withPersistableValue drops value of finished task that is too large
+// (e.g. fetched logs) and marks the info so that get_task reports it
+func (m *concreteManager) withPersistableValue(taskInfo Info) Info {
+	if !taskInfo.IsFinished() || taskInfo.Value == nil {
+		return taskInfo
+	}
+
+	valueJSON, err := json.Marshal(taskInfo.Value)
+	if err == nil && len(valueJSON) <= maxPersistedValueLength {
+		return taskInfo
+	}
+
+	if err != nil {
+		m.logger.Warn(concreteManagerLogTag, "Not persisting value of task %s that cannot be marshalled: %s", taskInfo.TaskID, err.Error())
+	} else {
+		m.logger.Warn(concreteManagerLogTag, "Not persisting value of task %s since it is %d bytes long", taskInfo.TaskID, len(valueJSON))
+	}
+
+	taskInfo.Value = nil
+	taskInfo.ValueDropped = true
+
+	return taskInfo
+}
+
+// evictFinishedInfos removes finished task infos that are too old
+// or exceed the maximum count (capped by maxPersistedFinishedInfos), oldest first
+func (m *concreteManager) evictFinishedInfos(taskInfos map[string]Info) {
+	now := m.timeService.Now()
+
+	var finishedInfos []Info
+
+	for taskID, taskInfo := range taskInfos {
+		if !taskInfo.IsFinished() {
+			continue
+		}
+
+		if m.retentionPolicy.MaxAge > 0 && now.Sub(taskInfo.FinishedAt) > m.retentionPolicy.MaxAge {
+			delete(taskInfos, taskID)
+			continue
+		}
+
+		finishedInfos = append(finishedInfos, taskInfo)
+	}
+
+	maxCount := maxPersistedFinishedInfos
+	if m.retentionPolicy.MaxCount > 0 && m.retentionPolicy.MaxCount < maxCount {
+		maxCount = m.retentionPolicy.MaxCount
+	}
+
+	if len(finishedInfos) <= maxCount {
+		return
+	}
+
+	sort.Sort(infosByFinishedAt(finishedInfos))
+
+	for _, taskInfo := range finishedInfos[:len(finishedInfos)-maxCount] {
+		delete(taskInfos, taskInfo.TaskID)
+	}
+}
+
 func (m *concreteManager) readInfos() (map[string]Info, error) {
 	taskInfos := make(map[string]Info)
 
@@ -139,3 +229,17 @@ func (m *concreteManager) writeInfos(taskInfos map[string]Info) error {
 
 	return nil
 }
+
+type infosByFinishedAt []Info
+
+func (infos infosByFinishedAt) Len() int {
+	return len(infos)
+}
+
+func (infos infosByFinishedAt) Less(i, j int) bool {
+	return infos[i].FinishedAt.Before(infos[j].FinishedAt)
+}
+
+func (infos infosByFinishedAt) Swap(i, j int) {
+	infos[i], infos[j] = infos[j], infos[i]
+}
@@ -3,6 +3,9 @@ package task_test
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -10,6 +13,7 @@ import (
 	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
 	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+	"github.com/pivotal-golang/clock/fakeclock"
 )
 
 func init() {
@@ -18,6 +22,8 @@ func init() {
 			It("returns manager with tasks.json as its tasks path", func() {
 				logger := boshlog.NewLogger(boshlog.LevelNone)
 				fs := fakesys.NewFakeFileSystem()
+				timeService := fakeclock.NewFakeClock(time.Unix(1000, 0))
+				retentionPolicy := boshtask.DefaultRetentionPolicy()
 
 				taskInfo := boshtask.Info{
 					TaskID:  "fake-task-id",
@@ -25,12 +31,12 @@ func init() {
 					Payload: []byte("fake-payload"),
 				}
 
-				manager := boshtask.NewManagerProvider().NewManager(logger, fs, "/dir/path")
+				manager := boshtask.NewManagerProvider().NewManager(logger, fs, "/dir/path", retentionPolicy, timeService)
 				err := manager.AddInfo(taskInfo)
 				Expect(err).ToNot(HaveOccurred())
 
 				// Check expected file location with another manager
-				otherManager := boshtask.NewManager(logger, fs, "/dir/path/tasks.json")
+				otherManager := boshtask.NewManager(logger, fs, "/dir/path/tasks.json", retentionPolicy, timeService)
 
 				taskInfos, err := otherManager.GetInfos()
 				Expect(err).ToNot(HaveOccurred())
@@ -41,15 +47,19 @@ func init() {
 
 	Describe("concreteManager", func() {
 		var (
-			logger  boshlog.Logger
-			fs      *fakesys.FakeFileSystem
-			manager boshtask.Manager
+			logger          boshlog.Logger
+			fs              *fakesys.FakeFileSystem
+			timeService     *fakeclock.FakeClock
+			retentionPolicy boshtask.RetentionPolicy
+			manager         boshtask.Manager
 		)
 
 		BeforeEach(func() {
 			logger = boshlog.NewLogger(boshlog.LevelNone)
 			fs = fakesys.NewFakeFileSystem()
-			manager = boshtask.NewManager(logger, fs, "/dir/path")
+			timeService = fakeclock.NewFakeClock(time.Unix(1000, 0))
+			retentionPolicy = boshtask.RetentionPolicy{MaxAge: time.Hour, MaxCount: 2}
+			manager = boshtask.NewManager(logger, fs, "/dir/path", retentionPolicy, timeService)
 		})
 
 		Describe("GetInfos", func() {
@@ -69,7 +79,7 @@ func init() {
 				Expect(err).ToNot(HaveOccurred())
 
 				// Make sure we are not getting cached copy of taskInfos
-				reloadedManager := boshtask.NewManager(logger, fs, "/dir/path")
+				reloadedManager := boshtask.NewManager(logger, fs, "/dir/path", retentionPolicy, timeService)
 
 				taskInfos, err := reloadedManager.GetInfos()
 				Expect(err).ToNot(HaveOccurred())
@@ -159,6 +169,131 @@ func init() {
 			})
 		})
 
+		Describe("finished task infos", func() {
+			finishedInfo := func(taskID string, finishedAt time.Time) boshtask.Info {
+				return boshtask.Info{
+					TaskID:     taskID,
+					Method:     "fake-method",
+					State:      boshtask.StateDone,
+					Value:      "fake-value",
+					StartedAt:  finishedAt.Add(-time.Minute),
+					FinishedAt: finishedAt,
+				}
+			}
+
+			It("keeps results of finished tasks across reloads", func() {
+				err := manager.AddInfo(boshtask.Info{
+					TaskID:     "fake-task-id",
+					Method:     "fake-method",
+					State:      boshtask.StateFailed,
+					Error:      "fake-error",
+					StartedAt:  time.Unix(900, 0).UTC(),
+					FinishedAt: time.Unix(950, 0).UTC(),
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				reloadedManager := boshtask.NewManager(logger, fs, "/dir/path", retentionPolicy, timeService)
+
+				taskInfos, err := reloadedManager.GetInfos()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(taskInfos).To(Equal([]boshtask.Info{
+					{
+						TaskID:     "fake-task-id",
+						Method:     "fake-method",
+						State:      boshtask.StateFailed,
+						Error:      "fake-error",
+						StartedAt:  time.Unix(900, 0).UTC(),
+						FinishedAt: time.Unix(950, 0).UTC(),
+					},
+				}))
+			})
+
+			It("drops values of finished tasks that are too large to be persisted", func() {
+				largeInfo := finishedInfo("fake-large-task-id", timeService.Now())
+				largeInfo.Value = strings.Repeat("x", 16*1024)
+
+				err := manager.AddInfo(largeInfo)
+				Expect(err).ToNot(HaveOccurred())
+
+				err = manager.AddInfo(finishedInfo("fake-small-task-id", timeService.Now()))
+				Expect(err).ToNot(HaveOccurred())
+
+				reloadedManager := boshtask.NewManager(logger, fs, "/dir/path", retentionPolicy, timeService)
+
+				taskInfos, err := reloadedManager.GetInfos()
+				Expect(err).ToNot(HaveOccurred())
+
+				valuesByTaskID := map[string]interface{}{}
+				droppedByTaskID := map[string]bool{}
+				for _, taskInfo := range taskInfos {
+					valuesByTaskID[taskInfo.TaskID] = taskInfo.Value
+					droppedByTaskID[taskInfo.TaskID] = taskInfo.ValueDropped
+				}
+
+				Expect(valuesByTaskID).To(Equal(map[string]interface{}{"fake-large-task-id": nil, "fake-small-task-id": "fake-value"}))
+				Expect(droppedByTaskID).To(Equal(map[string]bool{"fake-large-task-id": true, "fake-small-task-id": false}))
+
+				content, err := fs.ReadFileString("/dir/path")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(len(content)).To(BeNumerically("<", 1024))
+			})
+
+			It("evicts finished task infos older than max age", func() {
+				err := manager.AddInfo(finishedInfo("fake-old-task-id", timeService.Now().Add(-2*time.Hour)))
+				Expect(err).ToNot(HaveOccurred())
+
+				err = manager.AddInfo(finishedInfo("fake-new-task-id", timeService.Now()))
+				Expect(err).ToNot(HaveOccurred())
+
+				taskInfos, err := manager.GetInfos()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(taskInfos).To(HaveLen(1))
+				Expect(taskInfos[0].TaskID).To(Equal("fake-new-task-id"))
+			})
+
+			It("persists only most recent finished task infos even when retention policy keeps more", func() {
+				manager = boshtask.NewManager(logger, fs, "/dir/path", boshtask.DefaultRetentionPolicy(), timeService)
+
+				for i := 0; i < 100; i++ {
+					err := manager.AddInfo(finishedInfo(fmt.Sprintf("fake-task-id-%d", i), timeService.Now().Add(time.Duration(i)*time.Second)))
+					Expect(err).ToNot(HaveOccurred())
+				}
+
+				reloadedManager := boshtask.NewManager(logger, fs, "/dir/path", boshtask.DefaultRetentionPolicy(), timeService)
+
+				taskInfos, err := reloadedManager.GetInfos()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(taskInfos).To(HaveLen(64))
+
+				taskIDs := map[string]bool{}
+				for _, taskInfo := range taskInfos {
+					taskIDs[taskInfo.TaskID] = true
+				}
+				Expect(taskIDs).To(HaveKey("fake-task-id-99"))
+				Expect(taskIDs).To(HaveKey("fake-task-id-36"))
+				Expect(taskIDs).ToNot(HaveKey("fake-task-id-35"))
+			})
+
+			It("evicts oldest finished task infos beyond max count but keeps running ones", func() {
+				err := manager.AddInfo(boshtask.Info{TaskID: "fake-running-task-id", Method: "fake-method"})
+				Expect(err).ToNot(HaveOccurred())
+
+				for i, taskID := range []string{"fake-task-id-1", "fake-task-id-2", "fake-task-id-3"} {
+					err = manager.AddInfo(finishedInfo(taskID, timeService.Now().Add(time.Duration(i)*time.Second)))
+					Expect(err).ToNot(HaveOccurred())
+				}
+
+				taskInfos, err := manager.GetInfos()
+				Expect(err).ToNot(HaveOccurred())
+
+				var taskIDs []string
+				for _, taskInfo := range taskInfos {
+					taskIDs = append(taskIDs, taskInfo.TaskID)
+				}
+				Expect(taskIDs).To(ConsistOf("fake-running-task-id", "fake-task-id-2", "fake-task-id-3"))
+			})
+		})
+
 		Describe("RemoveInfo", func() {
 			BeforeEach(func() {
 				err := manager.AddInfo(boshtask.Info{
@@ -0,0 +1,30 @@
+package fakes
+
+import (
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
+)
+
+type FakeProgressReporter struct {
+	Reported []boshtask.Progress
+
+	ReportProgressCallBack func(boshtask.Progress)
+}
+
+func NewFakeProgressReporter() *FakeProgressReporter {
+	return &FakeProgressReporter{}
+}
+
+func (r *FakeProgressReporter) ReportProgress(progress boshtask.Progress) {
+	r.Reported = append(r.Reported, progress)
+	if r.ReportProgressCallBack != nil {
+		r.ReportProgressCallBack(progress)
+	}
+}
+
+func (r *FakeProgressReporter) Phases() []string {
+	var phases []string
+	for _, progress := range r.Reported {
+		phases = append(phases, progress.Phase)
+	}
+	return phases
+}
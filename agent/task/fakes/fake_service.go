@@ -6,13 +6,17 @@ import (
 
 type FakeService struct {
 	StartedTasks        map[string]boshtask.Task
+	FinishedTasks       map[string]boshtask.Task
 	CreateTaskErr       error
 	CreateTaskWithIDErr error
+
+	CancelledTaskIDs []string
 }
 
 func NewFakeService() *FakeService {
 	return &FakeService{
-		StartedTasks: make(map[string]boshtask.Task),
+		StartedTasks:  make(map[string]boshtask.Task),
+		FinishedTasks: make(map[string]boshtask.Task),
 	}
 }
 
@@ -46,7 +50,40 @@ func (s *FakeService) StartTask(task boshtask.Task) {
 	s.StartedTasks[task.ID] = task
 }
 
+func (s *FakeService) RecordProgress(id string, progress boshtask.Progress) {
+	task, found := s.StartedTasks[id]
+	if !found {
+		return
+	}
+	progress.Sequence = len(task.Events) + 1
+	task.Events = append(task.Events, progress)
+	s.StartedTasks[id] = task
+}
+
+func (s *FakeService) RecordFinishedTask(task boshtask.Task) {
+	s.FinishedTasks[task.ID] = task
+}
+
+func (s *FakeService) ListTasks() []boshtask.Task {
+	tasks := []boshtask.Task{}
+	for _, task := range s.StartedTasks {
+		tasks = append(tasks, task)
+	}
+	for _, task := range s.FinishedTasks {
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
+
 func (s *FakeService) FindTaskWithID(id string) (boshtask.Task, bool) {
+	if task, found := s.FinishedTasks[id]; found {
+		return task, true
+	}
 	task, found := s.StartedTasks[id]
 	return task, found
 }
+
+func (s *FakeService) CancelTask(task boshtask.Task) error {
+	s.CancelledTaskIDs = append(s.CancelledTaskIDs, task.ID)
+	return task.Cancel()
+}
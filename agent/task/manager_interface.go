@@ -1,18 +1,41 @@
 package task
 
 import (
+	"time"
+
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
 	boshsys "github.com/cloudfoundry/bosh-utils/system"
+	"github.com/pivotal-golang/clock"
 )
 
 type Info struct {
 	TaskID  string
 	Method  string
 	Payload []byte
+
+	// Result of the task; State is empty until the task finishes
+	State      State
+	Value      interface{}
+	Error      string
+	StartedAt  time.Time
+	FinishedAt time.Time
+
+	// Classification of the error so that it survives agent restarts
+	ErrorCode    boshcodederr.ErrorCode
+	Retryable    bool
+	ErrorDetails map[string]interface{}
+
+	// Set when Value was too large to be persisted
+	ValueDropped bool
+}
+
+func (i Info) IsFinished() bool {
+	return i.State == StateDone || i.State == StateFailed || i.State == StateCancelled
 }
 
 type ManagerProvider interface {
-	NewManager(boshlog.Logger, boshsys.FileSystem, string) Manager
+	NewManager(boshlog.Logger, boshsys.FileSystem, string, RetentionPolicy, clock.Clock) Manager
 }
 
 type Manager interface {
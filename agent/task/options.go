@@ -0,0 +1,26 @@
+package task
+
+import (
+	"time"
+)
+
+// Options configure how long finished tasks are kept around
+// for get_task, both in memory and in the task store.
+type Options struct {
+	ResultRetentionSeconds int
+	MaxFinishedTasks       int
+}
+
+func (o Options) RetentionPolicy() RetentionPolicy {
+	policy := DefaultRetentionPolicy()
+
+	if o.ResultRetentionSeconds > 0 {
+		policy.MaxAge = time.Duration(o.ResultRetentionSeconds) * time.Second
+	}
+
+	if o.MaxFinishedTasks > 0 {
+		policy.MaxCount = o.MaxFinishedTasks
+	}
+
+	return policy
+}
@@ -0,0 +1,26 @@
+package task_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/agent/task"
+)
+
+var _ = Describe("Options", func() {
+	Describe("RetentionPolicy", func() {
+		It("returns default policy when nothing is configured", func() {
+			Expect(Options{}.RetentionPolicy()).To(Equal(DefaultRetentionPolicy()))
+		})
+
+		It("returns configured max age and count", func() {
+			options := Options{ResultRetentionSeconds: 600, MaxFinishedTasks: 50}
+			Expect(options.RetentionPolicy()).To(Equal(RetentionPolicy{
+				MaxAge:   10 * time.Minute,
+				MaxCount: 50,
+			}))
+		})
+	})
+})
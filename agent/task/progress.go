@@ -0,0 +1,55 @@
+package task
+
+import (
+	"time"
+)
+
+// Progress describes how far along a running task is.
+// Percentage is nil when it cannot be determined.
+type Progress struct {
+	Sequence         int       `json:"sequence"`
+	Time             time.Time `json:"time"`
+	Phase            string    `json:"phase"`
+	BytesTransferred int64     `json:"bytes_transferred,omitempty"`
+	Percentage       *int      `json:"percentage,omitempty"`
+}
+
+// ProgressReporter is used by long running actions to report their progress
+type ProgressReporter interface {
+	ReportProgress(Progress)
+}
+
+// PercentageOf returns percentage of done out of total for use in Progress
+func PercentageOf(done, total int) *int {
+	if total <= 0 {
+		return nil
+	}
+	percentage := done * 100 / total
+	return &percentage
+}
+
+type noopProgressReporter struct{}
+
+func NewNoopProgressReporter() ProgressReporter {
+	return noopProgressReporter{}
+}
+
+func (r noopProgressReporter) ReportProgress(_ Progress) {}
+
+type taskProgressReporter struct {
+	taskID  string
+	service Service
+}
+
+// NewTaskProgressReporter returns reporter that records progress
+// as events of the task with given id
+func NewTaskProgressReporter(taskID string, service Service) ProgressReporter {
+	return taskProgressReporter{
+		taskID:  taskID,
+		service: service,
+	}
+}
+
+func (r taskProgressReporter) ReportProgress(progress Progress) {
+	r.service.RecordProgress(r.taskID, progress)
+}
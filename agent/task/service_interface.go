@@ -7,5 +7,19 @@ type Service interface {
 
 	// Records that task to run later
 	StartTask(Task)
+
+	// Records already finished task, e.g. one loaded from the task store
+	RecordFinishedTask(Task)
+
 	FindTaskWithID(string) (Task, bool)
+
+	// Removes queued task from its queue and records it as cancelled;
+	// running tasks are asked to cancel via their cancel func
+	CancelTask(Task) error
+
+	// Records progress event of a running task
+	RecordProgress(string, Progress)
+
+	// Returns running tasks and finished tasks that were not evicted yet
+	ListTasks() []Task
 }
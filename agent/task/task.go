@@ -1,5 +1,9 @@
 package task
 
+import (
+	"time"
+)
+
 type Func func() (value interface{}, err error)
 
 type CancelFunc func(task Task) error
@@ -12,14 +16,70 @@ const (
 	StateRunning State = "running"
 	StateDone    State = "done"
 	StateFailed  State = "failed"
+
+	// Task was stopped via cancel_task before it finished its work
+	StateCancelled State = "cancelled"
 )
 
+// ConcurrencyClass determines which pool of the task service runs a task.
+// Tasks in different classes do not wait on each other except for exclusive
+// tasks which wait for all other tasks to finish and run alone.
+type ConcurrencyClass string
+
+const (
+	// Tasks that change job, disk or network state; run alone
+	ConcurrencyExclusive ConcurrencyClass = "exclusive"
+
+	// Compilation reuses the single packages directory; run one at a time
+	ConcurrencyCompilation ConcurrencyClass = "compilation"
+
+	// Tasks that only read state or produce artifacts; run side by side
+	ConcurrencyShared ConcurrencyClass = "shared"
+)
+
+// ConcurrencyLimits maps each concurrency class to the maximum number
+// of its tasks that may run at the same time.
+type ConcurrencyLimits map[ConcurrencyClass]int
+
+func DefaultConcurrencyLimits() ConcurrencyLimits {
+	return ConcurrencyLimits{
+		ConcurrencyExclusive:   1,
+		ConcurrencyCompilation: 1,
+		ConcurrencyShared:      4,
+	}
+}
+
+// RetentionPolicy bounds how long and how many finished tasks
+// are kept around for get_task. Zero values disable the bound.
+type RetentionPolicy struct {
+	MaxAge   time.Duration
+	MaxCount int
+}
+
+func DefaultRetentionPolicy() RetentionPolicy {
+	return RetentionPolicy{
+		MaxAge:   time.Hour,
+		MaxCount: 1000,
+	}
+}
+
 type Task struct {
 	ID    string
 	State State
 	Value interface{}
 	Error error
 
+	Method string
+
+	// StartedAt is zero while the task waits in the queue of its pool
+	StartedAt  time.Time
+	FinishedAt time.Time
+
+	// Most recent progress events reported by the task, oldest first
+	Events []Progress
+
+	ConcurrencyClass ConcurrencyClass
+
 	Func       Func
 	CancelFunc CancelFunc
 	EndFunc    EndFunc
@@ -32,7 +92,16 @@ func (t Task) Cancel() error {
 	return nil
 }
 
+func (t Task) LatestProgress() *Progress {
+	if len(t.Events) == 0 {
+		return nil
+	}
+	latest := t.Events[len(t.Events)-1]
+	return &latest
+}
+
 type StateValue struct {
-	AgentTaskID string `json:"agent_task_id"`
-	State       State  `json:"state"`
+	AgentTaskID string    `json:"agent_task_id"`
+	State       State     `json:"state"`
+	Progress    *Progress `json:"progress,omitempty"`
 }
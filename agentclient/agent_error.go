@@ -0,0 +1,37 @@
+package agentclient
+
+import (
+	"fmt"
+
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+// AgentError is an exception that agent responded with.
+// Code is empty when agent does not classify its errors.
+type AgentError struct {
+	Message   string
+	Code      boshcodederr.ErrorCode
+	Retryable bool
+	Details   map[string]interface{}
+}
+
+func (e AgentError) Error() string {
+	return fmt.Sprintf("Agent responded with error: %s", e.Message)
+}
+
+// FindAgentError returns agent error from the chain of wrapped errors
+func FindAgentError(err error) (AgentError, bool) {
+	switch typedErr := err.(type) {
+	case AgentError:
+		return typedErr, true
+
+	case bosherr.ComplexError:
+		if agentErr, found := FindAgentError(typedErr.Err); found {
+			return agentErr, true
+		}
+		return FindAgentError(typedErr.Cause)
+	}
+
+	return AgentError{}, false
+}
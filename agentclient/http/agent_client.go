@@ -6,11 +6,14 @@ import (
 
 	"github.com/cloudfoundry/bosh-agent/agentclient"
 	"github.com/cloudfoundry/bosh-agent/agentclient/applyspec"
+	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
 	"github.com/cloudfoundry/bosh-agent/settings"
+	boshblob "github.com/cloudfoundry/bosh-utils/blobstore"
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 	"github.com/cloudfoundry/bosh-utils/httpclient"
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
 	boshretry "github.com/cloudfoundry/bosh-utils/retrystrategy"
+	boshuuid "github.com/cloudfoundry/bosh-utils/uuid"
 )
 
 type agentClient struct {
@@ -27,6 +30,9 @@ func NewAgentClient(
 	getTaskDelay time.Duration,
 	toleratedErrorCount int,
 	httpClient httpclient.HTTPClient,
+	blobstore boshblob.Blobstore,
+	signer *boshhandler.MessageSigner,
+	uuidGen boshuuid.Generator,
 	logger boshlog.Logger,
 ) agentclient.AgentClient {
 	// if this were NATS, we would need the agentID, but since it's http, the endpoint is unique to the agent
@@ -35,6 +41,10 @@ func NewAgentClient(
 		directorID: directorID,
 		endpoint:   agentEndpoint,
 		httpClient: httpClient,
+		uuidGen:    uuidGen,
+		blobstore:  blobstore,
+		signer:     signer,
+		logger:     logger,
 	}
 	return &agentClient{
 		agentRequest:        agentRequest,
@@ -47,7 +57,7 @@ func NewAgentClient(
 
 func (c *agentClient) Ping() (string, error) {
 	var response SimpleTaskResponse
-	err := c.agentRequest.Send("ping", []interface{}{}, &response)
+	err := c.send("ping", []interface{}{}, &response)
 	if err != nil {
 		return "", bosherr.WrapError(err, "Sending ping to the agent")
 	}
@@ -67,7 +77,7 @@ func (c *agentClient) Apply(spec applyspec.ApplySpec) error {
 
 func (c *agentClient) Start() error {
 	var response SimpleTaskResponse
-	err := c.agentRequest.Send("start", []interface{}{}, &response)
+	err := c.send("start", []interface{}{}, &response)
 	if err != nil {
 		return bosherr.WrapError(err, "Starting agent services")
 	}
@@ -82,16 +92,7 @@ func (c *agentClient) Start() error {
 func (c *agentClient) GetState() (agentclient.AgentState, error) {
 	var response StateResponse
 
-	getStateRetryable := boshretry.NewRetryable(func() (bool, error) {
-		err := c.agentRequest.Send("get_state", []interface{}{}, &response)
-		if err != nil {
-			return true, bosherr.WrapError(err, "Sending get_state to the agent")
-		}
-		return false, nil
-	})
-
-	attemptRetryStrategy := boshretry.NewAttemptRetryStrategy(c.toleratedErrorCount+1, c.getTaskDelay, getStateRetryable, c.logger)
-	err := attemptRetryStrategy.Try()
+	err := c.sendWithRetries("get_state", []interface{}{}, &response)
 	if err != nil {
 		return agentclient.AgentState{}, bosherr.WrapError(err, "Sending get_state to the agent")
 	}
@@ -106,7 +107,7 @@ func (c *agentClient) GetState() (agentclient.AgentState, error) {
 
 func (c *agentClient) ListDisk() ([]string, error) {
 	var response ListResponse
-	err := c.agentRequest.Send("list_disk", []interface{}{}, &response)
+	err := c.send("list_disk", []interface{}{}, &response)
 	if err != nil {
 		return []string{}, bosherr.WrapError(err, "Sending 'list_disk' to the agent")
 	}
@@ -139,9 +140,40 @@ func (c *agentClient) RunScript(scriptName string, options map[string]interface{
 	return err
 }
 
+// send sends request once
+func (c *agentClient) send(method string, arguments []interface{}, response Response) error {
+	requestID, err := c.agentRequest.NewRequestID()
+	if err != nil {
+		return err
+	}
+
+	return c.agentRequest.Send(requestID, method, arguments, response)
+}
+
+// sendWithRetries sends request again with the same id when it could not be
+// sent or its response was not received. Agent responds to the repeated request
+// with the original response so retrying exception would not change the outcome.
+func (c *agentClient) sendWithRetries(method string, arguments []interface{}, response Response) error {
+	requestID, err := c.agentRequest.NewRequestID()
+	if err != nil {
+		return err
+	}
+
+	sendRetryable := boshretry.NewRetryable(func() (bool, error) {
+		err := c.agentRequest.Send(requestID, method, arguments, response)
+		if err != nil {
+			_, found := agentclient.FindAgentError(err)
+			return !found, err
+		}
+		return false, nil
+	})
+
+	return boshretry.NewAttemptRetryStrategy(c.toleratedErrorCount+1, c.getTaskDelay, sendRetryable, c.logger).Try()
+}
+
 func (c *agentClient) sendAsyncTaskMessage(method string, arguments []interface{}) (value map[string]interface{}, err error) {
 	var response TaskResponse
-	err = c.agentRequest.Send(method, arguments, &response)
+	err = c.sendWithRetries(method, arguments, &response)
 	if err != nil {
 		return value, bosherr.WrapErrorf(err, "Sending '%s' to the agent", method)
 	}
@@ -153,9 +185,17 @@ func (c *agentClient) sendAsyncTaskMessage(method string, arguments []interface{
 
 	sendErrors := 0
 	getTaskRetryable := boshretry.NewRetryable(func() (bool, error) {
+		// Each poll is a new request since repeated request
+		// would be answered with the original task state
 		var response TaskResponse
-		err = c.agentRequest.Send("get_task", []interface{}{agentTaskID}, &response)
+		err = c.send("get_task", []interface{}{agentTaskID}, &response)
 		if err != nil {
+			// Classified exception means that the task itself failed;
+			// asking for its state again would not change the outcome
+			if agentErr, found := agentclient.FindAgentError(err); found && agentErr.Code != "" {
+				return false, bosherr.WrapError(err, "Sending 'get_task' to the agent")
+			}
+
 			sendErrors++
 			shouldRetry := sendErrors <= c.toleratedErrorCount
 			err = bosherr.WrapError(err, "Sending 'get_task' to the agent")
@@ -172,6 +212,10 @@ func (c *agentClient) sendAsyncTaskMessage(method string, arguments []interface{
 			return false, bosherr.WrapError(err, "Getting task state")
 		}
 
+		if taskState == "cancelled" {
+			return false, bosherr.Errorf("Task %s was cancelled", method)
+		}
+
 		if taskState != "running" {
 			var ok bool
 			value, ok = response.Value.(map[string]interface{})
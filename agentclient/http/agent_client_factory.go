@@ -6,8 +6,11 @@ import (
 	"time"
 
 	"github.com/cloudfoundry/bosh-agent/agentclient"
+	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
+	boshblob "github.com/cloudfoundry/bosh-utils/blobstore"
 	"github.com/cloudfoundry/bosh-utils/httpclient"
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	boshuuid "github.com/cloudfoundry/bosh-utils/uuid"
 )
 
 type AgentClientFactory interface {
@@ -16,20 +19,30 @@ type AgentClientFactory interface {
 
 type agentClientFactory struct {
 	getTaskDelay time.Duration
+	blobstore    boshblob.Blobstore
+	signer       *boshhandler.MessageSigner
 	logger       boshlog.Logger
 }
 
+// NewAgentClientFactory returns factory of clients that fetch responses
+// too large to be sent directly from the given blobstore (may be nil)
+// and that sign requests with the given signer (may be nil). Agents only
+// accept messages addressed to them so signer must be built with ForAgent.
 func NewAgentClientFactory(
 	getTaskDelay time.Duration,
+	blobstore boshblob.Blobstore,
+	signer *boshhandler.MessageSigner,
 	logger boshlog.Logger,
 ) AgentClientFactory {
 	return &agentClientFactory{
 		getTaskDelay: getTaskDelay,
+		blobstore:    blobstore,
+		signer:       signer,
 		logger:       logger,
 	}
 }
 
 func (f *agentClientFactory) NewAgentClient(directorID, mbusURL string) agentclient.AgentClient {
 	httpClient := httpclient.NewHTTPClient(httpclient.DefaultClient, f.logger)
-	return NewAgentClient(mbusURL, directorID, f.getTaskDelay, 10, httpClient, f.logger)
+	return NewAgentClient(mbusURL, directorID, f.getTaskDelay, 10, httpClient, f.blobstore, f.signer, boshuuid.NewGenerator(), f.logger)
 }
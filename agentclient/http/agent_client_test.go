@@ -3,29 +3,87 @@ package http_test
 import (
 	"encoding/json"
 	"errors"
+	"io/ioutil"
 	"net/http"
+	"os"
 
 	. "github.com/cloudfoundry/bosh-agent/agentclient/http"
 
 	"github.com/cloudfoundry/bosh-agent/agentclient"
 	"github.com/cloudfoundry/bosh-agent/agentclient/applyspec"
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
+	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
+	fakeblob "github.com/cloudfoundry/bosh-utils/blobstore/fakes"
 	fakehttpclient "github.com/cloudfoundry/bosh-utils/httpclient/fakes"
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakeuuid "github.com/cloudfoundry/bosh-utils/uuid/fakes"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/clock"
 )
 
 var _ = Describe("AgentClient", func() {
 	var (
 		fakeHTTPClient *fakehttpclient.FakeHTTPClient
+		uuidGen        *fakeuuid.FakeGenerator
 		agentClient    agentclient.AgentClient
 	)
 
 	BeforeEach(func() {
 		logger := boshlog.NewLogger(boshlog.LevelNone)
 		fakeHTTPClient = fakehttpclient.NewFakeHTTPClient()
+		uuidGen = &fakeuuid.FakeGenerator{GeneratedUUID: "fake-request-id"}
 		toleratedErrorCount := 2
-		agentClient = NewAgentClient("http://localhost:6305", "fake-uuid", 0, toleratedErrorCount, fakeHTTPClient, logger)
+		agentClient = NewAgentClient("http://localhost:6305", "fake-uuid", 0, toleratedErrorCount, fakeHTTPClient, nil, nil, uuidGen, logger)
+	})
+
+	Describe("request id", func() {
+		BeforeEach(func() {
+			uuidGen.GeneratedUUID = ""
+		})
+
+		requestIDs := func() []string {
+			ids := []string{}
+			for _, input := range fakeHTTPClient.PostInputs {
+				var request AgentRequestMessage
+				Expect(json.Unmarshal(input.Payload, &request)).To(Succeed())
+				ids = append(ids, request.RequestID)
+			}
+			return ids
+		}
+
+		It("sends request again with the same id when it could not be sent", func() {
+			fakeHTTPClient.SetPostBehavior("", 0, errors.New("connection reset by peer"))
+			fakeHTTPClient.SetPostBehavior("", http.StatusBadGateway, nil)
+			fakeHTTPClient.SetPostBehavior(`{"value":{"agent_task_id":"fake-agent-task-id","state":"running"}}`, 200, nil)
+			fakeHTTPClient.SetPostBehavior(`{"value":"stopped"}`, 200, nil)
+
+			err := agentClient.Stop()
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(requestIDs()).To(Equal([]string{"fake-uuid-0", "fake-uuid-0", "fake-uuid-0", "fake-uuid-1"}))
+		})
+
+		It("does not send request again when agent responded with exception", func() {
+			fakeHTTPClient.SetPostBehavior(`{"exception":{"message":"bad request"}}`, 200, nil)
+
+			err := agentClient.Stop()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("bad request"))
+
+			Expect(requestIDs()).To(Equal([]string{"fake-uuid-0"}))
+		})
+
+		It("asks for task state with a new request each time", func() {
+			fakeHTTPClient.SetPostBehavior(`{"value":{"agent_task_id":"fake-agent-task-id","state":"running"}}`, 200, nil)
+			fakeHTTPClient.SetPostBehavior(`{"value":{"agent_task_id":"fake-agent-task-id","state":"running"}}`, 200, nil)
+			fakeHTTPClient.SetPostBehavior(`{"value":"stopped"}`, 200, nil)
+
+			err := agentClient.Stop()
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(requestIDs()).To(Equal([]string{"fake-uuid-0", "fake-uuid-1", "fake-uuid-2"}))
+		})
 	})
 
 	Describe("get_task", func() {
@@ -67,6 +125,40 @@ var _ = Describe("AgentClient", func() {
 				})
 			})
 		})
+
+		Context("when the task is cancelled", func() {
+			It("returns error without asking for task state again", func() {
+				fakeHTTPClient.SetPostBehavior(`{"value":{"agent_task_id":"fake-agent-task-id","state":"running"}}`, 200, nil)
+				fakeHTTPClient.SetPostBehavior(`{"value":{"agent_task_id":"fake-agent-task-id","state":"cancelled"}}`, 200, nil)
+				fakeHTTPClient.SetPostBehavior(`{"value":"stopped"}`, 200, nil)
+
+				err := agentClient.Stop()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("Task stop was cancelled"))
+				Expect(fakeHTTPClient.PostInputs).To(HaveLen(2))
+			})
+		})
+
+		Context("when the task fails with classified error", func() {
+			It("returns agent error without asking for task state again", func() {
+				fakeHTTPClient.SetPostBehavior(`{"value":{"agent_task_id":"fake-agent-task-id","state":"running"}}`, 200, nil)
+				fakeHTTPClient.SetPostBehavior(`{"exception":{"message":"fake-message","code":"blobstore_unavailable","retryable":true,"details":{"fake-key":"fake-value"}}}`, 200, nil)
+				fakeHTTPClient.SetPostBehavior(`{"value":"stopped"}`, 200, nil)
+
+				err := agentClient.Stop()
+				Expect(err).To(HaveOccurred())
+				Expect(fakeHTTPClient.PostInputs).To(HaveLen(2))
+
+				agentErr, found := agentclient.FindAgentError(err)
+				Expect(found).To(BeTrue())
+				Expect(agentErr).To(Equal(agentclient.AgentError{
+					Message:   "fake-message",
+					Code:      boshcodederr.ErrorCodeBlobstoreUnavailable,
+					Retryable: true,
+					Details:   map[string]interface{}{"fake-key": "fake-value"},
+				}))
+			})
+		})
 	})
 
 	Describe("Ping", func() {
@@ -90,6 +182,7 @@ var _ = Describe("AgentClient", func() {
 					Method:    "ping",
 					Arguments: []interface{}{},
 					ReplyTo:   "fake-uuid",
+					RequestID: "fake-request-id",
 				}))
 			})
 
@@ -100,6 +193,19 @@ var _ = Describe("AgentClient", func() {
 			})
 		})
 
+		Context("when generating request id fails", func() {
+			BeforeEach(func() {
+				uuidGen.GenerateError = errors.New("fake-generate-error")
+			})
+
+			It("returns an error without making a request", func() {
+				_, err := agentClient.Ping()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("fake-generate-error"))
+				Expect(fakeHTTPClient.PostInputs).To(BeEmpty())
+			})
+		})
+
 		Context("when agent does not respond with 200", func() {
 			BeforeEach(func() {
 				fakeHTTPClient.SetPostBehavior("", http.StatusInternalServerError, nil)
@@ -149,6 +255,7 @@ var _ = Describe("AgentClient", func() {
 					Method:    "stop",
 					Arguments: []interface{}{},
 					ReplyTo:   "fake-uuid",
+					RequestID: "fake-request-id",
 				}))
 			})
 
@@ -167,6 +274,7 @@ var _ = Describe("AgentClient", func() {
 					Method:    "get_task",
 					Arguments: []interface{}{"fake-agent-task-id"},
 					ReplyTo:   "fake-uuid",
+					RequestID: "fake-request-id",
 				}))
 			})
 		})
@@ -174,6 +282,8 @@ var _ = Describe("AgentClient", func() {
 		Context("when agent does not respond with 200", func() {
 			BeforeEach(func() {
 				fakeHTTPClient.SetPostBehavior("", http.StatusInternalServerError, nil)
+				fakeHTTPClient.SetPostBehavior("", http.StatusInternalServerError, nil)
+				fakeHTTPClient.SetPostBehavior("", http.StatusInternalServerError, nil)
 			})
 
 			It("returns an error", func() {
@@ -238,6 +348,7 @@ var _ = Describe("AgentClient", func() {
 					Method:    "apply",
 					Arguments: []interface{}{specArgument},
 					ReplyTo:   "fake-uuid",
+					RequestID: "fake-request-id",
 				}))
 			})
 
@@ -256,6 +367,7 @@ var _ = Describe("AgentClient", func() {
 					Method:    "get_task",
 					Arguments: []interface{}{"fake-agent-task-id"},
 					ReplyTo:   "fake-uuid",
+					RequestID: "fake-request-id",
 				}))
 			})
 		})
@@ -263,6 +375,8 @@ var _ = Describe("AgentClient", func() {
 		Context("when agent does not respond with 200", func() {
 			BeforeEach(func() {
 				fakeHTTPClient.SetPostBehavior("", http.StatusInternalServerError, nil)
+				fakeHTTPClient.SetPostBehavior("", http.StatusInternalServerError, nil)
+				fakeHTTPClient.SetPostBehavior("", http.StatusInternalServerError, nil)
 			})
 
 			It("returns an error", func() {
@@ -306,6 +420,7 @@ var _ = Describe("AgentClient", func() {
 					Method:    "start",
 					Arguments: []interface{}{},
 					ReplyTo:   "fake-uuid",
+					RequestID: "fake-request-id",
 				}))
 			})
 		})
@@ -367,6 +482,7 @@ var _ = Describe("AgentClient", func() {
 					Method:    "get_state",
 					Arguments: []interface{}{},
 					ReplyTo:   "fake-uuid",
+					RequestID: "fake-request-id",
 				}))
 			})
 		})
@@ -393,11 +509,12 @@ var _ = Describe("AgentClient", func() {
 				fakeHTTPClient.SetPostBehavior(`{"exception":{"message":"bad request"}}`, 200, nil)
 			})
 
-			It("returns an error", func() {
+			It("returns an error without sending request again", func() {
 				stateResponse, err := agentClient.GetState()
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("bad request"))
 				Expect(stateResponse).To(Equal(agentclient.AgentState{}))
+				Expect(fakeHTTPClient.PostInputs).To(HaveLen(1))
 			})
 		})
 
@@ -454,6 +571,7 @@ var _ = Describe("AgentClient", func() {
 					Method:    "mount_disk",
 					Arguments: []interface{}{"fake-disk-cid"},
 					ReplyTo:   "fake-uuid",
+					RequestID: "fake-request-id",
 				}))
 			})
 
@@ -472,6 +590,7 @@ var _ = Describe("AgentClient", func() {
 					Method:    "get_task",
 					Arguments: []interface{}{"fake-agent-task-id"},
 					ReplyTo:   "fake-uuid",
+					RequestID: "fake-request-id",
 				}))
 			})
 		})
@@ -500,6 +619,7 @@ var _ = Describe("AgentClient", func() {
 						Method:    "unmount_disk",
 						Arguments: []interface{}{"fake-disk-cid"},
 						ReplyTo:   "fake-uuid",
+						RequestID: "fake-request-id",
 					}))
 				})
 
@@ -518,6 +638,7 @@ var _ = Describe("AgentClient", func() {
 						Method:    "get_task",
 						Arguments: []interface{}{"fake-agent-task-id"},
 						ReplyTo:   "fake-uuid",
+						RequestID: "fake-request-id",
 					}))
 				})
 			})
@@ -526,6 +647,8 @@ var _ = Describe("AgentClient", func() {
 		Context("when agent does not respond with 200", func() {
 			BeforeEach(func() {
 				fakeHTTPClient.SetPostBehavior("", http.StatusInternalServerError, nil)
+				fakeHTTPClient.SetPostBehavior("", http.StatusInternalServerError, nil)
+				fakeHTTPClient.SetPostBehavior("", http.StatusInternalServerError, nil)
 			})
 
 			It("returns an error", func() {
@@ -569,6 +692,7 @@ var _ = Describe("AgentClient", func() {
 					Method:    "list_disk",
 					Arguments: []interface{}{},
 					ReplyTo:   "fake-uuid",
+					RequestID: "fake-request-id",
 				}))
 			})
 
@@ -579,6 +703,119 @@ var _ = Describe("AgentClient", func() {
 			})
 		})
 
+		Context("when agent responds with blob containing the response", func() {
+			var (
+				blobstore    *fakeblob.FakeBlobstore
+				responsePath string
+			)
+
+			BeforeEach(func() {
+				responseFile, err := ioutil.TempFile("", "agent-response")
+				Expect(err).ToNot(HaveOccurred())
+				_, err = responseFile.Write([]byte(`{"value":["fake-disk-1", "fake-disk-2"]}`))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(responseFile.Close()).To(Succeed())
+				responsePath = responseFile.Name()
+
+				blobstore = fakeblob.NewFakeBlobstore()
+				blobstore.GetFileName = responsePath
+
+				logger := boshlog.NewLogger(boshlog.LevelNone)
+				agentClient = NewAgentClient("http://localhost:6305", "fake-uuid", 0, 2, fakeHTTPClient, blobstore, nil, uuidGen, logger)
+
+				fakeHTTPClient.SetPostBehavior(`{"response_blob":{"blobstore_id":"fake-blob-id","sha1":"fake-sha1"}}`, 200, nil)
+			})
+
+			AfterEach(func() {
+				os.Remove(responsePath)
+			})
+
+			It("returns disks from the response fetched from the blobstore", func() {
+				disks, err := agentClient.ListDisk()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(disks).To(Equal([]string{"fake-disk-1", "fake-disk-2"}))
+
+				Expect(blobstore.GetBlobIDs).To(Equal([]string{"fake-blob-id"}))
+				Expect(blobstore.GetFingerprints).To(Equal([]string{"fake-sha1"}))
+				Expect(blobstore.CleanUpFileName).To(Equal(responsePath))
+			})
+
+			It("deletes the blob once response was fetched", func() {
+				_, err := agentClient.ListDisk()
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(blobstore.DeleteBlobID).To(Equal("fake-blob-id"))
+			})
+
+			It("returns disks when blob cannot be deleted", func() {
+				blobstore.DeleteErr = errors.New("fake-delete-err")
+
+				disks, err := agentClient.ListDisk()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(disks).To(Equal([]string{"fake-disk-1", "fake-disk-2"}))
+			})
+
+			It("returns an error when blob cannot be fetched", func() {
+				blobstore.GetError = errors.New("fake-get-err")
+
+				_, err := agentClient.ListDisk()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("fake-get-err"))
+				Expect(blobstore.DeleteBlobID).To(BeEmpty())
+			})
+
+			It("returns an error when client has no blobstore", func() {
+				logger := boshlog.NewLogger(boshlog.LevelNone)
+				agentClient = NewAgentClient("http://localhost:6305", "fake-uuid", 0, 2, fakeHTTPClient, nil, nil, uuidGen, logger)
+
+				_, err := agentClient.ListDisk()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("blobstore is not configured"))
+			})
+		})
+
+		Context("when messages are signed", func() {
+			var (
+				agentSigner *boshhandler.MessageSigner
+			)
+
+			BeforeEach(func() {
+				signingOptions := boshhandler.MessageSigningOptions{HMACKey: []byte("fake-hmac-key"), Enforce: true}
+				agentSigner = boshhandler.NewMessageSigner(signingOptions, clock.NewClock())
+
+				logger := boshlog.NewLogger(boshlog.LevelNone)
+				signer := boshhandler.NewMessageSigner(signingOptions, clock.NewClock())
+				agentClient = NewAgentClient("http://localhost:6305", "fake-uuid", 0, 2, fakeHTTPClient, nil, signer, uuidGen, logger)
+			})
+
+			It("signs request and verifies signed response", func() {
+				signedResponse, err := agentSigner.Sign([]byte(`{"value":["fake-disk-1"]}`))
+				Expect(err).ToNot(HaveOccurred())
+				fakeHTTPClient.SetPostBehavior(string(signedResponse), 200, nil)
+
+				disks, err := agentClient.ListDisk()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(disks).To(Equal([]string{"fake-disk-1"}))
+
+				Expect(fakeHTTPClient.PostInputs).To(HaveLen(1))
+
+				requestJSON, err := agentSigner.Open(fakeHTTPClient.PostInputs[0].Payload)
+				Expect(err).ToNot(HaveOccurred())
+
+				var request AgentRequestMessage
+				Expect(json.Unmarshal(requestJSON, &request)).To(Succeed())
+				Expect(request.Method).To(Equal("list_disk"))
+			})
+
+			It("returns an error when response is not signed", func() {
+				fakeHTTPClient.SetPostBehavior(`{"value":["fake-disk-1"]}`, 200, nil)
+
+				_, err := agentClient.ListDisk()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("Rejecting unsigned message"))
+			})
+		})
+
 		Context("when agent does not respond with 200", func() {
 			BeforeEach(func() {
 				fakeHTTPClient.SetPostBehavior("", http.StatusInternalServerError, nil)
@@ -628,6 +865,7 @@ var _ = Describe("AgentClient", func() {
 					Method:    "migrate_disk",
 					Arguments: []interface{}{},
 					ReplyTo:   "fake-uuid",
+					RequestID: "fake-request-id",
 				}))
 			})
 
@@ -646,6 +884,7 @@ var _ = Describe("AgentClient", func() {
 					Method:    "get_task",
 					Arguments: []interface{}{"fake-agent-task-id"},
 					ReplyTo:   "fake-uuid",
+					RequestID: "fake-request-id",
 				}))
 			})
 		})
@@ -708,7 +947,8 @@ var _ = Describe("AgentClient", func() {
 						},
 					},
 				},
-				ReplyTo: "fake-uuid",
+				ReplyTo:   "fake-uuid",
+				RequestID: "fake-request-id",
 			}))
 		})
 	})
@@ -743,6 +983,7 @@ var _ = Describe("AgentClient", func() {
 					Method:    "delete_arp_entries",
 					Arguments: []interface{}{map[string]interface{}{"ips": expectedIps}},
 					ReplyTo:   "fake-uuid",
+					RequestID: "fake-request-id",
 				}))
 			})
 		})
@@ -750,6 +991,8 @@ var _ = Describe("AgentClient", func() {
 		Context("when agent does not respond with 200", func() {
 			BeforeEach(func() {
 				fakeHTTPClient.SetPostBehavior("", http.StatusInternalServerError, nil)
+				fakeHTTPClient.SetPostBehavior("", http.StatusInternalServerError, nil)
+				fakeHTTPClient.SetPostBehavior("", http.StatusInternalServerError, nil)
 			})
 
 			It("returns an error", func() {
@@ -5,27 +5,60 @@ import (
 	"io/ioutil"
 	"net/http"
 
+	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
+	boshblob "github.com/cloudfoundry/bosh-utils/blobstore"
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 	"github.com/cloudfoundry/bosh-utils/httpclient"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	boshuuid "github.com/cloudfoundry/bosh-utils/uuid"
 )
 
 type AgentRequestMessage struct {
 	Method    string        `json:"method"`
 	Arguments []interface{} `json:"arguments"`
 	ReplyTo   string        `json:"reply_to"`
+	RequestID string        `json:"request_id"`
 }
 
 type agentRequest struct {
 	directorID string
 	endpoint   string
 	httpClient httpclient.HTTPClient
+	uuidGen    boshuuid.Generator
+
+	// Used to fetch responses that agent uploaded since they were too large
+	blobstore boshblob.Blobstore
+
+	// Signs requests and verifies responses
+	signer *boshhandler.MessageSigner
+
+	logger boshlog.Logger
+}
+
+type blobResponseEnvelope struct {
+	ResponseBlob *boshhandler.ResponseBlob `json:"response_blob"`
+}
+
+// NewRequestID returns id identifying one logical request. Agent performs
+// request only once and responds to the request sent again with the same id
+// (e.g. after the response was lost) with the original response.
+func (r agentRequest) NewRequestID() (string, error) {
+	requestID, err := r.uuidGen.Generate()
+	if err != nil {
+		return "", bosherr.WrapError(err, "Generating request id")
+	}
+
+	return requestID, nil
 }
 
-func (r agentRequest) Send(method string, arguments []interface{}, response Response) error {
+// Send sends request with given id once; retries of the same
+// logical request must be sent with the same id
+func (r agentRequest) Send(requestID string, method string, arguments []interface{}, response Response) error {
 	postBody := AgentRequestMessage{
 		Method:    method,
 		Arguments: arguments,
 		ReplyTo:   r.directorID,
+		RequestID: requestID,
 	}
 
 	agentRequestJSON, err := json.Marshal(postBody)
@@ -33,6 +66,11 @@ func (r agentRequest) Send(method string, arguments []interface{}, response Resp
 		return bosherr.WrapError(err, "Marshaling agent request")
 	}
 
+	agentRequestJSON, err = r.signer.Sign(agentRequestJSON)
+	if err != nil {
+		return bosherr.WrapError(err, "Signing agent request")
+	}
+
 	httpResponse, err := r.httpClient.Post(r.endpoint, agentRequestJSON)
 	if err != nil {
 		return bosherr.WrapErrorf(err, "Performing request to agent endpoint '%s'", r.endpoint)
@@ -50,6 +88,16 @@ func (r agentRequest) Send(method string, arguments []interface{}, response Resp
 		return bosherr.WrapError(err, "Reading agent response")
 	}
 
+	responseBody, err = r.signer.Open(responseBody)
+	if err != nil {
+		return bosherr.WrapError(err, "Verifying agent response")
+	}
+
+	responseBody, err = r.fetchResponseBlob(responseBody)
+	if err != nil {
+		return err
+	}
+
 	err = response.Unmarshal(responseBody)
 	if err != nil {
 		return bosherr.WrapError(err, "Unmarshaling agent response")
@@ -62,3 +110,43 @@ func (r agentRequest) Send(method string, arguments []interface{}, response Resp
 
 	return nil
 }
+
+// fetchResponseBlob returns full response when agent responded with
+// a reference to the response in the blobstore. Agent uploads response
+// for each request so the blob is deleted once it was fetched.
+func (r agentRequest) fetchResponseBlob(responseBody []byte) ([]byte, error) {
+	var envelope blobResponseEnvelope
+
+	err := json.Unmarshal(responseBody, &envelope)
+	if err != nil || envelope.ResponseBlob == nil {
+		return responseBody, nil
+	}
+
+	blob := envelope.ResponseBlob
+
+	if r.blobstore == nil {
+		return nil, bosherr.Errorf("Fetching agent response from blob %s: blobstore is not configured", blob.BlobstoreID)
+	}
+
+	fileName, err := r.blobstore.Get(blob.BlobstoreID, blob.SHA1)
+	if err != nil {
+		return nil, bosherr.WrapErrorf(err, "Fetching agent response from blob %s", blob.BlobstoreID)
+	}
+
+	defer func() {
+		_ = r.blobstore.CleanUp(fileName)
+	}()
+
+	contents, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, bosherr.WrapErrorf(err, "Reading agent response from blob %s", blob.BlobstoreID)
+	}
+
+	// Response was received so failing to delete its blob is not fatal
+	err = r.blobstore.Delete(blob.BlobstoreID)
+	if err != nil {
+		r.logger.Warn("httpAgentClient", "Failed to delete agent response blob %s: %s", blob.BlobstoreID, err.Error())
+	}
+
+	return contents, nil
+}
@@ -6,6 +6,7 @@ import (
 	"runtime/debug"
 
 	"github.com/cloudfoundry/bosh-agent/agentclient"
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 )
 
@@ -15,7 +16,19 @@ type Response interface {
 }
 
 type exception struct {
-	Message string
+	Message   string
+	Code      boshcodederr.ErrorCode
+	Retryable bool
+	Details   map[string]interface{}
+}
+
+func (e *exception) AgentError() agentclient.AgentError {
+	return agentclient.AgentError{
+		Message:   e.Message,
+		Code:      e.Code,
+		Retryable: e.Retryable,
+		Details:   e.Details,
+	}
 }
 
 type SimpleTaskResponse struct {
@@ -25,7 +38,7 @@ type SimpleTaskResponse struct {
 
 func (r *SimpleTaskResponse) ServerError() error {
 	if r.Exception != nil {
-		return bosherr.Errorf("Agent responded with error: %s", r.Exception.Message)
+		return r.Exception.AgentError()
 	}
 	return nil
 }
@@ -41,7 +54,7 @@ type ListResponse struct {
 
 func (r *ListResponse) ServerError() error {
 	if r.Exception != nil {
-		return bosherr.Errorf("Agent responded with error: %s", r.Exception.Message)
+		return r.Exception.AgentError()
 	}
 	return nil
 }
@@ -64,7 +77,7 @@ type BlobResponse struct {
 
 func (r *BlobResponse) ServerError() error {
 	if r.Exception != nil {
-		return bosherr.Errorf("Agent responded with error: %s", r.Exception.Message)
+		return r.Exception.AgentError()
 	}
 	return nil
 }
@@ -80,7 +93,7 @@ type StateResponse struct {
 
 func (r *StateResponse) ServerError() error {
 	if r.Exception != nil {
-		return bosherr.Errorf("Agent responded with error: %s", r.Exception.Message)
+		return r.Exception.AgentError()
 	}
 	return nil
 }
@@ -101,7 +114,7 @@ type TaskResponse struct {
 
 func (r *TaskResponse) ServerError() error {
 	if r.Exception != nil {
-		return bosherr.Errorf("Agent responded with error: %s", r.Exception.Message)
+		return r.Exception.AgentError()
 	}
 	return nil
 }
@@ -1,7 +1,9 @@
 package http_test
 
 import (
+	"github.com/cloudfoundry/bosh-agent/agentclient"
 	. "github.com/cloudfoundry/bosh-agent/agentclient/http"
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -22,6 +24,23 @@ var _ = Describe("AgentResponse", func() {
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(Equal("Agent responded with error: fake-exception-message"))
 			})
+
+			Context("when exception is classified", func() {
+				BeforeEach(func() {
+					agentResponseJSON := `{"exception":{"message":"fake-exception-message","code":"script_failed","details":{"exit_status":1}}}`
+					err := agentTaskResponse.Unmarshal([]byte(agentResponseJSON))
+					Expect(err).ToNot(HaveOccurred())
+				})
+
+				It("returns agent error with code and details", func() {
+					err := agentTaskResponse.ServerError()
+					Expect(err).To(Equal(agentclient.AgentError{
+						Message: "fake-exception-message",
+						Code:    boshcodederr.ErrorCodeScriptFailed,
+						Details: map[string]interface{}{"exit_status": float64(1)},
+					}))
+				})
+			})
 		})
 
 		Describe("TaskID", func() {
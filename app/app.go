@@ -11,18 +11,22 @@ import (
 	boshapplier "github.com/cloudfoundry/bosh-agent/agent/applier"
 	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
 	boshbc "github.com/cloudfoundry/bosh-agent/agent/applier/bundlecollection"
+	boshdownloads "github.com/cloudfoundry/bosh-agent/agent/applier/downloads"
 	boshaj "github.com/cloudfoundry/bosh-agent/agent/applier/jobs"
 	boshap "github.com/cloudfoundry/bosh-agent/agent/applier/packages"
+	boshaudit "github.com/cloudfoundry/bosh-agent/agent/audit"
 	boshrunner "github.com/cloudfoundry/bosh-agent/agent/cmdrunner"
 	boshcomp "github.com/cloudfoundry/bosh-agent/agent/compiler"
 	boshscript "github.com/cloudfoundry/bosh-agent/agent/script"
 	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
+	boshcrypto "github.com/cloudfoundry/bosh-agent/crypto"
 	boshinf "github.com/cloudfoundry/bosh-agent/infrastructure"
 	boshjobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor"
 	boshmonit "github.com/cloudfoundry/bosh-agent/jobsupervisor/monit"
 	boshmbus "github.com/cloudfoundry/bosh-agent/mbus"
 	boshnotif "github.com/cloudfoundry/bosh-agent/notification"
 	boshplatform "github.com/cloudfoundry/bosh-agent/platform"
+	boshcancel "github.com/cloudfoundry/bosh-agent/platform/cancellable"
 	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
 	boshdirs "github.com/cloudfoundry/bosh-agent/settings/directories"
 	boshsigar "github.com/cloudfoundry/bosh-agent/sigar"
@@ -113,21 +117,26 @@ func (app *app) Setup(args []string) error {
 		return bosherr.WrapError(err, "Running bootstrap")
 	}
 
-	mbusHandlerProvider := boshmbus.NewHandlerProvider(settingsService, app.logger)
+	blobsettings := settingsService.GetSettings().Blobstore
 
-	mbusHandler, err := mbusHandlerProvider.Get(app.platform, app.dirProvider)
+	// Blobstore is rebuilt with runner that terminates blobstore commands of cancelled tasks
+	blobstore, err := boshcancel.NewBlobstore(app.platform.GetRunner(), func(runner boshsys.CmdRunner) (boshblob.Blobstore, error) {
+		blobstoreProvider := boshblob.NewProvider(app.platform.GetFs(), runner, app.dirProvider.EtcDir(), app.logger)
+		return blobstoreProvider.Get(blobsettings.Type, blobsettings.Options)
+	})
 	if err != nil {
-		return bosherr.WrapError(err, "Getting mbus handler")
+		return bosherr.WrapError(err, "Getting blobstore")
 	}
 
-	blobstoreProvider := boshblob.NewProvider(app.platform.GetFs(), app.platform.GetRunner(), app.dirProvider.EtcDir(), app.logger)
+	mbusHandlerProvider := boshmbus.NewHandlerProvider(settingsService, config.Mbus, app.logger)
 
-	blobsettings := settingsService.GetSettings().Blobstore
-	blobstore, err := blobstoreProvider.Get(blobsettings.Type, blobsettings.Options)
+	mbusHandler, err := mbusHandlerProvider.Get(app.platform, app.dirProvider, blobstore)
 	if err != nil {
-		return bosherr.WrapError(err, "Getting blobstore")
+		return bosherr.WrapError(err, "Getting mbus handler")
 	}
 
+	localHandler := mbusHandlerProvider.GetUnixSocketHandler(app.dirProvider)
+
 	monitClientProvider := boshmonit.NewProvider(app.platform, app.logger)
 
 	monitClient, err := monitClientProvider.Get()
@@ -150,16 +159,34 @@ func (app *app) Setup(args []string) error {
 
 	notifier := boshnotif.NewNotifier(mbusHandler)
 
-	applier, compiler := app.buildApplierAndCompiler(app.dirProvider, blobstore, jobSupervisor)
+	// Only NATS handler connecting over TLS uses certificates
+	mbusCertUpdater, _ := mbusHandler.(boshmbus.CertUpdater)
+
+	// Only handler failing over between several endpoints reports their status
+	mbusStatusReporter, _ := mbusHandler.(boshmbus.EndpointStatusReporter)
+
+	timeService := clock.NewClock()
+
+	applier, compiler := app.buildApplierAndCompiler(app.dirProvider, blobstore, jobSupervisor, config.Downloads, config.Compiler, timeService)
 
 	uuidGen := boshuuid.NewGenerator()
 
-	taskService := boshtask.NewAsyncTaskService(uuidGen, app.logger)
+	taskRetentionPolicy := config.Tasks.RetentionPolicy()
+
+	taskService := boshtask.NewAsyncTaskService(
+		uuidGen,
+		boshtask.DefaultConcurrencyLimits(),
+		taskRetentionPolicy,
+		timeService,
+		app.logger,
+	)
 
 	taskManager := boshtask.NewManagerProvider().NewManager(
 		app.logger,
 		app.platform.GetFs(),
 		app.dirProvider.BoshDir(),
+		taskRetentionPolicy,
+		timeService,
 	)
 
 	specFilePath := filepath.Join(app.dirProvider.BoshDir(), "spec.json")
@@ -168,8 +195,6 @@ func (app *app) Setup(args []string) error {
 		specFilePath,
 	)
 
-	timeService := clock.NewClock()
-
 	jobScriptProvider := boshscript.NewConcreteJobScriptProvider(
 		app.platform.GetRunner(),
 		app.platform.GetFs(),
@@ -179,18 +204,29 @@ func (app *app) Setup(args []string) error {
 		app.logger,
 	)
 
+	auditLog := boshaudit.NewFileLog(
+		filepath.Join(app.dirProvider.AgentLogsDir(), "audit.log"),
+		config.Audit,
+		app.platform.GetFs(),
+		app.logger,
+	)
+
 	actionFactory := boshaction.NewFactory(
 		settingsService,
 		app.platform,
 		blobstore,
 		taskService,
 		notifier,
+		mbusCertUpdater,
+		mbusStatusReporter,
 		applier,
 		compiler,
 		jobSupervisor,
 		specService,
 		jobScriptProvider,
 		scriptCommandFactory,
+		auditLog,
+		timeService,
 		app.logger,
 	)
 
@@ -202,6 +238,9 @@ func (app *app) Setup(args []string) error {
 		taskManager,
 		actionFactory,
 		actionRunner,
+		config.ActionPolicy,
+		auditLog,
+		timeService,
 	)
 
 	syslogServer := boshsyslog.NewServer(33331, net.Listen, app.logger)
@@ -209,6 +248,7 @@ func (app *app) Setup(args []string) error {
 	app.agent = boshagent.New(
 		app.logger,
 		mbusHandler,
+		localHandler,
 		app.platform,
 		actionDispatcher,
 		jobSupervisor,
@@ -237,9 +277,19 @@ func (app *app) GetPlatform() boshplatform.Platform {
 
 func (app *app) buildApplierAndCompiler(
 	dirProvider boshdirs.Provider,
-	blobstore boshblob.Blobstore,
+	blobstore boshcancel.Blobstore,
 	jobSupervisor boshjobsuper.JobSupervisor,
+	downloadOptions boshdownloads.Options,
+	compilerOptions boshcomp.Options,
+	timeService clock.Clock,
 ) (boshapplier.Applier, boshcomp.Compiler) {
+	blobstore = boshcancel.NewWrappedBlobstore(blobstore, func(blobstore boshblob.Blobstore) boshblob.Blobstore {
+		return boshcrypto.NewDigestVerifiableBlobstore(blobstore, app.platform.GetFs())
+	})
+
+	// Compiler keeps using blobstore directly since it downloads one package at a time
+	applierBlobstore := boshdownloads.NewDeduplicatingBlobstore(blobstore, downloadOptions, timeService, app.logger)
+
 	jobsBc := boshbc.NewFileBundleCollection(
 		dirProvider.DataDir(),
 		dirProvider.BaseDir(),
@@ -253,7 +303,7 @@ func (app *app) buildApplierAndCompiler(
 		dirProvider.BaseDir(),
 		dirProvider.JobsDir(),
 		"packages",
-		blobstore,
+		applierBlobstore,
 		app.platform.GetCompressor(),
 		app.platform.GetFs(),
 		app.logger,
@@ -263,7 +313,36 @@ func (app *app) buildApplierAndCompiler(
 		jobsBc,
 		jobSupervisor,
 		packageApplierProvider,
-		blobstore,
+		applierBlobstore,
+		app.platform.GetCompressor(),
+		app.platform.GetFs(),
+		app.logger,
+	)
+
+	// Apply enables jobs and packages in staging dir first and then
+	// renames staged dirs over the ones in base dir
+	stagingPackageApplierProvider := boshap.NewCompiledPackageApplierProvider(
+		dirProvider.DataDir(),
+		dirProvider.ApplyStagingDir(),
+		filepath.Join(dirProvider.ApplyStagingDir(), "jobs"),
+		"packages",
+		applierBlobstore,
+		app.platform.GetCompressor(),
+		app.platform.GetFs(),
+		app.logger,
+	)
+
+	stagingJobApplier := boshaj.NewRenderedJobApplier(
+		boshbc.NewFileBundleCollection(
+			dirProvider.DataDir(),
+			dirProvider.ApplyStagingDir(),
+			"jobs",
+			app.platform.GetFs(),
+			app.logger,
+		),
+		jobSupervisor,
+		stagingPackageApplierProvider,
+		applierBlobstore,
 		app.platform.GetCompressor(),
 		app.platform.GetFs(),
 		app.logger,
@@ -272,9 +351,13 @@ func (app *app) buildApplierAndCompiler(
 	applier := boshapplier.NewConcreteApplier(
 		jobApplier,
 		packageApplierProvider.Root(),
+		stagingJobApplier,
+		stagingPackageApplierProvider.Root(),
+		boshdownloads.NewScheduler(downloadOptions, app.logger),
 		app.platform,
 		jobSupervisor,
 		dirProvider,
+		app.platform.GetFs(),
 	)
 
 	platformRunner := app.platform.GetRunner()
@@ -294,6 +377,16 @@ func (app *app) buildApplierAndCompiler(
 		dirProvider,
 		packageApplierProvider.Root(),
 		packageApplierProvider.RootBundleCollection(),
+		app.platform.GetCompilationSandbox(),
+		compilerOptions,
+	)
+
+	compiler = boshcomp.NewCachingCompiler(
+		compiler,
+		blobstore,
+		fileSystem,
+		dirProvider.CompiledPackagesCacheDir(),
+		app.logger,
 	)
 
 	return applier, compiler
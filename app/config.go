@@ -3,7 +3,13 @@ package app
 import (
 	"encoding/json"
 
+	boshagent "github.com/cloudfoundry/bosh-agent/agent"
+	boshdownloads "github.com/cloudfoundry/bosh-agent/agent/applier/downloads"
+	boshaudit "github.com/cloudfoundry/bosh-agent/agent/audit"
+	boshcomp "github.com/cloudfoundry/bosh-agent/agent/compiler"
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
 	boshinf "github.com/cloudfoundry/bosh-agent/infrastructure"
+	boshmbus "github.com/cloudfoundry/bosh-agent/mbus"
 	boshplatform "github.com/cloudfoundry/bosh-agent/platform"
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 	boshsys "github.com/cloudfoundry/bosh-utils/system"
@@ -12,6 +18,12 @@ import (
 type Config struct {
 	Platform       boshplatform.Options
 	Infrastructure boshinf.Options
+	Tasks          boshtask.Options
+	ActionPolicy   boshagent.ActionPolicy
+	Audit          boshaudit.Options
+	Mbus           boshmbus.Options
+	Downloads      boshdownloads.Options
+	Compiler       boshcomp.Options
 }
 
 func LoadConfigFromPath(fs boshsys.FileSystem, path string) (Config, error) {
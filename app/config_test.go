@@ -4,8 +4,18 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	boshagent "github.com/cloudfoundry/bosh-agent/agent"
+	boshdownloads "github.com/cloudfoundry/bosh-agent/agent/applier/downloads"
+	boshaudit "github.com/cloudfoundry/bosh-agent/agent/audit"
+	boshcomp "github.com/cloudfoundry/bosh-agent/agent/compiler"
+	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
+	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
+	boshhttpsdispatcher "github.com/cloudfoundry/bosh-agent/httpsdispatcher"
 	boshinf "github.com/cloudfoundry/bosh-agent/infrastructure"
+	boshmbus "github.com/cloudfoundry/bosh-agent/mbus"
+	boshmicro "github.com/cloudfoundry/bosh-agent/micro"
 	boshplatform "github.com/cloudfoundry/bosh-agent/platform"
+	boshsandbox "github.com/cloudfoundry/bosh-agent/platform/sandbox"
 	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
 )
 
@@ -26,7 +36,13 @@ var _ = Describe("LoadConfigFromPath", func() {
 					"UsePreformattedPersistentDisk": true,
 					"BindMountPersistentDisk": true,
 					"SkipDiskSetup": true,
-					"DevicePathResolutionType": "virtio"
+					"DevicePathResolutionType": "virtio",
+					"CompilationSandbox": {
+						"Enabled": true,
+						"CPUs": 1.5,
+						"MemoryLimitBytes": 2147483648,
+						"TimeoutSeconds": 3600
+					}
 				}
 			},
 			"Infrastructure": {
@@ -63,7 +79,45 @@ var _ = Describe("LoadConfigFromPath", func() {
 				  "UseServerName": true,
 				  "UseRegistry": true
 				}
-			}
+			},
+			"Tasks": {
+				"ResultRetentionSeconds": 600,
+				"MaxFinishedTasks": 50
+			},
+			"ActionPolicy": {
+				"Rules": [
+					{"Transport": "https", "Identity": "admin", "Deny": ["ssh"]},
+					{"Transport": "https", "Allow": ["ping", "get_state", "fetch_logs"]}
+				]
+			},
+			"Audit": {
+				"MaxFileSizeBytes": 1048576,
+				"MaxRotatedFiles": 3
+			},
+			"Mbus": {
+				"OutboundQueue": {
+					"MaxMessages": 50,
+					"Persistent": true
+				},
+				"Webhooks": [
+					{"URL": "https://fake-webhook", "Headers": {"Authorization": "fake-auth"}, "MaxRetryDelaySeconds": 60}
+				],
+				"HTTPS": {
+					"CertPath": "/fake-agent.cert",
+					"KeyPath": "/fake-agent.key",
+					"ClientCAPath": "/fake-client-ca.cert",
+					"Credentials": [{"Username": "fake-user", "Password": "fake-password"}],
+					"ClientIdentities": {"fake-director": "director"}
+				},
+				"UnixSocket": {"Enabled": true, "AllowedUIDs": [1000], "AllowedGIDs": [1001]},
+				"Websocket": {"PingIntervalSeconds": 15}
+			},
+			"Downloads": {
+				"Parallelism": 8,
+				"MaxAttempts": 4,
+				"RetryDelaySeconds": 2
+			},
+			"Compiler": {"StrictSourceVerification": true}
 		}`)
 
 		config, err := LoadConfigFromPath(fs, "/fake-config.conf")
@@ -76,6 +130,12 @@ var _ = Describe("LoadConfigFromPath", func() {
 					BindMountPersistentDisk:       true,
 					SkipDiskSetup:                 true,
 					DevicePathResolutionType:      "virtio",
+					CompilationSandbox: boshsandbox.Options{
+						Enabled:          true,
+						CPUs:             1.5,
+						MemoryLimitBytes: 2147483648,
+						TimeoutSeconds:   3600,
+					},
 				},
 			},
 			Infrastructure: boshinf.Options{
@@ -108,6 +168,54 @@ var _ = Describe("LoadConfigFromPath", func() {
 					UseRegistry:   true,
 				},
 			},
+			Tasks: boshtask.Options{
+				ResultRetentionSeconds: 600,
+				MaxFinishedTasks:       50,
+			},
+			ActionPolicy: boshagent.ActionPolicy{
+				Rules: []boshagent.ActionPolicyRule{
+					{Transport: "https", Identity: "admin", Deny: []string{"ssh"}},
+					{Transport: "https", Allow: []string{"ping", "get_state", "fetch_logs"}},
+				},
+			},
+			Audit: boshaudit.Options{
+				MaxFileSizeBytes: 1048576,
+				MaxRotatedFiles:  3,
+			},
+			Mbus: boshmbus.Options{
+				OutboundQueue: boshhandler.OutboundQueueOptions{
+					MaxMessages: 50,
+					Persistent:  true,
+				},
+				Webhooks: []boshmicro.WebhookOptions{
+					{URL: "https://fake-webhook", Headers: map[string]string{"Authorization": "fake-auth"}, MaxRetryDelaySeconds: 60},
+				},
+				HTTPS: boshmicro.HTTPSOptions{
+					TLSOptions: boshhttpsdispatcher.TLSOptions{
+						CertPath:     "/fake-agent.cert",
+						KeyPath:      "/fake-agent.key",
+						ClientCAPath: "/fake-client-ca.cert",
+					},
+					Credentials:      []boshmicro.HTTPSCredential{{Username: "fake-user", Password: "fake-password"}},
+					ClientIdentities: map[string]string{"fake-director": "director"},
+				},
+				UnixSocket: boshmbus.UnixSocketOptions{
+					Enabled:     true,
+					AllowedUIDs: []uint32{1000},
+					AllowedGIDs: []uint32{1001},
+				},
+				Websocket: boshmbus.WebsocketOptions{
+					PingIntervalSeconds: 15,
+				},
+			},
+			Downloads: boshdownloads.Options{
+				Parallelism:       8,
+				MaxAttempts:       4,
+				RetryDelaySeconds: 2,
+			},
+			Compiler: boshcomp.Options{
+				StrictSourceVerification: true,
+			},
 		}))
 	})
 
@@ -0,0 +1,89 @@
+package codederror
+
+import (
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+// ErrorCode is a stable machine readable identifier of an error
+// returned to API consumers in exception responses
+type ErrorCode string
+
+const (
+	ErrorCodeUnknownAction        ErrorCode = "unknown_action"
+	ErrorCodeForbidden            ErrorCode = "forbidden"
+	ErrorCodeInvalidArguments     ErrorCode = "invalid_arguments"
+	ErrorCodeTaskNotFound         ErrorCode = "task_not_found"
+	ErrorCodeDiskNotFound         ErrorCode = "disk_not_found"
+	ErrorCodeBlobstoreUnavailable ErrorCode = "blobstore_unavailable"
+	ErrorCodeScriptFailed         ErrorCode = "script_failed"
+	ErrorCodeTimeout              ErrorCode = "timeout"
+	ErrorCodeResponseTooLarge     ErrorCode = "response_too_large"
+	ErrorCodeCancelled            ErrorCode = "cancelled"
+	ErrorCodeApplyFailed          ErrorCode = "apply_failed"
+)
+
+// Errors with these codes are usually caused by transient conditions
+var retryableErrorCodes = map[ErrorCode]bool{
+	ErrorCodeBlobstoreUnavailable: true,
+	ErrorCodeTimeout:              true,
+}
+
+// CodedError classifies wrapped error so that API consumers
+// do not need to match error messages to decide what to do
+type CodedError struct {
+	Code      ErrorCode
+	Retryable bool
+	Details   map[string]interface{}
+
+	Err error
+}
+
+// NewCodedError returns error with given code; whether it is retryable
+// is determined by the code
+func NewCodedError(code ErrorCode, err error) CodedError {
+	return CodedError{
+		Code:      code,
+		Retryable: retryableErrorCodes[code],
+		Err:       err,
+	}
+}
+
+// WithDetails returns a copy of the error with additional details
+func (e CodedError) WithDetails(details map[string]interface{}) CodedError {
+	e.Details = details
+	return e
+}
+
+func (e CodedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e CodedError) ShortError() string {
+	if typedErr, ok := e.Err.(bosherr.ShortenableError); ok {
+		return typedErr.ShortError()
+	}
+	return e.Err.Error()
+}
+
+// FindCodedError returns outermost coded error in the chain of wrapped errors
+func FindCodedError(err error) (CodedError, bool) {
+	switch typedErr := err.(type) {
+	case CodedError:
+		return typedErr, true
+
+	case bosherr.ComplexError:
+		if codedErr, found := FindCodedError(typedErr.Err); found {
+			return codedErr, true
+		}
+		return FindCodedError(typedErr.Cause)
+
+	case bosherr.MultiError:
+		for _, err := range typedErr.Errors {
+			if codedErr, found := FindCodedError(err); found {
+				return codedErr, true
+			}
+		}
+	}
+
+	return CodedError{}, false
+}
@@ -0,0 +1,58 @@
+package codederror_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/codederror"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+var _ = Describe("CodedError", func() {
+	It("has message of the wrapped error", func() {
+		err := NewCodedError(ErrorCodeDiskNotFound, errors.New("fake-msg"))
+		Expect(err.Error()).To(Equal("fake-msg"))
+	})
+
+	It("is retryable when its code usually indicates transient failure", func() {
+		Expect(NewCodedError(ErrorCodeBlobstoreUnavailable, errors.New("fake-msg")).Retryable).To(BeTrue())
+		Expect(NewCodedError(ErrorCodeTimeout, errors.New("fake-msg")).Retryable).To(BeTrue())
+		Expect(NewCodedError(ErrorCodeInvalidArguments, errors.New("fake-msg")).Retryable).To(BeFalse())
+	})
+
+	Describe("FindCodedError", func() {
+		It("finds coded error wrapped in other errors", func() {
+			codedErr := NewCodedError(ErrorCodeScriptFailed, errors.New("fake-msg"))
+			err := bosherr.WrapError(bosherr.WrapError(codedErr, "fake-wrap-1"), "fake-wrap-2")
+
+			foundErr, found := FindCodedError(err)
+			Expect(found).To(BeTrue())
+			Expect(foundErr).To(Equal(codedErr))
+		})
+
+		It("finds outermost coded error", func() {
+			innerErr := NewCodedError(ErrorCodeBlobstoreUnavailable, errors.New("fake-msg"))
+			outerErr := NewCodedError(ErrorCodeTimeout, bosherr.WrapError(innerErr, "fake-wrap"))
+
+			foundErr, found := FindCodedError(bosherr.WrapError(outerErr, "fake-wrap"))
+			Expect(found).To(BeTrue())
+			Expect(foundErr.Code).To(Equal(ErrorCodeTimeout))
+		})
+
+		It("finds coded error among multiple errors", func() {
+			codedErr := NewCodedError(ErrorCodeBlobstoreUnavailable, errors.New("fake-msg"))
+			err := bosherr.WrapError(bosherr.NewMultiError(errors.New("fake-msg"), bosherr.WrapError(codedErr, "fake-wrap")), "fake-wrap")
+
+			foundErr, found := FindCodedError(err)
+			Expect(found).To(BeTrue())
+			Expect(foundErr).To(Equal(codedErr))
+		})
+
+		It("does not find coded error in plain errors", func() {
+			_, found := FindCodedError(bosherr.WrapError(errors.New("fake-msg"), "fake-wrap"))
+			Expect(found).To(BeFalse())
+		})
+	})
+})
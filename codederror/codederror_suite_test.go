@@ -0,0 +1,13 @@
+package codederror_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestCodedError(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Coded Error Suite")
+}
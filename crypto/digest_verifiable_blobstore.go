@@ -0,0 +1,74 @@
+package crypto
+
+import (
+	"os"
+
+	boshblob "github.com/cloudfoundry/bosh-utils/blobstore"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshsys "github.com/cloudfoundry/bosh-utils/system"
+)
+
+// digestVerifiableBlobstore verifies blobs with the strongest algorithm
+// found in the fingerprint. Inner blobstore is asked not to verify since
+// it only understands plain SHA-1 fingerprints.
+type digestVerifiableBlobstore struct {
+	blobstore boshblob.Blobstore
+	fs        boshsys.FileSystem
+}
+
+func NewDigestVerifiableBlobstore(blobstore boshblob.Blobstore, fs boshsys.FileSystem) boshblob.Blobstore {
+	return digestVerifiableBlobstore{blobstore: blobstore, fs: fs}
+}
+
+func (b digestVerifiableBlobstore) Get(blobID, fingerprint string) (string, error) {
+	digest, err := ParseMultipleDigest(fingerprint)
+	if err != nil {
+		return "", bosherr.WrapErrorf(err, "Parsing digest of blob %s", blobID)
+	}
+
+	fileName, err := b.blobstore.Get(blobID, "")
+	if err != nil {
+		return "", err
+	}
+
+	if digest.IsEmpty() {
+		return fileName, nil
+	}
+
+	err = b.verify(fileName, digest)
+	if err != nil {
+		_ = b.blobstore.CleanUp(fileName)
+		return "", bosherr.WrapErrorf(err, "Verifying digest of blob %s", blobID)
+	}
+
+	return fileName, nil
+}
+
+func (b digestVerifiableBlobstore) verify(fileName string, digest MultipleDigest) error {
+	file, err := b.fs.OpenFile(fileName, os.O_RDONLY, 0)
+	if err != nil {
+		return bosherr.WrapError(err, "Opening blob")
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	return digest.Verify(file)
+}
+
+func (b digestVerifiableBlobstore) CleanUp(fileName string) error {
+	return b.blobstore.CleanUp(fileName)
+}
+
+func (b digestVerifiableBlobstore) Delete(blobID string) error {
+	return b.blobstore.Delete(blobID)
+}
+
+func (b digestVerifiableBlobstore) Create(fileName string) (string, string, error) {
+	return b.blobstore.Create(fileName)
+}
+
+func (b digestVerifiableBlobstore) Validate() error {
+	return b.blobstore.Validate()
+}
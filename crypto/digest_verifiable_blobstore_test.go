@@ -0,0 +1,80 @@
+package crypto_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/crypto"
+	boshblob "github.com/cloudfoundry/bosh-utils/blobstore"
+	fakeblob "github.com/cloudfoundry/bosh-utils/blobstore/fakes"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+)
+
+var _ = Describe("digestVerifiableBlobstore", func() {
+	var (
+		innerBlobstore *fakeblob.FakeBlobstore
+		fs             *fakesys.FakeFileSystem
+		blobstore      boshblob.Blobstore
+	)
+
+	BeforeEach(func() {
+		innerBlobstore = fakeblob.NewFakeBlobstore()
+		fs = fakesys.NewFakeFileSystem()
+		blobstore = NewDigestVerifiableBlobstore(innerBlobstore, fs)
+
+		innerBlobstore.GetFileName = "/fake-file"
+		fs.WriteFileString("/fake-file", "fake-contents")
+	})
+
+	Describe("Get", func() {
+		It("returns file matching the strongest digest without asking inner blobstore to verify it", func() {
+			fileName, err := blobstore.Get("fake-blob-id", "sha1:fake-mismatching-sha1;sha256:"+fakeContentsSHA256)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fileName).To(Equal("/fake-file"))
+
+			Expect(innerBlobstore.GetBlobIDs).To(Equal([]string{"fake-blob-id"}))
+			Expect(innerBlobstore.GetFingerprints).To(Equal([]string{""}))
+		})
+
+		It("verifies plain SHA-1 fingerprint", func() {
+			fileName, err := blobstore.Get("fake-blob-id", fakeContentsSHA1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fileName).To(Equal("/fake-file"))
+		})
+
+		It("returns file without verifying it when fingerprint is empty", func() {
+			fs.WriteFileString("/fake-file", "fake-other-contents")
+
+			fileName, err := blobstore.Get("fake-blob-id", "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fileName).To(Equal("/fake-file"))
+		})
+
+		It("returns error and cleans up file when digest does not match", func() {
+			_, err := blobstore.Get("fake-blob-id", "sha1:"+fakeContentsSHA1+";sha256:fake-mismatching-sha256")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Verifying digest of blob fake-blob-id"))
+			Expect(err.Error()).To(ContainSubstring("Expected stream to have digest 'sha256:fake-mismatching-sha256'"))
+
+			Expect(innerBlobstore.CleanUpFileName).To(Equal("/fake-file"))
+		})
+
+		It("returns error without downloading blob when fingerprint cannot be parsed", func() {
+			_, err := blobstore.Get("fake-blob-id", "fake-algorithm:abc")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Parsing digest of blob fake-blob-id"))
+
+			Expect(innerBlobstore.GetBlobIDs).To(BeEmpty())
+		})
+
+		It("returns error when inner blobstore fails", func() {
+			innerBlobstore.GetError = errors.New("fake-get-err")
+
+			_, err := blobstore.Get("fake-blob-id", fakeContentsSHA1)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("fake-get-err"))
+		})
+	})
+})
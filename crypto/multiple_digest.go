@@ -0,0 +1,238 @@
+package crypto
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"strings"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+type Algorithm string
+
+const (
+	DigestAlgorithmSHA1   Algorithm = "sha1"
+	DigestAlgorithmSHA256 Algorithm = "sha256"
+	DigestAlgorithmSHA512 Algorithm = "sha512"
+)
+
+// Algorithms from weakest to strongest
+var algorithms = []Algorithm{
+	DigestAlgorithmSHA1,
+	DigestAlgorithmSHA256,
+	DigestAlgorithmSHA512,
+}
+
+func (a Algorithm) newHash() hash.Hash {
+	switch a {
+	case DigestAlgorithmSHA256:
+		return sha256.New()
+	case DigestAlgorithmSHA512:
+		return sha512.New()
+	default:
+		return sha1.New()
+	}
+}
+
+func (a Algorithm) strength() int {
+	for i, algorithm := range algorithms {
+		if algorithm == a {
+			return i
+		}
+	}
+	return -1
+}
+
+type Digest struct {
+	Algorithm Algorithm
+	Value     string
+}
+
+func (d Digest) String() string {
+	return fmt.Sprintf("%s:%s", d.Algorithm, d.Value)
+}
+
+func (d Digest) Verify(reader io.Reader) error {
+	actual, err := NewDigest(d.Algorithm, reader)
+	if err != nil {
+		return err
+	}
+
+	if actual.Value != d.Value {
+		return bosherr.Errorf("Expected stream to have digest '%s' but was '%s'", d, actual)
+	}
+
+	return nil
+}
+
+// NewDigest calculates digest of everything read from reader
+func NewDigest(algorithm Algorithm, reader io.Reader) (Digest, error) {
+	h := algorithm.newHash()
+
+	_, err := io.Copy(h, reader)
+	if err != nil {
+		return Digest{}, bosherr.WrapErrorf(err, "Calculating %s digest", algorithm)
+	}
+
+	return Digest{Algorithm: algorithm, Value: fmt.Sprintf("%x", h.Sum(nil))}, nil
+}
+
+// MultipleDigest holds digests of the same blob calculated with
+// different algorithms, e.g. 'sha1:abc;sha256:def'. Plain value
+// without algorithm is a SHA-1 digest sent by older directors.
+type MultipleDigest struct {
+	digests []Digest
+}
+
+func NewMultipleDigest(digests ...Digest) MultipleDigest {
+	return MultipleDigest{digests: digests}
+}
+
+// ParseMultipleDigest ignores algorithms it does not know
+// so that directors can start sending stronger digests
+func ParseMultipleDigest(value string) (MultipleDigest, error) {
+	var digests []Digest
+
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return MultipleDigest{}, nil
+	}
+
+	if !strings.Contains(value, ":") {
+		return NewMultipleDigest(Digest{Algorithm: DigestAlgorithmSHA1, Value: strings.ToLower(value)}), nil
+	}
+
+	for _, piece := range strings.Split(value, ";") {
+		piece = strings.TrimSpace(piece)
+		if piece == "" {
+			continue
+		}
+
+		parts := strings.SplitN(piece, ":", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return MultipleDigest{}, bosherr.Errorf("Parsing digest '%s'", piece)
+		}
+
+		algorithm := Algorithm(strings.ToLower(parts[0]))
+		if algorithm.strength() < 0 {
+			continue
+		}
+
+		digests = append(digests, Digest{Algorithm: algorithm, Value: strings.ToLower(parts[1])})
+	}
+
+	if len(digests) == 0 {
+		return MultipleDigest{}, bosherr.Errorf("No supported digest algorithm found in '%s'", value)
+	}
+
+	return NewMultipleDigest(digests...), nil
+}
+
+func (m MultipleDigest) IsEmpty() bool {
+	return len(m.digests) == 0
+}
+
+func (m MultipleDigest) Digests() []Digest {
+	return m.digests
+}
+
+// Strongest returns digest calculated with the strongest algorithm
+func (m MultipleDigest) Strongest() (Digest, bool) {
+	var strongest Digest
+	var found bool
+
+	for _, digest := range m.digests {
+		if !found || digest.Algorithm.strength() > strongest.Algorithm.strength() {
+			strongest = digest
+			found = true
+		}
+	}
+
+	return strongest, found
+}
+
+// Verify checks contents with the strongest algorithm
+func (m MultipleDigest) Verify(reader io.Reader) error {
+	digest, found := m.Strongest()
+	if !found {
+		return bosherr.Error("No digest to verify against")
+	}
+
+	return digest.Verify(reader)
+}
+
+// StableValue stays the same when director switches from sending plain
+// SHA-1 to sending several digests, so that it can be used in bundle versions.
+func (m MultipleDigest) StableValue() string {
+	for _, digest := range m.digests {
+		if digest.Algorithm == DigestAlgorithmSHA1 {
+			return digest.Value
+		}
+	}
+
+	if digest, found := m.Strongest(); found {
+		return digest.Value
+	}
+
+	return ""
+}
+
+// StableDigestValue returns MultipleDigest.StableValue of given
+// digests or the value itself when it cannot be parsed
+func StableDigestValue(value string) string {
+	digest, err := ParseMultipleDigest(value)
+	if err != nil {
+		return value
+	}
+
+	return digest.StableValue()
+}
+
+// CanonicalValue lists all digests ordered by algorithm so that it
+// identifies the blob by every digest (not only the weakest one)
+// regardless of the order in which director sent them
+func (m MultipleDigest) CanonicalValue() string {
+	digests := append([]Digest{}, m.digests...)
+
+	sort.Sort(digestsByAlgorithm(digests))
+
+	return NewMultipleDigest(digests...).String()
+}
+
+// CanonicalDigestValue returns MultipleDigest.CanonicalValue of given
+// digests or the value itself when it cannot be parsed
+func CanonicalDigestValue(value string) string {
+	digest, err := ParseMultipleDigest(value)
+	if err != nil {
+		return value
+	}
+
+	return digest.CanonicalValue()
+}
+
+type digestsByAlgorithm []Digest
+
+func (d digestsByAlgorithm) Len() int      { return len(d) }
+func (d digestsByAlgorithm) Swap(i, j int) { d[i], d[j] = d[j], d[i] }
+
+func (d digestsByAlgorithm) Less(i, j int) bool {
+	if d[i].Algorithm != d[j].Algorithm {
+		return d[i].Algorithm.strength() < d[j].Algorithm.strength()
+	}
+	return d[i].Value < d[j].Value
+}
+
+func (m MultipleDigest) String() string {
+	var pieces []string
+
+	for _, digest := range m.digests {
+		pieces = append(pieces, digest.String())
+	}
+
+	return strings.Join(pieces, ";")
+}
@@ -0,0 +1,144 @@
+package crypto_test
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/crypto"
+)
+
+const (
+	fakeContentsSHA1   = "978ad524a02039f261773fe93d94973ae7de6470"
+	fakeContentsSHA256 = "d12d3a3ee8dcdc9e7ea3416fd618298ea50abde2cf434313c6c3edb213f441cd"
+)
+
+var _ = Describe("MultipleDigest", func() {
+	Describe("ParseMultipleDigest", func() {
+		It("parses plain value as SHA-1 digest", func() {
+			digest, err := ParseMultipleDigest("ABC123")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(digest.Digests()).To(Equal([]Digest{{Algorithm: DigestAlgorithmSHA1, Value: "abc123"}}))
+		})
+
+		It("parses several digests", func() {
+			digest, err := ParseMultipleDigest("sha1:abc;sha256:def; sha512:ghi")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(digest.Digests()).To(Equal([]Digest{
+				{Algorithm: DigestAlgorithmSHA1, Value: "abc"},
+				{Algorithm: DigestAlgorithmSHA256, Value: "def"},
+				{Algorithm: DigestAlgorithmSHA512, Value: "ghi"},
+			}))
+			Expect(digest.String()).To(Equal("sha1:abc;sha256:def;sha512:ghi"))
+		})
+
+		It("ignores unknown algorithms", func() {
+			digest, err := ParseMultipleDigest("sha1:abc;fake-algorithm:def")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(digest.Digests()).To(Equal([]Digest{{Algorithm: DigestAlgorithmSHA1, Value: "abc"}}))
+		})
+
+		It("returns empty digest for empty value", func() {
+			digest, err := ParseMultipleDigest("")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(digest.IsEmpty()).To(BeTrue())
+		})
+
+		It("returns error when no algorithm is known", func() {
+			_, err := ParseMultipleDigest("fake-algorithm:abc")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("No supported digest algorithm found in 'fake-algorithm:abc'"))
+		})
+
+		It("returns error when digest has no value", func() {
+			_, err := ParseMultipleDigest("sha1:abc;sha256:")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Parsing digest 'sha256:'"))
+		})
+	})
+
+	Describe("Strongest", func() {
+		It("returns digest calculated with the strongest algorithm", func() {
+			digest, err := ParseMultipleDigest("sha256:def;sha1:abc")
+			Expect(err).ToNot(HaveOccurred())
+
+			strongest, found := digest.Strongest()
+			Expect(found).To(BeTrue())
+			Expect(strongest).To(Equal(Digest{Algorithm: DigestAlgorithmSHA256, Value: "def"}))
+		})
+
+		It("returns false when there are no digests", func() {
+			_, found := MultipleDigest{}.Strongest()
+			Expect(found).To(BeFalse())
+		})
+	})
+
+	Describe("Verify", func() {
+		It("verifies contents with the strongest algorithm", func() {
+			digest := NewMultipleDigest(
+				Digest{Algorithm: DigestAlgorithmSHA1, Value: "fake-mismatching-sha1"},
+				Digest{Algorithm: DigestAlgorithmSHA256, Value: fakeContentsSHA256},
+			)
+
+			err := digest.Verify(strings.NewReader("fake-contents"))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("returns error when strongest digest does not match", func() {
+			digest := NewMultipleDigest(
+				Digest{Algorithm: DigestAlgorithmSHA1, Value: fakeContentsSHA1},
+				Digest{Algorithm: DigestAlgorithmSHA256, Value: "fake-mismatching-sha256"},
+			)
+
+			err := digest.Verify(strings.NewReader("fake-contents"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("Expected stream to have digest 'sha256:fake-mismatching-sha256' but was 'sha256:" + fakeContentsSHA256 + "'"))
+		})
+
+		It("returns error when there are no digests", func() {
+			err := MultipleDigest{}.Verify(strings.NewReader("fake-contents"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("No digest to verify against"))
+		})
+	})
+
+	Describe("StableValue", func() {
+		It("returns SHA-1 value so that it matches plain SHA-1 sent by older directors", func() {
+			digest, err := ParseMultipleDigest("sha256:def;sha1:abc")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(digest.StableValue()).To(Equal("abc"))
+
+			plainDigest, err := ParseMultipleDigest("abc")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(plainDigest.StableValue()).To(Equal("abc"))
+		})
+
+		It("returns value of the strongest digest when there is no SHA-1 digest", func() {
+			digest, err := ParseMultipleDigest("sha256:def;sha512:ghi")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(digest.StableValue()).To(Equal("ghi"))
+		})
+
+		It("returns unparsable value as is", func() {
+			Expect(StableDigestValue("fake-algorithm:abc")).To(Equal("fake-algorithm:abc"))
+			Expect(StableDigestValue("sha1:abc;sha256:def")).To(Equal("abc"))
+		})
+	})
+
+	Describe("CanonicalValue", func() {
+		It("lists all digests ordered by algorithm", func() {
+			digest, err := ParseMultipleDigest("SHA512:GHI;sha1:abc;sha256:def")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(digest.CanonicalValue()).To(Equal("sha1:abc;sha256:def;sha512:ghi"))
+		})
+
+		It("includes algorithm of plain SHA-1 digest", func() {
+			Expect(CanonicalDigestValue("ABC")).To(Equal("sha1:abc"))
+		})
+
+		It("returns unparsable value as is", func() {
+			Expect(CanonicalDigestValue("fake-algorithm:abc")).To(Equal("fake-algorithm:abc"))
+		})
+	})
+})
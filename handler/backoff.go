@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Backoff doubles delay after each attempt up to the max delay.
+// Returned delay is randomly picked from the upper half of the current
+// delay so that agents disconnected at the same time do not all
+// reconnect at the same time.
+type Backoff struct {
+	initialDelay time.Duration
+	maxDelay     time.Duration
+
+	delay time.Duration
+	rand  *rand.Rand
+	lock  sync.Mutex
+}
+
+func NewBackoff(initialDelay, maxDelay time.Duration) *Backoff {
+	return &Backoff{
+		initialDelay: initialDelay,
+		maxDelay:     maxDelay,
+		delay:        initialDelay,
+		rand:         rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (b *Backoff) Next() time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	delay := b.delay
+
+	b.delay *= 2
+	if b.delay > b.maxDelay {
+		b.delay = b.maxDelay
+	}
+
+	half := int64(delay / 2)
+
+	return time.Duration(half + b.rand.Int63n(half+1))
+}
+
+func (b *Backoff) Reset() {
+	b.lock.Lock()
+	b.delay = b.initialDelay
+	b.lock.Unlock()
+}
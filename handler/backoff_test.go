@@ -0,0 +1,34 @@
+package handler_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/handler"
+)
+
+var _ = Describe("Backoff", func() {
+	var backoff *Backoff
+
+	BeforeEach(func() {
+		backoff = NewBackoff(100*time.Millisecond, 400*time.Millisecond)
+	})
+
+	It("doubles delay up to max delay picking random delay from the upper half", func() {
+		for _, delay := range []time.Duration{100, 200, 400, 400} {
+			next := backoff.Next()
+			Expect(next).To(BeNumerically(">=", delay*time.Millisecond/2))
+			Expect(next).To(BeNumerically("<=", delay*time.Millisecond))
+		}
+	})
+
+	It("starts from initial delay after reset", func() {
+		backoff.Next()
+		backoff.Next()
+		backoff.Reset()
+
+		Expect(backoff.Next()).To(BeNumerically("<=", 100*time.Millisecond))
+	})
+})
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"sync"
+
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+// NotHandled is returned by handler funcs for requests they do not
+// claim so that the following funcs in the chain can handle them
+var NotHandled Response = notHandledResponse{}
+
+type notHandledResponse struct{}
+
+func (r notHandledResponse) Shorten() Response {
+	return r
+}
+
+// Delegate is returned like NotHandled by handler funcs that leave request
+// to the following funcs in the chain but need to know how it was handled
+// (e.g. to record it in the audit log). Given func is called with the response
+// of the func that handled the request or with unknown action error.
+func Delegate(observe func(Response)) Response {
+	return delegatedResponse{observe: observe}
+}
+
+type delegatedResponse struct {
+	observe func(Response)
+}
+
+func (r delegatedResponse) Shorten() Response {
+	return r
+}
+
+// Chain routes each request to the primary handler func and then
+// to the registered funcs in order of registration. The first func
+// that claims the request responds; requests claimed by none of them
+// are answered with unknown action error.
+type Chain struct {
+	funcs []Func
+	lock  sync.Mutex
+}
+
+func NewChain() *Chain {
+	return &Chain{}
+}
+
+// Register adds func that is asked after previously registered funcs
+func (c *Chain) Register(handlerFunc Func) {
+	c.lock.Lock()
+	c.funcs = append(c.funcs, handlerFunc)
+	c.lock.Unlock()
+}
+
+// Func returns handler func that routes requests through the chain
+func (c *Chain) Func(primaryFunc Func) Func {
+	return func(req Request) Response {
+		// Do not hold the lock while funcs are handling the request
+		c.lock.Lock()
+		funcs := append([]Func{primaryFunc}, c.funcs...)
+		c.lock.Unlock()
+
+		var observers []func(Response)
+
+		for _, handlerFunc := range funcs {
+			resp := handlerFunc(req)
+
+			if delegated, ok := resp.(delegatedResponse); ok {
+				observers = append(observers, delegated.observe)
+				continue
+			}
+
+			if resp != NotHandled {
+				return notify(observers, resp)
+			}
+		}
+
+		err := bosherr.Errorf("unknown message %s", req.Method)
+
+		return notify(observers, NewExceptionResponse(boshcodederr.NewCodedError(boshcodederr.ErrorCodeUnknownAction, err)))
+	}
+}
+
+func notify(observers []func(Response), resp Response) Response {
+	for _, observe := range observers {
+		observe(resp)
+	}
+
+	return resp
+}
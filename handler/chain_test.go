@@ -0,0 +1,111 @@
+package handler_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/handler"
+	boshassert "github.com/cloudfoundry/bosh-utils/assert"
+)
+
+var _ = Describe("Chain", func() {
+	var (
+		chain     *Chain
+		askedFunc []string
+	)
+
+	claiming := func(name, method string) Func {
+		return func(req Request) Response {
+			askedFunc = append(askedFunc, name)
+			if req.Method != method {
+				return NotHandled
+			}
+			return NewValueResponse(name)
+		}
+	}
+
+	BeforeEach(func() {
+		chain = NewChain()
+		askedFunc = nil
+	})
+
+	It("responds with response of the primary func when it handles request", func() {
+		chain.Register(claiming("additional", "ping"))
+
+		resp := chain.Func(claiming("primary", "ping"))(NewRequest("", "ping", nil))
+		Expect(resp).To(Equal(NewValueResponse("primary")))
+		Expect(askedFunc).To(Equal([]string{"primary"}))
+	})
+
+	It("asks registered funcs in order of registration until one of them handles request", func() {
+		chain.Register(claiming("first", "other"))
+		chain.Register(claiming("second", "plugin_method"))
+		chain.Register(claiming("third", "plugin_method"))
+
+		resp := chain.Func(claiming("primary", "ping"))(NewRequest("", "plugin_method", nil))
+		Expect(resp).To(Equal(NewValueResponse("second")))
+		Expect(askedFunc).To(Equal([]string{"primary", "first", "second"}))
+	})
+
+	It("asks funcs registered after chain func was built", func() {
+		chainFunc := chain.Func(claiming("primary", "ping"))
+		chain.Register(claiming("late", "plugin_method"))
+
+		Expect(chainFunc(NewRequest("", "plugin_method", nil))).To(Equal(NewValueResponse("late")))
+	})
+
+	It("treats nil response as handled request that does not need a reply", func() {
+		chain.Register(claiming("additional", "ping"))
+
+		resp := chain.Func(func(req Request) Response { return nil })(NewRequest("", "ping", nil))
+		Expect(resp).To(BeNil())
+	})
+
+	It("responds with unknown action error when no func handles request", func() {
+		chain.Register(claiming("additional", "other"))
+
+		resp := chain.Func(claiming("primary", "ping"))(NewRequest("", "fake-method", nil))
+		boshassert.MatchesJSONString(GinkgoT(), resp, `{"exception":{"message":"unknown message fake-method","code":"unknown_action"}}`)
+	})
+
+	Context("when func delegates request", func() {
+		var observed []Response
+
+		delegating := func(name string) Func {
+			return func(req Request) Response {
+				askedFunc = append(askedFunc, name)
+				return Delegate(func(resp Response) { observed = append(observed, resp) })
+			}
+		}
+
+		BeforeEach(func() {
+			observed = nil
+		})
+
+		It("tells delegating func how the following func handled request", func() {
+			chain.Register(claiming("additional", "plugin_method"))
+
+			resp := chain.Func(delegating("primary"))(NewRequest("", "plugin_method", nil))
+			Expect(resp).To(Equal(NewValueResponse("additional")))
+			Expect(askedFunc).To(Equal([]string{"primary", "additional"}))
+			Expect(observed).To(Equal([]Response{NewValueResponse("additional")}))
+		})
+
+		It("tells delegating func about nil response", func() {
+			chain.Register(func(req Request) Response { return nil })
+
+			resp := chain.Func(delegating("primary"))(NewRequest("", "plugin_method", nil))
+			Expect(resp).To(BeNil())
+			Expect(observed).To(Equal([]Response{nil}))
+		})
+
+		It("tells delegating func about unknown action error when no func handles request", func() {
+			resp := chain.Func(delegating("primary"))(NewRequest("", "fake-method", nil))
+			Expect(observed).To(Equal([]Response{resp}))
+
+			err := ResponseError(observed[0])
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("unknown message fake-method"))
+		})
+	})
+})
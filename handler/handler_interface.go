@@ -11,3 +11,26 @@ type Handler interface {
 
 	Send(target Target, topic Topic, message interface{}) error
 }
+
+// QueueStatsProvider is implemented by handlers that queue messages
+// which cannot be sent right away (e.g. while reconnecting)
+type QueueStatsProvider interface {
+	QueueStats() QueueStats
+}
+
+type QueueStats struct {
+	// Number of messages waiting to be sent
+	Depth int `json:"depth"`
+
+	// Messages dropped since queue was full
+	Dropped int64 `json:"dropped"`
+
+	// Messages replaced by newer messages of the same kind
+	Coalesced int64 `json:"coalesced"`
+}
+
+// HealthChecker is implemented by handlers that can tell
+// whether they are connected to the message bus
+type HealthChecker interface {
+	Healthy() bool
+}
@@ -0,0 +1,283 @@
+package handler
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pivotal-golang/clock"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+const (
+	SigningAlgorithmHMACSHA256 = "hmac-sha256"
+	SigningAlgorithmEd25519    = "ed25519"
+
+	defaultSignedMessageMaxAge = 5 * time.Minute
+
+	// Room for envelope fields other than payload
+	signedEnvelopeOverhead = 512
+)
+
+// MessageSigningOptions configure keys of one side of the conversation:
+// PrivateKey signs outgoing messages and PublicKey of the other side
+// verifies incoming messages. HMACKey is shared by both sides and
+// is used when ed25519 keys are not given; HMAC signed messages are
+// rejected once PublicKey is given so that they cannot be forged
+// by anyone who knows the shared key.
+type MessageSigningOptions struct {
+	HMACKey    []byte
+	PrivateKey ed25519.PrivateKey
+	PublicKey  ed25519.PublicKey
+
+	// Agent that messages are exchanged with; messages addressed to
+	// other agents are rejected so that they cannot be replayed to this one.
+	// Empty agent ID accepts messages addressed to any agent.
+	AgentID string
+
+	// Unsigned messages are rejected when enforced
+	Enforce bool
+
+	// Nonces of accepted messages are only kept in memory so messages
+	// signed before the signer was created could have been accepted
+	// by the previous agent process; such messages are rejected when set.
+	// Sender clock must not be behind since messages signed right
+	// after start are rejected otherwise.
+	RejectSignedBeforeStart bool
+
+	// Older messages (and messages from the future) are rejected
+	MaxAge time.Duration
+}
+
+func (o MessageSigningOptions) maxAge() time.Duration {
+	if o.MaxAge > 0 {
+		return o.MaxAge
+	}
+	return defaultSignedMessageMaxAge
+}
+
+type signedEnvelope struct {
+	Signed *signedMessage `json:"signed"`
+}
+
+type signedMessage struct {
+	Algorithm string `json:"algorithm"`
+	AgentID   string `json:"agent_id"`
+	Timestamp int64  `json:"timestamp"`
+	Nonce     string `json:"nonce"`
+	Payload   []byte `json:"payload"`
+	Signature []byte `json:"signature"`
+}
+
+// signedBytes covers metadata so that it cannot be changed
+// without invalidating the signature
+func (m signedMessage) signedBytes() []byte {
+	header := fmt.Sprintf("%s\n%s\n%d\n%s\n", m.Algorithm, m.AgentID, m.Timestamp, m.Nonce)
+	return append([]byte(header), m.Payload...)
+}
+
+// MessageSigner wraps JSON messages in signed envelopes and unwraps
+// received envelopes. Each envelope carries agent ID, timestamp and nonce
+// so that messages for other agents, stale and replayed messages are
+// rejected. Nil signer passes messages through as is.
+type MessageSigner struct {
+	options     MessageSigningOptions
+	timeService clock.Clock
+	startedAt   time.Time
+
+	// Nonces of accepted messages that are not stale yet
+	seenNonces map[string]time.Time
+	lock       sync.Mutex
+}
+
+func NewMessageSigner(options MessageSigningOptions, timeService clock.Clock) *MessageSigner {
+	return &MessageSigner{
+		options:     options,
+		timeService: timeService,
+		startedAt:   timeService.Now(),
+		seenNonces:  map[string]time.Time{},
+	}
+}
+
+// ForAgent returns signer with the same keys that exchanges
+// messages with given agent; nil signer stays nil
+func (s *MessageSigner) ForAgent(agentID string) *MessageSigner {
+	if s == nil {
+		return nil
+	}
+
+	options := s.options
+	options.AgentID = agentID
+
+	return NewMessageSigner(options, s.timeService)
+}
+
+// Enabled returns whether outgoing messages are signed
+func (s *MessageSigner) Enabled() bool {
+	if s == nil {
+		return false
+	}
+	return s.options.PrivateKey != nil || len(s.options.HMACKey) > 0
+}
+
+// Sign returns envelope with given payload; payload is returned
+// as is when there is no key to sign it with
+func (s *MessageSigner) Sign(payload []byte) ([]byte, error) {
+	if !s.Enabled() {
+		return payload, nil
+	}
+
+	nonce := make([]byte, 16)
+
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Generating nonce")
+	}
+
+	message := signedMessage{
+		AgentID:   s.options.AgentID,
+		Timestamp: s.timeService.Now().Unix(),
+		Nonce:     hex.EncodeToString(nonce),
+		Payload:   payload,
+	}
+
+	if s.options.PrivateKey != nil {
+		message.Algorithm = SigningAlgorithmEd25519
+		message.Signature = ed25519.Sign(s.options.PrivateKey, message.signedBytes())
+	} else {
+		message.Algorithm = SigningAlgorithmHMACSHA256
+		message.Signature = s.hmac(message)
+	}
+
+	envelopeJSON, err := json.Marshal(signedEnvelope{Signed: &message})
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Marshalling signed message")
+	}
+
+	return envelopeJSON, nil
+}
+
+// Open returns payload of verified envelope. Messages that are not
+// wrapped in an envelope are returned as is unless signing is enforced.
+func (s *MessageSigner) Open(messageJSON []byte) ([]byte, error) {
+	if s == nil {
+		return messageJSON, nil
+	}
+
+	var envelope signedEnvelope
+
+	err := json.Unmarshal(messageJSON, &envelope)
+	if err != nil || envelope.Signed == nil {
+		if s.options.Enforce {
+			return nil, bosherr.Error("Rejecting unsigned message")
+		}
+		return messageJSON, nil
+	}
+
+	message := *envelope.Signed
+
+	err = s.verifySignature(message)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.options.AgentID != "" && message.AgentID != s.options.AgentID {
+		return nil, bosherr.Errorf("Rejecting message addressed to agent '%s'", message.AgentID)
+	}
+
+	err = s.verifyFreshness(message)
+	if err != nil {
+		return nil, err
+	}
+
+	return message.Payload, nil
+}
+
+// MaxPayloadLength returns length of the longest payload whose
+// envelope still fits into message of given length
+func (s *MessageSigner) MaxPayloadLength(maxMessageLength int) int {
+	if !s.Enabled() || maxMessageLength == UnlimitedResponseLength {
+		return maxMessageLength
+	}
+
+	// Payload is base64 encoded in the envelope
+	return (maxMessageLength - signedEnvelopeOverhead) / 4 * 3
+}
+
+// verifySignature accepts only the algorithm implied by configured keys
+func (s *MessageSigner) verifySignature(message signedMessage) error {
+	switch message.Algorithm {
+	case SigningAlgorithmEd25519:
+		if s.options.PublicKey == nil {
+			return bosherr.Error("Rejecting message signed with ed25519 since public key is not configured")
+		}
+
+		if !ed25519.Verify(s.options.PublicKey, message.signedBytes(), message.Signature) {
+			return bosherr.Error("Rejecting message with invalid ed25519 signature")
+		}
+
+	case SigningAlgorithmHMACSHA256:
+		if s.options.PublicKey != nil {
+			return bosherr.Error("Rejecting message signed with HMAC since ed25519 public key is configured")
+		}
+
+		if len(s.options.HMACKey) == 0 {
+			return bosherr.Error("Rejecting message signed with HMAC since HMAC key is not configured")
+		}
+
+		if !hmac.Equal(s.hmac(message), message.Signature) {
+			return bosherr.Error("Rejecting message with invalid HMAC signature")
+		}
+
+	default:
+		return bosherr.Errorf("Rejecting message signed with unknown algorithm '%s'", message.Algorithm)
+	}
+
+	return nil
+}
+
+func (s *MessageSigner) verifyFreshness(message signedMessage) error {
+	now := s.timeService.Now()
+	maxAge := s.options.maxAge()
+	signedAt := time.Unix(message.Timestamp, 0)
+
+	// Allow for clock skew in both directions
+	if signedAt.Before(now.Add(-maxAge)) || signedAt.After(now.Add(maxAge)) {
+		return bosherr.Errorf("Rejecting stale message signed at %s", signedAt.UTC().Format(time.RFC3339))
+	}
+
+	// Timestamps only have second precision
+	if s.options.RejectSignedBeforeStart && signedAt.Before(s.startedAt.Truncate(time.Second)) {
+		return bosherr.Errorf("Rejecting message signed at %s before agent started", signedAt.UTC().Format(time.RFC3339))
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for nonce, nonceSignedAt := range s.seenNonces {
+		if nonceSignedAt.Before(now.Add(-maxAge)) {
+			delete(s.seenNonces, nonce)
+		}
+	}
+
+	if _, found := s.seenNonces[message.Nonce]; found {
+		return bosherr.Errorf("Rejecting replayed message with nonce %s", message.Nonce)
+	}
+
+	s.seenNonces[message.Nonce] = signedAt
+
+	return nil
+}
+
+func (s *MessageSigner) hmac(message signedMessage) []byte {
+	mac := hmac.New(sha256.New, s.options.HMACKey)
+	_, _ = mac.Write(message.signedBytes())
+	return mac.Sum(nil)
+}
@@ -0,0 +1,311 @@
+package handler_test
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/clock/fakeclock"
+
+	. "github.com/cloudfoundry/bosh-agent/handler"
+)
+
+var _ = Describe("MessageSigner", func() {
+	var (
+		timeService *fakeclock.FakeClock
+		payload     []byte
+	)
+
+	BeforeEach(func() {
+		timeService = fakeclock.NewFakeClock(time.Now())
+		payload = []byte(`{"method":"ping","arguments":[],"reply_to":"fake-reply-to"}`)
+	})
+
+	Context("with HMAC key", func() {
+		var (
+			signer   *MessageSigner
+			verifier *MessageSigner
+		)
+
+		BeforeEach(func() {
+			signer = NewMessageSigner(MessageSigningOptions{HMACKey: []byte("fake-hmac-key")}, timeService)
+			verifier = NewMessageSigner(MessageSigningOptions{HMACKey: []byte("fake-hmac-key"), Enforce: true}, timeService)
+		})
+
+		It("opens signed message", func() {
+			signed, err := signer.Sign(payload)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(signed)).To(ContainSubstring(`"algorithm":"hmac-sha256"`))
+
+			opened, err := verifier.Open(signed)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(opened).To(Equal(payload))
+		})
+
+		It("rejects message signed with different key", func() {
+			otherSigner := NewMessageSigner(MessageSigningOptions{HMACKey: []byte("other-hmac-key")}, timeService)
+
+			signed, err := otherSigner.Sign(payload)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = verifier.Open(signed)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("invalid HMAC signature"))
+		})
+
+		It("rejects message with tampered payload", func() {
+			signed, err := signer.Sign(payload)
+			Expect(err).ToNot(HaveOccurred())
+
+			var envelope map[string]map[string]interface{}
+			Expect(json.Unmarshal(signed, &envelope)).To(Succeed())
+
+			envelope["signed"]["payload"] = []byte(`{"method":"delete_arp_entries"}`)
+
+			tampered, err := json.Marshal(envelope)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = verifier.Open(tampered)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("invalid HMAC signature"))
+		})
+
+		It("rejects message with tampered timestamp", func() {
+			signed, err := signer.Sign(payload)
+			Expect(err).ToNot(HaveOccurred())
+
+			var envelope map[string]map[string]interface{}
+			Expect(json.Unmarshal(signed, &envelope)).To(Succeed())
+
+			envelope["signed"]["timestamp"] = timeService.Now().Add(time.Minute).Unix()
+
+			tampered, err := json.Marshal(envelope)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = verifier.Open(tampered)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("rejects stale message", func() {
+			signed, err := signer.Sign(payload)
+			Expect(err).ToNot(HaveOccurred())
+
+			timeService.Increment(6 * time.Minute)
+
+			_, err = verifier.Open(signed)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Rejecting stale message"))
+		})
+
+		It("rejects message signed too far in the future", func() {
+			signed, err := signer.Sign(payload)
+			Expect(err).ToNot(HaveOccurred())
+
+			timeService.Increment(-6 * time.Minute)
+
+			_, err = verifier.Open(signed)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Rejecting stale message"))
+		})
+
+		It("uses configured max age", func() {
+			verifier = NewMessageSigner(MessageSigningOptions{HMACKey: []byte("fake-hmac-key"), MaxAge: time.Minute}, timeService)
+
+			signed, err := signer.Sign(payload)
+			Expect(err).ToNot(HaveOccurred())
+
+			timeService.Increment(2 * time.Minute)
+
+			_, err = verifier.Open(signed)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("rejects replayed message", func() {
+			signed, err := signer.Sign(payload)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = verifier.Open(signed)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = verifier.Open(signed)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Rejecting replayed message"))
+		})
+
+		It("rejects message addressed to another agent", func() {
+			verifier = NewMessageSigner(MessageSigningOptions{HMACKey: []byte("fake-hmac-key"), AgentID: "fake-agent-id"}, timeService)
+
+			signed, err := signer.ForAgent("other-agent-id").Sign(payload)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(signed)).To(ContainSubstring(`"agent_id":"other-agent-id"`))
+
+			_, err = verifier.Open(signed)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Rejecting message addressed to agent 'other-agent-id'"))
+
+			signed, err = signer.ForAgent("fake-agent-id").Sign(payload)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = verifier.Open(signed)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("rejects message whose agent ID was changed after signing", func() {
+			verifier = NewMessageSigner(MessageSigningOptions{HMACKey: []byte("fake-hmac-key"), AgentID: "fake-agent-id"}, timeService)
+
+			signed, err := signer.ForAgent("other-agent-id").Sign(payload)
+			Expect(err).ToNot(HaveOccurred())
+
+			tampered := strings.Replace(string(signed), "other-agent-id", "fake-agent-id", 1)
+
+			_, err = verifier.Open([]byte(tampered))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("invalid HMAC signature"))
+		})
+
+		It("rejects message signed before verifier was created when configured", func() {
+			signed, err := signer.Sign(payload)
+			Expect(err).ToNot(HaveOccurred())
+
+			timeService.Increment(2 * time.Second)
+
+			verifier = NewMessageSigner(MessageSigningOptions{HMACKey: []byte("fake-hmac-key"), RejectSignedBeforeStart: true}, timeService)
+
+			_, err = verifier.Open(signed)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("before agent started"))
+
+			signed, err = signer.Sign(payload)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = verifier.Open(signed)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("opens different messages with the same payload", func() {
+			for i := 0; i < 2; i++ {
+				signed, err := signer.Sign(payload)
+				Expect(err).ToNot(HaveOccurred())
+
+				_, err = verifier.Open(signed)
+				Expect(err).ToNot(HaveOccurred())
+			}
+		})
+	})
+
+	Context("with ed25519 keys", func() {
+		var (
+			publicKey  ed25519.PublicKey
+			privateKey ed25519.PrivateKey
+		)
+
+		BeforeEach(func() {
+			var err error
+			publicKey, privateKey, err = ed25519.GenerateKey(nil)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("opens message signed with private key matching public key", func() {
+			signer := NewMessageSigner(MessageSigningOptions{PrivateKey: privateKey}, timeService)
+			verifier := NewMessageSigner(MessageSigningOptions{PublicKey: publicKey}, timeService)
+
+			signed, err := signer.Sign(payload)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(signed)).To(ContainSubstring(`"algorithm":"ed25519"`))
+
+			opened, err := verifier.Open(signed)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(opened).To(Equal(payload))
+		})
+
+		It("rejects message signed with other private key", func() {
+			_, otherPrivateKey, err := ed25519.GenerateKey(nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			signer := NewMessageSigner(MessageSigningOptions{PrivateKey: otherPrivateKey}, timeService)
+			verifier := NewMessageSigner(MessageSigningOptions{PublicKey: publicKey}, timeService)
+
+			signed, err := signer.Sign(payload)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = verifier.Open(signed)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("invalid ed25519 signature"))
+		})
+
+		It("rejects ed25519 signed message when public key is not configured", func() {
+			signer := NewMessageSigner(MessageSigningOptions{PrivateKey: privateKey}, timeService)
+			verifier := NewMessageSigner(MessageSigningOptions{HMACKey: []byte("fake-hmac-key")}, timeService)
+
+			signed, err := signer.Sign(payload)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = verifier.Open(signed)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("public key is not configured"))
+		})
+
+		It("rejects HMAC signed message when public key is configured", func() {
+			signer := NewMessageSigner(MessageSigningOptions{HMACKey: []byte("fake-hmac-key")}, timeService)
+			verifier := NewMessageSigner(MessageSigningOptions{HMACKey: []byte("fake-hmac-key"), PublicKey: publicKey}, timeService)
+
+			signed, err := signer.Sign(payload)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = verifier.Open(signed)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("ed25519 public key is configured"))
+		})
+	})
+
+	Context("without keys", func() {
+		It("does not sign messages", func() {
+			signer := NewMessageSigner(MessageSigningOptions{}, timeService)
+			Expect(signer.Enabled()).To(BeFalse())
+
+			signed, err := signer.Sign(payload)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(signed).To(Equal(payload))
+		})
+	})
+
+	Describe("Open", func() {
+		It("returns unsigned message when signing is not enforced", func() {
+			verifier := NewMessageSigner(MessageSigningOptions{HMACKey: []byte("fake-hmac-key")}, timeService)
+
+			opened, err := verifier.Open(payload)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(opened).To(Equal(payload))
+		})
+
+		It("rejects unsigned message when signing is enforced", func() {
+			verifier := NewMessageSigner(MessageSigningOptions{HMACKey: []byte("fake-hmac-key"), Enforce: true}, timeService)
+
+			_, err := verifier.Open(payload)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Rejecting unsigned message"))
+		})
+	})
+
+	Describe("MaxPayloadLength", func() {
+		It("leaves room for the envelope when signing", func() {
+			signer := NewMessageSigner(MessageSigningOptions{HMACKey: []byte("fake-hmac-key")}, timeService)
+
+			maxLength := signer.MaxPayloadLength(1024)
+			Expect(maxLength).To(BeNumerically("<", 1024))
+
+			signed, err := signer.Sign(make([]byte, maxLength))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(signed)).To(BeNumerically("<=", 1024))
+		})
+
+		It("returns given length when not signing", func() {
+			var signer *MessageSigner
+			Expect(signer.MaxPayloadLength(1024)).To(Equal(1024))
+			Expect(signer.MaxPayloadLength(UnlimitedResponseLength)).To(Equal(UnlimitedResponseLength))
+		})
+	})
+})
@@ -0,0 +1,246 @@
+package handler
+
+import (
+	"encoding/json"
+	"sync"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	boshsys "github.com/cloudfoundry/bosh-utils/system"
+)
+
+const (
+	outboundQueueLogTag = "outboundQueue"
+
+	defaultOutboundQueueMaxMessages = 100
+)
+
+type OutboundQueueOptions struct {
+	MaxMessages int
+
+	// Persistent queue is kept on disk so that
+	// queued alerts survive agent restarts
+	Persistent bool
+}
+
+func (o OutboundQueueOptions) maxMessages() int {
+	if o.MaxMessages > 0 {
+		return o.MaxMessages
+	}
+	return defaultOutboundQueueMaxMessages
+}
+
+type OutboundMessage struct {
+	ID      int64  `json:"id"`
+	Subject string `json:"subject"`
+	Payload []byte `json:"payload"`
+
+	// Queued message is replaced by a newer message with the same key
+	// (e.g. only the latest heartbeat is worth delivering)
+	CoalesceKey string `json:"coalesce_key,omitempty"`
+}
+
+// OutboundQueue keeps messages until they are published. Once queue is full
+// the oldest message is dropped to make room for the new one.
+type OutboundQueue interface {
+	Push(OutboundMessage) error
+
+	// First returns the oldest message without removing it
+	First() (OutboundMessage, bool, error)
+
+	// Remove removes message with given id; message may have already
+	// been dropped or replaced while it was being published
+	Remove(id int64) error
+
+	// Ready receives a value after messages are pushed
+	Ready() <-chan struct{}
+
+	Stats() QueueStats
+}
+
+type outboundQueue struct {
+	options OutboundQueueOptions
+	fs      boshsys.FileSystem
+	path    string
+	logger  boshlog.Logger
+
+	// Persisted messages are loaded before the first change
+	loaded   bool
+	messages []OutboundMessage
+	lastID   int64
+
+	dropped   int64
+	coalesced int64
+
+	readyCh chan struct{}
+	lock    sync.Mutex
+}
+
+// NewOutboundQueue returns queue that is written to the file
+// at given path when options ask for persistent queue
+func NewOutboundQueue(
+	options OutboundQueueOptions,
+	fs boshsys.FileSystem,
+	path string,
+	logger boshlog.Logger,
+) OutboundQueue {
+	return &outboundQueue{
+		options: options,
+		fs:      fs,
+		path:    path,
+		logger:  logger,
+		readyCh: make(chan struct{}, 1),
+	}
+}
+
+func (q *outboundQueue) Push(message OutboundMessage) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	err := q.load()
+	if err != nil {
+		return err
+	}
+
+	if message.CoalesceKey != "" {
+		kept := []OutboundMessage{}
+		for _, queuedMessage := range q.messages {
+			if queuedMessage.CoalesceKey == message.CoalesceKey {
+				q.coalesced++
+			} else {
+				kept = append(kept, queuedMessage)
+			}
+		}
+		q.messages = kept
+	}
+
+	if len(q.messages) >= q.options.maxMessages() {
+		dropped := q.messages[0]
+		q.messages = q.messages[1:]
+		q.dropped++
+
+		q.logger.Warn(outboundQueueLogTag, "Dropped message to %s since queue is full (dropped %d messages so far)", dropped.Subject, q.dropped)
+	}
+
+	q.lastID++
+	message.ID = q.lastID
+	q.messages = append(q.messages, message)
+
+	err = q.save()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case q.readyCh <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+func (q *outboundQueue) First() (OutboundMessage, bool, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	err := q.load()
+	if err != nil {
+		return OutboundMessage{}, false, err
+	}
+
+	if len(q.messages) == 0 {
+		return OutboundMessage{}, false, nil
+	}
+
+	return q.messages[0], true, nil
+}
+
+func (q *outboundQueue) Remove(id int64) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	err := q.load()
+	if err != nil {
+		return err
+	}
+
+	for i, message := range q.messages {
+		if message.ID == id {
+			q.messages = append(q.messages[:i], q.messages[i+1:]...)
+			return q.save()
+		}
+	}
+
+	return nil
+}
+
+func (q *outboundQueue) Ready() <-chan struct{} {
+	return q.readyCh
+}
+
+func (q *outboundQueue) Stats() QueueStats {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return QueueStats{
+		Depth:     len(q.messages),
+		Dropped:   q.dropped,
+		Coalesced: q.coalesced,
+	}
+}
+
+func (q *outboundQueue) load() error {
+	if q.loaded {
+		return nil
+	}
+
+	if q.options.Persistent && q.fs.FileExists(q.path) {
+		contents, err := q.fs.ReadFile(q.path)
+		if err != nil {
+			return bosherr.WrapError(err, "Reading outbound queue")
+		}
+
+		err = json.Unmarshal(contents, &q.messages)
+		if err != nil {
+			// Queue that cannot be read is not worth stopping the agent
+			q.logger.Error(outboundQueueLogTag, "Unmarshalling outbound queue: %s", err.Error())
+			q.messages = nil
+		}
+
+		for _, message := range q.messages {
+			if message.ID > q.lastID {
+				q.lastID = message.ID
+			}
+		}
+	}
+
+	q.loaded = true
+
+	return nil
+}
+
+func (q *outboundQueue) save() error {
+	if !q.options.Persistent {
+		return nil
+	}
+
+	contents, err := json.Marshal(q.messages)
+	if err != nil {
+		return bosherr.WrapError(err, "Marshalling outbound queue")
+	}
+
+	// Replaced with a rename so that queue is never left partially written
+	tmpPath := q.path + ".tmp"
+
+	err = q.fs.WriteFile(tmpPath, contents)
+	if err != nil {
+		return bosherr.WrapError(err, "Writing outbound queue")
+	}
+
+	err = q.fs.Rename(tmpPath, q.path)
+	if err != nil {
+		return bosherr.WrapError(err, "Replacing outbound queue")
+	}
+
+	return nil
+}
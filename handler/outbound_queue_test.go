@@ -0,0 +1,188 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/handler"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+)
+
+var _ = Describe("OutboundQueue", func() {
+	var (
+		options OutboundQueueOptions
+		fs      *fakesys.FakeFileSystem
+		queue   OutboundQueue
+	)
+
+	BeforeEach(func() {
+		options = OutboundQueueOptions{MaxMessages: 3}
+		fs = fakesys.NewFakeFileSystem()
+	})
+
+	JustBeforeEach(func() {
+		queue = NewOutboundQueue(options, fs, "/fake-queue-path", boshlog.NewLogger(boshlog.LevelNone))
+	})
+
+	push := func(subject, coalesceKey string) {
+		err := queue.Push(OutboundMessage{Subject: subject, Payload: []byte("fake-payload"), CoalesceKey: coalesceKey})
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	subjects := func() []string {
+		subjects := []string{}
+		for {
+			message, found, err := queue.First()
+			Expect(err).ToNot(HaveOccurred())
+			if !found {
+				return subjects
+			}
+			subjects = append(subjects, message.Subject)
+			Expect(queue.Remove(message.ID)).To(Succeed())
+		}
+	}
+
+	It("returns messages in order they were pushed", func() {
+		push("fake-subject-1", "")
+		push("fake-subject-2", "")
+
+		Expect(subjects()).To(Equal([]string{"fake-subject-1", "fake-subject-2"}))
+	})
+
+	It("signals that messages are ready after push", func() {
+		Expect(queue.Ready()).ToNot(Receive())
+
+		push("fake-subject-1", "")
+		push("fake-subject-2", "")
+
+		Expect(queue.Ready()).To(Receive())
+		Expect(queue.Ready()).ToNot(Receive())
+	})
+
+	It("keeps the first message until it is removed", func() {
+		push("fake-subject-1", "")
+
+		message, found, err := queue.First()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(found).To(BeTrue())
+
+		_, found, err = queue.First()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(found).To(BeTrue())
+
+		Expect(queue.Remove(message.ID)).To(Succeed())
+
+		_, found, err = queue.First()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(found).To(BeFalse())
+	})
+
+	It("replaces queued message with the same coalesce key", func() {
+		push("fake-heartbeat", "fake-key")
+		push("fake-alert", "")
+		push("fake-new-heartbeat", "fake-key")
+
+		Expect(queue.Stats()).To(Equal(QueueStats{Depth: 2, Coalesced: 1}))
+		Expect(subjects()).To(Equal([]string{"fake-alert", "fake-new-heartbeat"}))
+	})
+
+	It("drops the oldest message once queue is full", func() {
+		push("fake-subject-1", "")
+		push("fake-subject-2", "")
+		push("fake-subject-3", "")
+		push("fake-subject-4", "")
+
+		Expect(queue.Stats()).To(Equal(QueueStats{Depth: 3, Dropped: 1}))
+		Expect(subjects()).To(Equal([]string{"fake-subject-2", "fake-subject-3", "fake-subject-4"}))
+	})
+
+	It("ignores removing message that is no longer queued", func() {
+		push("fake-heartbeat", "fake-key")
+
+		message, _, err := queue.First()
+		Expect(err).ToNot(HaveOccurred())
+
+		push("fake-new-heartbeat", "fake-key")
+
+		Expect(queue.Remove(message.ID)).To(Succeed())
+		Expect(subjects()).To(Equal([]string{"fake-new-heartbeat"}))
+	})
+
+	It("does not write queue to disk", func() {
+		push("fake-subject-1", "")
+		Expect(fs.FileExists("/fake-queue-path")).To(BeFalse())
+	})
+
+	Context("when queue is persistent", func() {
+		BeforeEach(func() {
+			options.Persistent = true
+		})
+
+		It("writes queued messages to disk", func() {
+			push("fake-subject-1", "")
+
+			var messages []OutboundMessage
+
+			contents, err := fs.ReadFile("/fake-queue-path")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(json.Unmarshal(contents, &messages)).To(Succeed())
+
+			Expect(messages).To(Equal([]OutboundMessage{
+				{ID: 1, Subject: "fake-subject-1", Payload: []byte("fake-payload")},
+			}))
+		})
+
+		It("returns messages left by previous agent process after the new ones are pushed", func() {
+			fs.WriteFileString("/fake-queue-path", `[{"id":7,"subject":"fake-old-subject","payload":"ZmFrZQ=="}]`)
+
+			push("fake-new-subject", "")
+
+			message, found, err := queue.First()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(found).To(BeTrue())
+			Expect(message).To(Equal(OutboundMessage{ID: 7, Subject: "fake-old-subject", Payload: []byte("fake")}))
+
+			Expect(subjects()).To(Equal([]string{"fake-old-subject", "fake-new-subject"}))
+		})
+
+		It("ignores queue file that cannot be unmarshalled", func() {
+			fs.WriteFileString("/fake-queue-path", "fake-invalid-json")
+
+			push("fake-subject-1", "")
+			Expect(subjects()).To(Equal([]string{"fake-subject-1"}))
+		})
+
+		It("replaces queue file with a rename so that it is never partially written", func() {
+			push("fake-subject-1", "")
+
+			Expect(fs.RenameOldPaths).To(Equal([]string{"/fake-queue-path.tmp"}))
+			Expect(fs.RenameNewPaths).To(Equal([]string{"/fake-queue-path"}))
+			Expect(fs.FileExists("/fake-queue-path.tmp")).To(BeFalse())
+		})
+
+		It("returns error and keeps previous queue file when it cannot be replaced", func() {
+			fs.WriteFileString("/fake-queue-path", "[]")
+			fs.RenameError = errors.New("fake-rename-err")
+
+			err := queue.Push(OutboundMessage{Subject: "fake-subject-1"})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("fake-rename-err"))
+
+			contents, err := fs.ReadFileString("/fake-queue-path")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(contents).To(Equal("[]"))
+		})
+
+		It("returns error when queue cannot be written", func() {
+			fs.WriteFileError = errors.New("fake-write-err")
+
+			err := queue.Push(OutboundMessage{Subject: "fake-subject-1"})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("fake-write-err"))
+		})
+	})
+})
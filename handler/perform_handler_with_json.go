@@ -3,6 +3,7 @@ package handler
 import (
 	"encoding/json"
 
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
 )
@@ -13,7 +14,16 @@ const (
 	UnlimitedResponseLength = -1
 )
 
-func PerformHandlerWithJSON(rawJSON []byte, handler Func, maxResponseLength int, logger boshlog.Logger) ([]byte, Request, error) {
+// PerformHandlerWithJSON responds to JSON request with JSON response. Responses
+// longer than maxResponseLength are stored with overflow when it is given;
+// otherwise they are shortened or replaced with an error.
+func PerformHandlerWithJSON(
+	rawJSON []byte,
+	handler Func,
+	maxResponseLength int,
+	overflow ResponseOverflow,
+	logger boshlog.Logger,
+) ([]byte, Request, error) {
 	var request Request
 
 	err := json.Unmarshal(rawJSON, &request)
@@ -32,7 +42,7 @@ func PerformHandlerWithJSON(rawJSON []byte, handler Func, maxResponseLength int,
 		return []byte{}, request, nil
 	}
 
-	respJSON, err := marshalResponse(response, maxResponseLength, logger)
+	respJSON, err := marshalResponse(response, maxResponseLength, overflow, logger)
 	if err != nil {
 		return respJSON, request, err
 	}
@@ -44,39 +54,51 @@ func PerformHandlerWithJSON(rawJSON []byte, handler Func, maxResponseLength int,
 }
 
 func BuildErrorWithJSON(msg string, logger boshlog.Logger) ([]byte, error) {
-	response := NewExceptionResponse(bosherr.Error(msg))
+	return buildErrorWithJSON(bosherr.Error(msg), logger)
+}
+
+func buildErrorWithJSON(exception error, logger boshlog.Logger) ([]byte, error) {
+	response := NewExceptionResponse(exception)
 
 	respJSON, err := json.Marshal(response)
 	if err != nil {
 		return respJSON, bosherr.WrapError(err, "Marshalling JSON")
 	}
 
-	logger.Info(mbusHandlerLogTag, "Building error", msg)
+	logger.Info(mbusHandlerLogTag, "Building error", exception.Error())
 
 	return respJSON, nil
 }
 
-func marshalResponse(response Response, maxResponseLength int, logger boshlog.Logger) ([]byte, error) {
+func marshalResponse(response Response, maxResponseLength int, overflow ResponseOverflow, logger boshlog.Logger) ([]byte, error) {
 	respJSON, err := json.Marshal(response)
 	if err != nil {
 		logger.Error(mbusHandlerLogTag, "Failed to marshal response: %s", err.Error())
 		return respJSON, bosherr.WrapError(err, "Marshalling JSON response")
 	}
 
-	if maxResponseLength == UnlimitedResponseLength {
+	if maxResponseLength == UnlimitedResponseLength || len(respJSON) <= maxResponseLength {
 		return respJSON, nil
 	}
 
-	if len(respJSON) > maxResponseLength {
-		respJSON, err = json.Marshal(response.Shorten())
-		if err != nil {
-			logger.Error(mbusHandlerLogTag, "Failed to marshal response: %s", err.Error())
-			return respJSON, bosherr.WrapError(err, "Marshalling JSON response")
+	if overflow != nil {
+		blobRespJSON, err := storeResponse(respJSON, overflow)
+		if err == nil {
+			return blobRespJSON, nil
 		}
+
+		// Shortened response or an error is still better than no response
+		logger.Error(mbusHandlerLogTag, "Failed to store response that exceeded maximum allowed length: %s", err.Error())
+	}
+
+	respJSON, err = json.Marshal(response.Shorten())
+	if err != nil {
+		logger.Error(mbusHandlerLogTag, "Failed to marshal response: %s", err.Error())
+		return respJSON, bosherr.WrapError(err, "Marshalling JSON response")
 	}
 
 	if len(respJSON) > maxResponseLength {
-		respJSON, err = BuildErrorWithJSON(responseMaxLengthErrMsg, logger)
+		respJSON, err = buildErrorWithJSON(boshcodederr.NewCodedError(boshcodederr.ErrorCodeResponseTooLarge, bosherr.Error(responseMaxLengthErrMsg)), logger)
 		if err != nil {
 			logger.Error(mbusHandlerLogTag, "Failed to build 'max length exceeded' response: %s", err.Error())
 			return respJSON, bosherr.WrapError(err, "Building error")
@@ -85,3 +107,17 @@ func marshalResponse(response Response, maxResponseLength int, logger boshlog.Lo
 
 	return respJSON, nil
 }
+
+func storeResponse(respJSON []byte, overflow ResponseOverflow) ([]byte, error) {
+	blobResp, err := overflow.Store(respJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	blobRespJSON, err := json.Marshal(blobResp)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Marshalling JSON response")
+	}
+
+	return blobRespJSON, nil
+}
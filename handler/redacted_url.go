@@ -0,0 +1,18 @@
+package handler
+
+import (
+	"net/url"
+)
+
+// RedactedURL removes credentials from URL
+// since mbus and webhook URLs are logged and reported in state
+func RedactedURL(rawURL string) string {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return "[unparsable url]"
+	}
+
+	parsedURL.User = nil
+
+	return parsedURL.String()
+}
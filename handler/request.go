@@ -12,6 +12,36 @@ type Request struct {
 	ReplyTo string `json:"reply_to"`
 	Method  string
 	Payload []byte
+
+	// Optional id used to recognize retried requests
+	RequestID string `json:"request_id"`
+
+	// Set by the handler that received the request
+	Source RequestSource `json:"-"`
+}
+
+// RequestSource describes how request was received
+type RequestSource struct {
+	// Scheme of the mbus that delivered the request, e.g. nats or https
+	Transport string
+
+	// Authenticated identity of the sender; empty if transport does not authenticate senders
+	Identity string
+}
+
+func (s RequestSource) String() string {
+	if s.Identity == "" {
+		return s.Transport
+	}
+	return s.Transport + " as " + s.Identity
+}
+
+// WithRequestSource returns handler func that marks requests as received from given source
+func WithRequestSource(source RequestSource, handlerFunc Func) Func {
+	return func(req Request) Response {
+		req.Source = source
+		return handlerFunc(req)
+	}
 }
 
 func (r Request) GetPayload() []byte {
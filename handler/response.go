@@ -1,6 +1,7 @@
 package handler
 
 import (
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 )
 
@@ -25,6 +26,11 @@ func (r valueResponse) Shorten() Response {
 type exceptionResponse struct {
 	Exception struct {
 		Message string `json:"message,omitempty"`
+
+		// Included when error was classified via CodedError
+		Code      boshcodederr.ErrorCode `json:"code,omitempty"`
+		Retryable bool                   `json:"retryable,omitempty"`
+		Details   map[string]interface{} `json:"details,omitempty"`
 	} `json:"exception"`
 
 	err error
@@ -34,12 +40,19 @@ func NewExceptionResponse(err error) (resp Response) {
 	r := exceptionResponse{}
 	r.Exception.Message = err.Error()
 	r.err = err
+
+	if codedErr, found := boshcodederr.FindCodedError(err); found {
+		r.Exception.Code = codedErr.Code
+		r.Exception.Retryable = codedErr.Retryable
+		r.Exception.Details = codedErr.Details
+	}
+
 	return r
 }
 
 func (r exceptionResponse) Shorten() Response {
 	if typedErr, ok := r.err.(bosherr.ShortenableError); ok {
-		sr := exceptionResponse{}
+		sr := r
 		sr.Exception.Message = typedErr.ShortError()
 		sr.err = typedErr
 		return sr
@@ -47,3 +60,13 @@ func (r exceptionResponse) Shorten() Response {
 
 	return r
 }
+
+// ResponseError returns error that exception response was created with;
+// nil for other responses
+func ResponseError(resp Response) error {
+	if r, ok := resp.(exceptionResponse); ok {
+		return r.err
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"path/filepath"
+
+	boshblob "github.com/cloudfoundry/bosh-utils/blobstore"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshsys "github.com/cloudfoundry/bosh-utils/system"
+)
+
+// ResponseOverflow stores responses that exceed maximum allowed length
+// so that requester can fetch them instead of receiving an error.
+// Each response is stored in a new blob which requester deletes once fetched.
+type ResponseOverflow interface {
+	// Store returns small response that refers to the stored one
+	Store(respJSON []byte) (Response, error)
+}
+
+// ResponseBlob refers to the JSON of the full response in the blobstore
+type ResponseBlob struct {
+	BlobstoreID string `json:"blobstore_id"`
+	SHA1        string `json:"sha1"`
+}
+
+type blobResponse struct {
+	Blob ResponseBlob `json:"response_blob"`
+}
+
+func NewBlobResponse(blob ResponseBlob) Response {
+	return blobResponse{Blob: blob}
+}
+
+func (r blobResponse) Shorten() Response {
+	return r
+}
+
+type blobstoreResponseOverflow struct {
+	blobstore boshblob.Blobstore
+	fs        boshsys.FileSystem
+}
+
+func NewBlobstoreResponseOverflow(blobstore boshblob.Blobstore, fs boshsys.FileSystem) ResponseOverflow {
+	return blobstoreResponseOverflow{
+		blobstore: blobstore,
+		fs:        fs,
+	}
+}
+
+func (o blobstoreResponseOverflow) Store(respJSON []byte) (Response, error) {
+	tmpDir, err := o.fs.TempDir("bosh-agent-handler-ResponseOverflow-Store")
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Creating temporary directory for response")
+	}
+
+	defer func() {
+		_ = o.fs.RemoveAll(tmpDir)
+	}()
+
+	responsePath := filepath.Join(tmpDir, "response.json")
+
+	err = o.fs.WriteFile(responsePath, respJSON)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Writing response to temporary file")
+	}
+
+	blobID, sha1, err := o.blobstore.Create(responsePath)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Uploading response to blobstore")
+	}
+
+	return NewBlobResponse(ResponseBlob{BlobstoreID: blobID, SHA1: sha1}), nil
+}
@@ -0,0 +1,69 @@
+package handler_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/handler"
+	fakeblob "github.com/cloudfoundry/bosh-utils/blobstore/fakes"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+)
+
+var _ = Describe("blobstoreResponseOverflow", func() {
+	var (
+		blobstore *fakeblob.FakeBlobstore
+		fs        *fakesys.FakeFileSystem
+		overflow  ResponseOverflow
+	)
+
+	BeforeEach(func() {
+		blobstore = fakeblob.NewFakeBlobstore()
+		fs = fakesys.NewFakeFileSystem()
+		overflow = NewBlobstoreResponseOverflow(blobstore, fs)
+	})
+
+	Describe("Store", func() {
+		It("uploads response to the blobstore and returns response referring to the blob", func() {
+			var uploadedResponse string
+
+			blobstore.CreateBlobID = "fake-blob-id"
+			blobstore.CreateFingerprint = "fake-sha1"
+			blobstore.CreateCallBack = func() {
+				var err error
+				uploadedResponse, err = fs.ReadFileString(blobstore.CreateFileNames[0])
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			resp, err := overflow.Store([]byte(`{"value":"fake-value"}`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp).To(Equal(NewBlobResponse(ResponseBlob{BlobstoreID: "fake-blob-id", SHA1: "fake-sha1"})))
+
+			Expect(uploadedResponse).To(Equal(`{"value":"fake-value"}`))
+		})
+
+		It("removes temporary file with response", func() {
+			_, err := overflow.Store([]byte(`{"value":"fake-value"}`))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(fs.FileExists(blobstore.CreateFileNames[0])).To(BeFalse())
+		})
+
+		It("returns error when temporary directory cannot be created", func() {
+			fs.TempDirError = errors.New("fake-temp-dir-err")
+
+			_, err := overflow.Store([]byte(`{"value":"fake-value"}`))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("fake-temp-dir-err"))
+		})
+
+		It("returns error when response cannot be uploaded", func() {
+			blobstore.CreateErr = errors.New("fake-create-err")
+
+			_, err := overflow.Store([]byte(`{"value":"fake-value"}`))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("fake-create-err"))
+		})
+	})
+})
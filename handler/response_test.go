@@ -5,8 +5,10 @@ import (
 
 	. "github.com/onsi/ginkgo"
 
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
 	. "github.com/cloudfoundry/bosh-agent/handler"
 	boshassert "github.com/cloudfoundry/bosh-utils/assert"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 )
 
 type testShortError struct {
@@ -91,4 +93,27 @@ var _ = Describe("NewExceptionResponse", func() {
 			)
 		})
 	})
+
+	Context("with coded error", func() {
+		var err error
+
+		BeforeEach(func() {
+			codedErr := boshcodederr.NewCodedError(boshcodederr.ErrorCodeScriptFailed, errors.New("fake-msg")).WithDetails(map[string]interface{}{
+				"exit_status": 2,
+			})
+			err = bosherr.WrapError(codedErr, "fake-wrap")
+		})
+
+		It("includes code, retryable flag and details", func() {
+			resp := NewExceptionResponse(err)
+			boshassert.MatchesJSONString(GinkgoT(), resp,
+				`{"exception":{"message":"fake-wrap: fake-msg","code":"script_failed","details":{"exit_status":2}}}`)
+		})
+
+		It("keeps code after shortening", func() {
+			resp := NewExceptionResponse(boshcodederr.NewCodedError(boshcodederr.ErrorCodeTimeout, err))
+			boshassert.MatchesJSONString(GinkgoT(), resp.Shorten(),
+				`{"exception":{"message":"fake-wrap: fake-msg","code":"timeout","retryable":true}}`)
+		})
+	})
 })
@@ -0,0 +1,82 @@
+package httpsdispatcher
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+)
+
+const certLoaderLogTag = "certLoader"
+
+// certLoader serves certificate from given files and loads it again
+// once either file is modified. Certificate that fails to load
+// (e.g. files are being replaced) does not replace the current one.
+type certLoader struct {
+	certPath string
+	keyPath  string
+	logger   boshlog.Logger
+
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+	lock        sync.Mutex
+}
+
+func newCertLoader(certPath, keyPath string, logger boshlog.Logger) *certLoader {
+	return &certLoader{
+		certPath: certPath,
+		keyPath:  keyPath,
+		logger:   logger,
+	}
+}
+
+func (l *certLoader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	err := l.reloadIfModified()
+	if err != nil {
+		if l.cert == nil {
+			return nil, err
+		}
+
+		l.logger.Error(certLoaderLogTag, "Reloading certificate, keeping current one: %s", err.Error())
+	}
+
+	return l.cert, nil
+}
+
+func (l *certLoader) reloadIfModified() error {
+	certInfo, err := os.Stat(l.certPath)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Checking %s", l.certPath)
+	}
+
+	keyInfo, err := os.Stat(l.keyPath)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Checking %s", l.keyPath)
+	}
+
+	if l.cert != nil && certInfo.ModTime().Equal(l.certModTime) && keyInfo.ModTime().Equal(l.keyModTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(l.certPath, l.keyPath)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Loading %s and %s", l.certPath, l.keyPath)
+	}
+
+	if l.cert != nil {
+		l.logger.Info(certLoaderLogTag, "Reloaded certificate from %s", l.certPath)
+	}
+
+	l.cert = &cert
+	l.certModTime = certInfo.ModTime()
+	l.keyModTime = keyInfo.ModTime()
+
+	return nil
+}
@@ -2,6 +2,8 @@ package httpsdispatcher
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
@@ -10,17 +12,52 @@ import (
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
 )
 
+const (
+	defaultCertPath = "agent.cert"
+	defaultKeyPath  = "agent.key"
+)
+
+// TLSOptions configure certificate served by the dispatcher
+// and verification of client certificates
+type TLSOptions struct {
+	// Default to agent.cert and agent.key in the working directory.
+	// Files are reloaded once they change so that certificate
+	// can be rotated without restarting the agent.
+	CertPath string
+	KeyPath  string
+
+	// PEM encoded CAs that sign client certificates. Clients without
+	// certificate are still accepted unless RequireClientCert is set.
+	ClientCAPath      string
+	RequireClientCert bool
+}
+
+func (o TLSOptions) certPath() string {
+	if o.CertPath != "" {
+		return o.CertPath
+	}
+	return defaultCertPath
+}
+
+func (o TLSOptions) keyPath() string {
+	if o.KeyPath != "" {
+		return o.KeyPath
+	}
+	return defaultKeyPath
+}
+
 type HTTPSDispatcher struct {
 	httpServer *http.Server
 	mux        *http.ServeMux
 	host       string
+	options    TLSOptions
 	listener   net.Listener
 	logger     boshlog.Logger
 }
 
 type HTTPHandlerFunc func(writer http.ResponseWriter, request *http.Request)
 
-func NewHTTPSDispatcher(baseURL *url.URL, logger boshlog.Logger) *HTTPSDispatcher {
+func NewHTTPSDispatcher(baseURL *url.URL, options TLSOptions, logger boshlog.Logger) *HTTPSDispatcher {
 	tlsConfig := &tls.Config{
 		// SSLv3 is insecure due to BEAST and POODLE attacks
 		MinVersion: tls.VersionTLS10,
@@ -38,10 +75,10 @@ func NewHTTPSDispatcher(baseURL *url.URL, logger boshlog.Logger) *HTTPSDispatche
 		},
 		PreferServerCipherSuites: true,
 	}
-	return NewHTTPSDispatcherWithConfig(tlsConfig, baseURL, logger)
+	return NewHTTPSDispatcherWithConfig(tlsConfig, baseURL, options, logger)
 }
 
-func NewHTTPSDispatcherWithConfig(tlsConfig *tls.Config, baseURL *url.URL, logger boshlog.Logger) *HTTPSDispatcher {
+func NewHTTPSDispatcherWithConfig(tlsConfig *tls.Config, baseURL *url.URL, options TLSOptions, logger boshlog.Logger) *HTTPSDispatcher {
 	httpServer := &http.Server{
 		TLSConfig: tlsConfig,
 	}
@@ -52,18 +89,15 @@ func NewHTTPSDispatcherWithConfig(tlsConfig *tls.Config, baseURL *url.URL, logge
 		httpServer: httpServer,
 		mux:        mux,
 		host:       baseURL.Host,
+		options:    options,
 		logger:     logger,
 	}
 }
 
 func (h *HTTPSDispatcher) Start() error {
-	tcpListener, err := net.Listen("tcp", h.host)
-	if err != nil {
-		return bosherr.WrapError(err, "Starting HTTP listener")
-	}
-	h.listener = tcpListener
+	certLoader := newCertLoader(h.options.certPath(), h.options.keyPath(), h.logger)
 
-	cert, err := tls.LoadX509KeyPair("agent.cert", "agent.key")
+	_, err := certLoader.GetCertificate(nil)
 	if err != nil {
 		return bosherr.WrapError(err, "Loading agent SSL cert")
 	}
@@ -71,7 +105,27 @@ func (h *HTTPSDispatcher) Start() error {
 	// update the server config with the cert
 	config := h.httpServer.TLSConfig
 	config.NextProtos = []string{"http/1.1"}
-	config.Certificates = []tls.Certificate{cert}
+	config.GetCertificate = certLoader.GetCertificate
+
+	if h.options.ClientCAPath != "" {
+		clientCAs, err := loadCertPool(h.options.ClientCAPath)
+		if err != nil {
+			return bosherr.WrapError(err, "Loading client CA certificates")
+		}
+
+		config.ClientCAs = clientCAs
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+
+		if h.options.RequireClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	tcpListener, err := net.Listen("tcp", h.host)
+	if err != nil {
+		return bosherr.WrapError(err, "Starting HTTP listener")
+	}
+	h.listener = tcpListener
 
 	tlsListener := tls.NewListener(tcpListener, config)
 
@@ -88,3 +142,18 @@ func (h *HTTPSDispatcher) Stop() {
 func (h *HTTPSDispatcher) AddRoute(route string, handler HTTPHandlerFunc) {
 	h.mux.HandleFunc(route, handler)
 }
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, bosherr.WrapErrorf(err, "Reading %s", path)
+	}
+
+	pool := x509.NewCertPool()
+
+	if !pool.AppendCertsFromPEM(contents) {
+		return nil, bosherr.Errorf("No PEM encoded certificates found in %s", path)
+	}
+
+	return pool, nil
+}
@@ -3,9 +3,12 @@ package httpsdispatcher_test
 import (
 	"crypto/tls"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -39,7 +42,7 @@ var _ = Describe("HTTPSDispatcher", func() {
 		logger := boshlog.NewLogger(boshlog.LevelNone)
 		serverURL, err := url.Parse(targetURL)
 		Expect(err).ToNot(HaveOccurred())
-		dispatcher = boshdispatcher.NewHTTPSDispatcher(serverURL, logger)
+		dispatcher = boshdispatcher.NewHTTPSDispatcher(serverURL, boshdispatcher.TLSOptions{}, logger)
 
 		errChan := make(chan error)
 		go func() {
@@ -196,6 +199,137 @@ var _ = Describe("HTTPSDispatcher", func() {
 	})
 })
 
+var _ = Describe("HTTPSDispatcher with TLS options", func() {
+	var (
+		options    boshdispatcher.TLSOptions
+		dispatcher *boshdispatcher.HTTPSDispatcher
+		certDir    string
+	)
+
+	BeforeEach(func() {
+		var err error
+		certDir, err = ioutil.TempDir("", "https-dispatcher")
+		Expect(err).ToNot(HaveOccurred())
+
+		copyFile("agent.cert", filepath.Join(certDir, "agent.cert"))
+		copyFile("agent.key", filepath.Join(certDir, "agent.key"))
+
+		options = boshdispatcher.TLSOptions{
+			CertPath: filepath.Join(certDir, "agent.cert"),
+			KeyPath:  filepath.Join(certDir, "agent.key"),
+		}
+	})
+
+	JustBeforeEach(func() {
+		logger := boshlog.NewLogger(boshlog.LevelNone)
+		serverURL, err := url.Parse(targetURL)
+		Expect(err).ToNot(HaveOccurred())
+		dispatcher = boshdispatcher.NewHTTPSDispatcher(serverURL, options, logger)
+		dispatcher.AddRoute("/example", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(200) })
+
+		errChan := make(chan error)
+		go func() {
+			errChan <- dispatcher.Start()
+		}()
+
+		select {
+		case err := <-errChan:
+			Expect(err).ToNot(HaveOccurred())
+		case <-time.After(1 * time.Second):
+			// server should now be running, continue
+		}
+	})
+
+	AfterEach(func() {
+		dispatcher.Stop()
+		time.Sleep(1 * time.Second)
+		os.RemoveAll(certDir)
+	})
+
+	It("serves certificate from configured paths", func() {
+		Expect(servedCertificate()).To(Equal(loadCertificate("agent.cert", "agent.key")))
+	})
+
+	It("serves certificate that replaced the configured one", func() {
+		Expect(servedCertificate()).To(Equal(loadCertificate("agent.cert", "agent.key")))
+
+		copyFile("rotated_agent.cert", options.CertPath)
+		copyFile("rotated_agent.key", options.KeyPath)
+
+		// Make sure modification is noticed regardless of timestamp resolution
+		modTime := time.Now().Add(time.Minute)
+		Expect(os.Chtimes(options.CertPath, modTime, modTime)).To(Succeed())
+		Expect(os.Chtimes(options.KeyPath, modTime, modTime)).To(Succeed())
+
+		Expect(servedCertificate()).To(Equal(loadCertificate("rotated_agent.cert", "rotated_agent.key")))
+	})
+
+	It("keeps serving current certificate when replacement cannot be loaded", func() {
+		Expect(ioutil.WriteFile(options.CertPath, []byte("fake-invalid-cert"), 0600)).To(Succeed())
+
+		modTime := time.Now().Add(time.Minute)
+		Expect(os.Chtimes(options.CertPath, modTime, modTime)).To(Succeed())
+
+		Expect(servedCertificate()).To(Equal(loadCertificate("agent.cert", "agent.key")))
+	})
+
+	Context("when client certificate is required", func() {
+		BeforeEach(func() {
+			options.ClientCAPath = "rotated_agent.cert"
+			options.RequireClientCert = true
+		})
+
+		It("rejects clients without certificate", func() {
+			client := getHTTPClient()
+			_, err := client.Get(targetURL + "/example")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+var _ = Describe("HTTPSDispatcher with invalid TLS options", func() {
+	var (
+		options boshdispatcher.TLSOptions
+	)
+
+	start := func() error {
+		logger := boshlog.NewLogger(boshlog.LevelNone)
+		serverURL, err := url.Parse(targetURL)
+		Expect(err).ToNot(HaveOccurred())
+		return boshdispatcher.NewHTTPSDispatcher(serverURL, options, logger).Start()
+	}
+
+	It("fails to start when certificate cannot be loaded", func() {
+		options = boshdispatcher.TLSOptions{CertPath: "missing-agent.cert"}
+		Expect(start()).To(MatchError(ContainSubstring("Loading agent SSL cert")))
+	})
+
+	It("fails to start when client CA cannot be loaded", func() {
+		options = boshdispatcher.TLSOptions{ClientCAPath: "missing-ca.cert"}
+		Expect(start()).To(MatchError(ContainSubstring("Loading client CA certificates")))
+	})
+})
+
+func copyFile(src, dst string) {
+	contents, err := ioutil.ReadFile(src)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(ioutil.WriteFile(dst, contents, 0600)).To(Succeed())
+}
+
+func loadCertificate(certPath, keyPath string) []byte {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	Expect(err).ToNot(HaveOccurred())
+	return cert.Certificate[0]
+}
+
+func servedCertificate() []byte {
+	conn, err := tls.Dial("tcp", "127.0.0.1:7789", &tls.Config{InsecureSkipVerify: true})
+	Expect(err).ToNot(HaveOccurred())
+	defer conn.Close()
+
+	return conn.ConnectionState().PeerCertificates[0].Raw
+}
+
 func getHTTPClient() http.Client {
 	tlsConfig := &tls.Config{
 		InsecureSkipVerify: true,
@@ -14,6 +14,7 @@ import (
 	"github.com/cloudfoundry/bosh-utils/httpclient"
 	"github.com/cloudfoundry/bosh-utils/logger"
 	boshsys "github.com/cloudfoundry/bosh-utils/system"
+	boshuuid "github.com/cloudfoundry/bosh-utils/uuid"
 )
 
 type TestEnvironment struct {
@@ -295,7 +296,7 @@ func (t *TestEnvironment) StartAgentTunnel(mbusUser, mbusPass string, mbusPort i
 
 	httpClient := httpclient.NewHTTPClient(httpclient.DefaultClient, t.logger)
 	mbusURL := fmt.Sprintf("https://%s:%s@localhost:16868", mbusUser, mbusPass)
-	client := http.NewAgentClient(mbusURL, "fake-director-uuid", 1*time.Second, 10, httpClient, t.logger)
+	client := http.NewAgentClient(mbusURL, "fake-director-uuid", 1*time.Second, 10, httpClient, nil, nil, boshuuid.NewGenerator(), t.logger)
 
 	for i := 1; i < 1000000; i++ {
 		t.logger.Debug("test environment", "Trying to contact agent via ssh tunnel...")
@@ -154,6 +154,6 @@ func (d *dummyNatsJobSupervisor) statusHandler(req boshhandler.Request) boshhand
 
 		return nil
 	default:
-		return nil
+		return boshhandler.NotHandled
 	}
 }
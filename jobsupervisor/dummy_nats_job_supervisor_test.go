@@ -45,7 +45,8 @@ var _ = Describe("dummyNatsJobSupervisor", func() {
 
 		It("does not change the status given other messages", func() {
 			statusMessage := boshhandler.NewRequest("", "some_other_message", []byte(`{"status":"failing"}`))
-			handler.RegisteredAdditionalFunc(statusMessage)
+			resp := handler.RegisteredAdditionalFunc(statusMessage)
+			Expect(resp).To(Equal(boshhandler.NotHandled))
 			Expect(dummyNats.Status()).To(Equal("running"))
 		})
 	})
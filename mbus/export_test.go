@@ -0,0 +1,105 @@
+package mbus
+
+// Exports private connection provider and handlers so that they
+// can be tested with controlled delays from the mbus_test package
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/yagnats"
+	"github.com/pivotal-golang/clock"
+
+	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
+	boshplatform "github.com/cloudfoundry/bosh-agent/platform"
+	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+)
+
+func NewReconnectingConnectionProviderWithSleep(
+	connInfo *yagnats.ConnectionInfo,
+	initialDelay, maxDelay time.Duration,
+	sleep func(time.Duration),
+) *ReconnectingConnectionProvider {
+	provider := newReconnectingConnectionProvider(connInfo, boshhandler.NewBackoff(initialDelay, maxDelay))
+	provider.sleep = sleep
+	return provider
+}
+
+// NewNatsHandlerWithConnectDelay lets tests retry first connection without waiting
+func NewNatsHandlerWithConnectDelay(
+	settingsService boshsettings.Service,
+	client yagnats.NATSClient,
+	outboundQueue boshhandler.OutboundQueue,
+	connectDelay time.Duration,
+	logger boshlog.Logger,
+	platform boshplatform.Platform,
+) Handler {
+	handler := newNatsHandlerForURL("", settingsService, client, outboundQueue, nil, nil, logger, platform)
+	handler.connectInitialDelay = connectDelay
+	handler.connectMaxDelay = connectDelay
+	return handler
+}
+
+// NewHandlerProviderWithClock lets tests build the same message signer as the provider
+func NewHandlerProviderWithClock(settingsService boshsettings.Service, options Options, timeService clock.Clock, logger boshlog.Logger) HandlerProvider {
+	provider := NewHandlerProvider(settingsService, options, logger)
+	provider.timeService = timeService
+	return provider
+}
+
+// NewUnixSocketHandlerWithAgentUID lets tests pretend that agent
+// runs as a different user than the one connecting to the socket
+func NewUnixSocketHandlerWithAgentUID(path string, options UnixSocketOptions, agentUID uint32, logger boshlog.Logger) boshhandler.Handler {
+	handler := NewUnixSocketHandler(path, options, logger).(*unixSocketHandler)
+	handler.agentUID = agentUID
+	return handler
+}
+
+func NewFailoverHandlerWithInterval(endpoints []Endpoint, healthCheckInterval time.Duration, logger boshlog.Logger) boshhandler.Handler {
+	handler := NewFailoverHandler(endpoints, FailoverOptions{}, logger).(*failoverHandler)
+	handler.healthCheckInterval = healthCheckInterval
+	return handler
+}
+
+func NewWebsocketHandlerWithPingInterval(
+	mbusURL string,
+	settingsService boshsettings.Service,
+	outboundQueue boshhandler.OutboundQueue,
+	pingInterval time.Duration,
+	logger boshlog.Logger,
+) Handler {
+	handler := NewWebsocketHandler(mbusURL, settingsService, outboundQueue, nil, nil, WebsocketOptions{}, logger).(*websocketHandler)
+	handler.pingInterval = pingInterval
+	return handler
+}
+
+// WebsocketServerConn lets tests act as the director that agent connects to
+type WebsocketServerConn struct {
+	*websocketConn
+}
+
+func AcceptWebsocket(w http.ResponseWriter, r *http.Request) (WebsocketServerConn, error) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return WebsocketServerConn{}, errors.New("Request does not upgrade to websocket")
+	}
+
+	conn, bufrw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return WebsocketServerConn{}, err
+	}
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + websocketAcceptKey(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n"
+
+	_, err = conn.Write([]byte(response))
+	if err != nil {
+		return WebsocketServerConn{}, err
+	}
+
+	return WebsocketServerConn{newWebsocketConn(conn, bufrw.Reader, false)}, nil
+}
@@ -0,0 +1,367 @@
+package mbus
+
+import (
+	"crypto/sha256"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
+	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+)
+
+const (
+	failoverHandlerLogTag = "failoverHandler"
+
+	defaultHealthCheckIntervalSeconds = 10
+
+	// Copies of a request published to a NATS cluster reach the agent
+	// over every endpoint at about the same time
+	duplicateRequestWindow = 30 * time.Second
+)
+
+type FailoverOptions struct {
+	// How often endpoints are checked (and endpoints that
+	// failed to start are started again); defaults to 10 seconds
+	HealthCheckIntervalSeconds int
+}
+
+func (o FailoverOptions) healthCheckInterval() time.Duration {
+	if o.HealthCheckIntervalSeconds > 0 {
+		return time.Duration(o.HealthCheckIntervalSeconds) * time.Second
+	}
+	return defaultHealthCheckIntervalSeconds * time.Second
+}
+
+// EndpointStatusReporter is implemented by handlers
+// that fail over between several mbus endpoints
+type EndpointStatusReporter interface {
+	EndpointStatus() EndpointStatus
+}
+
+type EndpointStatus struct {
+	// URL of the endpoint that messages are sent to
+	Active    string           `json:"active"`
+	Endpoints []EndpointHealth `json:"endpoints"`
+}
+
+type EndpointHealth struct {
+	URL     string `json:"url"`
+	Healthy bool   `json:"healthy"`
+}
+
+// Endpoint is a handler connected to one of the mbus URLs
+type Endpoint struct {
+	URL     string
+	Handler boshhandler.Handler
+}
+
+type failoverEndpoint struct {
+	Endpoint
+
+	running bool
+	healthy bool
+}
+
+type receivedRequest struct {
+	endpoint   int
+	receivedAt time.Time
+}
+
+// failoverHandler receives requests from all endpoints so that
+// director can reach the agent over any of them. Messages sent by
+// the agent go to the first healthy endpoint in order of preference;
+// agent goes back to preferred endpoint once it is healthy again.
+// Request that already came over another endpoint is dropped so that
+// actions are not run (and answered) once per endpoint.
+type failoverHandler struct {
+	endpoints []*failoverEndpoint
+	active    int
+
+	received     map[[sha256.Size]byte]receivedRequest
+	receivedLock sync.Mutex
+
+	healthCheckInterval time.Duration
+
+	handlerFunc boshhandler.Func
+	stopCh      chan struct{}
+
+	lock   sync.Mutex
+	logger boshlog.Logger
+}
+
+func NewFailoverHandler(endpoints []Endpoint, options FailoverOptions, logger boshlog.Logger) boshhandler.Handler {
+	handler := &failoverHandler{
+		healthCheckInterval: options.healthCheckInterval(),
+		received:            map[[sha256.Size]byte]receivedRequest{},
+		logger:              logger,
+	}
+
+	for _, endpoint := range endpoints {
+		endpoint.URL = boshhandler.RedactedURL(endpoint.URL)
+		handler.endpoints = append(handler.endpoints, &failoverEndpoint{Endpoint: endpoint})
+	}
+
+	return handler
+}
+
+func (h *failoverHandler) Run(handlerFunc boshhandler.Func) error {
+	err := h.Start(handlerFunc)
+	if err != nil {
+		return bosherr.WrapError(err, "Starting failover handler")
+	}
+
+	defer h.Stop()
+
+	h.lock.Lock()
+	stopCh := h.stopCh
+	h.lock.Unlock()
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(c)
+
+	select {
+	case <-c:
+	case <-stopCh:
+	}
+
+	return nil
+}
+
+// Start starts all endpoints; endpoints that fail to start
+// are started again during following health checks
+func (h *failoverHandler) Start(handlerFunc boshhandler.Func) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.stopCh != nil {
+		return bosherr.Error("Failover handler is already started")
+	}
+
+	h.handlerFunc = handlerFunc
+	h.stopCh = make(chan struct{})
+
+	for i := range h.endpoints {
+		h.startEndpoint(i)
+	}
+
+	go h.checkEndpoints(h.stopCh)
+
+	return nil
+}
+
+func (h *failoverHandler) Stop() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.stopCh == nil {
+		return
+	}
+
+	close(h.stopCh)
+	h.stopCh = nil
+
+	for _, endpoint := range h.endpoints {
+		if endpoint.running {
+			endpoint.Handler.Stop()
+			endpoint.running = false
+		}
+	}
+}
+
+func (h *failoverHandler) RegisterAdditionalFunc(handlerFunc boshhandler.Func) {
+	for _, endpoint := range h.endpoints {
+		endpoint.Handler.RegisterAdditionalFunc(handlerFunc)
+	}
+}
+
+func (h *failoverHandler) Send(target boshhandler.Target, topic boshhandler.Topic, message interface{}) error {
+	h.lock.Lock()
+	endpoint := h.endpoints[h.active]
+	h.lock.Unlock()
+
+	return endpoint.Handler.Send(target, topic, message)
+}
+
+func (h *failoverHandler) EndpointStatus() EndpointStatus {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	status := EndpointStatus{Active: h.endpoints[h.active].URL}
+
+	for _, endpoint := range h.endpoints {
+		status.Endpoints = append(status.Endpoints, EndpointHealth{
+			URL:     endpoint.URL,
+			Healthy: endpoint.healthy,
+		})
+	}
+
+	return status
+}
+
+// QueueStats adds up messages queued by all endpoints since messages
+// queued before failing over are sent once endpoint is healthy again
+func (h *failoverHandler) QueueStats() boshhandler.QueueStats {
+	var stats boshhandler.QueueStats
+
+	for _, endpoint := range h.endpoints {
+		if statsProvider, ok := endpoint.Handler.(boshhandler.QueueStatsProvider); ok {
+			endpointStats := statsProvider.QueueStats()
+			stats.Depth += endpointStats.Depth
+			stats.Dropped += endpointStats.Dropped
+			stats.Coalesced += endpointStats.Coalesced
+		}
+	}
+
+	return stats
+}
+
+func (h *failoverHandler) UpdateCert(cert boshsettings.CertKeyPair) error {
+	for _, endpoint := range h.endpoints {
+		if certUpdater, ok := endpoint.Handler.(CertUpdater); ok {
+			err := certUpdater.UpdateCert(cert)
+			if err != nil {
+				return bosherr.WrapErrorf(err, "Updating certificates of mbus endpoint %s", endpoint.URL)
+			}
+		}
+	}
+
+	return nil
+}
+
+// startEndpoint expects lock to be held. Handlers such as HTTPS handler
+// keep serving in Start so each endpoint is started in the background.
+func (h *failoverHandler) startEndpoint(index int) {
+	endpoint := h.endpoints[index]
+	endpoint.running = true
+
+	handlerFunc := h.withoutDuplicates(index, h.handlerFunc)
+	stopCh := h.stopCh
+
+	go func() {
+		err := endpoint.Handler.Start(handlerFunc)
+		if err == nil {
+			return
+		}
+
+		h.lock.Lock()
+		defer h.lock.Unlock()
+
+		endpoint.running = false
+		endpoint.healthy = false
+
+		select {
+		case <-stopCh:
+			// Handlers that keep serving return an error once they are stopped
+		default:
+			h.logger.Error(failoverHandlerLogTag, "Starting mbus endpoint %s: %s", endpoint.URL, err.Error())
+		}
+	}()
+}
+
+// withoutDuplicates returns handler func that answers nothing to requests
+// that were recently received over another endpoint. Since endpoints run
+// the primary func before additional funcs, dropped requests reach no func.
+// Requests received again over the same endpoint are retries and are handled.
+func (h *failoverHandler) withoutDuplicates(index int, handlerFunc boshhandler.Func) boshhandler.Func {
+	return func(req boshhandler.Request) boshhandler.Response {
+		key := sha256.Sum256(req.Payload)
+		now := time.Now()
+
+		h.receivedLock.Lock()
+
+		for receivedKey, received := range h.received {
+			if now.Sub(received.receivedAt) > duplicateRequestWindow {
+				delete(h.received, receivedKey)
+			}
+		}
+
+		received, found := h.received[key]
+		if !found || received.endpoint == index {
+			h.received[key] = receivedRequest{endpoint: index, receivedAt: now}
+		}
+
+		h.receivedLock.Unlock()
+
+		if found && received.endpoint != index {
+			h.logger.Info(failoverHandlerLogTag, "Dropping request %s received again over mbus endpoint %s", req.Method, h.endpoints[index].URL)
+			return nil
+		}
+
+		return handlerFunc(req)
+	}
+}
+
+func (h *failoverHandler) checkEndpoints(stopCh <-chan struct{}) {
+	for {
+		select {
+		case <-time.After(h.healthCheckInterval):
+			h.checkEndpointsOnce(stopCh)
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func (h *failoverHandler) checkEndpointsOnce(stopCh <-chan struct{}) {
+	healthy := make([]bool, len(h.endpoints))
+
+	for i, endpoint := range h.endpoints {
+		h.lock.Lock()
+		running := endpoint.running
+		h.lock.Unlock()
+
+		// Checking health may take a while so lock is not held
+		healthy[i] = running && h.isHealthy(endpoint.Handler)
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	select {
+	case <-stopCh:
+		return
+	default:
+	}
+
+	active := h.active
+	foundHealthy := false
+
+	for i, endpoint := range h.endpoints {
+		endpoint.healthy = healthy[i] && endpoint.running
+
+		if endpoint.healthy && !foundHealthy {
+			active = i
+			foundHealthy = true
+		}
+
+		if !endpoint.running {
+			h.startEndpoint(i)
+		}
+	}
+
+	// Messages keep going to the last active endpoint when no endpoint is healthy
+	if !foundHealthy {
+		h.logger.Warn(failoverHandlerLogTag, "No mbus endpoint is healthy; keeping %s active", h.endpoints[h.active].URL)
+		return
+	}
+
+	if active != h.active {
+		h.logger.Info(failoverHandlerLogTag, "Switching active mbus endpoint from %s to %s", h.endpoints[h.active].URL, h.endpoints[active].URL)
+		h.active = active
+	}
+}
+
+func (h *failoverHandler) isHealthy(handler boshhandler.Handler) bool {
+	if healthChecker, ok := handler.(boshhandler.HealthChecker); ok {
+		return healthChecker.Healthy()
+	}
+
+	// Handlers that cannot be checked are healthy while they are running
+	return true
+}
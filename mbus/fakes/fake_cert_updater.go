@@ -0,0 +1,17 @@
+package fakes
+
+import (
+	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
+)
+
+type FakeCertUpdater struct {
+	UpdateCertCert   boshsettings.CertKeyPair
+	UpdateCertCalled bool
+	UpdateCertErr    error
+}
+
+func (u *FakeCertUpdater) UpdateCert(cert boshsettings.CertKeyPair) error {
+	u.UpdateCertCalled = true
+	u.UpdateCertCert = cert
+	return u.UpdateCertErr
+}
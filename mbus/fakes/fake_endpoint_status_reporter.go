@@ -0,0 +1,13 @@
+package fakes
+
+import (
+	boshmbus "github.com/cloudfoundry/bosh-agent/mbus"
+)
+
+type FakeEndpointStatusReporter struct {
+	Status boshmbus.EndpointStatus
+}
+
+func (r *FakeEndpointStatusReporter) EndpointStatus() boshmbus.EndpointStatus {
+	return r.Status
+}
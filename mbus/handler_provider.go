@@ -1,59 +1,182 @@
 package mbus
 
 import (
+	"fmt"
 	"net/url"
+	"path/filepath"
 
 	"github.com/cloudfoundry/yagnats"
+	"github.com/pivotal-golang/clock"
 
 	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
 	boshmicro "github.com/cloudfoundry/bosh-agent/micro"
 	boshplatform "github.com/cloudfoundry/bosh-agent/platform"
 	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
 	boshdir "github.com/cloudfoundry/bosh-agent/settings/directories"
+	boshblob "github.com/cloudfoundry/bosh-utils/blobstore"
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
 )
 
+type Options struct {
+	// Configures how messages sent by the agent are queued
+	// until they are delivered (e.g. while NATS server is down)
+	OutboundQueue boshhandler.OutboundQueueOptions
+
+	// Receive messages sent by the agent when it is reached over HTTPS
+	Webhooks []boshmicro.WebhookOptions
+
+	// Certificates and credentials used when agent is reached over HTTPS
+	HTTPS boshmicro.HTTPSOptions
+
+	// Lets tooling on the VM send requests to the agent over
+	// a unix socket alongside the NATS or HTTPS handler
+	UnixSocket UnixSocketOptions
+
+	// Configures connection that agent dials out to the director
+	// with when it cannot be reached (e.g. behind NAT)
+	Websocket WebsocketOptions
+
+	// Configures health checking of endpoints when
+	// several mbus URLs are given in settings
+	Failover FailoverOptions
+}
+
 type HandlerProvider struct {
 	settingsService boshsettings.Service
+	options         Options
+	timeService     clock.Clock
 	logger          boshlog.Logger
 	handler         boshhandler.Handler
 }
 
 func NewHandlerProvider(
 	settingsService boshsettings.Service,
+	options Options,
 	logger boshlog.Logger,
 ) (p HandlerProvider) {
 	p.settingsService = settingsService
+	p.options = options
+	p.timeService = clock.NewClock()
 	p.logger = logger
 	return
 }
 
+// Get returns handler for the mbus in settings; responses that are too
+// large to be sent over the mbus are uploaded to the given blobstore.
+// Handler fails over between endpoints when several mbus URLs are given.
 func (p HandlerProvider) Get(
 	platform boshplatform.Platform,
 	dirProvider boshdir.Provider,
+	blobstore boshblob.Blobstore,
 ) (handler boshhandler.Handler, err error) {
 	if p.handler != nil {
 		handler = p.handler
 		return
 	}
 
-	mbusURL, err := url.Parse(p.settingsService.GetSettings().Mbus)
+	settings := p.settingsService.GetSettings()
+
+	signer, err := NewMessageSigner(settings.Env.GetMbusSigning(), settings.AgentID, p.timeService)
 	if err != nil {
-		err = bosherr.WrapError(err, "Parsing handler URL")
+		err = bosherr.WrapError(err, "Building message signer")
+		return
+	}
+
+	mbusURLs := settings.GetMbusURLs()
+
+	if len(mbusURLs) <= 1 {
+		mbusURL := settings.Mbus
+		if len(mbusURLs) == 1 {
+			mbusURL = mbusURLs[0]
+		}
+
+		handler, err = p.getForURL(mbusURL, 0, signer, platform, dirProvider, blobstore)
+		if err != nil {
+			return
+		}
+
+		p.handler = handler
 		return
 	}
 
+	var endpoints []Endpoint
+
+	for i, mbusURL := range mbusURLs {
+		var endpointHandler boshhandler.Handler
+
+		endpointHandler, err = p.getForURL(mbusURL, i, signer, platform, dirProvider, blobstore)
+		if err != nil {
+			err = bosherr.WrapErrorf(err, "Getting handler for mbus endpoint %s", boshhandler.RedactedURL(mbusURL))
+			return
+		}
+
+		endpoints = append(endpoints, Endpoint{URL: mbusURL, Handler: endpointHandler})
+	}
+
+	handler = NewFailoverHandler(endpoints, p.options.Failover, p.logger)
+
+	p.handler = handler
+
+	return
+}
+
+func (p HandlerProvider) getForURL(
+	rawMbusURL string,
+	index int,
+	signer *boshhandler.MessageSigner,
+	platform boshplatform.Platform,
+	dirProvider boshdir.Provider,
+	blobstore boshblob.Blobstore,
+) (boshhandler.Handler, error) {
+	mbusURL, err := url.Parse(rawMbusURL)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Parsing handler URL")
+	}
+
+	responseOverflow := boshhandler.NewBlobstoreResponseOverflow(blobstore, platform.GetFs())
+
+	// Each endpoint keeps its own queue so that messages queued
+	// before failing over are sent once the endpoint is back
+	queueFileName := "mbus_outbound_queue.json"
+	if index > 0 {
+		queueFileName = fmt.Sprintf("mbus_outbound_queue_%d.json", index)
+	}
+
+	newOutboundQueue := func() boshhandler.OutboundQueue {
+		return boshhandler.NewOutboundQueue(
+			p.options.OutboundQueue,
+			platform.GetFs(),
+			filepath.Join(dirProvider.BoshDir(), queueFileName),
+			p.logger,
+		)
+	}
+
 	switch mbusURL.Scheme {
-	case "nats":
-		handler = NewNatsHandler(p.settingsService, yagnats.NewClient(), p.logger, platform)
+	case "nats", "tls":
+		return newNatsHandlerForURL(rawMbusURL, p.settingsService, yagnats.NewClient(), newOutboundQueue(), responseOverflow, signer, p.logger, platform), nil
+
+	case "wss":
+		return NewWebsocketHandler(rawMbusURL, p.settingsService, newOutboundQueue(), responseOverflow, signer, p.options.Websocket, p.logger), nil
+
 	case "https":
-		handler = boshmicro.NewHTTPSHandler(mbusURL, p.logger, platform.GetFs(), dirProvider)
+		webhookSender, err := boshmicro.NewWebhookSender(p.options.Webhooks, p.settingsService.GetSettings().AgentID, p.options.OutboundQueue, platform.GetFs(), dirProvider.BoshDir(), p.logger)
+		if err != nil {
+			return nil, bosherr.WrapError(err, "Building webhook sender")
+		}
+
+		return boshmicro.NewHTTPSHandler(mbusURL, p.options.HTTPS, responseOverflow, signer, webhookSender, p.logger, platform.GetFs(), dirProvider), nil
+
 	default:
-		err = bosherr.Errorf("Message Bus Handler with scheme %s could not be found", mbusURL.Scheme)
+		return nil, bosherr.Errorf("Message Bus Handler with scheme %s could not be found", mbusURL.Scheme)
 	}
+}
 
-	p.handler = handler
+// GetUnixSocketHandler returns nil when unix socket is not enabled
+func (p HandlerProvider) GetUnixSocketHandler(dirProvider boshdir.Provider) boshhandler.Handler {
+	if !p.options.UnixSocket.Enabled {
+		return nil
+	}
 
-	return
+	return NewUnixSocketHandler(filepath.Join(dirProvider.BoshDir(), "agent.sock"), p.options.UnixSocket, p.logger)
 }
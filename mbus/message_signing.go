@@ -0,0 +1,66 @@
+package mbus
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"time"
+
+	"github.com/pivotal-golang/clock"
+
+	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
+	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+// NewMessageSigner returns signer configured with base64 encoded
+// keys from settings; it passes messages through when no keys are given.
+// Only messages addressed to given agent and signed after the signer
+// was built are accepted since seen nonces do not survive restarts.
+func NewMessageSigner(signing boshsettings.MessageSigning, agentID string, timeService clock.Clock) (*boshhandler.MessageSigner, error) {
+	options := boshhandler.MessageSigningOptions{
+		AgentID:                 agentID,
+		Enforce:                 signing.Enforce,
+		MaxAge:                  time.Duration(signing.MaxAgeSeconds) * time.Second,
+		RejectSignedBeforeStart: true,
+	}
+
+	if signing.HMACKey != "" {
+		hmacKey, err := base64.StdEncoding.DecodeString(signing.HMACKey)
+		if err != nil {
+			return nil, bosherr.WrapError(err, "Decoding HMAC key")
+		}
+
+		options.HMACKey = hmacKey
+	}
+
+	if signing.DirectorPublicKey != "" {
+		publicKey, err := base64.StdEncoding.DecodeString(signing.DirectorPublicKey)
+		if err != nil {
+			return nil, bosherr.WrapError(err, "Decoding director public key")
+		}
+
+		if len(publicKey) != ed25519.PublicKeySize {
+			return nil, bosherr.Errorf("Director public key must be %d bytes long", ed25519.PublicKeySize)
+		}
+
+		options.PublicKey = ed25519.PublicKey(publicKey)
+	}
+
+	if signing.AgentPrivateKey != "" {
+		privateKey, err := base64.StdEncoding.DecodeString(signing.AgentPrivateKey)
+		if err != nil {
+			return nil, bosherr.WrapError(err, "Decoding agent private key")
+		}
+
+		switch len(privateKey) {
+		case ed25519.SeedSize:
+			options.PrivateKey = ed25519.NewKeyFromSeed(privateKey)
+		case ed25519.PrivateKeySize:
+			options.PrivateKey = ed25519.PrivateKey(privateKey)
+		default:
+			return nil, bosherr.Errorf("Agent private key must be %d byte seed or %d byte key", ed25519.SeedSize, ed25519.PrivateKeySize)
+		}
+	}
+
+	return boshhandler.NewMessageSigner(options, timeService), nil
+}
@@ -11,6 +11,7 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/cloudfoundry/yagnats"
 
@@ -38,8 +39,37 @@ type natsHandler struct {
 	client          yagnats.NATSClient
 	platform        boshplatform.Platform
 
-	handlerFuncs     []boshhandler.Func
-	handlerFuncsLock sync.Mutex
+	// NATS server that handler connects to when agent fails
+	// over between several servers; mbus from settings otherwise
+	mbusURL string
+
+	// Sent messages are published from the queue so that
+	// they are not lost while connection is re-established
+	outboundQueue   boshhandler.OutboundQueue
+	publisherStopCh chan struct{}
+	publisherLock   sync.Mutex
+
+	// Stores responses that are too large to be published
+	responseOverflow boshhandler.ResponseOverflow
+
+	// Verifies requests and signs replies
+	signer *boshhandler.MessageSigner
+
+	// Routes requests to the func handler was started with
+	// and funcs registered with RegisterAdditionalFunc
+	chain *boshhandler.Chain
+
+	// First connection is retried with the same backoff as reconnections
+	// until it succeeds or handler is stopped
+	connectInitialDelay time.Duration
+	connectMaxDelay     time.Duration
+	connectStopCh       chan struct{}
+	connectLock         sync.Mutex
+
+	// Set when connection is established over TLS; built once so that
+	// certificates rotated with UpdateCert are kept when handler is restarted
+	tlsDialer     *natsTLSDialer
+	tlsDialerLock sync.Mutex
 
 	logger boshlog.Logger
 	logTag string
@@ -48,13 +78,38 @@ type natsHandler struct {
 func NewNatsHandler(
 	settingsService boshsettings.Service,
 	client yagnats.NATSClient,
+	outboundQueue boshhandler.OutboundQueue,
+	responseOverflow boshhandler.ResponseOverflow,
+	signer *boshhandler.MessageSigner,
 	logger boshlog.Logger,
 	platform boshplatform.Platform,
 ) Handler {
+	return newNatsHandlerForURL("", settingsService, client, outboundQueue, responseOverflow, signer, logger, platform)
+}
+
+func newNatsHandlerForURL(
+	mbusURL string,
+	settingsService boshsettings.Service,
+	client yagnats.NATSClient,
+	outboundQueue boshhandler.OutboundQueue,
+	responseOverflow boshhandler.ResponseOverflow,
+	signer *boshhandler.MessageSigner,
+	logger boshlog.Logger,
+	platform boshplatform.Platform,
+) *natsHandler {
 	return &natsHandler{
+		mbusURL:         mbusURL,
 		settingsService: settingsService,
 		client:          client,
 		platform:        platform,
+		outboundQueue:   outboundQueue,
+		chain:           boshhandler.NewChain(),
+
+		connectInitialDelay: reconnectInitialDelay,
+		connectMaxDelay:     reconnectMaxDelay,
+
+		responseOverflow: responseOverflow,
+		signer:           signer,
 
 		logger: logger,
 		logTag: "NATS Handler",
@@ -75,8 +130,6 @@ func (h *natsHandler) Run(handlerFunc boshhandler.Func) error {
 }
 
 func (h *natsHandler) Start(handlerFunc boshhandler.Func) error {
-	h.RegisterAdditionalFunc(handlerFunc)
-
 	connProvider, err := h.getConnectionInfo()
 	if err != nil {
 		return bosherr.WrapError(err, "Getting connection info")
@@ -96,7 +149,7 @@ func (h *natsHandler) Start(handlerFunc boshhandler.Func) error {
 		}
 	})
 
-	err = h.client.Connect(connProvider)
+	err = h.connect(connProvider)
 	if err != nil {
 		return bosherr.WrapError(err, "Connecting")
 	}
@@ -107,29 +160,22 @@ func (h *natsHandler) Start(handlerFunc boshhandler.Func) error {
 
 	h.logger.Info(h.logTag, "Subscribing to %s", subject)
 
-	_, err = h.client.Subscribe(subject, func(natsMsg *yagnats.Message) {
-		// Do not lock handler funcs around possible network calls!
-		h.handlerFuncsLock.Lock()
-		handlerFuncs := h.handlerFuncs
-		h.handlerFuncsLock.Unlock()
+	chainFunc := h.chain.Func(handlerFunc)
 
-		for _, handlerFunc := range handlerFuncs {
-			h.handleNatsMsg(natsMsg, handlerFunc)
-		}
+	_, err = h.client.Subscribe(subject, func(natsMsg *yagnats.Message) {
+		h.handleNatsMsg(natsMsg, chainFunc)
 	})
 	if err != nil {
 		return bosherr.WrapErrorf(err, "Subscribing to %s", subject)
 	}
 
+	h.startPublisher()
+
 	return nil
 }
 
 func (h *natsHandler) RegisterAdditionalFunc(handlerFunc boshhandler.Func) {
-	// Currently not locking since RegisterAdditionalFunc
-	// is not a primary way of adding handlerFunc.
-	h.handlerFuncsLock.Lock()
-	h.handlerFuncs = append(h.handlerFuncs, handlerFunc)
-	h.handlerFuncsLock.Unlock()
+	h.chain.Register(handlerFunc)
 }
 
 func (h *natsHandler) Send(target boshhandler.Target, topic boshhandler.Topic, message interface{}) error {
@@ -143,19 +189,205 @@ func (h *natsHandler) Send(target boshhandler.Target, topic boshhandler.Topic, m
 
 	settings := h.settingsService.GetSettings()
 
-	subject := fmt.Sprintf("%s.agent.%s.%s", target, topic, settings.AgentID)
-	return h.client.Publish(subject, bytes)
+	outboundMessage := boshhandler.OutboundMessage{
+		Subject: fmt.Sprintf("%s.agent.%s.%s", target, topic, settings.AgentID),
+		Payload: bytes,
+	}
+
+	// Only the latest heartbeat is delivered after reconnecting
+	if topic == boshhandler.Heartbeat {
+		outboundMessage.CoalesceKey = outboundMessage.Subject
+	}
+
+	err = h.outboundQueue.Push(outboundMessage)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Queueing message (target=%s, topic=%s)", target, topic)
+	}
+
+	return nil
+}
+
+// Healthy returns whether NATS server responds to ping
+func (h *natsHandler) Healthy() bool {
+	return h.client.Ping()
+}
+
+func (h *natsHandler) QueueStats() boshhandler.QueueStats {
+	return h.outboundQueue.Stats()
+}
+
+func (h *natsHandler) UpdateCert(cert boshsettings.CertKeyPair) error {
+	h.tlsDialerLock.Lock()
+	tlsDialer := h.tlsDialer
+	h.tlsDialerLock.Unlock()
+
+	if tlsDialer == nil {
+		h.logger.Warn(h.logTag, "Ignoring mbus certificates since NATS connection does not use TLS")
+		return nil
+	}
+
+	err := tlsDialer.UpdateCert(cert)
+	if err != nil {
+		return bosherr.WrapError(err, "Updating NATS certificates")
+	}
+
+	h.logger.Info(h.logTag, "Updated NATS certificates; they will be used once connection is re-established")
+
+	return nil
 }
 
 func (h *natsHandler) Stop() {
+	h.connectLock.Lock()
+	if h.connectStopCh != nil {
+		close(h.connectStopCh)
+		h.connectStopCh = nil
+	}
+	h.connectLock.Unlock()
+
+	h.stopPublisher()
 	h.client.Disconnect()
 }
 
+// connect keeps trying to connect so that agent started while NATS server
+// is unavailable (e.g. while director is being updated) does not exit;
+// it gives up once handler is stopped
+func (h *natsHandler) connect(connInfo *yagnats.ConnectionInfo) error {
+	stopCh := make(chan struct{})
+
+	h.connectLock.Lock()
+	h.connectStopCh = stopCh
+	h.connectLock.Unlock()
+
+	backoff := boshhandler.NewBackoff(h.connectInitialDelay, h.connectMaxDelay)
+	connProvider := newReconnectingConnectionProvider(connInfo, backoff)
+
+	for {
+		err := h.client.Connect(connProvider)
+		if err == nil {
+			return nil
+		}
+
+		h.logger.Error(h.logTag, "Failed to connect to NATS server: %s", err.Error())
+
+		select {
+		case <-time.After(backoff.Next()):
+		case <-stopCh:
+			return err
+		}
+	}
+}
+
+func (h *natsHandler) startPublisher() {
+	h.publisherLock.Lock()
+	defer h.publisherLock.Unlock()
+
+	if h.publisherStopCh != nil {
+		return
+	}
+
+	h.publisherStopCh = make(chan struct{})
+
+	go h.publishQueuedMessages(h.publisherStopCh)
+}
+
+func (h *natsHandler) stopPublisher() {
+	h.publisherLock.Lock()
+	defer h.publisherLock.Unlock()
+
+	if h.publisherStopCh != nil {
+		close(h.publisherStopCh)
+		h.publisherStopCh = nil
+	}
+}
+
+// publishQueuedMessages publishes queued messages in order. Message that
+// cannot be published (e.g. while reconnecting) is kept in the queue
+// and publishing is retried with increasing delay.
+func (h *natsHandler) publishQueuedMessages(stopCh <-chan struct{}) {
+	retryBackoff := boshhandler.NewBackoff(reconnectInitialDelay, reconnectMaxDelay)
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+
+		message, found, err := h.outboundQueue.First()
+		if err != nil {
+			h.logger.Error(h.logTag, "Reading outbound queue: %s", err.Error())
+		}
+
+		if !found {
+			select {
+			case <-h.outboundQueue.Ready():
+				continue
+			case <-stopCh:
+				return
+			}
+		}
+
+		published, err := h.publish(message, stopCh)
+		if !published {
+			return
+		}
+
+		if err != nil {
+			delay := retryBackoff.Next()
+
+			h.logger.Warn(h.logTag, "Publishing to %s failed, retrying in %s (%d messages queued): %s",
+				message.Subject, delay, h.outboundQueue.Stats().Depth, err.Error())
+
+			select {
+			case <-time.After(delay):
+				continue
+			case <-stopCh:
+				return
+			}
+		}
+
+		retryBackoff.Reset()
+
+		err = h.outboundQueue.Remove(message.ID)
+		if err != nil {
+			h.logger.Error(h.logTag, "Removing published message from outbound queue: %s", err.Error())
+		}
+	}
+}
+
+// publish gives up waiting once publisher is stopped since yagnats client
+// blocks publishing while it is disconnected until it reconnects. Abandoned
+// message is kept in the queue hence it may be published again once
+// publisher is started if abandoned publish goes through after reconnecting.
+func (h *natsHandler) publish(message boshhandler.OutboundMessage, stopCh <-chan struct{}) (bool, error) {
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- h.client.Publish(message.Subject, message.Payload)
+	}()
+
+	select {
+	case err := <-errCh:
+		return true, err
+	case <-stopCh:
+		return false, nil
+	}
+}
+
 func (h *natsHandler) handleNatsMsg(natsMsg *yagnats.Message, handlerFunc boshhandler.Func) {
+	// Reply subject is part of the request so forged
+	// requests are dropped without replying to anyone
+	reqBytes, err := h.signer.Open(natsMsg.Payload)
+	if err != nil {
+		h.logger.Error(h.logTag, "Verifying request: %s", err.Error())
+		return
+	}
+
 	respBytes, req, err := boshhandler.PerformHandlerWithJSON(
-		natsMsg.Payload,
-		handlerFunc,
-		responseMaxLength,
+		reqBytes,
+		boshhandler.WithRequestSource(boshhandler.RequestSource{Transport: "nats"}, handlerFunc),
+		h.signer.MaxPayloadLength(responseMaxLength),
+		h.responseOverflow,
 		h.logger,
 	)
 	if err != nil {
@@ -164,6 +396,12 @@ func (h *natsHandler) handleNatsMsg(natsMsg *yagnats.Message, handlerFunc boshha
 	}
 
 	if len(respBytes) > 0 {
+		respBytes, err = h.signer.Sign(respBytes)
+		if err != nil {
+			h.logger.Error(h.logTag, "Signing response: %s", err.Error())
+			return
+		}
+
 		err = h.client.Publish(req.ReplyTo, respBytes)
 		if err != nil {
 			h.logger.Error(h.logTag, "Publishing to the client: %s", err.Error())
@@ -190,7 +428,12 @@ func (h *natsHandler) runUntilInterrupted() {
 func (h *natsHandler) getConnectionInfo() (*yagnats.ConnectionInfo, error) {
 	settings := h.settingsService.GetSettings()
 
-	natsURL, err := url.Parse(settings.Mbus)
+	mbusURL := h.mbusURL
+	if mbusURL == "" {
+		mbusURL = settings.Mbus
+	}
+
+	natsURL, err := url.Parse(mbusURL)
 	if err != nil {
 		return nil, bosherr.WrapError(err, "Parsing Nats URL")
 	}
@@ -208,5 +451,44 @@ func (h *natsHandler) getConnectionInfo() (*yagnats.ConnectionInfo, error) {
 		connInfo.Username = user.Username()
 	}
 
+	cert := settings.Env.GetMbusCert()
+
+	// Configured CA implies TLS so that traffic is never sent in plain text by mistake
+	if natsURL.Scheme == "tls" || cert.CA != "" {
+		tlsDialer, err := h.getTLSDialer(natsURL.Host, cert)
+		if err != nil {
+			return nil, bosherr.WrapError(err, "Configuring TLS")
+		}
+
+		h.logger.Info(h.logTag, "Connecting to %s over TLS", natsURL.Host)
+
+		connInfo.Dial = tlsDialer.Dial
+	}
+
 	return connInfo, nil
 }
+
+// getTLSDialer builds dialer with certificates from settings when handler
+// is started for the first time; later starts keep using the same dialer
+func (h *natsHandler) getTLSDialer(host string, cert boshsettings.CertKeyPair) (*natsTLSDialer, error) {
+	h.tlsDialerLock.Lock()
+	defer h.tlsDialerLock.Unlock()
+
+	if h.tlsDialer != nil {
+		return h.tlsDialer, nil
+	}
+
+	serverName, _, err := net.SplitHostPort(host)
+	if err != nil {
+		serverName = host
+	}
+
+	tlsDialer, err := newNatsTLSDialer(serverName, cert)
+	if err != nil {
+		return nil, err
+	}
+
+	h.tlsDialer = tlsDialer
+
+	return tlsDialer, nil
+}
@@ -3,19 +3,25 @@ package mbus_test
 import (
 	"bytes"
 	"errors"
+	"strings"
+	"sync"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
 	"github.com/cloudfoundry/yagnats"
 	"github.com/cloudfoundry/yagnats/fakeyagnats"
+	"github.com/pivotal-golang/clock"
 
 	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
 	. "github.com/cloudfoundry/bosh-agent/mbus"
 	fakeplatform "github.com/cloudfoundry/bosh-agent/platform/fakes"
 	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
 	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	fakeblob "github.com/cloudfoundry/bosh-utils/blobstore/fakes"
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
 )
 
 func init() {
@@ -23,6 +29,7 @@ func init() {
 		var (
 			settingsService *fakesettings.FakeSettingsService
 			client          *fakeyagnats.FakeYagnats
+			outboundQueue   boshhandler.OutboundQueue
 			logger          boshlog.Logger
 			handler         boshhandler.Handler
 			platform        *fakeplatform.FakePlatform
@@ -43,11 +50,48 @@ func init() {
 			logger = boshlog.NewWriterLogger(boshlog.LevelError, loggerOutBuf, loggerErrBuf)
 
 			client = fakeyagnats.New()
+			outboundQueue = boshhandler.NewOutboundQueue(boshhandler.OutboundQueueOptions{}, fakesys.NewFakeFileSystem(), "/fake-queue-path", logger)
 			platform = fakeplatform.NewFakePlatform()
-			handler = NewNatsHandler(settingsService, client, logger, platform)
+			handler = NewNatsHandler(settingsService, client, outboundQueue, nil, nil, logger, platform)
 		})
 
 		Describe("Start", func() {
+			Context("when first connection fails", func() {
+				var failingClient *failingConnectClient
+
+				BeforeEach(func() {
+					failingClient = &failingConnectClient{FakeYagnats: client, failures: 2}
+					handler = NewNatsHandlerWithConnectDelay(settingsService, failingClient, outboundQueue, time.Millisecond, logger, platform)
+				})
+
+				It("retries connecting until it succeeds", func() {
+					err := handler.Start(func(req boshhandler.Request) (resp boshhandler.Response) { return })
+					Expect(err).ToNot(HaveOccurred())
+					defer handler.Stop()
+
+					Expect(failingClient.Attempts()).To(Equal(3))
+					Expect(client.ConnectedConnectionProvider()).ToNot(BeNil())
+					Expect(client.SubscriptionCount()).To(Equal(1))
+				})
+
+				It("returns error when handler is stopped before connection succeeds", func() {
+					failingClient.failures = -1
+
+					errCh := make(chan error, 1)
+					go func() {
+						errCh <- handler.Start(func(req boshhandler.Request) (resp boshhandler.Response) { return })
+					}()
+
+					Eventually(failingClient.Attempts).Should(BeNumerically(">", 1))
+					handler.Stop()
+
+					var err error
+					Eventually(errCh).Should(Receive(&err))
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("fake-connect-err"))
+				})
+			})
+
 			It("starts", func() {
 				var receivedRequest boshhandler.Request
 
@@ -74,6 +118,7 @@ func init() {
 					ReplyTo: "reply to me!",
 					Method:  "ping",
 					Payload: expectedPayload,
+					Source:  boshhandler.RequestSource{Transport: "nats"},
 				}))
 
 				Expect(client.PublishedMessageCount()).To(Equal(1))
@@ -82,6 +127,31 @@ func init() {
 				Expect(messages[0].Payload).To(Equal([]byte(`{"value":"expected value"}`)))
 			})
 
+			It("passes request id to the handler", func() {
+				var receivedRequest boshhandler.Request
+
+				handler.Start(func(req boshhandler.Request) (resp boshhandler.Response) {
+					receivedRequest = req
+					return boshhandler.NewValueResponse("expected value")
+				})
+				defer handler.Stop()
+
+				expectedPayload := []byte(`{"method":"ping","arguments":[],"reply_to":"reply to me!","request_id":"fake-request-id"}`)
+				subscription := client.Subscriptions("agent.my-agent-id")[0]
+				subscription.Callback(&yagnats.Message{
+					Subject: "agent.my-agent-id",
+					Payload: expectedPayload,
+				})
+
+				Expect(receivedRequest).To(Equal(boshhandler.Request{
+					ReplyTo:   "reply to me!",
+					Method:    "ping",
+					Payload:   expectedPayload,
+					RequestID: "fake-request-id",
+					Source:    boshhandler.RequestSource{Transport: "nats"},
+				}))
+			})
+
 			It("cleans up ip-mac address cache for nats configured with ip address", func() {
 				handler.Start(func(req boshhandler.Request) (resp boshhandler.Response) {
 					return nil
@@ -170,7 +240,156 @@ func init() {
 				Expect(len(messages)).To(Equal(2))
 				Expect(messages[0].Payload).To(MatchRegexp("value"))
 				Expect(messages[1].Payload).To(Equal([]byte(
-					`{"exception":{"message":"Response exceeded maximum allowed length"}}`)))
+					`{"exception":{"message":"Response exceeded maximum allowed length","code":"response_too_large"}}`)))
+			})
+
+			Context("when response overflow is configured", func() {
+				var (
+					blobstore *fakeblob.FakeBlobstore
+				)
+
+				BeforeEach(func() {
+					blobstore = fakeblob.NewFakeBlobstore()
+					blobstore.CreateBlobID = "fake-blob-id"
+					blobstore.CreateFingerprint = "fake-sha1"
+
+					overflow := boshhandler.NewBlobstoreResponseOverflow(blobstore, fakesys.NewFakeFileSystem())
+					handler = NewNatsHandler(settingsService, client, outboundQueue, overflow, nil, logger, platform)
+				})
+
+				It("uploads response bigger than 1MB to the blobstore and responds with its blobstore id", func() {
+					err := handler.Start(func(req boshhandler.Request) (resp boshhandler.Response) {
+						return boshhandler.NewValueResponse(strings.Repeat("A", 1024*1024))
+					})
+					Expect(err).ToNot(HaveOccurred())
+					defer handler.Stop()
+
+					subscription := client.Subscriptions("agent.my-agent-id")[0]
+					subscription.Callback(&yagnats.Message{
+						Subject: "agent.my-agent-id",
+						Payload: []byte(`{"method":"big","arguments":[], "reply_to": "fake-reply-to"}`),
+					})
+
+					Expect(blobstore.CreateFileNames).To(HaveLen(1))
+
+					messages := client.PublishedMessages("fake-reply-to")
+					Expect(len(messages)).To(Equal(1))
+					Expect(messages[0].Payload).To(Equal([]byte(
+						`{"response_blob":{"blobstore_id":"fake-blob-id","sha1":"fake-sha1"}}`)))
+				})
+
+				It("responds with an error if the response cannot be uploaded", func() {
+					blobstore.CreateErr = errors.New("fake-create-err")
+
+					err := handler.Start(func(req boshhandler.Request) (resp boshhandler.Response) {
+						return boshhandler.NewValueResponse(strings.Repeat("A", 1024*1024))
+					})
+					Expect(err).ToNot(HaveOccurred())
+					defer handler.Stop()
+
+					subscription := client.Subscriptions("agent.my-agent-id")[0]
+					subscription.Callback(&yagnats.Message{
+						Subject: "agent.my-agent-id",
+						Payload: []byte(`{"method":"big","arguments":[], "reply_to": "fake-reply-to"}`),
+					})
+
+					messages := client.PublishedMessages("fake-reply-to")
+					Expect(len(messages)).To(Equal(1))
+					Expect(messages[0].Payload).To(Equal([]byte(
+						`{"exception":{"message":"Response exceeded maximum allowed length","code":"response_too_large"}}`)))
+				})
+			})
+
+			Context("when messages are signed", func() {
+				var (
+					directorSigner *boshhandler.MessageSigner
+				)
+
+				BeforeEach(func() {
+					signingOptions := boshhandler.MessageSigningOptions{HMACKey: []byte("fake-hmac-key"), Enforce: true}
+					directorSigner = boshhandler.NewMessageSigner(signingOptions, clock.NewClock())
+
+					signer := boshhandler.NewMessageSigner(signingOptions, clock.NewClock())
+					handler = NewNatsHandler(settingsService, client, outboundQueue, nil, signer, logger, platform)
+				})
+
+				It("verifies signed request and signs reply", func() {
+					var receivedRequest boshhandler.Request
+
+					err := handler.Start(func(req boshhandler.Request) (resp boshhandler.Response) {
+						receivedRequest = req
+						return boshhandler.NewValueResponse("expected value")
+					})
+					Expect(err).ToNot(HaveOccurred())
+					defer handler.Stop()
+
+					expectedPayload := []byte(`{"method":"ping","arguments":[],"reply_to":"fake-reply-to"}`)
+
+					signedPayload, err := directorSigner.Sign(expectedPayload)
+					Expect(err).ToNot(HaveOccurred())
+
+					subscription := client.Subscriptions("agent.my-agent-id")[0]
+					subscription.Callback(&yagnats.Message{
+						Subject: "agent.my-agent-id",
+						Payload: signedPayload,
+					})
+
+					Expect(receivedRequest.Method).To(Equal("ping"))
+					Expect(receivedRequest.GetPayload()).To(Equal(expectedPayload))
+
+					messages := client.PublishedMessages("fake-reply-to")
+					Expect(len(messages)).To(Equal(1))
+
+					reply, err := directorSigner.Open(messages[0].Payload)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(reply).To(Equal([]byte(`{"value":"expected value"}`)))
+				})
+
+				It("drops unsigned request without replying", func() {
+					handlerCalled := false
+
+					err := handler.Start(func(req boshhandler.Request) (resp boshhandler.Response) {
+						handlerCalled = true
+						return boshhandler.NewValueResponse("expected value")
+					})
+					Expect(err).ToNot(HaveOccurred())
+					defer handler.Stop()
+
+					subscription := client.Subscriptions("agent.my-agent-id")[0]
+					subscription.Callback(&yagnats.Message{
+						Subject: "agent.my-agent-id",
+						Payload: []byte(`{"method":"ping","arguments":[],"reply_to":"fake-reply-to"}`),
+					})
+
+					Expect(handlerCalled).To(BeFalse())
+					Expect(client.PublishedMessages("fake-reply-to")).To(BeEmpty())
+					Expect(loggerErrBuf.String()).To(ContainSubstring("Rejecting unsigned message"))
+				})
+
+				It("drops replayed request", func() {
+					handlerCalls := 0
+
+					err := handler.Start(func(req boshhandler.Request) (resp boshhandler.Response) {
+						handlerCalls++
+						return boshhandler.NewValueResponse("expected value")
+					})
+					Expect(err).ToNot(HaveOccurred())
+					defer handler.Stop()
+
+					signedPayload, err := directorSigner.Sign([]byte(`{"method":"ping","arguments":[],"reply_to":"fake-reply-to"}`))
+					Expect(err).ToNot(HaveOccurred())
+
+					subscription := client.Subscriptions("agent.my-agent-id")[0]
+					for i := 0; i < 2; i++ {
+						subscription.Callback(&yagnats.Message{
+							Subject: "agent.my-agent-id",
+							Payload: signedPayload,
+						})
+					}
+
+					Expect(handlerCalls).To(Equal(1))
+					Expect(client.PublishedMessages("fake-reply-to")).To(HaveLen(1))
+				})
 			})
 
 			It("can add additional handler funcs to receive requests", func() {
@@ -178,7 +397,7 @@ func init() {
 
 				handler.Start(func(req boshhandler.Request) (resp boshhandler.Response) {
 					firstHandlerReq = req
-					return boshhandler.NewValueResponse("first-handler-resp")
+					return boshhandler.NotHandled
 				})
 				defer handler.Stop()
 
@@ -200,20 +419,65 @@ func init() {
 					ReplyTo: "fake-reply-to",
 					Method:  "ping",
 					Payload: expectedPayload,
+					Source:  boshhandler.RequestSource{Transport: "nats"},
 				}))
 
 				Expect(secondHandlerRequest).To(Equal(boshhandler.Request{
 					ReplyTo: "fake-reply-to",
 					Method:  "ping",
 					Payload: expectedPayload,
+					Source:  boshhandler.RequestSource{Transport: "nats"},
 				}))
 
-				// Bosh handler responses were sent
+				// Only response of the handler that handled request was sent
 				Expect(client.PublishedMessageCount()).To(Equal(1))
 				messages := client.PublishedMessages("fake-reply-to")
-				Expect(len(messages)).To(Equal(2))
+				Expect(len(messages)).To(Equal(1))
+				Expect(messages[0].Payload).To(Equal([]byte(`{"value":"second-handler-resp"}`)))
+			})
+
+			It("does not ask additional handler funcs once request is handled", func() {
+				additionalFuncCalled := false
+
+				handler.Start(func(req boshhandler.Request) (resp boshhandler.Response) {
+					return boshhandler.NewValueResponse("first-handler-resp")
+				})
+				defer handler.Stop()
+
+				handler.RegisterAdditionalFunc(func(req boshhandler.Request) (resp boshhandler.Response) {
+					additionalFuncCalled = true
+					return boshhandler.NewValueResponse("second-handler-resp")
+				})
+
+				subscription := client.Subscriptions("agent.my-agent-id")[0]
+				subscription.Callback(&yagnats.Message{
+					Subject: "agent.my-agent-id",
+					Payload: []byte(`{"method":"ping","arguments":[], "reply_to": "fake-reply-to"}`),
+				})
+
+				Expect(additionalFuncCalled).To(BeFalse())
+
+				messages := client.PublishedMessages("fake-reply-to")
+				Expect(len(messages)).To(Equal(1))
 				Expect(messages[0].Payload).To(Equal([]byte(`{"value":"first-handler-resp"}`)))
-				Expect(messages[1].Payload).To(Equal([]byte(`{"value":"second-handler-resp"}`)))
+			})
+
+			It("responds with unknown action error when no handler func handles request", func() {
+				handler.Start(func(req boshhandler.Request) (resp boshhandler.Response) {
+					return boshhandler.NotHandled
+				})
+				defer handler.Stop()
+
+				subscription := client.Subscriptions("agent.my-agent-id")[0]
+				subscription.Callback(&yagnats.Message{
+					Subject: "agent.my-agent-id",
+					Payload: []byte(`{"method":"fake-method","arguments":[], "reply_to": "fake-reply-to"}`),
+				})
+
+				messages := client.PublishedMessages("fake-reply-to")
+				Expect(len(messages)).To(Equal(1))
+				Expect(messages[0].Payload).To(Equal([]byte(
+					`{"exception":{"message":"unknown message fake-method","code":"unknown_action"}}`)))
 			})
 
 			It("has the correct connection info", func() {
@@ -221,7 +485,9 @@ func init() {
 				Expect(err).ToNot(HaveOccurred())
 				defer handler.Stop()
 
-				Expect(client.ConnectedConnectionProvider()).To(Equal(&yagnats.ConnectionInfo{
+				connProvider, ok := client.ConnectedConnectionProvider().(*ReconnectingConnectionProvider)
+				Expect(ok).To(BeTrue())
+				Expect(connProvider.ConnectionInfo).To(Equal(&yagnats.ConnectionInfo{
 					Addr:     "127.0.0.1:1234",
 					Username: "fake-username",
 					Password: "fake-password",
@@ -230,7 +496,7 @@ func init() {
 
 			It("does not err when no username and password", func() {
 				settingsService.Settings.Mbus = "nats://127.0.0.1:1234"
-				handler = NewNatsHandler(settingsService, client, logger, platform)
+				handler = NewNatsHandler(settingsService, client, outboundQueue, nil, nil, logger, platform)
 
 				err := handler.Start(func(req boshhandler.Request) (res boshhandler.Response) { return })
 				Expect(err).ToNot(HaveOccurred())
@@ -239,7 +505,7 @@ func init() {
 
 			It("errs when has username without password", func() {
 				settingsService.Settings.Mbus = "nats://foo@127.0.0.1:1234"
-				handler = NewNatsHandler(settingsService, client, logger, platform)
+				handler = NewNatsHandler(settingsService, client, outboundQueue, nil, nil, logger, platform)
 
 				err := handler.Start(func(req boshhandler.Request) (res boshhandler.Response) { return })
 				Expect(err).To(HaveOccurred())
@@ -248,7 +514,18 @@ func init() {
 		})
 
 		Describe("Send", func() {
+			startHandler := func() {
+				err := handler.Start(func(req boshhandler.Request) (res boshhandler.Response) { return })
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			AfterEach(func() {
+				handler.Stop()
+			})
+
 			It("sends the message over nats to a subject that includes the target and topic", func() {
+				startHandler()
+
 				errCh := make(chan error, 1)
 
 				payload := map[string]string{"key1": "value1", "keyA": "valueA"}
@@ -263,13 +540,131 @@ func init() {
 				}
 				Expect(err).ToNot(HaveOccurred())
 
-				Expect(client.PublishedMessageCount()).To(Equal(1))
+				Eventually(client.PublishedMessageCount).Should(Equal(1))
 				messages := client.PublishedMessages("hm.agent.heartbeat.my-agent-id")
 				Expect(messages).To(HaveLen(1))
 				Expect(messages[0].Payload).To(Equal(
 					[]byte("{\"key1\":\"value1\",\"keyA\":\"valueA\"}"),
 				))
 			})
+
+			It("publishes messages sent before handler is started once it is started", func() {
+				err := handler.Send(boshhandler.HealthMonitor, boshhandler.Alert, "fake-alert")
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(client.PublishedMessageCount()).To(Equal(0))
+				Expect(handler.(boshhandler.QueueStatsProvider).QueueStats().Depth).To(Equal(1))
+
+				startHandler()
+
+				Eventually(func() []yagnats.Message {
+					return client.PublishedMessages("hm.agent.alert.my-agent-id")
+				}).Should(HaveLen(1))
+
+				Eventually(func() int {
+					return handler.(boshhandler.QueueStatsProvider).QueueStats().Depth
+				}).Should(Equal(0))
+			})
+
+			It("delivers only the latest of queued heartbeats", func() {
+				err := handler.Send(boshhandler.HealthMonitor, boshhandler.Heartbeat, "fake-heartbeat-1")
+				Expect(err).ToNot(HaveOccurred())
+
+				err = handler.Send(boshhandler.HealthMonitor, boshhandler.Alert, "fake-alert")
+				Expect(err).ToNot(HaveOccurred())
+
+				err = handler.Send(boshhandler.HealthMonitor, boshhandler.Heartbeat, "fake-heartbeat-2")
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(handler.(boshhandler.QueueStatsProvider).QueueStats()).To(Equal(boshhandler.QueueStats{
+					Depth:     2,
+					Coalesced: 1,
+				}))
+
+				startHandler()
+
+				Eventually(func() []yagnats.Message {
+					return client.PublishedMessages("hm.agent.heartbeat.my-agent-id")
+				}).Should(HaveLen(1))
+
+				messages := client.PublishedMessages("hm.agent.heartbeat.my-agent-id")
+				Expect(messages[0].Payload).To(Equal([]byte(`"fake-heartbeat-2"`)))
+				Expect(client.PublishedMessages("hm.agent.alert.my-agent-id")).To(HaveLen(1))
+			})
+
+			It("keeps message queued and retries publishing it when publishing fails", func() {
+				publishAttempts := 0
+				client.WhenPublishing("hm.agent.alert.my-agent-id", func(*yagnats.Message) error {
+					publishAttempts++
+					if publishAttempts == 1 {
+						return errors.New("disconnected")
+					}
+					return nil
+				})
+
+				startHandler()
+
+				err := handler.Send(boshhandler.HealthMonitor, boshhandler.Alert, "fake-alert")
+				Expect(err).ToNot(HaveOccurred())
+
+				Eventually(func() []yagnats.Message {
+					return client.PublishedMessages("hm.agent.alert.my-agent-id")
+				}, 2*time.Second).Should(HaveLen(1))
+
+				Expect(handler.(boshhandler.QueueStatsProvider).QueueStats().Depth).To(Equal(0))
+			})
+
+			It("keeps message queued when handler is stopped while publishing waits for connection", func() {
+				publishingCh := make(chan struct{}, 1)
+				releaseCh := make(chan struct{})
+				client.WhenPublishing("hm.agent.alert.my-agent-id", func(*yagnats.Message) error {
+					publishingCh <- struct{}{}
+					<-releaseCh
+					return nil
+				})
+
+				startHandler()
+
+				err := handler.Send(boshhandler.HealthMonitor, boshhandler.Alert, "fake-alert")
+				Expect(err).ToNot(HaveOccurred())
+
+				Eventually(publishingCh).Should(Receive())
+
+				handler.Stop()
+				close(releaseCh)
+
+				Consistently(func() int {
+					return handler.(boshhandler.QueueStatsProvider).QueueStats().Depth
+				}, 200*time.Millisecond).Should(Equal(1))
+			})
 		})
 	})
 }
+
+// failingConnectClient fails first connection attempts; negative failures fail all of them
+type failingConnectClient struct {
+	*fakeyagnats.FakeYagnats
+
+	failures     int
+	attempts     int
+	attemptsLock sync.Mutex
+}
+
+func (c *failingConnectClient) Connect(connectionProvider yagnats.ConnectionProvider) error {
+	c.attemptsLock.Lock()
+	c.attempts++
+	attempts := c.attempts
+	c.attemptsLock.Unlock()
+
+	if c.failures < 0 || attempts <= c.failures {
+		return errors.New("fake-connect-err")
+	}
+
+	return c.FakeYagnats.Connect(connectionProvider)
+}
+
+func (c *failingConnectClient) Attempts() int {
+	c.attemptsLock.Lock()
+	defer c.attemptsLock.Unlock()
+	return c.attempts
+}
@@ -0,0 +1,183 @@
+package mbus
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+const (
+	natsTLSDialTimeout = 5 * time.Second
+
+	natsInfoMaxLength = 64 * 1024
+)
+
+// CertUpdater is implemented by handlers that can switch
+// to new certificates without restarting the agent
+type CertUpdater interface {
+	UpdateCert(boshsettings.CertKeyPair) error
+}
+
+type natsServerInfo struct {
+	TLSRequired bool `json:"tls_required"`
+}
+
+// natsTLSDialer connects to NATS server that requires TLS.
+// Server sends INFO in plain text first and then waits for the client
+// to start TLS handshake; the rest of the protocol goes over TLS.
+type natsTLSDialer struct {
+	serverName string
+
+	config     *tls.Config
+	configLock sync.RWMutex
+}
+
+func newNatsTLSDialer(serverName string, cert boshsettings.CertKeyPair) (*natsTLSDialer, error) {
+	dialer := &natsTLSDialer{serverName: serverName}
+
+	err := dialer.UpdateCert(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	return dialer, nil
+}
+
+// UpdateCert makes following connections use given certificates;
+// current connection is kept until it is re-established
+func (d *natsTLSDialer) UpdateCert(cert boshsettings.CertKeyPair) error {
+	config, err := newNatsTLSConfig(d.serverName, cert)
+	if err != nil {
+		return err
+	}
+
+	d.configLock.Lock()
+	d.config = config
+	d.configLock.Unlock()
+
+	return nil
+}
+
+func (d *natsTLSDialer) Dial(network, address string) (net.Conn, error) {
+	conn, err := net.DialTimeout(network, address, natsTLSDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConn, err := d.upgrade(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
+
+func (d *natsTLSDialer) upgrade(conn net.Conn) (net.Conn, error) {
+	err := conn.SetDeadline(time.Now().Add(natsTLSDialTimeout))
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Setting connection deadline")
+	}
+
+	info, err := readNatsServerInfo(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	// Never fall back to plain text once TLS is configured
+	if !info.TLSRequired {
+		return nil, bosherr.Error("NATS server does not require TLS")
+	}
+
+	d.configLock.RLock()
+	config := d.config
+	d.configLock.RUnlock()
+
+	tlsConn := tls.Client(conn, config)
+
+	err = tlsConn.Handshake()
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Performing TLS handshake with NATS server")
+	}
+
+	err = conn.SetDeadline(time.Time{})
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Clearing connection deadline")
+	}
+
+	return tlsConn, nil
+}
+
+// readNatsServerInfo reads INFO line byte by byte so that
+// no part of TLS handshake that follows is consumed
+func readNatsServerInfo(conn net.Conn) (natsServerInfo, error) {
+	var info natsServerInfo
+
+	line := []byte{}
+	char := make([]byte, 1)
+
+	for {
+		_, err := conn.Read(char)
+		if err != nil {
+			return info, bosherr.WrapError(err, "Reading INFO from NATS server")
+		}
+
+		if char[0] == '\n' {
+			break
+		}
+
+		line = append(line, char[0])
+
+		if len(line) > natsInfoMaxLength {
+			return info, bosherr.Error("INFO from NATS server is too long")
+		}
+	}
+
+	line = bytes.TrimSpace(line)
+
+	if !bytes.HasPrefix(line, []byte("INFO ")) {
+		return info, bosherr.Errorf("Expected INFO from NATS server but received '%s'", line)
+	}
+
+	err := json.Unmarshal(bytes.TrimPrefix(line, []byte("INFO ")), &info)
+	if err != nil {
+		return info, bosherr.WrapError(err, "Unmarshalling INFO from NATS server")
+	}
+
+	return info, nil
+}
+
+// newNatsTLSConfig verifies server certificate against given CA
+// (or system CAs if none is given) and server name from mbus URL
+func newNatsTLSConfig(serverName string, cert boshsettings.CertKeyPair) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if cert.CA != "" {
+		config.RootCAs = x509.NewCertPool()
+
+		if !config.RootCAs.AppendCertsFromPEM([]byte(cert.CA)) {
+			return nil, bosherr.Error("Parsing mbus CA certificate")
+		}
+	}
+
+	if cert.Certificate != "" || cert.PrivateKey != "" {
+		keyPair, err := tls.X509KeyPair([]byte(cert.Certificate), []byte(cert.PrivateKey))
+		if err != nil {
+			return nil, bosherr.WrapError(err, "Parsing mbus client certificate")
+		}
+
+		config.Certificates = []tls.Certificate{keyPair}
+	}
+
+	return config, nil
+}
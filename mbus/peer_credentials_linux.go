@@ -0,0 +1,31 @@
+package mbus
+
+import (
+	"net"
+	"syscall"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+func getPeerCredentials(conn *net.UnixConn) (peerCredentials, error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return peerCredentials{}, bosherr.WrapError(err, "Getting raw connection")
+	}
+
+	var ucred *syscall.Ucred
+	var ucredErr error
+
+	err = rawConn.Control(func(fd uintptr) {
+		ucred, ucredErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return peerCredentials{}, bosherr.WrapError(err, "Accessing socket")
+	}
+
+	if ucredErr != nil {
+		return peerCredentials{}, bosherr.WrapError(ucredErr, "Getting SO_PEERCRED")
+	}
+
+	return peerCredentials{PID: uint32(ucred.Pid), UID: ucred.Uid, GID: ucred.Gid}, nil
+}
@@ -0,0 +1,13 @@
+// +build !linux
+
+package mbus
+
+import (
+	"net"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+func getPeerCredentials(conn *net.UnixConn) (peerCredentials, error) {
+	return peerCredentials{}, bosherr.Error("Peer credentials are only supported on Linux")
+}
@@ -0,0 +1,56 @@
+package mbus
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/yagnats"
+
+	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
+)
+
+const (
+	reconnectInitialDelay = 500 * time.Millisecond
+	reconnectMaxDelay     = 30 * time.Second
+)
+
+// ReconnectingConnectionProvider waits before every connection attempt
+// that follows the first one. NATS client keeps asking for a new connection
+// when the current one is lost; without waiting all agents would
+// hammer NATS server while it is restarting.
+type ReconnectingConnectionProvider struct {
+	*yagnats.ConnectionInfo
+
+	backoff *boshhandler.Backoff
+	sleep   func(time.Duration)
+
+	connectedOnce bool
+	lock          sync.Mutex
+}
+
+func newReconnectingConnectionProvider(connInfo *yagnats.ConnectionInfo, backoff *boshhandler.Backoff) *ReconnectingConnectionProvider {
+	return &ReconnectingConnectionProvider{
+		ConnectionInfo: connInfo,
+		backoff:        backoff,
+		sleep:          time.Sleep,
+	}
+}
+
+func (p *ReconnectingConnectionProvider) ProvideConnection() (*yagnats.Connection, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.connectedOnce {
+		p.sleep(p.backoff.Next())
+	}
+
+	conn, err := p.ConnectionInfo.ProvideConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	p.connectedOnce = true
+	p.backoff.Reset()
+
+	return conn, nil
+}
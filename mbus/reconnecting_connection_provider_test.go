@@ -0,0 +1,112 @@
+package mbus_test
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cloudfoundry/yagnats"
+
+	. "github.com/cloudfoundry/bosh-agent/mbus"
+)
+
+var _ = Describe("ReconnectingConnectionProvider", func() {
+	var (
+		dialErr     error
+		serverConns []net.Conn
+		sleeps      []time.Duration
+		provider    *ReconnectingConnectionProvider
+	)
+
+	BeforeEach(func() {
+		dialErr = nil
+		serverConns = nil
+		sleeps = nil
+
+		connInfo := &yagnats.ConnectionInfo{
+			Addr: "fake-addr",
+			Dial: func(network, address string) (net.Conn, error) {
+				if dialErr != nil {
+					return nil, dialErr
+				}
+
+				clientConn, serverConn := net.Pipe()
+				serverConns = append(serverConns, serverConn)
+
+				// Accept CONNECT sent by the client during handshake
+				go func() {
+					_, err := bufio.NewReader(serverConn).ReadString('\n')
+					if err == nil {
+						_, _ = serverConn.Write([]byte("+OK\r\n"))
+					}
+				}()
+
+				return clientConn, nil
+			},
+		}
+
+		provider = NewReconnectingConnectionProviderWithSleep(
+			connInfo,
+			100*time.Millisecond,
+			time.Second,
+			func(delay time.Duration) { sleeps = append(sleeps, delay) },
+		)
+	})
+
+	AfterEach(func() {
+		for _, serverConn := range serverConns {
+			_ = serverConn.Close()
+		}
+	})
+
+	It("connects right away the first time", func() {
+		_, err := provider.ProvideConnection()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sleeps).To(BeEmpty())
+	})
+
+	It("waits longer before each reconnect attempt while reconnecting fails", func() {
+		_, err := provider.ProvideConnection()
+		Expect(err).ToNot(HaveOccurred())
+
+		dialErr = errors.New("fake-dial-err")
+
+		for i := 0; i < 3; i++ {
+			_, err = provider.ProvideConnection()
+			Expect(err).To(MatchError("fake-dial-err"))
+		}
+
+		Expect(sleeps).To(HaveLen(3))
+		Expect(sleeps[0]).To(BeNumerically("<=", 100*time.Millisecond))
+		Expect(sleeps[1]).To(BeNumerically(">=", 100*time.Millisecond))
+		Expect(sleeps[2]).To(BeNumerically(">=", 200*time.Millisecond))
+	})
+
+	It("starts from initial delay once reconnected", func() {
+		_, err := provider.ProvideConnection()
+		Expect(err).ToNot(HaveOccurred())
+
+		dialErr = errors.New("fake-dial-err")
+
+		_, err = provider.ProvideConnection()
+		Expect(err).To(HaveOccurred())
+
+		_, err = provider.ProvideConnection()
+		Expect(err).To(HaveOccurred())
+
+		dialErr = nil
+
+		_, err = provider.ProvideConnection()
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = provider.ProvideConnection()
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(sleeps).To(HaveLen(4))
+		Expect(sleeps[3]).To(BeNumerically("<=", 100*time.Millisecond))
+	})
+})
@@ -0,0 +1,235 @@
+package mbus
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+
+	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+)
+
+const (
+	unixSocketHandlerLogTag = "unixSocketHandler"
+
+	// Every peer may connect; peers are authorized by their credentials
+	unixSocketMode = 0666
+)
+
+type UnixSocketOptions struct {
+	Enabled bool
+
+	// Callers running with these uids or gids are allowed besides
+	// the user that the agent runs as; unless action policy rules
+	// allow more they may only run actions that do not change anything
+	// (e.g. get_state), not run_script or fetch_logs
+	AllowedUIDs []uint32
+	AllowedGIDs []uint32
+}
+
+type peerCredentials struct {
+	PID uint32
+	UID uint32
+	GID uint32
+}
+
+// unixSocketHandler lets tooling on the VM send requests to the agent
+// without going through the director. Each line received on a connection
+// is a JSON request and each request is answered with a line of JSON.
+type unixSocketHandler struct {
+	path     string
+	options  UnixSocketOptions
+	agentUID uint32
+	chain    *boshhandler.Chain
+	logger   boshlog.Logger
+
+	listener     net.Listener
+	listenerLock sync.Mutex
+}
+
+func NewUnixSocketHandler(path string, options UnixSocketOptions, logger boshlog.Logger) boshhandler.Handler {
+	return &unixSocketHandler{
+		path:     path,
+		options:  options,
+		agentUID: uint32(os.Getuid()),
+		chain:    boshhandler.NewChain(),
+		logger:   logger,
+	}
+}
+
+func (h *unixSocketHandler) Run(handlerFunc boshhandler.Func) error {
+	err := h.Start(handlerFunc)
+	if err != nil {
+		return bosherr.WrapError(err, "Starting unix socket handler")
+	}
+	return nil
+}
+
+// Start accepts connections until handler is stopped
+func (h *unixSocketHandler) Start(handlerFunc boshhandler.Func) error {
+	// Socket left behind by previous agent process would prevent listening
+	err := os.Remove(h.path)
+	if err != nil && !os.IsNotExist(err) {
+		return bosherr.WrapErrorf(err, "Removing stale socket %s", h.path)
+	}
+
+	listener, err := net.Listen("unix", h.path)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Listening on %s", h.path)
+	}
+
+	err = os.Chmod(h.path, unixSocketMode)
+	if err != nil {
+		_ = listener.Close()
+		return bosherr.WrapErrorf(err, "Changing permissions of %s", h.path)
+	}
+
+	h.listenerLock.Lock()
+	h.listener = listener
+	h.listenerLock.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if h.stopped(listener) {
+				return nil
+			}
+			return bosherr.WrapError(err, "Accepting connection")
+		}
+
+		go h.serve(conn.(*net.UnixConn), h.chain.Func(handlerFunc))
+	}
+}
+
+func (h *unixSocketHandler) Stop() {
+	h.listenerLock.Lock()
+	defer h.listenerLock.Unlock()
+
+	if h.listener != nil {
+		_ = h.listener.Close()
+		h.listener = nil
+	}
+}
+
+func (h *unixSocketHandler) RegisterAdditionalFunc(handlerFunc boshhandler.Func) {
+	h.chain.Register(handlerFunc)
+}
+
+func (h *unixSocketHandler) Send(target boshhandler.Target, topic boshhandler.Topic, message interface{}) error {
+	return bosherr.Errorf("Unix socket handler cannot send messages (target=%s, topic=%s)", target, topic)
+}
+
+func (h *unixSocketHandler) stopped(listener net.Listener) bool {
+	h.listenerLock.Lock()
+	defer h.listenerLock.Unlock()
+
+	return h.listener != listener
+}
+
+func (h *unixSocketHandler) serve(conn *net.UnixConn, handlerFunc boshhandler.Func) {
+	defer h.logger.HandlePanic("Unix Socket Handler")
+	defer conn.Close()
+
+	creds, err := getPeerCredentials(conn)
+	if err != nil {
+		h.logger.Error(unixSocketHandlerLogTag, "Getting peer credentials: %s", err.Error())
+		return
+	}
+
+	if !h.allows(creds) {
+		h.logger.Warn(unixSocketHandlerLogTag, "Rejected connection from pid %d (uid=%d, gid=%d)", creds.PID, creds.UID, creds.GID)
+		h.respond(conn, h.buildError("Not authorized"))
+		return
+	}
+
+	source := boshhandler.RequestSource{
+		Transport: "unix",
+		Identity:  h.identity(creds),
+	}
+
+	reader := bufio.NewReader(conn)
+
+	for {
+		line, readErr := reader.ReadBytes('\n')
+
+		if len(line) > 0 {
+			respBytes, _, err := boshhandler.PerformHandlerWithJSON(
+				line,
+				boshhandler.WithRequestSource(source, handlerFunc),
+				boshhandler.UnlimitedResponseLength,
+				nil,
+				h.logger,
+			)
+			if err != nil {
+				h.logger.Error(unixSocketHandlerLogTag, "Performing request from %s: %s", source, err.Error())
+				respBytes = h.buildError(err.Error())
+			}
+
+			if !h.respond(conn, respBytes) {
+				return
+			}
+		}
+
+		if readErr != nil {
+			if readErr != io.EOF {
+				h.logger.Error(unixSocketHandlerLogTag, "Reading request from %s: %s", source, readErr.Error())
+			}
+			return
+		}
+	}
+}
+
+func (h *unixSocketHandler) allows(creds peerCredentials) bool {
+	if creds.UID == h.agentUID {
+		return true
+	}
+
+	for _, uid := range h.options.AllowedUIDs {
+		if creds.UID == uid {
+			return true
+		}
+	}
+
+	for _, gid := range h.options.AllowedGIDs {
+		if creds.GID == gid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// identity is the name of the user so that action policy
+// rules can refer to users without knowing their uids
+func (h *unixSocketHandler) identity(creds peerCredentials) string {
+	uid := strconv.FormatUint(uint64(creds.UID), 10)
+
+	u, err := user.LookupId(uid)
+	if err != nil {
+		return uid
+	}
+
+	return u.Username
+}
+
+func (h *unixSocketHandler) buildError(msg string) []byte {
+	respBytes, err := boshhandler.BuildErrorWithJSON(msg, h.logger)
+	if err != nil {
+		h.logger.Error(unixSocketHandlerLogTag, "Building error response: %s", err.Error())
+	}
+	return respBytes
+}
+
+func (h *unixSocketHandler) respond(conn *net.UnixConn, respBytes []byte) bool {
+	_, err := conn.Write(append(respBytes, '\n'))
+	if err != nil {
+		h.logger.Error(unixSocketHandlerLogTag, "Writing response: %s", err.Error())
+		return false
+	}
+	return true
+}
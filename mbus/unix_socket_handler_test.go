@@ -0,0 +1,177 @@
+// +build linux
+
+package mbus_test
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
+	. "github.com/cloudfoundry/bosh-agent/mbus"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+)
+
+var _ = Describe("unixSocketHandler", func() {
+	var (
+		socketDir  string
+		socketPath string
+		options    UnixSocketOptions
+		agentUID   uint32
+		handler    boshhandler.Handler
+
+		receivedRequests chan boshhandler.Request
+		runErrCh         chan error
+	)
+
+	BeforeEach(func() {
+		var err error
+		socketDir, err = ioutil.TempDir("", "unix-socket-handler")
+		Expect(err).ToNot(HaveOccurred())
+
+		socketPath = filepath.Join(socketDir, "agent.sock")
+		options = UnixSocketOptions{Enabled: true}
+		agentUID = uint32(os.Getuid())
+		receivedRequests = make(chan boshhandler.Request, 10)
+	})
+
+	JustBeforeEach(func() {
+		logger := boshlog.NewLogger(boshlog.LevelNone)
+		handler = NewUnixSocketHandlerWithAgentUID(socketPath, options, agentUID, logger)
+		runErrCh = make(chan error, 1)
+
+		// Handler of previous test may still be serving while
+		// these variables are reassigned, so goroutine uses copies
+		runningHandler, requests, errCh := handler, receivedRequests, runErrCh
+		go func() {
+			errCh <- runningHandler.Run(func(req boshhandler.Request) boshhandler.Response {
+				requests <- req
+				return boshhandler.NewValueResponse("pong")
+			})
+		}()
+
+		Eventually(func() error {
+			conn, err := net.Dial("unix", socketPath)
+			if err == nil {
+				conn.Close()
+			}
+			return err
+		}).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		handler.Stop()
+		os.RemoveAll(socketDir)
+	})
+
+	currentIdentity := func() string {
+		u, err := user.Current()
+		Expect(err).ToNot(HaveOccurred())
+		return u.Username
+	}
+
+	sendRequests := func(requests ...string) []string {
+		conn, err := net.Dial("unix", socketPath)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		responses := []string{}
+
+		for _, request := range requests {
+			_, err = conn.Write([]byte(request + "\n"))
+			Expect(err).ToNot(HaveOccurred())
+
+			response, err := reader.ReadString('\n')
+			Expect(err).ToNot(HaveOccurred())
+
+			responses = append(responses, response)
+		}
+
+		return responses
+	}
+
+	It("performs requests received over the socket", func() {
+		responses := sendRequests(`{"method":"ping","arguments":[]}`)
+		Expect(responses).To(Equal([]string{`{"value":"pong"}` + "\n"}))
+
+		var req boshhandler.Request
+		Eventually(receivedRequests).Should(Receive(&req))
+		Expect(req.Method).To(Equal("ping"))
+		Expect(req.Source).To(Equal(boshhandler.RequestSource{Transport: "unix", Identity: currentIdentity()}))
+	})
+
+	It("performs multiple requests sent over the same connection", func() {
+		responses := sendRequests(
+			`{"method":"ping","arguments":[]}`,
+			`{"method":"get_state","arguments":[]}`,
+		)
+		Expect(responses).To(HaveLen(2))
+		Expect(receivedRequests).To(HaveLen(2))
+	})
+
+	It("responds with exception when request cannot be parsed", func() {
+		responses := sendRequests(`fake-invalid-json`)
+		Expect(responses[0]).To(ContainSubstring(`"exception"`))
+		Expect(responses[0]).To(ContainSubstring("Unmarshalling JSON payload"))
+	})
+
+	It("lets everyone connect so that peers are authorized by their credentials", func() {
+		info, err := os.Stat(socketPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0666)))
+	})
+
+	It("stops running once stopped", func() {
+		handler.Stop()
+		Eventually(runErrCh).Should(Receive(BeNil()))
+	})
+
+	Context("when socket was left behind by previous agent", func() {
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(socketPath, []byte{}, 0600)).To(Succeed())
+		})
+
+		It("replaces it", func() {
+			Expect(sendRequests(`{"method":"ping","arguments":[]}`)).To(HaveLen(1))
+		})
+	})
+
+	Context("when peer runs as a different user than the agent", func() {
+		BeforeEach(func() {
+			agentUID = uint32(os.Getuid()) + 1
+		})
+
+		It("rejects requests", func() {
+			responses := sendRequests(`{"method":"ping","arguments":[]}`)
+			Expect(responses[0]).To(ContainSubstring("Not authorized"))
+			Expect(receivedRequests).To(BeEmpty())
+		})
+
+		Context("when peer uid is allowed", func() {
+			BeforeEach(func() {
+				options.AllowedUIDs = []uint32{uint32(os.Getuid())}
+			})
+
+			It("accepts requests", func() {
+				Expect(sendRequests(`{"method":"ping","arguments":[]}`)).To(Equal([]string{`{"value":"pong"}` + "\n"}))
+			})
+		})
+
+		Context("when peer gid is allowed", func() {
+			BeforeEach(func() {
+				options.AllowedGIDs = []uint32{uint32(os.Getgid())}
+			})
+
+			It("accepts requests", func() {
+				Expect(sendRequests(`{"method":"ping","arguments":[]}`)).To(Equal([]string{`{"value":"pong"}` + "\n"}))
+			})
+		})
+	})
+})
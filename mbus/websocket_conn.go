@@ -0,0 +1,353 @@
+package mbus
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+const (
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	websocketOpContinuation = 0x0
+	websocketOpText         = 0x1
+	websocketOpBinary       = 0x2
+	websocketOpClose        = 0x8
+	websocketOpPing         = 0x9
+	websocketOpPong         = 0xA
+
+	// Requests such as apply carry whole specs
+	websocketMaxMessageLength = 64 * 1024 * 1024
+
+	websocketMaxControlPayloadLength = 125
+)
+
+// websocketConn is a minimal RFC 6455 connection that exchanges text
+// messages. Client side masks frames it writes; server side (used by tests)
+// does not. Ping frames are answered while reading messages.
+type websocketConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	client bool
+
+	// Connection is considered dead when nothing is received for this long
+	readTimeout time.Duration
+
+	writeLock sync.Mutex
+}
+
+func newWebsocketConn(conn net.Conn, reader *bufio.Reader, client bool) *websocketConn {
+	return &websocketConn{conn: conn, reader: reader, client: client}
+}
+
+// dialWebsocket connects to ws:// or wss:// URL; credentials
+// included in URL are sent with basic auth during the handshake
+func dialWebsocket(wsURL *url.URL, header http.Header, tlsConfig *tls.Config, timeout time.Duration) (*websocketConn, error) {
+	address := wsURL.Host
+	if wsURL.Port() == "" {
+		if wsURL.Scheme == "wss" {
+			address = net.JoinHostPort(wsURL.Hostname(), "443")
+		} else {
+			address = net.JoinHostPort(wsURL.Hostname(), "80")
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+
+	var conn net.Conn
+	var err error
+
+	if wsURL.Scheme == "wss" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, bosherr.WrapErrorf(err, "Connecting to %s", address)
+	}
+
+	wsConn, err := websocketHandshake(conn, wsURL, header, timeout)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return wsConn, nil
+}
+
+func websocketHandshake(conn net.Conn, wsURL *url.URL, header http.Header, timeout time.Duration) (*websocketConn, error) {
+	err := conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Setting connection deadline")
+	}
+
+	keyBytes := make([]byte, 16)
+
+	_, err = rand.Read(keyBytes)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Generating websocket key")
+	}
+
+	key := base64.StdEncoding.EncodeToString(keyBytes)
+
+	httpURL := *wsURL
+	httpURL.User = nil
+	httpURL.Scheme = strings.Replace(httpURL.Scheme, "ws", "http", 1)
+
+	request, err := http.NewRequest("GET", httpURL.String(), nil)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Building handshake request")
+	}
+
+	for name, values := range header {
+		request.Header[name] = values
+	}
+
+	request.Header.Set("Upgrade", "websocket")
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Sec-WebSocket-Key", key)
+	request.Header.Set("Sec-WebSocket-Version", "13")
+
+	if wsURL.User != nil {
+		password, _ := wsURL.User.Password()
+		request.SetBasicAuth(wsURL.User.Username(), password)
+	}
+
+	err = request.Write(conn)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Sending handshake request")
+	}
+
+	reader := bufio.NewReader(conn)
+
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Reading handshake response")
+	}
+
+	_ = response.Body.Close()
+
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		return nil, bosherr.Errorf("Websocket handshake failed with status %d", response.StatusCode)
+	}
+
+	if !strings.EqualFold(response.Header.Get("Upgrade"), "websocket") {
+		return nil, bosherr.Error("Websocket handshake response does not upgrade connection")
+	}
+
+	if response.Header.Get("Sec-WebSocket-Accept") != websocketAcceptKey(key) {
+		return nil, bosherr.Error("Websocket handshake response has invalid accept key")
+	}
+
+	err = conn.SetDeadline(time.Time{})
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Clearing connection deadline")
+	}
+
+	return newWebsocketConn(conn, reader, true), nil
+}
+
+func websocketAcceptKey(key string) string {
+	hash := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// ReadMessage returns the next text or binary message; control
+// frames received in the meantime are handled
+func (c *websocketConn) ReadMessage() ([]byte, error) {
+	var message []byte
+
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case websocketOpPing:
+			err = c.writeFrame(websocketOpPong, payload)
+			if err != nil {
+				return nil, bosherr.WrapError(err, "Responding to ping")
+			}
+			continue
+
+		case websocketOpPong:
+			continue
+
+		case websocketOpClose:
+			_ = c.writeFrame(websocketOpClose, nil)
+			return nil, io.EOF
+
+		case websocketOpText, websocketOpBinary:
+			if message != nil {
+				return nil, bosherr.Error("Received new websocket message before previous message was finished")
+			}
+			message = payload
+
+		case websocketOpContinuation:
+			if message == nil {
+				return nil, bosherr.Error("Received websocket continuation frame without a message")
+			}
+			message = append(message, payload...)
+
+		default:
+			return nil, bosherr.Errorf("Received websocket frame with unknown opcode %d", opcode)
+		}
+
+		if len(message) > websocketMaxMessageLength {
+			return nil, bosherr.Error("Websocket message is too long")
+		}
+
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+func (c *websocketConn) WriteMessage(message []byte) error {
+	return c.writeFrame(websocketOpText, message)
+}
+
+func (c *websocketConn) WritePing() error {
+	return c.writeFrame(websocketOpPing, nil)
+}
+
+// Close closes connection without waiting for the other
+// side to acknowledge since connection is not used afterwards
+func (c *websocketConn) Close() error {
+	_ = c.writeFrame(websocketOpClose, nil)
+	return c.conn.Close()
+}
+
+func (c *websocketConn) readFrame() (bool, byte, []byte, error) {
+	if c.readTimeout > 0 {
+		err := c.conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+		if err != nil {
+			return false, 0, nil, bosherr.WrapError(err, "Setting read deadline")
+		}
+	}
+
+	header := make([]byte, 2)
+
+	_, err := io.ReadFull(c.reader, header)
+	if err != nil {
+		return false, 0, nil, err
+	}
+
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+
+	switch length {
+	case 126:
+		extended := make([]byte, 2)
+		_, err = io.ReadFull(c.reader, extended)
+		length = uint64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		_, err = io.ReadFull(c.reader, extended)
+		length = binary.BigEndian.Uint64(extended)
+	}
+	if err != nil {
+		return false, 0, nil, err
+	}
+
+	if length > websocketMaxMessageLength {
+		return false, 0, nil, bosherr.Error("Websocket frame is too long")
+	}
+
+	var maskKey []byte
+
+	if masked {
+		maskKey = make([]byte, 4)
+
+		_, err = io.ReadFull(c.reader, maskKey)
+		if err != nil {
+			return false, 0, nil, err
+		}
+	}
+
+	payload := make([]byte, length)
+
+	_, err = io.ReadFull(c.reader, payload)
+	if err != nil {
+		return false, 0, nil, err
+	}
+
+	if masked {
+		maskWebsocketPayload(payload, maskKey)
+	}
+
+	return fin, opcode, payload, nil
+}
+
+func (c *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	if opcode >= websocketOpClose && len(payload) > websocketMaxControlPayloadLength {
+		return bosherr.Error("Websocket control frame payload is too long")
+	}
+
+	frame := []byte{0x80 | opcode}
+
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+
+	length := len(payload)
+
+	switch {
+	case length < 126:
+		frame = append(frame, maskBit|byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, maskBit|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(length))
+	default:
+		frame = append(frame, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(length))
+	}
+
+	// Payload is copied so that masking does not change the caller's slice
+	payloadStart := len(frame)
+
+	if c.client {
+		maskKey := make([]byte, 4)
+
+		_, err := rand.Read(maskKey)
+		if err != nil {
+			return bosherr.WrapError(err, "Generating websocket mask")
+		}
+
+		frame = append(frame, maskKey...)
+		payloadStart += 4
+		frame = append(frame, payload...)
+		maskWebsocketPayload(frame[payloadStart:], maskKey)
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	_, err := c.conn.Write(frame)
+
+	return err
+}
+
+func maskWebsocketPayload(payload []byte, maskKey []byte) {
+	for i := range payload {
+		payload[i] ^= maskKey[i%4]
+	}
+}
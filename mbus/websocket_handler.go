@@ -0,0 +1,460 @@
+package mbus
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
+	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+)
+
+const (
+	websocketDialTimeout = 10 * time.Second
+
+	defaultWebsocketPingIntervalSeconds = 30
+)
+
+type WebsocketOptions struct {
+	// Ping is sent this often and connection is re-established when
+	// nothing is received for twice as long; defaults to 30 seconds
+	PingIntervalSeconds int
+}
+
+func (o WebsocketOptions) pingInterval() time.Duration {
+	if o.PingIntervalSeconds > 0 {
+		return time.Duration(o.PingIntervalSeconds) * time.Second
+	}
+	return defaultWebsocketPingIntervalSeconds * time.Second
+}
+
+// websocketMessage is sent by the agent; subject is the NATS subject
+// that message would have been published to so that director can
+// route responses, heartbeats and alerts the same way
+type websocketMessage struct {
+	Subject string          `json:"subject"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// websocketHandler dials out to the director so that agents that
+// cannot be reached (e.g. behind NAT) receive requests over the
+// connection they established. Director sends the same JSON requests
+// that it would publish to NATS.
+type websocketHandler struct {
+	mbusURL         string
+	settingsService boshsettings.Service
+
+	// Sent messages are written from the queue so that
+	// they are not lost while connection is re-established
+	outboundQueue boshhandler.OutboundQueue
+
+	responseOverflow boshhandler.ResponseOverflow
+	signer           *boshhandler.MessageSigner
+	chain            *boshhandler.Chain
+	pingInterval     time.Duration
+
+	// Certificates from settings unless updated later
+	cert     *boshsettings.CertKeyPair
+	certLock sync.RWMutex
+
+	conn        *websocketConn
+	connectedCh chan struct{}
+	connLock    sync.Mutex
+
+	stopCh chan struct{}
+	lock   sync.Mutex
+
+	logger boshlog.Logger
+	logTag string
+}
+
+func NewWebsocketHandler(
+	mbusURL string,
+	settingsService boshsettings.Service,
+	outboundQueue boshhandler.OutboundQueue,
+	responseOverflow boshhandler.ResponseOverflow,
+	signer *boshhandler.MessageSigner,
+	options WebsocketOptions,
+	logger boshlog.Logger,
+) Handler {
+	return &websocketHandler{
+		mbusURL:          mbusURL,
+		settingsService:  settingsService,
+		outboundQueue:    outboundQueue,
+		responseOverflow: responseOverflow,
+		signer:           signer,
+		chain:            boshhandler.NewChain(),
+		pingInterval:     options.pingInterval(),
+		connectedCh:      make(chan struct{}, 1),
+		logger:           logger,
+		logTag:           "Websocket Handler",
+	}
+}
+
+func (h *websocketHandler) Run(handlerFunc boshhandler.Func) error {
+	err := h.Start(handlerFunc)
+	if err != nil {
+		return bosherr.WrapError(err, "Starting websocket handler")
+	}
+
+	defer h.Stop()
+
+	h.lock.Lock()
+	stopCh := h.stopCh
+	h.lock.Unlock()
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(c)
+
+	select {
+	case <-c:
+	case <-stopCh:
+	}
+
+	return nil
+}
+
+// Start returns once configuration is checked; connection
+// is established (and re-established) in the background
+func (h *websocketHandler) Start(handlerFunc boshhandler.Func) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.stopCh != nil {
+		return bosherr.Error("Websocket handler is already started")
+	}
+
+	wsURL, err := url.Parse(h.mbusURL)
+	if err != nil {
+		return bosherr.WrapError(err, "Parsing mbus URL")
+	}
+
+	_, err = newNatsTLSConfig(wsURL.Hostname(), h.getCert())
+	if err != nil {
+		return bosherr.WrapError(err, "Configuring TLS")
+	}
+
+	h.stopCh = make(chan struct{})
+
+	go h.connect(wsURL, h.chain.Func(handlerFunc), h.stopCh)
+	go h.publishQueuedMessages(h.stopCh)
+
+	return nil
+}
+
+func (h *websocketHandler) Stop() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.stopCh == nil {
+		return
+	}
+
+	close(h.stopCh)
+	h.stopCh = nil
+
+	// Unblocks reading from the connection
+	conn := h.currentConn()
+	if conn != nil {
+		_ = conn.Close()
+	}
+}
+
+func (h *websocketHandler) RegisterAdditionalFunc(handlerFunc boshhandler.Func) {
+	h.chain.Register(handlerFunc)
+}
+
+func (h *websocketHandler) Send(target boshhandler.Target, topic boshhandler.Topic, message interface{}) error {
+	bytes, err := json.Marshal(message)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Marshalling message (target=%s, topic=%s): %#v", target, topic, message)
+	}
+
+	h.logger.Info(h.logTag, "Sending %s message '%s'", target, topic)
+	h.logger.DebugWithDetails(h.logTag, "Message Payload", string(bytes))
+
+	settings := h.settingsService.GetSettings()
+
+	outboundMessage := boshhandler.OutboundMessage{
+		Subject: fmt.Sprintf("%s.agent.%s.%s", target, topic, settings.AgentID),
+		Payload: bytes,
+	}
+
+	// Only the latest heartbeat is delivered after reconnecting
+	if topic == boshhandler.Heartbeat {
+		outboundMessage.CoalesceKey = outboundMessage.Subject
+	}
+
+	err = h.outboundQueue.Push(outboundMessage)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Queueing message (target=%s, topic=%s)", target, topic)
+	}
+
+	return nil
+}
+
+// Healthy returns whether agent is connected to the director
+func (h *websocketHandler) Healthy() bool {
+	return h.currentConn() != nil
+}
+
+func (h *websocketHandler) QueueStats() boshhandler.QueueStats {
+	return h.outboundQueue.Stats()
+}
+
+func (h *websocketHandler) UpdateCert(cert boshsettings.CertKeyPair) error {
+	_, err := newNatsTLSConfig("", cert)
+	if err != nil {
+		return bosherr.WrapError(err, "Updating websocket certificates")
+	}
+
+	h.certLock.Lock()
+	h.cert = &cert
+	h.certLock.Unlock()
+
+	h.logger.Info(h.logTag, "Updated websocket certificates; they will be used once connection is re-established")
+
+	return nil
+}
+
+func (h *websocketHandler) getCert() boshsettings.CertKeyPair {
+	h.certLock.RLock()
+	defer h.certLock.RUnlock()
+
+	if h.cert != nil {
+		return *h.cert
+	}
+
+	return h.settingsService.GetSettings().Env.GetMbusCert()
+}
+
+// connect keeps connection to the director open until handler is stopped
+func (h *websocketHandler) connect(wsURL *url.URL, handlerFunc boshhandler.Func, stopCh <-chan struct{}) {
+	backoff := boshhandler.NewBackoff(reconnectInitialDelay, reconnectMaxDelay)
+	address := boshhandler.RedactedURL(wsURL.String())
+
+	for {
+		conn, err := h.dial(wsURL)
+		if err == nil {
+			backoff.Reset()
+
+			h.logger.Info(h.logTag, "Connected to %s", address)
+
+			err = h.serve(conn, handlerFunc, stopCh)
+
+			_ = conn.Close()
+		}
+
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+
+		delay := backoff.Next()
+
+		h.logger.Warn(h.logTag, "Connection to %s failed, reconnecting in %s: %s", address, delay, err.Error())
+
+		select {
+		case <-time.After(delay):
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func (h *websocketHandler) dial(wsURL *url.URL) (*websocketConn, error) {
+	tlsConfig, err := newNatsTLSConfig(wsURL.Hostname(), h.getCert())
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Configuring TLS")
+	}
+
+	header := http.Header{}
+	header.Set("X-Bosh-Agent-Id", h.settingsService.GetSettings().AgentID)
+
+	conn, err := dialWebsocket(wsURL, header, tlsConfig, websocketDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	// Director is expected to answer pings
+	conn.readTimeout = 2 * h.pingInterval
+
+	return conn, nil
+}
+
+// serve handles requests received over the connection until it fails
+func (h *websocketHandler) serve(conn *websocketConn, handlerFunc boshhandler.Func, stopCh <-chan struct{}) error {
+	h.setConn(conn, stopCh)
+	defer h.setConn(nil, stopCh)
+
+	keepAliveStopCh := make(chan struct{})
+	defer close(keepAliveStopCh)
+
+	go h.keepAlive(conn, keepAliveStopCh)
+
+	for {
+		message, err := conn.ReadMessage()
+		if err != nil {
+			return bosherr.WrapError(err, "Reading message")
+		}
+
+		// Long running requests do not hold up following requests and pings
+		go h.handleMessage(conn, message, handlerFunc)
+	}
+}
+
+func (h *websocketHandler) keepAlive(conn *websocketConn, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(h.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := conn.WritePing()
+			if err != nil {
+				// Makes reading fail so that connection is re-established
+				_ = conn.Close()
+				return
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func (h *websocketHandler) handleMessage(conn *websocketConn, message []byte, handlerFunc boshhandler.Func) {
+	reqBytes, err := h.signer.Open(message)
+	if err != nil {
+		h.logger.Error(h.logTag, "Verifying request: %s", err.Error())
+		return
+	}
+
+	respBytes, req, err := boshhandler.PerformHandlerWithJSON(
+		reqBytes,
+		boshhandler.WithRequestSource(boshhandler.RequestSource{Transport: "websocket"}, handlerFunc),
+		h.signer.MaxPayloadLength(responseMaxLength),
+		h.responseOverflow,
+		h.logger,
+	)
+	if err != nil {
+		h.logger.Error(h.logTag, "Running handler: %s", err)
+		return
+	}
+
+	if len(respBytes) == 0 {
+		return
+	}
+
+	respBytes, err = h.signer.Sign(respBytes)
+	if err != nil {
+		h.logger.Error(h.logTag, "Signing response: %s", err.Error())
+		return
+	}
+
+	err = h.write(conn, req.ReplyTo, respBytes)
+	if err != nil {
+		h.logger.Error(h.logTag, "Responding to the director: %s", err.Error())
+	}
+}
+
+// publishQueuedMessages writes queued messages in order while connected
+func (h *websocketHandler) publishQueuedMessages(stopCh <-chan struct{}) {
+	retryBackoff := boshhandler.NewBackoff(reconnectInitialDelay, reconnectMaxDelay)
+
+	for {
+		message, found, err := h.outboundQueue.First()
+		if err != nil {
+			h.logger.Error(h.logTag, "Reading outbound queue: %s", err.Error())
+		}
+
+		if !found {
+			select {
+			case <-h.outboundQueue.Ready():
+				continue
+			case <-stopCh:
+				return
+			}
+		}
+
+		conn := h.currentConn()
+		if conn == nil {
+			select {
+			case <-h.connectedCh:
+				continue
+			case <-stopCh:
+				return
+			}
+		}
+
+		err = h.write(conn, message.Subject, message.Payload)
+		if err != nil {
+			delay := retryBackoff.Next()
+
+			h.logger.Warn(h.logTag, "Sending to %s failed, retrying in %s (%d messages queued): %s",
+				message.Subject, delay, h.outboundQueue.Stats().Depth, err.Error())
+
+			select {
+			case <-time.After(delay):
+				continue
+			case <-stopCh:
+				return
+			}
+		}
+
+		retryBackoff.Reset()
+
+		err = h.outboundQueue.Remove(message.ID)
+		if err != nil {
+			h.logger.Error(h.logTag, "Removing sent message from outbound queue: %s", err.Error())
+		}
+	}
+}
+
+func (h *websocketHandler) write(conn *websocketConn, subject string, payload []byte) error {
+	messageJSON, err := json.Marshal(websocketMessage{Subject: subject, Payload: payload})
+	if err != nil {
+		return bosherr.WrapError(err, "Marshalling websocket message")
+	}
+
+	return conn.WriteMessage(messageJSON)
+}
+
+func (h *websocketHandler) setConn(conn *websocketConn, stopCh <-chan struct{}) {
+	h.connLock.Lock()
+	defer h.connLock.Unlock()
+
+	h.conn = conn
+
+	if conn == nil {
+		return
+	}
+
+	// Connection established while stopping would not be closed otherwise
+	select {
+	case <-stopCh:
+		_ = conn.Close()
+	default:
+	}
+
+	select {
+	case h.connectedCh <- struct{}{}:
+	default:
+	}
+}
+
+func (h *websocketHandler) currentConn() *websocketConn {
+	h.connLock.Lock()
+	defer h.connLock.Unlock()
+
+	return h.conn
+}
@@ -0,0 +1,214 @@
+package mbus_test
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
+	. "github.com/cloudfoundry/bosh-agent/mbus"
+	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+)
+
+type websocketAgentMessage struct {
+	Subject string          `json:"subject"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+var _ = Describe("websocketHandler", func() {
+	var (
+		server          *httptest.Server
+		serverConns     chan WebsocketServerConn
+		upgradeRequests chan *http.Request
+		settingsService *fakesettings.FakeSettingsService
+		outboundQueue   boshhandler.OutboundQueue
+		pingInterval    time.Duration
+		handler         Handler
+	)
+
+	BeforeEach(func() {
+		serverConns = make(chan WebsocketServerConn, 10)
+		upgradeRequests = make(chan *http.Request, 10)
+
+		// Channels are captured since connections may still arrive after test is finished
+		conns, requests := serverConns, upgradeRequests
+
+		server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := AcceptWebsocket(w, r)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			requests <- r
+			conns <- conn
+		}))
+
+		caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+		settingsService = &fakesettings.FakeSettingsService{
+			Settings: boshsettings.Settings{
+				AgentID: "my-agent-id",
+				Env: boshsettings.Env{
+					Bosh: boshsettings.BoshEnv{
+						Mbus: boshsettings.MbusEnv{Cert: boshsettings.CertKeyPair{CA: string(caPEM)}},
+					},
+				},
+			},
+		}
+
+		logger := boshlog.NewLogger(boshlog.LevelNone)
+		outboundQueue = boshhandler.NewOutboundQueue(boshhandler.OutboundQueueOptions{}, fakesys.NewFakeFileSystem(), "/fake-queue-path", logger)
+		pingInterval = time.Minute
+	})
+
+	JustBeforeEach(func() {
+		logger := boshlog.NewLogger(boshlog.LevelNone)
+		wsURL := strings.Replace(server.URL, "https://", "wss://fake-user:fake-pass@", 1) + "/agents"
+		handler = NewWebsocketHandlerWithPingInterval(wsURL, settingsService, outboundQueue, pingInterval, logger)
+	})
+
+	AfterEach(func() {
+		handler.Stop()
+		server.Close()
+	})
+
+	readAgentMessage := func(conn WebsocketServerConn) websocketAgentMessage {
+		var message websocketAgentMessage
+
+		messageJSON, err := conn.ReadMessage()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(json.Unmarshal(messageJSON, &message)).To(Succeed())
+
+		return message
+	}
+
+	It("dials out to the director with credentials and agent id", func() {
+		err := handler.Start(func(req boshhandler.Request) boshhandler.Response { return nil })
+		Expect(err).ToNot(HaveOccurred())
+
+		var request *http.Request
+		Eventually(upgradeRequests).Should(Receive(&request))
+
+		username, password, ok := request.BasicAuth()
+		Expect(ok).To(BeTrue())
+		Expect(username).To(Equal("fake-user"))
+		Expect(password).To(Equal("fake-pass"))
+		Expect(request.URL.Path).To(Equal("/agents"))
+		Expect(request.Header.Get("X-Bosh-Agent-Id")).To(Equal("my-agent-id"))
+
+		Eventually(handler.(boshhandler.HealthChecker).Healthy).Should(BeTrue())
+	})
+
+	It("receives requests and responds over the same connection", func() {
+		var receivedRequest boshhandler.Request
+
+		err := handler.Start(func(req boshhandler.Request) boshhandler.Response {
+			receivedRequest = req
+			return boshhandler.NewValueResponse("pong")
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		var conn WebsocketServerConn
+		Eventually(serverConns).Should(Receive(&conn))
+
+		requestJSON := []byte(`{"method":"ping","arguments":[],"reply_to":"director.fake-reply-to"}`)
+		Expect(conn.WriteMessage(requestJSON)).To(Succeed())
+
+		message := readAgentMessage(conn)
+		Expect(message.Subject).To(Equal("director.fake-reply-to"))
+		Expect(string(message.Payload)).To(Equal(`{"value":"pong"}`))
+
+		Expect(receivedRequest.Method).To(Equal("ping"))
+		Expect(receivedRequest.Payload).To(Equal(requestJSON))
+		Expect(receivedRequest.Source).To(Equal(boshhandler.RequestSource{Transport: "websocket"}))
+	})
+
+	It("sends heartbeats and alerts over the connection", func() {
+		err := handler.Start(func(req boshhandler.Request) boshhandler.Response { return nil })
+		Expect(err).ToNot(HaveOccurred())
+
+		err = handler.Send(boshhandler.HealthMonitor, boshhandler.Heartbeat, map[string]string{"fake-key": "fake-value"})
+		Expect(err).ToNot(HaveOccurred())
+
+		var conn WebsocketServerConn
+		Eventually(serverConns).Should(Receive(&conn))
+
+		message := readAgentMessage(conn)
+		Expect(message.Subject).To(Equal("hm.agent.heartbeat.my-agent-id"))
+		Expect(string(message.Payload)).To(Equal(`{"fake-key":"fake-value"}`))
+
+		Eventually(func() int { return outboundQueue.Stats().Depth }).Should(Equal(0))
+	})
+
+	It("reconnects when connection is closed by the director", func() {
+		err := handler.Start(func(req boshhandler.Request) boshhandler.Response {
+			return boshhandler.NewValueResponse("pong")
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		var conn WebsocketServerConn
+		Eventually(serverConns).Should(Receive(&conn))
+		Expect(conn.Close()).To(Succeed())
+
+		Eventually(serverConns, 5*time.Second).Should(Receive(&conn))
+
+		Expect(conn.WriteMessage([]byte(`{"method":"ping","arguments":[],"reply_to":"fake-reply-to"}`))).To(Succeed())
+		Expect(readAgentMessage(conn).Subject).To(Equal("fake-reply-to"))
+	})
+
+	Context("when director does not answer pings", func() {
+		BeforeEach(func() {
+			pingInterval = 50 * time.Millisecond
+		})
+
+		It("re-establishes connection", func() {
+			err := handler.Start(func(req boshhandler.Request) boshhandler.Response { return nil })
+			Expect(err).ToNot(HaveOccurred())
+
+			// Connection is not read from so pings are never answered
+			Eventually(serverConns).Should(Receive())
+			Eventually(serverConns, 5*time.Second).Should(Receive())
+		})
+	})
+
+	Context("when director answers pings", func() {
+		BeforeEach(func() {
+			pingInterval = 50 * time.Millisecond
+		})
+
+		It("keeps the connection", func() {
+			err := handler.Start(func(req boshhandler.Request) boshhandler.Response { return nil })
+			Expect(err).ToNot(HaveOccurred())
+
+			var conn WebsocketServerConn
+			Eventually(serverConns).Should(Receive(&conn))
+
+			// Pings are answered while reading
+			go conn.ReadMessage()
+
+			Consistently(serverConns, 500*time.Millisecond).ShouldNot(Receive())
+		})
+	})
+
+	Context("when mbus CA certificate is invalid", func() {
+		BeforeEach(func() {
+			settingsService.Settings.Env.Bosh.Mbus.Cert.CA = "fake-invalid-ca"
+		})
+
+		It("returns an error", func() {
+			err := handler.Start(func(req boshhandler.Request) boshhandler.Response { return nil })
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Configuring TLS"))
+		})
+	})
+})
@@ -2,7 +2,7 @@ package micro
 
 import (
 	"bufio"
-	"encoding/base64"
+	"crypto/subtle"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -18,25 +18,70 @@ import (
 	boshsys "github.com/cloudfoundry/bosh-utils/system"
 )
 
+// HTTPSOptions configure certificates of HTTPS handler
+// and credentials that clients authenticate with
+type HTTPSOptions struct {
+	boshdispatcher.TLSOptions
+
+	// Accepted besides credentials included in mbus URL
+	// so that credentials can be rotated without downtime
+	Credentials []HTTPSCredential
+
+	// Maps common name of verified client certificate to identity
+	// that request is made by; when set, certificates with other common
+	// names are rejected; when empty, common name is used as identity
+	ClientIdentities map[string]string
+
+	// Longer responses are uploaded to the blobstore (e.g. when proxy
+	// between director and agent limits response size); unlimited when 0
+	MaxResponseLength int
+}
+
+func (o HTTPSOptions) maxResponseLength() int {
+	if o.MaxResponseLength > 0 {
+		return o.MaxResponseLength
+	}
+	return boshhandler.UnlimitedResponseLength
+}
+
+type HTTPSCredential struct {
+	Username string
+	Password string
+}
+
 type HTTPSHandler struct {
-	parsedURL   *url.URL
-	logger      boshlog.Logger
-	dispatcher  *boshdispatcher.HTTPSDispatcher
-	fs          boshsys.FileSystem
-	dirProvider boshdir.Provider
+	parsedURL     *url.URL
+	options       HTTPSOptions
+	overflow      boshhandler.ResponseOverflow
+	signer        *boshhandler.MessageSigner
+	webhookSender *WebhookSender
+	chain         *boshhandler.Chain
+	logger        boshlog.Logger
+	dispatcher    *boshdispatcher.HTTPSDispatcher
+	fs            boshsys.FileSystem
+	dirProvider   boshdir.Provider
 }
 
 func NewHTTPSHandler(
 	parsedURL *url.URL,
+	options HTTPSOptions,
+	overflow boshhandler.ResponseOverflow,
+	signer *boshhandler.MessageSigner,
+	webhookSender *WebhookSender,
 	logger boshlog.Logger,
 	fs boshsys.FileSystem,
 	dirProvider boshdir.Provider,
 ) (handler HTTPSHandler) {
 	handler.parsedURL = parsedURL
+	handler.options = options
+	handler.overflow = overflow
+	handler.signer = signer
+	handler.webhookSender = webhookSender
+	handler.chain = boshhandler.NewChain()
 	handler.logger = logger
 	handler.fs = fs
 	handler.dirProvider = dirProvider
-	handler.dispatcher = boshdispatcher.NewHTTPSDispatcher(parsedURL, logger)
+	handler.dispatcher = boshdispatcher.NewHTTPSDispatcher(parsedURL, options.TLSOptions, logger)
 	return
 }
 
@@ -49,30 +94,67 @@ func (h HTTPSHandler) Run(handlerFunc boshhandler.Func) error {
 }
 
 func (h HTTPSHandler) Start(handlerFunc boshhandler.Func) error {
-	h.dispatcher.AddRoute("/agent", h.agentHandler(handlerFunc))
+	h.dispatcher.AddRoute("/agent", h.agentHandler(h.chain.Func(handlerFunc)))
 	h.dispatcher.AddRoute("/blobs/", h.blobsHandler())
+	h.webhookSender.Start()
 	return h.dispatcher.Start()
 }
 
 func (h HTTPSHandler) Stop() {
+	h.webhookSender.Stop()
 	h.dispatcher.Stop()
 }
 
 func (h HTTPSHandler) RegisterAdditionalFunc(handlerFunc boshhandler.Func) {
-	panic("HTTPSHandler does not support registering additional handler funcs")
+	h.chain.Register(handlerFunc)
 }
 
+// Send delivers message to configured webhooks since there is
+// no message bus that the health monitor would listen on
 func (h HTTPSHandler) Send(target boshhandler.Target, topic boshhandler.Topic, message interface{}) error {
-	return nil
+	return h.webhookSender.Send(target, topic, message)
 }
 
-func (h HTTPSHandler) requestNotAuthorized(request *http.Request) bool {
-	username := h.parsedURL.User.Username()
-	password, _ := h.parsedURL.User.Password()
-	auth := username + ":" + password
-	expectedAuthorizationHeader := "Basic " + base64.StdEncoding.EncodeToString([]byte(auth))
+// authenticate returns identity of the client that made the request.
+// Verified client certificate takes precedence over basic auth.
+func (h HTTPSHandler) authenticate(request *http.Request) (string, bool) {
+	if request.TLS != nil && len(request.TLS.VerifiedChains) > 0 {
+		commonName := request.TLS.VerifiedChains[0][0].Subject.CommonName
+
+		if len(h.options.ClientIdentities) == 0 {
+			return commonName, true
+		}
+
+		identity, found := h.options.ClientIdentities[commonName]
+		return identity, found
+	}
 
-	return expectedAuthorizationHeader != request.Header.Get("Authorization")
+	username, password, ok := request.BasicAuth()
+	if !ok {
+		return "", false
+	}
+
+	for _, credential := range h.credentials() {
+		usernameMatches := subtle.ConstantTimeCompare([]byte(username), []byte(credential.Username)) == 1
+		passwordMatches := subtle.ConstantTimeCompare([]byte(password), []byte(credential.Password)) == 1
+
+		if usernameMatches && passwordMatches {
+			return username, true
+		}
+	}
+
+	return "", false
+}
+
+func (h HTTPSHandler) credentials() []HTTPSCredential {
+	var credentials []HTTPSCredential
+
+	if h.parsedURL.User != nil {
+		password, _ := h.parsedURL.User.Password()
+		credentials = append(credentials, HTTPSCredential{Username: h.parsedURL.User.Username(), Password: password})
+	}
+
+	return append(credentials, h.options.Credentials...)
 }
 
 func (h HTTPSHandler) agentHandler(handlerFunc boshhandler.Func) (agentHandler func(http.ResponseWriter, *http.Request)) {
@@ -82,7 +164,8 @@ func (h HTTPSHandler) agentHandler(handlerFunc boshhandler.Func) (agentHandler f
 			return
 		}
 
-		if h.requestNotAuthorized(r) {
+		identity, authorized := h.authenticate(r)
+		if !authorized {
 			w.Header().Add("WWW-Authenticate", `Basic realm=""`)
 			w.WriteHeader(401)
 			return
@@ -95,10 +178,23 @@ func (h HTTPSHandler) agentHandler(handlerFunc boshhandler.Func) (agentHandler f
 			return
 		}
 
+		rawJSONPayload, err = h.signer.Open(rawJSONPayload)
+		if err != nil {
+			h.logger.Error("https_handler", "Verifying request: %s", err.Error())
+			w.WriteHeader(403)
+			return
+		}
+
+		source := boshhandler.RequestSource{
+			Transport: "https",
+			Identity:  identity,
+		}
+
 		respBytes, _, err := boshhandler.PerformHandlerWithJSON(
 			rawJSONPayload,
-			handlerFunc,
-			boshhandler.UnlimitedResponseLength,
+			boshhandler.WithRequestSource(source, handlerFunc),
+			h.signer.MaxPayloadLength(h.options.maxResponseLength()),
+			h.overflow,
 			h.logger,
 		)
 		if err != nil {
@@ -107,6 +203,14 @@ func (h HTTPSHandler) agentHandler(handlerFunc boshhandler.Func) (agentHandler f
 			return
 		}
 
+		respBytes, err = h.signer.Sign(respBytes)
+		if err != nil {
+			err = bosherr.WrapError(err, "Signing response")
+			h.logger.Error("https_handler", err.Error())
+			w.WriteHeader(500)
+			return
+		}
+
 		_, err = w.Write(respBytes)
 		if err != nil {
 			err = bosherr.WrapError(err, "Writing response")
@@ -0,0 +1,294 @@
+package micro
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	boshsys "github.com/cloudfoundry/bosh-utils/system"
+)
+
+const (
+	webhookSenderLogTag = "webhookSender"
+
+	defaultWebhookRetryDelaySeconds    = 5
+	defaultWebhookMaxRetryDelaySeconds = 30
+	defaultWebhookTimeoutSeconds       = 10
+)
+
+// WebhookOptions configure URL that receives messages sent by the agent
+// (heartbeats, alerts...) when agent is reached over HTTPS instead of NATS
+type WebhookOptions struct {
+	// Credentials included in URL are sent with basic auth
+	URL string
+
+	// Added to each request (e.g. Authorization)
+	Headers map[string]string
+
+	// PEM encoded CA certificate used to verify the webhook server;
+	// system CAs are used when it is empty
+	CACert string
+
+	// Failed delivery is retried with delay doubling
+	// from RetryDelaySeconds up to MaxRetryDelaySeconds
+	RetryDelaySeconds    int
+	MaxRetryDelaySeconds int
+
+	TimeoutSeconds int
+}
+
+func (o WebhookOptions) retryDelay() time.Duration {
+	if o.RetryDelaySeconds > 0 {
+		return time.Duration(o.RetryDelaySeconds) * time.Second
+	}
+	return defaultWebhookRetryDelaySeconds * time.Second
+}
+
+func (o WebhookOptions) maxRetryDelay() time.Duration {
+	maxDelay := time.Duration(defaultWebhookMaxRetryDelaySeconds) * time.Second
+	if o.MaxRetryDelaySeconds > 0 {
+		maxDelay = time.Duration(o.MaxRetryDelaySeconds) * time.Second
+	}
+
+	if maxDelay < o.retryDelay() {
+		return o.retryDelay()
+	}
+	return maxDelay
+}
+
+func (o WebhookOptions) timeout() time.Duration {
+	if o.TimeoutSeconds > 0 {
+		return time.Duration(o.TimeoutSeconds) * time.Second
+	}
+	return defaultWebhookTimeoutSeconds * time.Second
+}
+
+type webhook struct {
+	options WebhookOptions
+	client  *http.Client
+
+	// Messages are delivered in order by a single worker
+	// so that unavailable webhook holds up only its own queue
+	queue boshhandler.OutboundQueue
+}
+
+// WebhookSender POSTs each message to all configured webhooks.
+// Body is the same JSON payload that would be published to NATS
+// and X-Bosh-Subject header carries the NATS subject.
+type WebhookSender struct {
+	webhooks []webhook
+	agentID  string
+	logger   boshlog.Logger
+
+	workersStopCh chan struct{}
+	workersLock   sync.Mutex
+}
+
+// NewWebhookSender queues messages of each webhook in its own file
+// in given directory when queue options ask for persistent queue
+func NewWebhookSender(
+	options []WebhookOptions,
+	agentID string,
+	queueOptions boshhandler.OutboundQueueOptions,
+	fs boshsys.FileSystem,
+	queueDir string,
+	logger boshlog.Logger,
+) (*WebhookSender, error) {
+	sender := &WebhookSender{
+		agentID: agentID,
+		logger:  logger,
+	}
+
+	for i, webhookOptions := range options {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+		if webhookOptions.CACert != "" {
+			tlsConfig.RootCAs = x509.NewCertPool()
+
+			if !tlsConfig.RootCAs.AppendCertsFromPEM([]byte(webhookOptions.CACert)) {
+				return nil, bosherr.Errorf("Parsing CA certificate of webhook %s", boshhandler.RedactedURL(webhookOptions.URL))
+			}
+		}
+
+		queuePath := filepath.Join(queueDir, fmt.Sprintf("webhook_outbound_queue_%d.json", i))
+
+		sender.webhooks = append(sender.webhooks, webhook{
+			options: webhookOptions,
+			client: &http.Client{
+				Transport: &http.Transport{
+					Proxy:           http.ProxyFromEnvironment,
+					TLSClientConfig: tlsConfig,
+				},
+				Timeout: webhookOptions.timeout(),
+			},
+			queue: boshhandler.NewOutboundQueue(queueOptions, fs, queuePath, logger),
+		})
+	}
+
+	return sender, nil
+}
+
+// Start starts one worker per webhook that delivers queued messages
+func (s *WebhookSender) Start() {
+	s.workersLock.Lock()
+	defer s.workersLock.Unlock()
+
+	if s.workersStopCh != nil {
+		return
+	}
+
+	s.workersStopCh = make(chan struct{})
+
+	for _, w := range s.webhooks {
+		go s.deliverQueuedMessages(w, s.workersStopCh)
+	}
+}
+
+// Stop stops workers once they finish delivering current messages;
+// messages left in the queues are delivered after sender is started again
+func (s *WebhookSender) Stop() {
+	s.workersLock.Lock()
+	defer s.workersLock.Unlock()
+
+	if s.workersStopCh != nil {
+		close(s.workersStopCh)
+		s.workersStopCh = nil
+	}
+}
+
+// Send queues message for each webhook so that unavailable
+// webhook does not hold up the agent; messages are dropped only
+// when queue overflows while webhook is unavailable
+func (s *WebhookSender) Send(target boshhandler.Target, topic boshhandler.Topic, message interface{}) error {
+	if len(s.webhooks) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Marshalling message (target=%s, topic=%s): %#v", target, topic, message)
+	}
+
+	outboundMessage := boshhandler.OutboundMessage{
+		Subject: fmt.Sprintf("%s.agent.%s.%s", target, topic, s.agentID),
+		Payload: payload,
+	}
+
+	// Only the latest heartbeat is worth delivering to webhook that fell behind
+	if topic == boshhandler.Heartbeat {
+		outboundMessage.CoalesceKey = outboundMessage.Subject
+	}
+
+	for _, w := range s.webhooks {
+		err = w.queue.Push(outboundMessage)
+		if err != nil {
+			return bosherr.WrapErrorf(err, "Queueing message for webhook %s (target=%s, topic=%s)", boshhandler.RedactedURL(w.options.URL), target, topic)
+		}
+	}
+
+	return nil
+}
+
+// deliverQueuedMessages delivers queued messages in order. Message that
+// cannot be delivered (e.g. while webhook is unavailable) is kept in the
+// queue and delivery is retried with increasing delay.
+func (s *WebhookSender) deliverQueuedMessages(w webhook, stopCh <-chan struct{}) {
+	webhookURL := boshhandler.RedactedURL(w.options.URL)
+	retryBackoff := boshhandler.NewBackoff(w.options.retryDelay(), w.options.maxRetryDelay())
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+
+		message, found, err := w.queue.First()
+		if err != nil {
+			s.logger.Error(webhookSenderLogTag, "Reading queue of webhook %s: %s", webhookURL, err.Error())
+		}
+
+		if !found {
+			select {
+			case <-w.queue.Ready():
+				continue
+			case <-stopCh:
+				return
+			}
+		}
+
+		retryable, err := s.post(w, message.Subject, message.Payload)
+		if err != nil && retryable {
+			delay := retryBackoff.Next()
+
+			s.logger.Warn(webhookSenderLogTag, "Delivering %s to webhook %s failed, retrying in %s (%d messages queued): %s",
+				message.Subject, webhookURL, delay, w.queue.Stats().Depth, err.Error())
+
+			select {
+			case <-time.After(delay):
+				continue
+			case <-stopCh:
+				return
+			}
+		}
+
+		retryBackoff.Reset()
+
+		if err != nil {
+			// Rejected message would be rejected again
+			s.logger.Error(webhookSenderLogTag, "Dropping %s rejected by webhook %s: %s", message.Subject, webhookURL, err.Error())
+		} else {
+			s.logger.Debug(webhookSenderLogTag, "Delivered %s to webhook %s", message.Subject, webhookURL)
+		}
+
+		err = w.queue.Remove(message.ID)
+		if err != nil {
+			s.logger.Error(webhookSenderLogTag, "Removing message from queue of webhook %s: %s", webhookURL, err.Error())
+		}
+	}
+}
+
+// post returns whether failed request is worth retrying
+func (s *WebhookSender) post(w webhook, subject string, payload []byte) (bool, error) {
+	request, err := http.NewRequest("POST", w.options.URL, bytes.NewReader(payload))
+	if err != nil {
+		return false, bosherr.WrapError(err, "Building request")
+	}
+
+	for name, value := range w.options.Headers {
+		request.Header.Set(name, value)
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Bosh-Subject", subject)
+
+	response, err := w.client.Do(request)
+	if err != nil {
+		return true, bosherr.WrapError(err, "Performing request")
+	}
+
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, response.Body)
+		_ = response.Body.Close()
+	}()
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+
+	// Other client errors would fail the same way again
+	retryable := response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests
+
+	return retryable, bosherr.Errorf("Webhook responded with status %d", response.StatusCode)
+}
@@ -0,0 +1,73 @@
+package cancellable
+
+import (
+	boshblob "github.com/cloudfoundry/bosh-utils/blobstore"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshsys "github.com/cloudfoundry/bosh-utils/system"
+)
+
+// BlobstoreBuilder builds blobstore that shells out (e.g. to blobstore cli) with given runner
+type BlobstoreBuilder func(boshsys.CmdRunner) (boshblob.Blobstore, error)
+
+// Blobstore can be asked for a blobstore whose
+// commands are terminated once cancelCh is closed
+type Blobstore interface {
+	boshblob.Blobstore
+
+	WithCancel(cancelCh <-chan struct{}) (boshblob.Blobstore, error)
+}
+
+type blobstore struct {
+	boshblob.Blobstore
+
+	runner boshsys.CmdRunner
+	build  BlobstoreBuilder
+}
+
+func NewBlobstore(runner boshsys.CmdRunner, build BlobstoreBuilder) (Blobstore, error) {
+	builtBlobstore, err := build(runner)
+	if err != nil {
+		return nil, err
+	}
+
+	return blobstore{
+		Blobstore: builtBlobstore,
+		runner:    runner,
+		build:     build,
+	}, nil
+}
+
+func (b blobstore) WithCancel(cancelCh <-chan struct{}) (boshblob.Blobstore, error) {
+	cancellableBlobstore, err := b.build(NewCmdRunner(b.runner, cancelCh))
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Building cancellable blobstore")
+	}
+
+	return cancellableBlobstore, nil
+}
+
+type wrappedBlobstore struct {
+	boshblob.Blobstore
+
+	inner Blobstore
+	wrap  func(boshblob.Blobstore) boshblob.Blobstore
+}
+
+// NewWrappedBlobstore decorates blobstore (e.g. with digest verification)
+// so that blobstores asked for with WithCancel are decorated the same way
+func NewWrappedBlobstore(blobstore Blobstore, wrap func(boshblob.Blobstore) boshblob.Blobstore) Blobstore {
+	return wrappedBlobstore{
+		Blobstore: wrap(blobstore),
+		inner:     blobstore,
+		wrap:      wrap,
+	}
+}
+
+func (b wrappedBlobstore) WithCancel(cancelCh <-chan struct{}) (boshblob.Blobstore, error) {
+	cancellableBlobstore, err := b.inner.WithCancel(cancelCh)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.wrap(cancellableBlobstore), nil
+}
@@ -0,0 +1,108 @@
+package cancellable_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/platform/cancellable"
+	boshblob "github.com/cloudfoundry/bosh-utils/blobstore"
+	fakeblob "github.com/cloudfoundry/bosh-utils/blobstore/fakes"
+	boshsys "github.com/cloudfoundry/bosh-utils/system"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+)
+
+var _ = Describe("blobstore", func() {
+	var (
+		runner        *fakesys.FakeCmdRunner
+		builtRunners  []boshsys.CmdRunner
+		fakeBlobstore *fakeblob.FakeBlobstore
+		buildErr      error
+		build         BlobstoreBuilder
+	)
+
+	BeforeEach(func() {
+		runner = fakesys.NewFakeCmdRunner()
+		builtRunners = nil
+		fakeBlobstore = fakeblob.NewFakeBlobstore()
+		buildErr = nil
+
+		build = func(runner boshsys.CmdRunner) (boshblob.Blobstore, error) {
+			builtRunners = append(builtRunners, runner)
+			return fakeBlobstore, buildErr
+		}
+	})
+
+	It("uses blobstore built with given runner", func() {
+		blobstore, err := NewBlobstore(runner, build)
+		Expect(err).ToNot(HaveOccurred())
+
+		fakeBlobstore.GetFileName = "/fake-file"
+		fileName, err := blobstore.Get("fake-blob-id", "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fileName).To(Equal("/fake-file"))
+
+		Expect(builtRunners).To(Equal([]boshsys.CmdRunner{runner}))
+	})
+
+	It("builds blobstore with cancellable runner when asked for one", func() {
+		blobstore, err := NewBlobstore(runner, build)
+		Expect(err).ToNot(HaveOccurred())
+
+		cancelCh := make(chan struct{})
+
+		_, err = blobstore.WithCancel(cancelCh)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(builtRunners).To(HaveLen(2))
+		Expect(builtRunners[1]).To(Equal(NewCmdRunner(runner, cancelCh)))
+	})
+
+	It("returns error when blobstore cannot be built", func() {
+		buildErr = errors.New("fake-build-err")
+
+		_, err := NewBlobstore(runner, build)
+		Expect(err).To(MatchError("fake-build-err"))
+	})
+
+	Describe("NewWrappedBlobstore", func() {
+		It("wraps blobstore and blobstores asked for with cancel", func() {
+			blobstore, err := NewBlobstore(runner, build)
+			Expect(err).ToNot(HaveOccurred())
+
+			var wrapped []boshblob.Blobstore
+			wrapperBlobstore := fakeblob.NewFakeBlobstore()
+			wrap := func(blobstore boshblob.Blobstore) boshblob.Blobstore {
+				wrapped = append(wrapped, blobstore)
+				return wrapperBlobstore
+			}
+
+			wrappedBlobstore := NewWrappedBlobstore(blobstore, wrap)
+			Expect(wrapped).To(HaveLen(1))
+
+			wrapperBlobstore.GetFileName = "/fake-wrapped-file"
+			fileName, err := wrappedBlobstore.Get("fake-blob-id", "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fileName).To(Equal("/fake-wrapped-file"))
+
+			cancellableBlobstore, err := wrappedBlobstore.WithCancel(make(chan struct{}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cancellableBlobstore).To(Equal(wrapperBlobstore))
+			Expect(wrapped).To(HaveLen(2))
+			Expect(wrapped[1]).To(Equal(fakeBlobstore))
+		})
+
+		It("returns error when cancellable blobstore cannot be built", func() {
+			blobstore, err := NewBlobstore(runner, build)
+			Expect(err).ToNot(HaveOccurred())
+
+			buildErr = errors.New("fake-build-err")
+
+			wrappedBlobstore := NewWrappedBlobstore(blobstore, func(bs boshblob.Blobstore) boshblob.Blobstore { return bs })
+			_, err = wrappedBlobstore.WithCancel(make(chan struct{}))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("fake-build-err"))
+		})
+	})
+})
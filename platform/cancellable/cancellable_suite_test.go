@@ -0,0 +1,13 @@
+package cancellable_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestCancellable(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Cancellable Suite")
+}
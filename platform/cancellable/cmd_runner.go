@@ -0,0 +1,88 @@
+package cancellable
+
+import (
+	"strings"
+	"time"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshsys "github.com/cloudfoundry/bosh-utils/system"
+)
+
+const terminateGracePeriod = 10 * time.Second
+
+type cmdRunner struct {
+	runner   boshsys.CmdRunner
+	cancelCh <-chan struct{}
+}
+
+// NewCmdRunner returns runner that terminates running command once cancelCh
+// is closed and refuses to start new ones afterwards. Processes started with
+// RunComplexCommandAsync are left to the caller to terminate.
+func NewCmdRunner(runner boshsys.CmdRunner, cancelCh <-chan struct{}) boshsys.CmdRunner {
+	return cmdRunner{runner: runner, cancelCh: cancelCh}
+}
+
+func (r cmdRunner) RunComplexCommand(cmd boshsys.Command) (string, string, int, error) {
+	if r.cancelled() {
+		return "", "", -1, bosherr.Errorf("Not running '%s' since it was cancelled", cmd.Name)
+	}
+
+	process, err := r.runner.RunComplexCommandAsync(cmd)
+	if err != nil {
+		return "", "", -1, err
+	}
+
+	var result boshsys.Result
+
+	isCancelled := false
+	cancelCh := r.cancelCh
+
+	// Can only wait once on a process but cancel channel stays closed once cancelled
+	for processExitedCh := process.Wait(); processExitedCh != nil; {
+		select {
+		case result = <-processExitedCh:
+			processExitedCh = nil
+		case <-cancelCh:
+			cancelCh = nil
+			isCancelled = true
+
+			// Process is killed once grace period is over hence it always exits
+			_ = process.TerminateNicely(terminateGracePeriod)
+		}
+	}
+
+	if isCancelled {
+		return result.Stdout, result.Stderr, result.ExitStatus, bosherr.Errorf("Running '%s' was cancelled", cmd.Name)
+	}
+
+	return result.Stdout, result.Stderr, result.ExitStatus, result.Error
+}
+
+func (r cmdRunner) RunComplexCommandAsync(cmd boshsys.Command) (boshsys.Process, error) {
+	if r.cancelled() {
+		return nil, bosherr.Errorf("Not running '%s' since it was cancelled", cmd.Name)
+	}
+
+	return r.runner.RunComplexCommandAsync(cmd)
+}
+
+func (r cmdRunner) RunCommand(cmdName string, args ...string) (string, string, int, error) {
+	return r.RunComplexCommand(boshsys.Command{Name: cmdName, Args: args})
+}
+
+func (r cmdRunner) RunCommandWithInput(input, cmdName string, args ...string) (string, string, int, error) {
+	return r.RunComplexCommand(boshsys.Command{Name: cmdName, Args: args, Stdin: strings.NewReader(input)})
+}
+
+func (r cmdRunner) CommandExists(cmdName string) bool {
+	return r.runner.CommandExists(cmdName)
+}
+
+func (r cmdRunner) cancelled() bool {
+	select {
+	case <-r.cancelCh:
+		return true
+	default:
+		return false
+	}
+}
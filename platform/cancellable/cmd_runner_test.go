@@ -0,0 +1,74 @@
+package cancellable_test
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/platform/cancellable"
+	boshsys "github.com/cloudfoundry/bosh-utils/system"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+)
+
+var _ = Describe("cmdRunner", func() {
+	var (
+		fakeRunner *fakesys.FakeCmdRunner
+		cancelCh   chan struct{}
+		runner     boshsys.CmdRunner
+	)
+
+	BeforeEach(func() {
+		fakeRunner = fakesys.NewFakeCmdRunner()
+		cancelCh = make(chan struct{})
+		runner = NewCmdRunner(fakeRunner, cancelCh)
+	})
+
+	It("returns result of the command", func() {
+		fakeRunner.AddProcess("tar czf /fake-tarball", &fakesys.FakeProcess{
+			WaitResult: boshsys.Result{Stdout: "fake-stdout", ExitStatus: 0},
+		})
+
+		stdout, _, exitStatus, err := runner.RunCommand("tar", "czf", "/fake-tarball")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stdout).To(Equal("fake-stdout"))
+		Expect(exitStatus).To(Equal(0))
+	})
+
+	It("returns error of the command", func() {
+		fakeRunner.AddProcess("tar czf /fake-tarball", &fakesys.FakeProcess{
+			WaitResult: boshsys.Result{ExitStatus: 2, Error: errors.New("fake-tar-err")},
+		})
+
+		_, _, exitStatus, err := runner.RunCommand("tar", "czf", "/fake-tarball")
+		Expect(err).To(MatchError("fake-tar-err"))
+		Expect(exitStatus).To(Equal(2))
+	})
+
+	It("terminates running command once cancelled", func() {
+		process := &fakesys.FakeProcess{
+			TerminatedNicelyCallBack: func(p *fakesys.FakeProcess) {
+				p.WaitCh <- boshsys.Result{ExitStatus: 143}
+			},
+		}
+		fakeRunner.AddProcess("tar czf /fake-tarball", process)
+		fakeRunner.SetCmdCallback("tar czf /fake-tarball", func() { close(cancelCh) })
+
+		_, _, _, err := runner.RunCommand("tar", "czf", "/fake-tarball")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("Running 'tar' was cancelled"))
+
+		Expect(process.TerminatedNicely).To(BeTrue())
+		Expect(process.TerminateNicelyKillGracePeriod).To(Equal(10 * time.Second))
+	})
+
+	It("does not start commands once cancelled", func() {
+		close(cancelCh)
+
+		_, _, _, err := runner.RunCommand("tar", "czf", "/fake-tarball")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("Not running 'tar' since it was cancelled"))
+		Expect(fakeRunner.RunComplexCommands).To(BeEmpty())
+	})
+})
@@ -0,0 +1,33 @@
+package cancellable
+
+import (
+	boshcmd "github.com/cloudfoundry/bosh-utils/fileutil"
+	boshsys "github.com/cloudfoundry/bosh-utils/system"
+)
+
+// Compressor can be asked for a compressor whose
+// tar commands are terminated once cancelCh is closed
+type Compressor interface {
+	boshcmd.Compressor
+
+	WithCancel(cancelCh <-chan struct{}) boshcmd.Compressor
+}
+
+type tarballCompressor struct {
+	boshcmd.Compressor
+
+	runner boshsys.CmdRunner
+	fs     boshsys.FileSystem
+}
+
+func NewTarballCompressor(runner boshsys.CmdRunner, fs boshsys.FileSystem) Compressor {
+	return tarballCompressor{
+		Compressor: boshcmd.NewTarballCompressor(runner, fs),
+		runner:     runner,
+		fs:         fs,
+	}
+}
+
+func (c tarballCompressor) WithCancel(cancelCh <-chan struct{}) boshcmd.Compressor {
+	return boshcmd.NewTarballCompressor(NewCmdRunner(c.runner, cancelCh), c.fs)
+}
@@ -0,0 +1,36 @@
+package cancellable
+
+import (
+	boshcmd "github.com/cloudfoundry/bosh-utils/fileutil"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	boshsys "github.com/cloudfoundry/bosh-utils/system"
+)
+
+// Copier can be asked for a copier whose
+// cp commands are terminated once cancelCh is closed
+type Copier interface {
+	boshcmd.Copier
+
+	WithCancel(cancelCh <-chan struct{}) boshcmd.Copier
+}
+
+type cpCopier struct {
+	boshcmd.Copier
+
+	runner boshsys.CmdRunner
+	fs     boshsys.FileSystem
+	logger boshlog.Logger
+}
+
+func NewCpCopier(runner boshsys.CmdRunner, fs boshsys.FileSystem, logger boshlog.Logger) Copier {
+	return cpCopier{
+		Copier: boshcmd.NewCpCopier(runner, fs, logger),
+		runner: runner,
+		fs:     fs,
+		logger: logger,
+	}
+}
+
+func (c cpCopier) WithCancel(cancelCh <-chan struct{}) boshcmd.Copier {
+	return boshcmd.NewCpCopier(NewCmdRunner(c.runner, cancelCh), c.fs, c.logger)
+}
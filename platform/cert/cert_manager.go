@@ -5,6 +5,7 @@ import (
 	"strings"
 	"time"
 
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 	"github.com/cloudfoundry/bosh-utils/logger"
 	boshsys "github.com/cloudfoundry/bosh-utils/system"
@@ -114,6 +115,8 @@ func (c *certManager) UpdateCertificates(certs string) error {
 			Args: c.updateCmdArgs,
 		}
 
+		lastAttemptTimedOut := false
+
 		for i := 1; i < 4; i++ {
 			c.logger.Debug(c.logTag, "Try to update new certificate files with retry, take %d of 3", i)
 
@@ -126,11 +129,13 @@ func (c *certManager) UpdateCertificates(certs string) error {
 
 			select {
 			case <-time.After(c.updateTimeout * time.Second):
+				lastAttemptTimedOut = true
 				err = process.TerminateNicely(5 * time.Second)
 				if err != nil {
 					c.logger.Debug(c.logTag, "Failed to terminate update certificates cmd '%s' after %d seconds", c.updateCmdPath, c.updateTimeout)
 				}
 			case result := <-resultChannel:
+				lastAttemptTimedOut = false
 				if result.Error == nil {
 					c.logger.Debug(c.logTag, "Successfully updated new certificate files")
 					return nil
@@ -138,7 +143,11 @@ func (c *certManager) UpdateCertificates(certs string) error {
 			}
 		}
 
-		return bosherr.Error("Updating certificates with retries")
+		err := bosherr.Error("Updating certificates with retries")
+		if lastAttemptTimedOut {
+			return boshcodederr.NewCodedError(boshcodederr.ErrorCodeTimeout, err)
+		}
+		return err
 	}
 
 	c.logger.Debug(c.logTag, "Try to update new certificate files without retry")
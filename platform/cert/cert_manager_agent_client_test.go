@@ -7,6 +7,7 @@ import (
 	"github.com/cloudfoundry/bosh-agent/settings"
 	fakehttpclient "github.com/cloudfoundry/bosh-utils/httpclient/fakes"
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakeuuid "github.com/cloudfoundry/bosh-utils/uuid/fakes"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -20,7 +21,7 @@ var _ = Describe("CertManager", func() {
 	BeforeEach(func() {
 		logger := boshlog.NewLogger(boshlog.LevelNone)
 		fakeHTTPClient = fakehttpclient.NewFakeHTTPClient()
-		agentClient = NewAgentClient("http://localhost:6305", "fake-uuid", 0, 10, fakeHTTPClient, logger)
+		agentClient = NewAgentClient("http://localhost:6305", "fake-uuid", 0, 10, fakeHTTPClient, nil, nil, fakeuuid.NewFakeGenerator(), logger)
 		fakeHTTPClient.SetPostBehavior(`{"value":{"agent_task_id":"fake-agent-task-id","state":"running"}}`, 200, nil)
 		fakeHTTPClient.SetPostBehavior(`{"value":"updated"}`, 200, nil)
 	})
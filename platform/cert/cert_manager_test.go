@@ -9,6 +9,7 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	boshcodederr "github.com/cloudfoundry/bosh-agent/codederror"
 	"github.com/cloudfoundry/bosh-agent/platform/cert"
 	"github.com/cloudfoundry/bosh-utils/logger"
 	"github.com/cloudfoundry/bosh-utils/system"
@@ -313,6 +314,10 @@ var _ = Describe("Certificate Management", func() {
 				Expect(fakeProcess3.TerminateNicelyKillGracePeriod).To(Equal(5 * time.Second))
 
 				Expect(err).To(HaveOccurred())
+
+				codedErr, found := boshcodederr.FindCodedError(err)
+				Expect(found).To(BeTrue())
+				Expect(codedErr.Code).To(Equal(boshcodederr.ErrorCodeTimeout))
 			})
 		})
 
@@ -6,6 +6,7 @@ import (
 
 	boshdpresolv "github.com/cloudfoundry/bosh-agent/infrastructure/devicepathresolver"
 	boshcert "github.com/cloudfoundry/bosh-agent/platform/cert"
+	boshsandbox "github.com/cloudfoundry/bosh-agent/platform/sandbox"
 	boshstats "github.com/cloudfoundry/bosh-agent/platform/stats"
 	boshvitals "github.com/cloudfoundry/bosh-agent/platform/vitals"
 	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
@@ -40,6 +41,7 @@ type dummyPlatform struct {
 	devicePathResolver boshdpresolv.DevicePathResolver
 	logger             boshlog.Logger
 	certManager        boshcert.Manager
+	compilationSandbox boshsandbox.Sandbox
 }
 
 func NewDummyPlatform(
@@ -60,6 +62,7 @@ func NewDummyPlatform(
 		devicePathResolver: devicePathResolver,
 		vitalsService:      boshvitals.NewService(collector, dirProvider),
 		certManager:        boshcert.NewDummyCertManager(fs, cmdRunner, 0, logger),
+		compilationSandbox: boshsandbox.NewNoopSandbox(),
 	}
 }
 
@@ -136,6 +139,10 @@ func (p dummyPlatform) GetCertManager() (certManager boshcert.Manager) {
 	return p.certManager
 }
 
+func (p dummyPlatform) GetCompilationSandbox() boshsandbox.Sandbox {
+	return p.compilationSandbox
+}
+
 func (p dummyPlatform) SetupLogrotate(groupName, basePath, size string) (err error) {
 	return
 }
@@ -76,6 +76,18 @@ func describeDummyPlatform() {
 		})
 	})
 
+	Describe("GetCompilationSandbox", func() {
+		It("returns a sandbox that leaves commands unchanged", func() {
+			cmd := boshsys.Command{Name: "fake-cmd", Args: []string{"fake-arg"}}
+
+			wrappedCmd, err := platform.GetCompilationSandbox().Wrap("fake-name", cmd, []string{"/fake-dir"}, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(wrappedCmd.Command).To(Equal(cmd))
+			Expect(wrappedCmd.Timeout).To(BeZero())
+			Expect(wrappedCmd.CleanUp()).To(Succeed())
+		})
+	})
+
 	Describe("UnmountPersistentDisk", func() {
 		Context("when there are two mounted persistent disks in the mounts json", func() {
 			BeforeEach(func() {
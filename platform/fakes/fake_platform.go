@@ -7,6 +7,8 @@ import (
 	fakedpresolv "github.com/cloudfoundry/bosh-agent/infrastructure/devicepathresolver/fakes"
 	boshcert "github.com/cloudfoundry/bosh-agent/platform/cert"
 	fakecert "github.com/cloudfoundry/bosh-agent/platform/cert/fakes"
+	boshsandbox "github.com/cloudfoundry/bosh-agent/platform/sandbox"
+	fakesandbox "github.com/cloudfoundry/bosh-agent/platform/sandbox/fakes"
 	boshvitals "github.com/cloudfoundry/bosh-agent/platform/vitals"
 	fakevitals "github.com/cloudfoundry/bosh-agent/platform/vitals/fakes"
 	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
@@ -127,6 +129,8 @@ type FakePlatform struct {
 
 	certManager boshcert.Manager
 
+	CompilationSandbox *fakesandbox.FakeSandbox
+
 	GetHostPublicKeyValue string
 	GetHostPublicKeyError error
 
@@ -151,6 +155,7 @@ func NewFakePlatform() (platform *FakePlatform) {
 	platform.GetFileContentsFromDiskContents = map[string][]byte{}
 	platform.GetFileContentsFromDiskErrs = map[string]error{}
 	platform.certManager = new(fakecert.FakeManager)
+	platform.CompilationSandbox = fakesandbox.NewFakeSandbox()
 	platform.SetupRawEphemeralDisksCallCount = 0
 	platform.SetupRawEphemeralDisksDevices = nil
 	platform.SetupRawEphemeralDisksErr = nil
@@ -255,6 +260,10 @@ func (p *FakePlatform) GetCertManager() (certManager boshcert.Manager) {
 	return p.certManager
 }
 
+func (p *FakePlatform) GetCompilationSandbox() boshsandbox.Sandbox {
+	return p.CompilationSandbox
+}
+
 func (p *FakePlatform) SetupLogrotate(groupName, basePath, size string) (err error) {
 	return
 }
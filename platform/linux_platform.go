@@ -16,6 +16,7 @@ import (
 	boshdevutil "github.com/cloudfoundry/bosh-agent/platform/deviceutil"
 	boshdisk "github.com/cloudfoundry/bosh-agent/platform/disk"
 	boshnet "github.com/cloudfoundry/bosh-agent/platform/net"
+	boshsandbox "github.com/cloudfoundry/bosh-agent/platform/sandbox"
 	boshstats "github.com/cloudfoundry/bosh-agent/platform/stats"
 	boshvitals "github.com/cloudfoundry/bosh-agent/platform/vitals"
 	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
@@ -70,6 +71,9 @@ type LinuxOptions struct {
 
 	// Device prexix when using virtio (defaults to 'virtio')
 	VirtioDevicePrefix string
+
+	// Limits applied to packaging scripts during compilation
+	CompilationSandbox boshsandbox.Options
 }
 
 type linux struct {
@@ -84,6 +88,7 @@ type linux struct {
 	diskManager            boshdisk.Manager
 	netManager             boshnet.Manager
 	certManager            boshcert.Manager
+	compilationSandbox     boshsandbox.Sandbox
 	monitRetryStrategy     boshretry.RetryStrategy
 	devicePathResolver     boshdpresolv.DevicePathResolver
 	diskScanDuration       time.Duration
@@ -105,6 +110,7 @@ func NewLinuxPlatform(
 	diskManager boshdisk.Manager,
 	netManager boshnet.Manager,
 	certManager boshcert.Manager,
+	compilationSandbox boshsandbox.Sandbox,
 	monitRetryStrategy boshretry.RetryStrategy,
 	devicePathResolver boshdpresolv.DevicePathResolver,
 	diskScanDuration time.Duration,
@@ -125,6 +131,7 @@ func NewLinuxPlatform(
 		diskManager:            diskManager,
 		netManager:             netManager,
 		certManager:            certManager,
+		compilationSandbox:     compilationSandbox,
 		monitRetryStrategy:     monitRetryStrategy,
 		devicePathResolver:     devicePathResolver,
 		diskScanDuration:       diskScanDuration,
@@ -190,6 +197,10 @@ func (p linux) GetCertManager() boshcert.Manager {
 	return p.certManager
 }
 
+func (p linux) GetCompilationSandbox() boshsandbox.Sandbox {
+	return p.compilationSandbox
+}
+
 func (p linux) GetHostPublicKey() (string, error) {
 	hostPublicKeyPath := "/etc/ssh/ssh_host_rsa_key.pub"
 	hostPublicKey, err := p.fs.ReadFileString(hostPublicKeyPath)
@@ -15,6 +15,7 @@ import (
 	boshdisk "github.com/cloudfoundry/bosh-agent/platform/disk"
 	fakedisk "github.com/cloudfoundry/bosh-agent/platform/disk/fakes"
 	fakenet "github.com/cloudfoundry/bosh-agent/platform/net/fakes"
+	fakesandbox "github.com/cloudfoundry/bosh-agent/platform/sandbox/fakes"
 	fakestats "github.com/cloudfoundry/bosh-agent/platform/stats/fakes"
 	boshvitals "github.com/cloudfoundry/bosh-agent/platform/vitals"
 	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
@@ -42,6 +43,7 @@ func describeLinuxPlatform() {
 		vitalsService              boshvitals.Service
 		netManager                 *fakenet.FakeManager
 		certManager                *fakecert.FakeManager
+		compilationSandbox         *fakesandbox.FakeSandbox
 		monitRetryStrategy         *fakeretry.FakeRetryStrategy
 		fakeDefaultNetworkResolver *fakenet.FakeDefaultNetworkResolver
 
@@ -66,6 +68,7 @@ func describeLinuxPlatform() {
 		vitalsService = boshvitals.NewService(collector, dirProvider)
 		netManager = &fakenet.FakeManager{}
 		certManager = new(fakecert.FakeManager)
+		compilationSandbox = fakesandbox.NewFakeSandbox()
 		monitRetryStrategy = fakeretry.NewFakeRetryStrategy()
 		devicePathResolver = fakedpresolv.NewFakeDevicePathResolver()
 		fakeDefaultNetworkResolver = &fakenet.FakeDefaultNetworkResolver{}
@@ -99,6 +102,7 @@ func describeLinuxPlatform() {
 			diskManager,
 			netManager,
 			certManager,
+			compilationSandbox,
 			monitRetryStrategy,
 			devicePathResolver,
 			5*time.Millisecond,
@@ -287,6 +291,7 @@ bosh_foobar:...`
 					diskManager,
 					netManager,
 					certManager,
+					compilationSandbox,
 					monitRetryStrategy,
 					devicePathResolver,
 					5*time.Millisecond,
@@ -3,6 +3,7 @@ package platform
 import (
 	boshdpresolv "github.com/cloudfoundry/bosh-agent/infrastructure/devicepathresolver"
 	"github.com/cloudfoundry/bosh-agent/platform/cert"
+	"github.com/cloudfoundry/bosh-agent/platform/sandbox"
 	boshvitals "github.com/cloudfoundry/bosh-agent/platform/vitals"
 	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
 	boshdir "github.com/cloudfoundry/bosh-agent/settings/directories"
@@ -64,6 +65,9 @@ type Platform interface {
 
 	GetCertManager() cert.Manager
 
+	// GetCompilationSandbox returns sandbox that packaging scripts are run in
+	GetCompilationSandbox() sandbox.Sandbox
+
 	GetHostPublicKey() (string, error)
 
 	RemoveDevTools(packageFileListPath string) error
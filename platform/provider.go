@@ -4,18 +4,19 @@ import (
 	"time"
 
 	"github.com/cloudfoundry/bosh-agent/infrastructure/devicepathresolver"
+	boshcancel "github.com/cloudfoundry/bosh-agent/platform/cancellable"
 	boshcdrom "github.com/cloudfoundry/bosh-agent/platform/cdrom"
 	boshcert "github.com/cloudfoundry/bosh-agent/platform/cert"
 	boshdisk "github.com/cloudfoundry/bosh-agent/platform/disk"
 	boshnet "github.com/cloudfoundry/bosh-agent/platform/net"
 	bosharp "github.com/cloudfoundry/bosh-agent/platform/net/arp"
 	boship "github.com/cloudfoundry/bosh-agent/platform/net/ip"
+	boshsandbox "github.com/cloudfoundry/bosh-agent/platform/sandbox"
 	boshstats "github.com/cloudfoundry/bosh-agent/platform/stats"
 	boshudev "github.com/cloudfoundry/bosh-agent/platform/udevdevice"
 	boshvitals "github.com/cloudfoundry/bosh-agent/platform/vitals"
 	boshdirs "github.com/cloudfoundry/bosh-agent/settings/directories"
 	bosherror "github.com/cloudfoundry/bosh-utils/errors"
-	boshcmd "github.com/cloudfoundry/bosh-utils/fileutil"
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
 	boshretry "github.com/cloudfoundry/bosh-utils/retrystrategy"
 	boshsys "github.com/cloudfoundry/bosh-utils/system"
@@ -51,8 +52,8 @@ func NewProvider(logger boshlog.Logger, dirProvider boshdirs.Provider, statsColl
 	linuxCdrom := boshcdrom.NewLinuxCdrom("/dev/sr0", udev, runner)
 	linuxCdutil := boshcdrom.NewCdUtil(dirProvider.SettingsDir(), fs, linuxCdrom, logger)
 
-	compressor := boshcmd.NewTarballCompressor(runner, fs)
-	copier := boshcmd.NewCpCopier(runner, fs, logger)
+	compressor := boshcancel.NewTarballCompressor(runner, fs)
+	copier := boshcancel.NewCpCopier(runner, fs, logger)
 
 	// Kick of stats collection as soon as possible
 	statsCollector.StartCollecting(SigarStatsCollectionInterval, nil)
@@ -77,6 +78,13 @@ func NewProvider(logger boshlog.Logger, dirProvider boshdirs.Provider, statsColl
 	centosCertManager := boshcert.NewCentOSCertManager(fs, runner, 0, logger)
 	ubuntuCertManager := boshcert.NewUbuntuCertManager(fs, runner, 60, logger)
 
+	var compilationSandbox boshsandbox.Sandbox
+	if options.Linux.CompilationSandbox.Enabled {
+		compilationSandbox = boshsandbox.NewLinuxSandbox(options.Linux.CompilationSandbox, dirProvider.CompilationSandboxDir(), fs, runner, logger)
+	} else {
+		compilationSandbox = boshsandbox.NewNoopSandbox()
+	}
+
 	routesSearcher := boshnet.NewCmdRoutesSearcher(runner)
 	linuxDefaultNetworkResolver := boshnet.NewDefaultNetworkResolver(routesSearcher, ipResolver)
 
@@ -110,6 +118,7 @@ func NewProvider(logger boshlog.Logger, dirProvider boshdirs.Provider, statsColl
 		linuxDiskManager,
 		centosNetManager,
 		centosCertManager,
+		compilationSandbox,
 		monitRetryStrategy,
 		devicePathResolver,
 		500*time.Millisecond,
@@ -131,6 +140,7 @@ func NewProvider(logger boshlog.Logger, dirProvider boshdirs.Provider, statsColl
 		linuxDiskManager,
 		ubuntuNetManager,
 		ubuntuCertManager,
+		compilationSandbox,
 		monitRetryStrategy,
 		devicePathResolver,
 		500*time.Millisecond,
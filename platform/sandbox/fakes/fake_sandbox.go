@@ -0,0 +1,49 @@
+package fakes
+
+import (
+	"time"
+
+	boshsandbox "github.com/cloudfoundry/bosh-agent/platform/sandbox"
+	boshsys "github.com/cloudfoundry/bosh-utils/system"
+)
+
+type FakeSandbox struct {
+	WrapName         string
+	WrapCmd          boshsys.Command
+	WrapWritableDirs []string
+	WrapBindMounts   []boshsandbox.BindMount
+	WrapErr          error
+
+	// Prefix is added to the wrapped command name
+	WrapPrefix  string
+	WrapTimeout time.Duration
+
+	CleanUpCallCount int
+	CleanUpErr       error
+}
+
+func NewFakeSandbox() *FakeSandbox {
+	return &FakeSandbox{}
+}
+
+func (s *FakeSandbox) Wrap(name string, cmd boshsys.Command, writableDirs []string, bindMounts []boshsandbox.BindMount) (boshsandbox.WrappedCommand, error) {
+	s.WrapName = name
+	s.WrapCmd = cmd
+	s.WrapWritableDirs = writableDirs
+	s.WrapBindMounts = bindMounts
+
+	if s.WrapErr != nil {
+		return boshsandbox.WrappedCommand{}, s.WrapErr
+	}
+
+	cmd.Name = s.WrapPrefix + cmd.Name
+
+	return boshsandbox.WrappedCommand{
+		Command: cmd,
+		Timeout: s.WrapTimeout,
+		CleanUp: func() error {
+			s.CleanUpCallCount++
+			return s.CleanUpErr
+		},
+	}, nil
+}
@@ -0,0 +1,436 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	boshsys "github.com/cloudfoundry/bosh-utils/system"
+)
+
+const (
+	defaultUsername     = "bosh_compile"
+	defaultMaxProcesses = 4096
+
+	cgroupRoot       = "/sys/fs/cgroup"
+	cgroupPrefix     = "bosh-sandbox-"
+	cpuPeriodMicros  = 100000
+	sandboxPathValue = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+	cgroupRemovalAttempts = 10
+	cgroupRemovalDelay    = 100 * time.Millisecond
+)
+
+// confineScript runs in a private mount namespace as root.
+// It joins cgroups, makes bind mounts private to the namespace,
+// leaves only writable dirs writable and then drops privileges
+// to run the given command. It fails if any mount cannot be made read-only.
+//
+// Target of the bind mount (e.g. shared symlink of the package) is replaced
+// by a directory in a tmpfs mounted over its parent; other entries of the parent
+// are kept visible as symlinks (or as links into the original parent).
+const confineScript = `set -e
+for procs in $BOSH_SANDBOX_CGROUP_PROCS; do
+  echo $$ > "$procs"
+done
+mount --make-rprivate /
+for dir in $BOSH_SANDBOX_WRITABLE_DIRS; do
+  mount --bind "$dir" "$dir"
+done
+for bind in $BOSH_SANDBOX_BIND_MOUNTS; do
+  src=${bind%%=*}
+  dst=${bind#*=}
+  parent=$(dirname "$dst")
+  orig=$(mktemp -d)
+  mount --bind "$parent" "$orig"
+  mount -t tmpfs -o mode=0755 tmpfs "$parent"
+  for entry in "$orig"/* "$orig"/.[!.]*; do
+    [ -e "$entry" ] || [ -L "$entry" ] || continue
+    name=$(basename "$entry")
+    if [ -L "$entry" ]; then
+      cp -P "$entry" "$parent/$name"
+    else
+      ln -s "$orig/$name" "$parent/$name"
+    fi
+  done
+  rm -f "$dst"
+  mkdir "$dst"
+  mount --bind "$src" "$dst"
+done
+for mnt in $(awk '{ print $2 }' /proc/self/mounts); do
+  case "$mnt" in
+    /proc|/proc/*|/sys|/sys/*|/dev|/dev/*) continue ;;
+  esac
+  for dir in $BOSH_SANDBOX_WRITABLE_DIRS; do
+    if [ "$mnt" = "$dir" ]; then continue 2; fi
+  done
+  for bind in $BOSH_SANDBOX_BIND_MOUNTS; do
+    if [ "$mnt" = "${bind#*=}" ]; then continue 2; fi
+  done
+  if ! mount -o remount,bind,ro "$mnt"; then
+    echo "Failed to make $mnt read-only" >&2
+    exit 1
+  fi
+done
+uid=$BOSH_SANDBOX_UID
+gid=$BOSH_SANDBOX_GID
+unset BOSH_SANDBOX_CGROUP_PROCS BOSH_SANDBOX_WRITABLE_DIRS BOSH_SANDBOX_BIND_MOUNTS BOSH_SANDBOX_UID BOSH_SANDBOX_GID
+exec setpriv --reuid="$uid" --regid="$gid" --clear-groups --no-new-privs -- "$@"
+`
+
+type linuxSandbox struct {
+	options    Options
+	sandboxDir string
+	fs         boshsys.FileSystem
+	runner     boshsys.CmdRunner
+	logTag     string
+	logger     boshlog.Logger
+}
+
+// NewLinuxSandbox returns sandbox that runs commands as an unprivileged user
+// in a separate mount namespace and cgroup. Private HOME and TMPDIR
+// for each command are created inside sandboxDir.
+func NewLinuxSandbox(
+	options Options,
+	sandboxDir string,
+	fs boshsys.FileSystem,
+	runner boshsys.CmdRunner,
+	logger boshlog.Logger,
+) Sandbox {
+	if options.Username == "" {
+		options.Username = defaultUsername
+	}
+
+	if options.MaxProcesses == 0 {
+		options.MaxProcesses = defaultMaxProcesses
+	}
+
+	return linuxSandbox{
+		options:    options,
+		sandboxDir: sandboxDir,
+		fs:         fs,
+		runner:     runner,
+		logTag:     "linuxSandbox",
+		logger:     logger,
+	}
+}
+
+func (s linuxSandbox) Wrap(name string, cmd boshsys.Command, writableDirs []string, bindMounts []BindMount) (WrappedCommand, error) {
+	uid, gid, err := s.ensureUser()
+	if err != nil {
+		return WrappedCommand{}, err
+	}
+
+	tmpDir := path.Join(s.sandboxDir, name)
+
+	err = s.fs.RemoveAll(tmpDir)
+	if err != nil {
+		return WrappedCommand{}, bosherr.WrapErrorf(err, "Removing sandbox tmp dir %s", tmpDir)
+	}
+
+	err = s.fs.MkdirAll(tmpDir, os.FileMode(0700))
+	if err != nil {
+		return WrappedCommand{}, bosherr.WrapErrorf(err, "Creating sandbox tmp dir %s", tmpDir)
+	}
+
+	// Copied to avoid modifying caller's slice
+	ownedDirs := append(append([]string{}, writableDirs...), tmpDir)
+
+	cleanUp := func(cgroupDirs []string) error {
+		return s.cleanUp(cgroupDirs, ownedDirs, tmpDir)
+	}
+
+	for _, dir := range ownedDirs {
+		_, _, _, err := s.runner.RunCommand("chown", "-R", uid+":"+gid, dir)
+		if err != nil {
+			_ = cleanUp(nil)
+			return WrappedCommand{}, bosherr.WrapErrorf(err, "Changing owner of %s", dir)
+		}
+	}
+
+	cgroupDirs, err := s.createCgroups(name)
+	if err != nil {
+		_ = cleanUp(cgroupDirs)
+		return WrappedCommand{}, bosherr.WrapError(err, "Creating sandbox cgroups")
+	}
+
+	var procsFiles []string
+
+	for _, dir := range cgroupDirs {
+		procsFiles = append(procsFiles, path.Join(dir, "cgroup.procs"))
+	}
+
+	env := map[string]string{
+		"PATH":   sandboxPathValue,
+		"HOME":   tmpDir,
+		"TMPDIR": tmpDir,
+	}
+
+	for envName, envValue := range cmd.Env {
+		env[envName] = envValue
+	}
+
+	env["BOSH_SANDBOX_CGROUP_PROCS"] = strings.Join(procsFiles, " ")
+	env["BOSH_SANDBOX_WRITABLE_DIRS"] = strings.Join(ownedDirs, " ")
+
+	var binds []string
+
+	for _, bindMount := range bindMounts {
+		binds = append(binds, bindMount.Source+"="+bindMount.Target)
+	}
+
+	env["BOSH_SANDBOX_BIND_MOUNTS"] = strings.Join(binds, " ")
+	env["BOSH_SANDBOX_UID"] = uid
+	env["BOSH_SANDBOX_GID"] = gid
+
+	args := []string{"--mount", "--fork", "--", "/bin/sh", "-c", confineScript, "sandbox", cmd.Name}
+
+	wrappedCmd := boshsys.Command{
+		Name:           "unshare",
+		Args:           append(args, cmd.Args...),
+		Env:            env,
+		UseIsolatedEnv: true,
+		WorkingDir:     cmd.WorkingDir,
+		Stdin:          cmd.Stdin,
+		Stdout:         cmd.Stdout,
+		Stderr:         cmd.Stderr,
+	}
+
+	return WrappedCommand{
+		Command: wrappedCmd,
+		Timeout: time.Duration(s.options.TimeoutSeconds) * time.Second,
+		CleanUp: func() error { return cleanUp(cgroupDirs) },
+	}, nil
+}
+
+func (s linuxSandbox) ensureUser() (string, string, error) {
+	username := s.options.Username
+
+	_, _, _, err := s.runner.RunCommand("id", "-u", username)
+	if err != nil {
+		_, _, _, err = s.runner.RunCommand(
+			"useradd",
+			"--system",
+			"--user-group",
+			"--no-create-home",
+			"--home-dir", "/nonexistent",
+			"--shell", "/usr/sbin/nologin",
+			username,
+		)
+		if err != nil {
+			return "", "", bosherr.WrapErrorf(err, "Creating sandbox user %s", username)
+		}
+	}
+
+	uid, _, _, err := s.runner.RunCommand("id", "-u", username)
+	if err != nil {
+		return "", "", bosherr.WrapErrorf(err, "Getting uid of sandbox user %s", username)
+	}
+
+	gid, _, _, err := s.runner.RunCommand("id", "-g", username)
+	if err != nil {
+		return "", "", bosherr.WrapErrorf(err, "Getting gid of sandbox user %s", username)
+	}
+
+	return strings.TrimSpace(uid), strings.TrimSpace(gid), nil
+}
+
+// createCgroups returns created cgroup dirs even when it fails
+// so that they could be removed
+func (s linuxSandbox) createCgroups(name string) ([]string, error) {
+	limits := map[string]map[string]string{}
+
+	if s.options.CPUs > 0 {
+		quota := int64(s.options.CPUs * cpuPeriodMicros)
+		limits["cpu"] = map[string]string{
+			"cpu.max":           fmt.Sprintf("%d %d", quota, cpuPeriodMicros),
+			"cpu.cfs_period_us": fmt.Sprintf("%d", cpuPeriodMicros),
+			"cpu.cfs_quota_us":  fmt.Sprintf("%d", quota),
+		}
+	}
+
+	if s.options.MemoryLimitBytes > 0 {
+		limits["memory"] = map[string]string{
+			"memory.max":            fmt.Sprintf("%d", s.options.MemoryLimitBytes),
+			"memory.limit_in_bytes": fmt.Sprintf("%d", s.options.MemoryLimitBytes),
+		}
+	}
+
+	limits["pids"] = map[string]string{
+		"pids.max": fmt.Sprintf("%d", s.options.MaxProcesses),
+	}
+
+	if s.fs.FileExists(path.Join(cgroupRoot, "cgroup.controllers")) {
+		return s.createUnifiedCgroup(name, limits)
+	}
+
+	return s.createControllerCgroups(name, limits)
+}
+
+func (s linuxSandbox) createUnifiedCgroup(name string, limits map[string]map[string]string) ([]string, error) {
+	var controllers []string
+
+	for _, controller := range []string{"cpu", "memory", "pids"} {
+		if _, found := limits[controller]; found {
+			controllers = append(controllers, "+"+controller)
+		}
+	}
+
+	err := s.fs.WriteFileString(path.Join(cgroupRoot, "cgroup.subtree_control"), strings.Join(controllers, " "))
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Enabling cgroup controllers")
+	}
+
+	dir := path.Join(cgroupRoot, cgroupPrefix+name)
+
+	err = s.fs.MkdirAll(dir, os.FileMode(0755))
+	if err != nil {
+		return nil, bosherr.WrapErrorf(err, "Creating cgroup %s", dir)
+	}
+
+	limitFiles := map[string]string{
+		"cpu":    "cpu.max",
+		"memory": "memory.max",
+		"pids":   "pids.max",
+	}
+
+	for controller, files := range limits {
+		err := s.writeLimit(dir, limitFiles[controller], files)
+		if err != nil {
+			return []string{dir}, err
+		}
+	}
+
+	return []string{dir}, nil
+}
+
+func (s linuxSandbox) createControllerCgroups(name string, limits map[string]map[string]string) ([]string, error) {
+	var dirs []string
+
+	limitFiles := map[string][]string{
+		"cpu":    {"cpu.cfs_period_us", "cpu.cfs_quota_us"},
+		"memory": {"memory.limit_in_bytes"},
+		"pids":   {"pids.max"},
+	}
+
+	for _, controller := range []string{"cpu", "memory", "pids"} {
+		files, found := limits[controller]
+		if !found {
+			continue
+		}
+
+		dir := path.Join(cgroupRoot, controller, cgroupPrefix+name)
+
+		err := s.fs.MkdirAll(dir, os.FileMode(0755))
+		if err != nil {
+			return dirs, bosherr.WrapErrorf(err, "Creating cgroup %s", dir)
+		}
+
+		dirs = append(dirs, dir)
+
+		for _, fileName := range limitFiles[controller] {
+			err := s.writeLimit(dir, fileName, files)
+			if err != nil {
+				return dirs, err
+			}
+		}
+	}
+
+	return dirs, nil
+}
+
+func (s linuxSandbox) writeLimit(dir, fileName string, files map[string]string) error {
+	filePath := path.Join(dir, fileName)
+
+	err := s.fs.WriteFileString(filePath, files[fileName])
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Writing cgroup limit %s", filePath)
+	}
+
+	return nil
+}
+
+func (s linuxSandbox) cleanUp(cgroupDirs, ownedDirs []string, tmpDir string) error {
+	var errs []error
+
+	err := s.removeCgroups(cgroupDirs)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	// Files created inside sandbox are handed back to root
+	for _, dir := range ownedDirs {
+		if dir == tmpDir {
+			continue
+		}
+
+		_, _, _, err := s.runner.RunCommand("chown", "-R", "root:root", dir)
+		if err != nil {
+			errs = append(errs, bosherr.WrapErrorf(err, "Changing owner of %s", dir))
+		}
+	}
+
+	err = s.fs.RemoveAll(tmpDir)
+	if err != nil {
+		errs = append(errs, bosherr.WrapErrorf(err, "Removing sandbox tmp dir %s", tmpDir))
+	}
+
+	if len(errs) > 0 {
+		return bosherr.NewMultiError(errs...)
+	}
+
+	return nil
+}
+
+// removeCgroups kills processes left behind by the command
+// (e.g. background processes) since cgroups can only be removed once empty
+func (s linuxSandbox) removeCgroups(dirs []string) error {
+	var err error
+
+	for attempt := 0; attempt < cgroupRemovalAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(cgroupRemovalDelay)
+		}
+
+		var remainingDirs []string
+
+		for _, dir := range dirs {
+			s.killProcesses(dir)
+
+			err = s.fs.RemoveAll(dir)
+			if err != nil {
+				s.logger.Debug(s.logTag, "Failed to remove cgroup %s: %s", dir, err.Error())
+				remainingDirs = append(remainingDirs, dir)
+			}
+		}
+
+		if len(remainingDirs) == 0 {
+			return nil
+		}
+
+		dirs = remainingDirs
+	}
+
+	return bosherr.WrapErrorf(err, "Removing cgroups %s", strings.Join(dirs, ", "))
+}
+
+func (s linuxSandbox) killProcesses(dir string) {
+	procs, err := s.fs.ReadFileString(path.Join(dir, "cgroup.procs"))
+	if err != nil {
+		return
+	}
+
+	pids := strings.Fields(procs)
+	if len(pids) == 0 {
+		return
+	}
+
+	_, _, _, err = s.runner.RunCommand("kill", append([]string{"-9"}, pids...)...)
+	if err != nil {
+		s.logger.Debug(s.logTag, "Failed to kill processes in cgroup %s: %s", dir, err.Error())
+	}
+}
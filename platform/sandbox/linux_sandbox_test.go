@@ -0,0 +1,258 @@
+package sandbox_test
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/platform/sandbox"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	boshsys "github.com/cloudfoundry/bosh-utils/system"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+)
+
+var _ = Describe("linuxSandbox", func() {
+	var (
+		options Options
+		fs      *fakesys.FakeFileSystem
+		runner  *fakesys.FakeCmdRunner
+		sandbox Sandbox
+		cmd     boshsys.Command
+	)
+
+	BeforeEach(func() {
+		options = Options{Enabled: true}
+		fs = fakesys.NewFakeFileSystem()
+		runner = fakesys.NewFakeCmdRunner()
+
+		runner.AddCmdResult("id -u bosh_compile", fakesys.FakeCmdResult{Stdout: "999\n", Sticky: true})
+		runner.AddCmdResult("id -g bosh_compile", fakesys.FakeCmdResult{Stdout: "998\n", Sticky: true})
+
+		cmd = boshsys.Command{
+			Name:       "bash",
+			Args:       []string{"-x", "packaging"},
+			Env:        map[string]string{"BOSH_INSTALL_TARGET": "/fake-install-dir"},
+			WorkingDir: "/fake-compile-dir",
+		}
+	})
+
+	JustBeforeEach(func() {
+		logger := boshlog.NewLogger(boshlog.LevelNone)
+		sandbox = NewLinuxSandbox(options, "/fake-sandbox-dir", fs, runner, logger)
+	})
+
+	Describe("Wrap", func() {
+		It("runs command in a private mount namespace with isolated environment", func() {
+			wrappedCmd, err := sandbox.Wrap("fake-name", cmd, []string{"/fake-compile-dir", "/fake-install-dir"}, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(wrappedCmd.Command.Name).To(Equal("unshare"))
+			Expect(wrappedCmd.Command.Args[:5]).To(Equal([]string{"--mount", "--fork", "--", "/bin/sh", "-c"}))
+			Expect(wrappedCmd.Command.Args[6:]).To(Equal([]string{"sandbox", "bash", "-x", "packaging"}))
+			Expect(wrappedCmd.Command.WorkingDir).To(Equal("/fake-compile-dir"))
+			Expect(wrappedCmd.Command.UseIsolatedEnv).To(BeTrue())
+
+			env := wrappedCmd.Command.Env
+			Expect(env["BOSH_INSTALL_TARGET"]).To(Equal("/fake-install-dir"))
+			Expect(env["HOME"]).To(Equal("/fake-sandbox-dir/fake-name"))
+			Expect(env["TMPDIR"]).To(Equal("/fake-sandbox-dir/fake-name"))
+			Expect(env["PATH"]).ToNot(BeEmpty())
+			Expect(env["BOSH_SANDBOX_UID"]).To(Equal("999"))
+			Expect(env["BOSH_SANDBOX_GID"]).To(Equal("998"))
+			Expect(env["BOSH_SANDBOX_WRITABLE_DIRS"]).To(Equal("/fake-compile-dir /fake-install-dir /fake-sandbox-dir/fake-name"))
+			Expect(env["BOSH_SANDBOX_BIND_MOUNTS"]).To(BeEmpty())
+		})
+
+		It("passes bind mounts to be made inside the namespace", func() {
+			bindMounts := []BindMount{
+				{Source: "/fake-install-dir", Target: "/fake-enable-path"},
+				{Source: "/fake-compile-dir", Target: "/fake-other-path"},
+			}
+
+			wrappedCmd, err := sandbox.Wrap("fake-name", cmd, []string{"/fake-compile-dir", "/fake-install-dir"}, bindMounts)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(wrappedCmd.Command.Env["BOSH_SANDBOX_BIND_MOUNTS"]).To(Equal("/fake-install-dir=/fake-enable-path /fake-compile-dir=/fake-other-path"))
+		})
+
+		It("creates private tmp dir", func() {
+			_, err := sandbox.Wrap("fake-name", cmd, []string{"/fake-compile-dir"}, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fs.FileExists("/fake-sandbox-dir/fake-name")).To(BeTrue())
+		})
+
+		It("hands writable dirs over to sandbox user", func() {
+			_, err := sandbox.Wrap("fake-name", cmd, []string{"/fake-compile-dir", "/fake-install-dir"}, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(runner.RunCommands).To(ContainElement([]string{"chown", "-R", "999:998", "/fake-compile-dir"}))
+			Expect(runner.RunCommands).To(ContainElement([]string{"chown", "-R", "999:998", "/fake-install-dir"}))
+			Expect(runner.RunCommands).To(ContainElement([]string{"chown", "-R", "999:998", "/fake-sandbox-dir/fake-name"}))
+		})
+
+		It("does not create sandbox user when it already exists", func() {
+			_, err := sandbox.Wrap("fake-name", cmd, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			for _, runCmd := range runner.RunCommands {
+				Expect(runCmd[0]).ToNot(Equal("useradd"))
+			}
+		})
+
+		It("returns timeout from options", func() {
+			options.TimeoutSeconds = 90
+
+			sandbox = NewLinuxSandbox(options, "/fake-sandbox-dir", fs, runner, boshlog.NewLogger(boshlog.LevelNone))
+
+			wrappedCmd, err := sandbox.Wrap("fake-name", cmd, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(wrappedCmd.Timeout).To(Equal(90 * time.Second))
+		})
+
+		Context("when sandbox user does not exist", func() {
+			BeforeEach(func() {
+				options.Username = "fake-user"
+				runner.AddCmdResult("id -u fake-user", fakesys.FakeCmdResult{Error: errors.New("fake-id-err")})
+				runner.AddCmdResult("id -u fake-user", fakesys.FakeCmdResult{Stdout: "1001"})
+				runner.AddCmdResult("id -g fake-user", fakesys.FakeCmdResult{Stdout: "1001"})
+			})
+
+			It("creates unprivileged system user", func() {
+				wrappedCmd, err := sandbox.Wrap("fake-name", cmd, nil, nil)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(runner.RunCommands).To(ContainElement([]string{
+					"useradd",
+					"--system",
+					"--user-group",
+					"--no-create-home",
+					"--home-dir", "/nonexistent",
+					"--shell", "/usr/sbin/nologin",
+					"fake-user",
+				}))
+				Expect(wrappedCmd.Command.Env["BOSH_SANDBOX_UID"]).To(Equal("1001"))
+			})
+
+			It("returns an error if creating user fails", func() {
+				runner.AddCmdResult("useradd --system --user-group --no-create-home --home-dir /nonexistent --shell /usr/sbin/nologin fake-user", fakesys.FakeCmdResult{Error: errors.New("fake-useradd-err")})
+
+				_, err := sandbox.Wrap("fake-name", cmd, nil, nil)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("fake-useradd-err"))
+			})
+		})
+
+		Context("when cgroup v2 is available", func() {
+			BeforeEach(func() {
+				fs.WriteFileString("/sys/fs/cgroup/cgroup.controllers", "cpu memory pids")
+
+				options.CPUs = 1.5
+				options.MemoryLimitBytes = 1024
+			})
+
+			It("limits cpu, memory and processes in a single cgroup", func() {
+				wrappedCmd, err := sandbox.Wrap("fake-name", cmd, nil, nil)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(fs.ReadFileString("/sys/fs/cgroup/cgroup.subtree_control")).To(Equal("+cpu +memory +pids"))
+				Expect(fs.ReadFileString("/sys/fs/cgroup/bosh-sandbox-fake-name/cpu.max")).To(Equal("150000 100000"))
+				Expect(fs.ReadFileString("/sys/fs/cgroup/bosh-sandbox-fake-name/memory.max")).To(Equal("1024"))
+				Expect(fs.ReadFileString("/sys/fs/cgroup/bosh-sandbox-fake-name/pids.max")).To(Equal("4096"))
+
+				Expect(wrappedCmd.Command.Env["BOSH_SANDBOX_CGROUP_PROCS"]).To(Equal("/sys/fs/cgroup/bosh-sandbox-fake-name/cgroup.procs"))
+			})
+
+			It("only limits processes by default", func() {
+				options.CPUs = 0
+				options.MemoryLimitBytes = 0
+				options.MaxProcesses = 10
+
+				sandbox = NewLinuxSandbox(options, "/fake-sandbox-dir", fs, runner, boshlog.NewLogger(boshlog.LevelNone))
+
+				_, err := sandbox.Wrap("fake-name", cmd, nil, nil)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(fs.ReadFileString("/sys/fs/cgroup/cgroup.subtree_control")).To(Equal("+pids"))
+				Expect(fs.ReadFileString("/sys/fs/cgroup/bosh-sandbox-fake-name/pids.max")).To(Equal("10"))
+				Expect(fs.FileExists("/sys/fs/cgroup/bosh-sandbox-fake-name/cpu.max")).To(BeFalse())
+				Expect(fs.FileExists("/sys/fs/cgroup/bosh-sandbox-fake-name/memory.max")).To(BeFalse())
+			})
+
+			It("returns an error and cleans up if writing limit fails", func() {
+				fs.WriteFileErrors["/sys/fs/cgroup/bosh-sandbox-fake-name/memory.max"] = errors.New("fake-write-err")
+
+				_, err := sandbox.Wrap("fake-name", cmd, nil, nil)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("fake-write-err"))
+
+				Expect(fs.FileExists("/sys/fs/cgroup/bosh-sandbox-fake-name")).To(BeFalse())
+				Expect(fs.FileExists("/fake-sandbox-dir/fake-name")).To(BeFalse())
+			})
+		})
+
+		Context("when only cgroup v1 is available", func() {
+			BeforeEach(func() {
+				options.CPUs = 2
+				options.MemoryLimitBytes = 1024
+			})
+
+			It("limits cpu, memory and processes in a cgroup per controller", func() {
+				wrappedCmd, err := sandbox.Wrap("fake-name", cmd, nil, nil)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(fs.ReadFileString("/sys/fs/cgroup/cpu/bosh-sandbox-fake-name/cpu.cfs_period_us")).To(Equal("100000"))
+				Expect(fs.ReadFileString("/sys/fs/cgroup/cpu/bosh-sandbox-fake-name/cpu.cfs_quota_us")).To(Equal("200000"))
+				Expect(fs.ReadFileString("/sys/fs/cgroup/memory/bosh-sandbox-fake-name/memory.limit_in_bytes")).To(Equal("1024"))
+				Expect(fs.ReadFileString("/sys/fs/cgroup/pids/bosh-sandbox-fake-name/pids.max")).To(Equal("4096"))
+
+				Expect(strings.Fields(wrappedCmd.Command.Env["BOSH_SANDBOX_CGROUP_PROCS"])).To(Equal([]string{
+					"/sys/fs/cgroup/cpu/bosh-sandbox-fake-name/cgroup.procs",
+					"/sys/fs/cgroup/memory/bosh-sandbox-fake-name/cgroup.procs",
+					"/sys/fs/cgroup/pids/bosh-sandbox-fake-name/cgroup.procs",
+				}))
+			})
+		})
+	})
+
+	Describe("CleanUp", func() {
+		var wrappedCmd WrappedCommand
+
+		BeforeEach(func() {
+			fs.WriteFileString("/sys/fs/cgroup/cgroup.controllers", "cpu memory pids")
+		})
+
+		JustBeforeEach(func() {
+			var err error
+			wrappedCmd, err = sandbox.Wrap("fake-name", cmd, []string{"/fake-compile-dir", "/fake-install-dir"}, nil)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("kills left behind processes and removes cgroup", func() {
+			fs.WriteFileString("/sys/fs/cgroup/bosh-sandbox-fake-name/cgroup.procs", "123\n456\n")
+
+			Expect(wrappedCmd.CleanUp()).To(Succeed())
+
+			Expect(runner.RunCommands).To(ContainElement([]string{"kill", "-9", "123", "456"}))
+			Expect(fs.FileExists("/sys/fs/cgroup/bosh-sandbox-fake-name")).To(BeFalse())
+		})
+
+		It("hands writable dirs back to root and removes private tmp dir", func() {
+			Expect(wrappedCmd.CleanUp()).To(Succeed())
+
+			Expect(runner.RunCommands).To(ContainElement([]string{"chown", "-R", "root:root", "/fake-compile-dir"}))
+			Expect(runner.RunCommands).To(ContainElement([]string{"chown", "-R", "root:root", "/fake-install-dir"}))
+			Expect(fs.FileExists("/fake-sandbox-dir/fake-name")).To(BeFalse())
+		})
+
+		It("returns an error if handing dirs back to root fails", func() {
+			runner.AddCmdResult("chown -R root:root /fake-install-dir", fakesys.FakeCmdResult{Error: errors.New("fake-chown-err")})
+
+			err := wrappedCmd.CleanUp()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("fake-chown-err"))
+		})
+	})
+})
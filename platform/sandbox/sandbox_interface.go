@@ -0,0 +1,52 @@
+package sandbox
+
+import (
+	"time"
+
+	boshsys "github.com/cloudfoundry/bosh-utils/system"
+)
+
+type Options struct {
+	// When set to true packaging scripts are run as an unprivileged user
+	// with resource limits and a read-only view of the file system
+	Enabled bool
+
+	// User that runs packaging scripts (defaults to 'bosh_compile')
+	Username string
+
+	// Number of CPUs available to the packaging script; 0 means no limit
+	CPUs float64
+
+	// Memory available to the packaging script; 0 means no limit
+	MemoryLimitBytes int64
+
+	// Maximum number of processes (defaults to 4096)
+	MaxProcesses int
+
+	// Packaging script is terminated after running for that long; 0 means no timeout
+	TimeoutSeconds int
+}
+
+type WrappedCommand struct {
+	Command boshsys.Command
+
+	// Command is expected to be cancelled once timeout is reached; 0 means no timeout
+	Timeout time.Duration
+
+	// CleanUp must be called once command exits
+	CleanUp func() error
+}
+
+// BindMount makes Source (one of writable dirs) available at Target
+// only to the command in the sandbox; Target does not have to exist
+// and may be a symlink shared with the rest of the system.
+type BindMount struct {
+	Source string
+	Target string
+}
+
+type Sandbox interface {
+	// Wrap returns command that runs given command confined to the sandbox
+	// where only writableDirs (and bind mounts of them) could be modified.
+	Wrap(name string, cmd boshsys.Command, writableDirs []string, bindMounts []BindMount) (WrappedCommand, error)
+}
@@ -4,6 +4,7 @@ import (
 	boshdpresolv "github.com/cloudfoundry/bosh-agent/infrastructure/devicepathresolver"
 	boshcert "github.com/cloudfoundry/bosh-agent/platform/cert"
 	boshnet "github.com/cloudfoundry/bosh-agent/platform/net"
+	boshsandbox "github.com/cloudfoundry/bosh-agent/platform/sandbox"
 	boshstats "github.com/cloudfoundry/bosh-agent/platform/stats"
 	boshvitals "github.com/cloudfoundry/bosh-agent/platform/vitals"
 	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
@@ -25,6 +26,7 @@ type WindowsPlatform struct {
 	netManager         boshnet.Manager
 	devicePathResolver boshdpresolv.DevicePathResolver
 	certManager        boshcert.Manager
+	compilationSandbox boshsandbox.Sandbox
 }
 
 func NewWindowsPlatform(
@@ -47,6 +49,7 @@ func NewWindowsPlatform(
 		devicePathResolver: devicePathResolver,
 		vitalsService:      boshvitals.NewService(collector, dirProvider),
 		certManager:        boshcert.NewDummyCertManager(fs, cmdRunner, 0, logger),
+		compilationSandbox: boshsandbox.NewNoopSandbox(),
 	}
 }
 
@@ -122,6 +125,10 @@ func (p WindowsPlatform) GetCertManager() (certManager boshcert.Manager) {
 	return p.certManager
 }
 
+func (p WindowsPlatform) GetCompilationSandbox() boshsandbox.Sandbox {
+	return p.compilationSandbox
+}
+
 func (p WindowsPlatform) SetupLogrotate(groupName, basePath, size string) (err error) {
 	return
 }
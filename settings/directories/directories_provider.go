@@ -46,6 +46,14 @@ func (p Provider) CompileDir() string {
 	return path.Join(p.DataDir(), "compile")
 }
 
+func (p Provider) CompiledPackagesCacheDir() string {
+	return path.Join(p.DataDir(), "compiled_packages_cache")
+}
+
+func (p Provider) CompilationSandboxDir() string {
+	return path.Join(p.DataDir(), "compilation_sandbox")
+}
+
 func (p Provider) MonitJobsDir() string {
 	return path.Join(p.BaseDir(), "monit", "job")
 }
@@ -58,6 +66,12 @@ func (p Provider) JobsDir() string {
 	return path.Join(p.BaseDir(), "jobs")
 }
 
+// ApplyStagingDir keeps jobs, packages and job supervisor configuration
+// of apply spec being applied until they replace current ones
+func (p Provider) ApplyStagingDir() string {
+	return path.Join(p.BaseDir(), "apply_staging")
+}
+
 func (p Provider) JobBinDir(jobName string) string {
 	return path.Join(p.JobsDir(), jobName, "bin")
 }
@@ -21,6 +21,7 @@ type Settings struct {
 	Networks     Networks  `json:"networks"`
 	Ntp          []string  `json:"ntp"`
 	Mbus         string    `json:"mbus"`
+	MbusURLs     []string  `json:"mbus_urls"`
 	VM           VM        `json:"vm"`
 	TrustedCerts string    `json:"trusted_certs"`
 }
@@ -127,6 +128,25 @@ func (s Settings) RawEphemeralDiskSettings() (devices []DiskSettings) {
 	return s.Disks.RawEphemeral
 }
 
+// GetMbusURLs returns mbus URLs in order of preference;
+// Mbus is preferred over the URLs listed in MbusURLs
+func (s Settings) GetMbusURLs() []string {
+	var urls []string
+
+	seen := map[string]bool{}
+
+	for _, url := range append([]string{s.Mbus}, s.MbusURLs...) {
+		if url == "" || seen[url] {
+			continue
+		}
+
+		seen[url] = true
+		urls = append(urls, url)
+	}
+
+	return urls
+}
+
 type Env struct {
 	Bosh             BoshEnv             `json:"bosh"`
 	PersistentDiskFS disk.FileSystemType `json:"persistent_disk_fs"`
@@ -144,10 +164,54 @@ func (e Env) GetRemoveDevTools() bool {
 	return e.Bosh.RemoveDevTools
 }
 
+func (e Env) GetMbusCert() CertKeyPair {
+	return e.Bosh.Mbus.Cert
+}
+
+func (e Env) GetMbusSigning() MessageSigning {
+	return e.Bosh.Mbus.Signing
+}
+
 type BoshEnv struct {
-	Password         string `json:"password"`
-	KeepRootPassword bool   `json:"keep_root_password"`
-	RemoveDevTools   bool   `json:"remove_dev_tools"`
+	Password         string  `json:"password"`
+	KeepRootPassword bool    `json:"keep_root_password"`
+	RemoveDevTools   bool    `json:"remove_dev_tools"`
+	Mbus             MbusEnv `json:"mbus"`
+}
+
+type MbusEnv struct {
+	Cert CertKeyPair `json:"cert"`
+
+	Signing MessageSigning `json:"signing"`
+}
+
+// MessageSigning holds base64 encoded keys used to sign messages
+// exchanged with the director. Requests are verified with director's
+// ed25519 public key when it is given or otherwise with HMAC key;
+// replies are signed with agent's ed25519 private key (seed or full key)
+// or otherwise with HMAC key.
+type MessageSigning struct {
+	HMACKey           string `json:"hmac_key"`
+	DirectorPublicKey string `json:"director_public_key"`
+	AgentPrivateKey   string `json:"agent_private_key"`
+
+	// Unsigned messages are rejected when enforced
+	Enforce bool `json:"enforce"`
+
+	// Older messages are rejected; defaults to 5 minutes
+	MaxAgeSeconds int `json:"max_age_seconds"`
+}
+
+// CertKeyPair holds PEM encoded certificates. CA is used to verify
+// the server; Certificate and PrivateKey authenticate the agent.
+type CertKeyPair struct {
+	CA          string `json:"ca"`
+	Certificate string `json:"certificate"`
+	PrivateKey  string `json:"private_key"`
+}
+
+func (c CertKeyPair) IsEmpty() bool {
+	return c.CA == "" && c.Certificate == "" && c.PrivateKey == ""
 }
 
 type NetworkType string
@@ -398,6 +398,29 @@ var _ = Describe("Settings", func() {
 		})
 	})
 
+	Describe("GetMbusURLs", func() {
+		It("returns mbus followed by other mbus urls", func() {
+			settings = Settings{
+				Mbus:     "nats://fake-nats-1",
+				MbusURLs: []string{"nats://fake-nats-2", "https://fake-https"},
+			}
+
+			Expect(settings.GetMbusURLs()).To(Equal([]string{"nats://fake-nats-1", "nats://fake-nats-2", "https://fake-https"}))
+		})
+
+		It("skips empty and repeated urls", func() {
+			settings = Settings{
+				MbusURLs: []string{"nats://fake-nats-1", "", "nats://fake-nats-1"},
+			}
+
+			Expect(settings.GetMbusURLs()).To(Equal([]string{"nats://fake-nats-1"}))
+		})
+
+		It("returns no urls when mbus is not configured", func() {
+			Expect(Settings{}.GetMbusURLs()).To(BeEmpty())
+		})
+	})
+
 	It("allows different types for blobstore option values", func() {
 		settingsJSON := `{"blobstore":{"options":{"string":"value", "int":443, "bool":true, "map":{}}}}`
 
@@ -596,5 +619,40 @@ var _ = Describe("Settings", func() {
 			Expect(env.GetKeepRootPassword()).To(BeFalse())
 			Expect(env.GetRemoveDevTools()).To(BeTrue())
 		})
+
+		It("unmarshal mbus cert correctly", func() {
+			var env Env
+			envJSON := `{"bosh": {"mbus": {"cert": {"ca": "fake-ca", "certificate": "fake-cert", "private_key": "fake-key"}}}}`
+
+			err := json.Unmarshal([]byte(envJSON), &env)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(env.GetMbusCert()).To(Equal(CertKeyPair{
+				CA:          "fake-ca",
+				Certificate: "fake-cert",
+				PrivateKey:  "fake-key",
+			}))
+			Expect(env.GetMbusCert().IsEmpty()).To(BeFalse())
+		})
+
+		It("unmarshal mbus message signing correctly", func() {
+			var env Env
+			envJSON := `{"bosh": {"mbus": {"signing": {
+				"hmac_key": "fake-hmac-key",
+				"director_public_key": "fake-public-key",
+				"agent_private_key": "fake-private-key",
+				"enforce": true,
+				"max_age_seconds": 60
+			}}}}`
+
+			err := json.Unmarshal([]byte(envJSON), &env)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(env.GetMbusSigning()).To(Equal(MessageSigning{
+				HMACKey:           "fake-hmac-key",
+				DirectorPublicKey: "fake-public-key",
+				AgentPrivateKey:   "fake-private-key",
+				Enforce:           true,
+				MaxAgeSeconds:     60,
+			}))
+		})
 	})
 })